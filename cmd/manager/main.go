@@ -18,6 +18,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/kudobuilder/kudo/pkg/apis"
 	"github.com/kudobuilder/kudo/pkg/controller/instance"
@@ -25,13 +26,15 @@ import (
 	"github.com/kudobuilder/kudo/pkg/controller/operatorversion"
 	"github.com/kudobuilder/kudo/pkg/version"
 
+	"k8s.io/apimachinery/pkg/types"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
 
 func main() {
-	logf.SetLogger(logf.ZapLogger(false))
+	// development logger enables debug output, e.g. kustomizations generated when rendering templates
+	logf.SetLogger(logf.ZapLogger(os.Getenv("KUDO_DEBUG") == "true"))
 	log := logf.Log.WithName("entrypoint")
 
 	// Get version of KUDO
@@ -73,10 +76,36 @@ func main() {
 	}
 
 	log.Info("Setting up instance controller")
+	labelKeys, err := instance.ParseLabelKeys(os.Getenv("KUDO_LABEL_KEYS"))
+	if err != nil {
+		log.Error(err, "invalid KUDO_LABEL_KEYS")
+		os.Exit(1)
+	}
+	// execution events are streamed to watchers only when the address to serve them at is configured
+	var executionEvents *instance.ExecutionEvents
+	if address := os.Getenv("KUDO_EXECUTION_EVENTS_ADDRESS"); address != "" {
+		executionEvents = instance.NewExecutionEvents()
+		if err := mgr.Add(instance.ServeExecutionEvents(address, executionEvents)); err != nil {
+			log.Error(err, "unable to serve execution events")
+			os.Exit(1)
+		}
+	}
 	err = (&instance.Reconciler{
 		Client:   mgr.GetClient(),
 		Recorder: mgr.GetEventRecorderFor("instance-controller"),
 		Scheme:   mgr.GetScheme(),
+		ClusterConfig: types.NamespacedName{
+			Name:      instance.ClusterConfigMapName,
+			Namespace: clusterConfigNamespace(),
+		},
+		StallTimeout: stallTimeout(),
+		KindPolicy: instance.KindPolicy{
+			Allowed: instance.ParseKinds(os.Getenv("KUDO_ALLOWED_KINDS")),
+			Denied:  instance.ParseKinds(os.Getenv("KUDO_DENIED_KINDS")),
+		},
+		ConventionsFallback: os.Getenv("KUDO_CONVENTIONS_FALLBACK") == "true",
+		LabelKeys:           labelKeys,
+		Events:              executionEvents,
 	}).SetupWithManager(mgr)
 	if err != nil {
 		log.Error(err, "unable to register instance controller to the manager")
@@ -90,3 +119,20 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// clusterConfigNamespace returns namespace the manager runs in, that is where the cluster config map is expected
+func clusterConfigNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "kudo-system"
+}
+
+// stallTimeout returns the time a plan can make no progress before a warning is reported, it can be changed using
+// the KUDO_STALL_TIMEOUT environment variable, e.g. "1h"
+func stallTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("KUDO_STALL_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return instance.DefaultStallTimeout
+}
@@ -40,6 +40,12 @@ type InstanceStatus struct {
 	// slice would be enough here but we cannot use slice because order of sequence in yaml is considered significant while here it's not
 	PlanStatus       map[string]PlanStatus `json:"planStatus,omitempty"`
 	AggregatedStatus AggregatedStatus      `json:"aggregatedStatus,omitempty"`
+
+	// AppliedParameters are the effective parameters (including defaults) of the last successfully finished plan
+	// they are used as defaults for parameters not set on the instance, so that values survive an upgrade
+	AppliedParameters map[string]string `json:"appliedParameters,omitempty"`
+	// AppliedOperatorVersion is the name of the OperatorVersion AppliedParameters were applied with
+	AppliedOperatorVersion string `json:"appliedOperatorVersion,omitempty"`
 }
 
 // AggregatedStatus is overview of an instance status derived from the plan status
@@ -76,6 +82,119 @@ type PlanStatus struct {
 	Status          ExecutionStatus `json:"status,omitempty"`
 	LastFinishedRun metav1.Time     `json:"lastFinishedRun,omitempty"`
 	Phases          []PhaseStatus   `json:"phases,omitempty"`
+
+	// BlueGreen tracks the colors of the blue-green phases of this plan, keyed by the phase name
+	BlueGreen map[string]BlueGreenStatus `json:"blueGreen,omitempty"`
+
+	// FailedAttempts is the number of consecutive executions of this plan that failed without making any progress
+	FailedAttempts int32 `json:"failedAttempts,omitempty"`
+
+	// Partitions tracks the rolling update partition of StatefulSets rolled out by partitioned phases of this plan, keyed
+	// by namespace and name of the StatefulSet, StatefulSets are removed once all their pods are updated
+	Partitions map[string]int32 `json:"partitions,omitempty"`
+
+	// ScaledReplicas tracks the replica count workloads had before a step of this plan scaled them to zero, keyed by
+	// kind, namespace and name of the workload, counts are kept when the plan restarts and are removed once restored
+	ScaledReplicas map[string]int32 `json:"scaledReplicas,omitempty"`
+
+	// LastError describes the error the last execution of this plan failed with, it is cleared once an execution succeeds
+	LastError *ExecutionError `json:"lastError,omitempty"`
+
+	// ParameterChanges lists the parameters that differ from the ones applied by the last successfully finished plan, it
+	// is recorded when the plan starts and tells why it runs, values of sensitive parameters are masked
+	ParameterChanges []ParameterChange `json:"parameterChanges,omitempty"`
+
+	// LastProgressTime is the time a step of this plan last changed its status or stage, it is used to detect plans that
+	// do not get any further, e.g. waiting for an object that never becomes healthy
+	LastProgressTime metav1.Time `json:"lastProgressTime,omitempty"`
+
+	// Conditions describe the execution of this plan beyond its status
+	Conditions []PlanCondition `json:"conditions,omitempty"`
+}
+
+// PlanConditionType is the type of a condition of a plan
+type PlanConditionType string
+
+const (
+	// PlanStalled is true when the plan made no progress for longer than the controller allows, the plan is not failed
+	// because of that and keeps being executed
+	PlanStalled PlanConditionType = "Stalled"
+
+	// PlanDrifted is true when objects of the plan with the detect-drift conflict policy differ from the rendered ones,
+	// they are left untouched until the plan is executed to reconcile the drift
+	PlanDrifted PlanConditionType = "Drifted"
+
+	// PlanDependencyUnhealthy is true while a dependency of the plan is unhealthy and the plan is paused because of that,
+	// or after it aborted the plan
+	PlanDependencyUnhealthy PlanConditionType = "DependencyUnhealthy"
+)
+
+// PlanCondition describes one aspect of the execution of a plan
+type PlanCondition struct {
+	Type   PlanConditionType      `json:"type"`
+	Status corev1.ConditionStatus `json:"status"`
+	// Reason is a machine readable reason of the last transition of the condition
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the time the status of the condition last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// Condition returns the condition of the given type, nil if the plan does not have it
+func (s *PlanStatus) Condition(conditionType PlanConditionType) *PlanCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds the condition or replaces the one of the same type, the transition time is kept when the status
+// of the condition did not change
+func (s *PlanStatus) SetCondition(condition PlanCondition) {
+	existing := s.Condition(condition.Type)
+	if existing == nil {
+		s.Conditions = append(s.Conditions, condition)
+		return
+	}
+	if existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	*existing = condition
+}
+
+// SensitiveValueMask replaces values of sensitive parameters in the status
+const SensitiveValueMask = "<masked>"
+
+// ParameterChange is a parameter that was added, removed or changed, Old is nil for added parameters and New is nil for
+// removed ones
+type ParameterChange struct {
+	Name string  `json:"name"`
+	Old  *string `json:"old,omitempty"`
+	New  *string `json:"new,omitempty"`
+}
+
+// ExecutionError is the machine readable representation of an error that occurred when executing a plan
+type ExecutionError struct {
+	// Code identifies the kind of the error, e.g. `InvalidPlan` or `CommandFailed`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+	// Phase and Step point to where the error occurred, they are empty for errors of the whole plan
+	Phase string `json:"phase,omitempty"`
+	Step  string `json:"step,omitempty"`
+	// Retryable is true when KUDO retries the execution, otherwise manual intervention is required
+	Retryable bool `json:"retryable"`
+}
+
+// BlueGreenStatus is representing the colors of a blue-green phase
+type BlueGreenStatus struct {
+	// LiveColor is the color the Service currently points to, empty before the first rollout finished
+	LiveColor string `json:"liveColor,omitempty"`
+	// TargetColor is the color being rolled out, empty when no rollout is in progress
+	TargetColor string `json:"targetColor,omitempty"`
+	// RolloutStarted is the time the rollout of TargetColor started
+	RolloutStarted metav1.Time `json:"rolloutStarted,omitempty"`
 }
 
 // PhaseStatus is representing status of a phase
@@ -83,14 +202,77 @@ type PhaseStatus struct {
 	Name   string          `json:"name,omitempty"`
 	Status ExecutionStatus `json:"status,omitempty"`
 	Steps  []StepStatus    `json:"steps,omitempty"`
+
+	// CompletedSteps and TotalSteps report progress of the phase, they are updated every time the phase is executed
+	CompletedSteps int `json:"completedSteps,omitempty"`
+	TotalSteps     int `json:"totalSteps,omitempty"`
 }
 
 // StepStatus is representing status of a step
 type StepStatus struct {
 	Name   string          `json:"name,omitempty"`
 	Status ExecutionStatus `json:"status,omitempty"`
+	// Message contains details about the last error of this step, including whether it is going to be retried, or about
+	// the object the step is waiting for to become healthy
+	Message string `json:"message,omitempty"`
+	// Output contains the output of the command run by this step, if the command task asked for it to be captured
+	Output string `json:"output,omitempty"`
+	// Stage is the part of a step with a barrier, pre or post tasks that is being executed
+	Stage StepStage `json:"stage,omitempty"`
+	// StartedAt is the time the step started in the current execution of the plan
+	StartedAt metav1.Time `json:"startedAt,omitempty"`
+	// AppliedAt is the time the step first applied each of its objects, keyed by kind, namespace and name of the object,
+	// it is tracked only for steps with SettleSeconds
+	AppliedAt map[string]metav1.Time `json:"appliedAt,omitempty"`
+	// ResourceAttempts is the number of times in a row applying each object of the step failed, keyed by kind, namespace
+	// and name of the object, it is tracked only for steps with ResourceRetries
+	ResourceAttempts map[string]int32 `json:"resourceAttempts,omitempty"`
+	// Drift lists the fields changed outside of KUDO of objects of the step with the detect-drift conflict policy, keyed by
+	// kind, namespace and name of the object, e.g. `spec.replicas: 5 -> 3`
+	Drift map[string]string `json:"drift,omitempty"`
+	// ReconcileDrift makes the step patch objects with the detect-drift conflict policy too, it is set when the plan is
+	// started to reconcile drift
+	ReconcileDrift bool `json:"reconcileDrift,omitempty"`
+	// Created lists the objects the step created in the current execution of the plan, keyed by kind, namespace and name
+	// of the object, it is tracked only for steps whose deadline rolls them back
+	Created []string `json:"created,omitempty"`
+	// Applied records the content each object of the step was last applied with, keyed by kind, namespace and name of
+	// the object, objects whose rendered content and live version did not change since are not patched again. It is kept
+	// when the plan is started again.
+	Applied map[string]AppliedObject `json:"applied,omitempty"`
+	// Healthy records the objects of the step with sticky health that were healthy in the current execution of the plan,
+	// keyed by kind, namespace and name of the object, the value is the digest of the rendered object. Their health is
+	// not checked again while they are rendered the same.
+	Healthy map[string]string `json:"healthy,omitempty"`
+}
+
+// AppliedObject is the content an object was applied with together with the version of the object the API server
+// returned for it.
+type AppliedObject struct {
+	// Digest is the sha256 digest of the rendered object
+	Digest string `json:"digest"`
+	// ResourceVersion is the version of the object right after it was applied, it changes with every change of the
+	// object, also the ones made outside of KUDO
+	ResourceVersion string `json:"resourceVersion,omitempty"`
 }
 
+// StepStage is the part of a step that is being executed.
+type StepStage string
+
+const (
+	// PreTasksStage applies the pre tasks of the step.
+	PreTasksStage StepStage = "PRE_TASKS"
+
+	// TasksStage applies the tasks of the step.
+	TasksStage StepStage = "TASKS"
+
+	// PostTasksStage applies the post tasks of the step.
+	PostTasksStage StepStage = "POST_TASKS"
+
+	// BarrierStage waits for the conditions of the barrier of the step.
+	BarrierStage StepStage = "BARRIER"
+)
+
 // ExecutionStatus captures the state of the rollout.
 type ExecutionStatus string
 
@@ -113,6 +295,9 @@ const (
 	// ExecutionNeverRun is used when this plan/phase/step was never run so far
 	ExecutionNeverRun ExecutionStatus = "NEVER_RUN"
 
+	// ExecutionPaused is used for a step that is not started because of a breakpoint set on the instance.
+	ExecutionPaused ExecutionStatus = "PAUSED"
+
 	// DeployPlanName is the name of the deployment plan
 	DeployPlanName = "deploy"
 
@@ -135,7 +320,7 @@ func (s ExecutionStatus) IsFinished() bool {
 
 // IsRunning returns true if the plan is currently being executed
 func (s ExecutionStatus) IsRunning() bool {
-	return s == ExecutionInProgress || s == ExecutionPending || s == ErrorStatus
+	return s == ExecutionInProgress || s == ExecutionPending || s == ErrorStatus || s == ExecutionPaused
 }
 
 // GetPlanInProgress returns plan status of currently active plan or nil if no plan is running
@@ -176,6 +361,7 @@ func (i *Instance) EnsurePlanStatusInitialized(ov *OperatorVersion) {
 		existingPlanStatus, planExists := i.Status.PlanStatus[planName]
 		if planExists {
 			planStatus.Status = existingPlanStatus.Status
+			planStatus.BlueGreen = existingPlanStatus.BlueGreen
 		}
 		for _, phase := range plan.Phases {
 			phaseStatus := &PhaseStatus{
@@ -227,10 +413,24 @@ func (i *Instance) StartPlanExecution(planName string, ov *OperatorVersion) erro
 			notFound = false
 			planStatus := i.Status.PlanStatus[planIndex]
 			planStatus.Status = ExecutionPending
+			planStatus.FailedAttempts = 0
+			planStatus.LastError = nil
+			planStatus.Partitions = nil
+			planStatus.ParameterChanges = nil
+			planStatus.LastProgressTime = metav1.Time{}
+			planStatus.Conditions = nil
 			for j, p := range v.Phases {
 				planStatus.Phases[j].Status = ExecutionPending
 				for k := range p.Steps {
 					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].Status = ExecutionPending
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].Stage = ""
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].StartedAt = metav1.Time{}
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].AppliedAt = nil
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].ResourceAttempts = nil
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].Drift = nil
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].ReconcileDrift = false
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].Created = nil
+					i.Status.PlanStatus[planIndex].Phases[j].Steps[k].Healthy = nil
 				}
 			}
 
@@ -255,6 +455,62 @@ func (i *Instance) StartPlanExecution(planName string, ov *OperatorVersion) erro
 	return nil
 }
 
+// ReconcileDrift starts the plan like StartPlanExecution does, all its steps patch objects with drift this time
+func (i *Instance) ReconcileDrift(planName string, ov *OperatorVersion) error {
+	if err := i.StartPlanExecution(planName, ov); err != nil {
+		return err
+	}
+	planStatus := i.Status.PlanStatus[planName]
+	for j, p := range planStatus.Phases {
+		for k := range p.Steps {
+			planStatus.Phases[j].Steps[k].ReconcileDrift = true
+		}
+	}
+	return nil
+}
+
+// RetryFailedSteps restarts a failed plan from the steps that failed instead of starting it again from scratch
+// steps that completed are not executed again, failed steps are pending again and continue in the stage they failed in,
+// steps after them are still pending from the failed execution and are executed once the steps before them complete
+func (i *Instance) RetryFailedSteps(planName string) error {
+	planStatus, ok := i.Status.PlanStatus[planName]
+	if !ok {
+		return &InstanceError{fmt.Errorf("asked to retry a plan %s but no such plan found in instance %s/%s", planName, i.Namespace, i.Name), kudo.String("PlanNotFound")}
+	}
+	if planStatus.Status != ExecutionFatalError && planStatus.Status != ErrorStatus {
+		return &InstanceError{fmt.Errorf("asked to retry plan %s of instance %s/%s but it did not fail, its status is %s", planName, i.Namespace, i.Name, planStatus.Status), kudo.String("PlanNotFailed")}
+	}
+	if active := i.GetPlanInProgress(); active != nil && active.Name != planName {
+		return &InstanceError{fmt.Errorf("asked to retry plan %s of instance %s/%s but plan %s is in progress", planName, i.Namespace, i.Name, active.Name), kudo.String("PlanInProgress")}
+	}
+
+	for j, p := range planStatus.Phases {
+		for k, s := range p.Steps {
+			if s.Status != ErrorStatus && s.Status != ExecutionFatalError {
+				continue
+			}
+			planStatus.Phases[j].Steps[k].Status = ExecutionPending
+			planStatus.Phases[j].Steps[k].Message = ""
+			planStatus.Phases[j].Steps[k].StartedAt = metav1.Time{}
+			planStatus.Phases[j].Steps[k].AppliedAt = nil
+			planStatus.Phases[j].Steps[k].ResourceAttempts = nil
+			planStatus.Phases[j].Steps[k].Drift = nil
+			planStatus.Phases[j].Steps[k].Created = nil
+		}
+		if p.Status == ErrorStatus || p.Status == ExecutionFatalError {
+			planStatus.Phases[j].Status = ExecutionPending
+		}
+	}
+	planStatus.Status = ExecutionPending
+	planStatus.FailedAttempts = 0
+	planStatus.LastError = nil
+	i.Status.PlanStatus[planName] = planStatus
+
+	i.Status.AggregatedStatus.Status = ExecutionPending
+	i.Status.AggregatedStatus.ActivePlanName = planName
+	return nil
+}
+
 // isUpgradePlan returns true if this could be an upgrade plan - this is just an approximation because deploy plan can be used for both
 func isUpgradePlan(planName string) bool {
 	return planName == DeployPlanName || planName == UpgradePlanName
@@ -31,6 +31,10 @@ type OperatorVersionSpec struct {
 	Templates map[string]string   `json:"templates,omitempty"`
 	Tasks     map[string]TaskSpec `json:"tasks,omitempty"`
 
+	// TemplatingLanguage is the language the templates are written in, go templates are used when it is not set.
+	// +optional
+	TemplatingLanguage TemplatingLanguage `json:"templatingLanguage,omitempty"`
+
 	Parameters []Parameter `json:"parameters,omitempty"`
 
 	// Plans maps a plan name to a plan.
@@ -47,6 +51,15 @@ type OperatorVersionSpec struct {
 	UpgradableFrom []OperatorVersion `json:"upgradableFrom,omitempty"`
 }
 
+// TemplatingLanguage is the language templates of an operator are written in.
+type TemplatingLanguage string
+
+// GoTemplate templates are rendered by the go template engine with sprig functions, see the engine package.
+const GoTemplate TemplatingLanguage = "go-template"
+
+// Envsubst templates only substitute references like `${Params.REPLICAS}` with values.
+const Envsubst TemplatingLanguage = "envsubst"
+
 // Ordering specifies how the subitems in this plan/phase should be rolled out.
 type Ordering string
 
@@ -57,11 +70,89 @@ const Serial Ordering = "serial"
 // Parallel specifies that the plan or objects in the phase can all be launched at the same time.
 const Parallel Ordering = "parallel"
 
+// BlueGreen specifies that the steps of the phase are rolled out serially as a new color next to the live one. Once all of
+// them are healthy, the Service of the phase is switched to the new color and the previous color is deleted.
+const BlueGreen Ordering = "blue-green"
+
+// Partitioned specifies that the steps of the phase are applied serially and StatefulSets of the steps that already exist
+// are updated one pod at a time by decrementing the partition of their rolling update, each pod has to be ready before the
+// next one is updated. When a pod does not become ready, the rollout halts at the current partition.
+const Partitioned Ordering = "partitioned"
+
 // Plan specifies a series of Phases that need to be completed.
 type Plan struct {
 	Strategy Ordering `json:"strategy" validate:"required"` // makes field mandatory and checks if set and non empty
 	// Phases maps a phase name to a Phase object.
 	Phases []Phase `json:"phases" validate:"required,gt=0,dive"` // makes field mandatory and checks if its gt 0
+
+	// Verify makes the plan only check the health of the objects its steps render, nothing is created, patched or deleted.
+	// A step of a verify plan is complete once all its objects exist and are healthy, e.g. to let monitoring confirm that
+	// an instance is still healthy long after it was deployed.
+	Verify bool `json:"verify,omitempty"` // no checks needed
+
+	// Profiles select the phases of the plan that run depending on values of parameters, e.g. a restore phase that runs
+	// only when a RESTORE parameter is "true", so that install, upgrade and restore variants can share one plan. Phases
+	// that are not selected are skipped. All phases run when no profile is active.
+	Profiles []PlanProfile `json:"profiles,omitempty" validate:"dive"` // makes field optional and validates the items
+
+	// CheckQuota makes the plan check before it applies anything that the resource requests of its workloads fit into
+	// the resource quotas of their namespaces, so that a plan that cannot fit fails right away instead of getting stuck
+	// half way applied on a create rejected by the quota.
+	CheckQuota bool `json:"checkQuota,omitempty"` // no checks needed
+
+	// Dependencies are instances the plan needs to stay healthy while it runs, e.g. the ZooKeeper instance of a Kafka
+	// instance. They are checked every time the plan is executed, before any of its steps, and the plan does not get
+	// any further while one of them is unhealthy.
+	Dependencies []PlanDependency `json:"dependencies,omitempty" validate:"dive"` // makes field optional and validates the items
+
+	// ContinuousReconciliation makes the plan check the objects of its completed steps every time it is executed while it
+	// is in progress, a step whose object is gone or no longer healthy, e.g. because it was deleted by hand, is started
+	// again so that it creates the object again before the plan gets any further. It costs a read of every object of
+	// the completed steps per execution. Steps of partitioned and blue-green phases and objects of command tasks are not
+	// checked.
+	ContinuousReconciliation bool `json:"continuousReconciliation,omitempty"` // no checks needed
+}
+
+// PlanDependency is an instance a plan depends on.
+//
+// An instance is healthy while its active plan is complete, so a dependency that fails or runs a plan of its own
+// holds up the dependent plan. A paused plan continues with the step it stopped at once the dependency is healthy
+// again, an aborted plan fails and has to be started again.
+type PlanDependency struct {
+	// Name of the instance, rendered like templates of the plan, e.g. `{{ .Params.ZOOKEEPER_INSTANCE }}`.
+	Name string `json:"name" validate:"required"` // makes field mandatory and checks if set and non empty
+	// Namespace of the instance, the namespace of the dependent instance if not set.
+	Namespace string `json:"namespace,omitempty"` // no checks needed
+	// OnUnhealthy is what happens to the plan while the dependency is unhealthy, Pause if not set.
+	OnUnhealthy DependencyAction `json:"onUnhealthy,omitempty"` // validated by the plan execution
+}
+
+// DependencyAction is what happens to a plan when one of its dependencies is unhealthy
+type DependencyAction string
+
+const (
+	// DependencyPause keeps the plan in progress without executing any of its steps.
+	DependencyPause DependencyAction = "Pause"
+	// DependencyAbort fails the plan fatally.
+	DependencyAbort DependencyAction = "Abort"
+)
+
+// PlanProfile selects the phases of a plan that run when a parameter has a given value.
+//
+// A phase runs if it is included by an active profile, or if no active profile includes any phase, and no active
+// profile excludes it. Excluding a phase wins over including it, so that profiles that disagree never run a phase
+// that one of them asked to skip.
+type PlanProfile struct {
+	Name string `json:"name" validate:"required"` // makes field mandatory and checks if set and non empty
+
+	// Parameter and Value activate the profile when the parameter of the instance has the value.
+	Parameter string `json:"parameter" validate:"required"` // makes field mandatory and checks if set and non empty
+	Value     string `json:"value"`                         // no checks needed
+
+	// Include lists the phases that run when the profile is active.
+	Include []string `json:"include,omitempty"` // field optional, no need to validate
+	// Exclude lists the phases that are skipped when the profile is active.
+	Exclude []string `json:"exclude,omitempty"` // field optional, no need to validate
 }
 
 // Parameter captures the variability of an OperatorVersion being instantiated in an instance.
@@ -89,15 +180,107 @@ type Parameter struct {
 	// Default is `update` if a plan with that name exists, otherwise it's `deploy`
 	Trigger string `json:"trigger,omitempty"`
 
+	// Type constrains values of the parameter, values not matching the type fail the plan before anything is applied.
+	// Default is `string` which accepts any value.
+	Type ParameterType `json:"type,omitempty"`
+
+	// Group is the name of the group the parameter belongs to, it only organizes related parameters for display.
+	Group string `json:"group,omitempty"`
+
+	// RequiredWhen makes the parameter required when all the conditions are met, e.g. a TLS certificate that is needed only
+	// when TLS is enabled. Required parameters are required regardless of these conditions.
+	RequiredWhen []ParameterCondition `json:"requiredWhen,omitempty"`
+
+	// PinDigest enforces digests for image parameters, values with a tag only are resolved to the digest the tag points to
+	// before rendering, so the templates only ever see pinned images. When the digest cannot be resolved, the plan fails.
+	PinDigest bool `json:"pinDigest,omitempty"`
+
+	// Sensitive marks parameters whose values must not be shown, e.g. passwords, their values are masked wherever KUDO
+	// reports them.
+	Sensitive bool `json:"sensitive,omitempty"`
+
 	// TODO: Add generated parameters (e.g. passwords).
 	// These values should be saved off in a secret instead of updating the spec
 	// with values that viewing the instance does not return credentials.
 
 }
 
+// ParameterCondition is met when the parameter has the given value.
+type ParameterCondition struct {
+	Parameter string `json:"parameter"`
+	Value     string `json:"value"`
+}
+
+// ParameterType specifies what values a parameter accepts.
+type ParameterType string
+
+// StringParameterType accepts any value.
+const StringParameterType ParameterType = "string"
+
+// QuantityParameterType accepts resource quantities like `512Mi` or `0.5`, e.g. for container resource requests.
+const QuantityParameterType ParameterType = "quantity"
+
+// ImageParameterType accepts container image references like `nginx:1.17` or `nginx@sha256:...`.
+const ImageParameterType ParameterType = "image"
+
+// ValuesParameterType accepts a YAML map of values, e.g. the content of a Helm values.yaml. Values of all the values
+// parameters are merged in the order the parameters are defined in and exposed to templates as `.Values` together with
+// all the other parameters, which override top-level keys of the values with the same name.
+const ValuesParameterType ParameterType = "values"
+
+// ListParameterType accepts a YAML list, e.g. `[a, b, c]`. Steps iterate over list parameters with `forEach`.
+const ListParameterType ParameterType = "list"
+
 // TaskSpec is a struct containing lists of Kustomize resources.
 type TaskSpec struct {
 	Resources []string `json:"resources"`
+
+	// Command makes this task run a command in a Job, the step is healthy once the command exits successfully.
+	Command *CommandSpec `json:"command,omitempty"`
+
+	// Helm makes this task render a Helm chart, the rendered objects are applied like the resources of the task.
+	Helm *HelmSpec `json:"helm,omitempty"`
+
+	// Owner makes the objects of this task owned by the given object instead of the instance, e.g. by the OperatorVersion
+	// for infrastructure shared by all its instances, or by a parent Instance of a composition. The objects are then
+	// garbage collected with the owner instead of the instance.
+	Owner *TaskOwner `json:"owner,omitempty"`
+
+	// Containers names a list parameter with container specs merged into the pod templates of the Deployments,
+	// StatefulSets, DaemonSets, ReplicaSets and Jobs of the task, e.g. to let users add sidecars or environment variables
+	// without forking the templates. Containers are merged by name: fields of a container with the name of a container
+	// of the template override its fields, containers with other names are added.
+	Containers string `json:"containers,omitempty"` // field optional, validated by the controller
+}
+
+// TaskOwner references the object that owns the objects of a task. The owner has to live in the namespace of the instance
+// or be cluster scoped, as Kubernetes does not allow owners in other namespaces.
+type TaskOwner struct {
+	APIVersion string `json:"apiVersion" validate:"required"` // makes field mandatory and checks if set and non empty
+	Kind       string `json:"kind" validate:"required"`       // makes field mandatory and checks if set and non empty
+	// Name is templated, e.g. `{{ .OperatorName }}-{{ .Params.VERSION }}`
+	Name string `json:"name" validate:"required"` // makes field mandatory and checks if set and non empty
+}
+
+// HelmSpec describes a Helm chart rendered when executing a task.
+// Files of the chart are stored among the templates of the operator in a directory named after the chart, with the chart
+// templates directly in it, e.g. `redis/Chart.yaml`, `redis/values.yaml`, `redis/_helpers.tpl` and `redis/deployment.yaml`.
+// Objects of Helm hooks are not applied, pre and post tasks of the step take their place.
+type HelmSpec struct {
+	Chart string `json:"chart" validate:"required"` // makes field mandatory and checks if set and non empty
+
+	// Values override the defaults of the chart, keys are dotted paths like `image.tag` and values are templated,
+	// e.g. `{{ .Params.VERSION }}`. Rendered values are parsed as YAML, so `3` is a number and `true` a boolean.
+	Values map[string]string `json:"values,omitempty"` // no checks needed
+}
+
+// CommandSpec describes a command that is run as a Job when executing a task.
+type CommandSpec struct {
+	Image   string   `json:"image" validate:"required"`                     // makes field mandatory and checks if set and non empty
+	Command []string `json:"command" validate:"required,gt=0,dive,required"` // makes field mandatory and checks if non empty
+
+	// CaptureOutput stores the termination message of the finished command in the status of the step.
+	CaptureOutput bool `json:"captureOutput,omitempty"` // no checks needed
 }
 
 // Phase specifies a list of steps that contain Kubernetes objects.
@@ -107,6 +290,29 @@ type Phase struct {
 
 	// Steps maps a step name to a list of templated Kubernetes objects stored as a string.
 	Steps []Step `json:"steps" validate:"required,gt=0,dive"` // makes field mandatory and checks if its gt 0
+
+	// MaxConcurrency bounds how many steps of a parallel phase are applied at the same time.
+	// When not set, the controller falls back to its own default.
+	MaxConcurrency int `json:"maxConcurrency,omitempty" validate:"omitempty,gte=1"` // makes field optional and checks if its gte 1
+
+	// FailFast makes a parallel phase stop starting its steps as soon as one of them fails, steps that did not start yet
+	// are aborted and started again with the next execution. Steps already running stop before applying their next
+	// object and continue with the next execution, objects applied by steps are kept. By default all the steps are
+	// executed and all their errors are reported.
+	FailFast bool `json:"failFast,omitempty"` // no checks needed
+
+	// BlueGreen configures the rollout of a phase with the blue-green strategy.
+	BlueGreen *BlueGreenSpec `json:"blueGreen,omitempty"` // field optional, no need to validate
+}
+
+// BlueGreenSpec describes how traffic is switched between the colors of a blue-green phase.
+type BlueGreenSpec struct {
+	// Service is the templated name of the Service whose selector is switched to the new color once it is healthy.
+	Service string `json:"service" validate:"required"` // makes field mandatory and checks if set and non empty
+
+	// ProgressDeadlineSeconds is the time the new color has to become healthy, after that it is deleted and the live
+	// color is kept. When not set, the rollout waits for the new color indefinitely.
+	ProgressDeadlineSeconds int `json:"progressDeadlineSeconds,omitempty" validate:"omitempty,gte=1"` // makes field optional and checks if its gte 1
 }
 
 // Step defines a specific set of operations that occur.
@@ -115,10 +321,187 @@ type Step struct {
 	Tasks  []string `json:"tasks" validate:"required,gt=0,dive,required"` // makes field mandatory and checks if non empty
 	Delete bool     `json:"delete,omitempty"`                             // no checks needed
 
+	// Order changes the position of the step in a serial phase, steps are executed from the lowest order to the highest
+	// one. Steps with the same order, including the ones that do not set it, keep the order in which they are defined.
+	Order int `json:"order,omitempty"` // no checks needed
+
+	// DeleteSelector deletes all objects of the given kind matching the selector, in addition to the objects of the step tasks.
+	DeleteSelector *DeleteSelector `json:"deleteSelector,omitempty"` // field optional, no need to validate
+
+	// ForceDelete makes a deleting step wait until its objects are gone and forces the deletion of objects that are stuck
+	// terminating for too long, e.g. a PVC still used by a pod. Forcing the deletion can lose data, so it is never done
+	// unless the step asks for it.
+	ForceDelete *ForceDelete `json:"forceDelete,omitempty"` // field optional, no need to validate
+
+	// WaitForDeletion makes a deleting step wait until its objects are gone before it completes, so that the following
+	// steps do not race against objects that are still terminating. Steps with ForceDelete always wait.
+	WaitForDeletion *WaitForDeletion `json:"waitForDeletion,omitempty"` // field optional, no need to validate
+
+	// GracePeriodSeconds is the time objects deleted by the step get to terminate gracefully, e.g. a long drain window
+	// for pods of a stateful service or zero to delete a throwaway Job immediately. The default grace period of the
+	// objects is used when not set.
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"` // validated by the plan execution
+
+	// Recreate makes the step delete objects that differ from the rendered ones and create them again instead of patching
+	// them, e.g. to re-run a Job. Objects can opt in or out one by one with the kudo.dev/update-strategy annotation.
+	// Objects that hold data, e.g. StatefulSets and PersistentVolumeClaims, are never recreated, the step fails instead.
+	Recreate bool `json:"recreate,omitempty"` // no checks needed
+
+	// Deadline is the time the step has to become healthy, after that it fails and, if the deadline asks for it, first
+	// deletes the objects it created so that a retry starts from a clean state.
+	Deadline *StepDeadline `json:"deadline,omitempty"` // field optional, no need to validate
+
+	// PodHealth makes the step healthy once all the pods matching the selector are ready, instead of checking the health
+	// of the objects of the step, e.g. when the pods belong to a workload managed by another operator. The objects of the
+	// step are still applied.
+	PodHealth *PodHealth `json:"podHealth,omitempty"` // field optional, no need to validate
+
+	// PatchCondition is a template evaluated against the existing object before it is patched, the object is only patched
+	// when the condition renders to "true". The existing object is available as `.Existing` and the rendered one as `.Desired`,
+	// e.g. `{{ lt .Existing.spec.replicas .Desired.spec.replicas }}`. Objects that are not patched are considered healthy.
+	PatchCondition string `json:"patchCondition,omitempty"` // no checks needed
+
+	// MinReadyReplicas makes the step healthy once the sum of ready replicas of all its Deployments and StatefulSets reaches
+	// the minimum, regardless of the health of the individual workloads, e.g. to wait for a quorum of a service spread across
+	// several Deployments. Other objects of the step still have to be healthy on their own.
+	MinReadyReplicas int32 `json:"minReadyReplicas,omitempty" validate:"omitempty,gte=1"` // makes field optional and checks if its gte 1
+
+	// SettleSeconds is the time after the step first applied an object during which the object is not considered healthy
+	// even if its status says so, status of objects that were just created or patched is often stale for a moment, e.g.
+	// a Deployment still reports ready replicas of its previous version. No grace period is applied by default.
+	SettleSeconds int32 `json:"settleSeconds,omitempty"`
+
+	// MinAgeSeconds is the time an object of the step has to exist, counted from its creation timestamp, before the step
+	// considers it healthy, e.g. to give a ConfigMap time to propagate to the pods mounting it before the next step
+	// proceeds. Objects that exist for longer already, e.g. because an earlier execution created them, do not wait.
+	MinAgeSeconds int32 `json:"minAgeSeconds,omitempty" validate:"omitempty,gte=1"` // makes field optional and checks if its gte 1
+
+	// ResourceRetries is the number of times applying a single object of the step may fail in a row before the step
+	// fails. Objects that fail within their budget do not keep the step from applying its other objects, they are
+	// retried with the next execution of the plan. When not set, the first object that fails stops the step and the
+	// step is retried indefinitely.
+	ResourceRetries int32 `json:"resourceRetries,omitempty" validate:"omitempty,gte=1"` // makes field optional and checks if its gte 1
+
+	// TaskConditions are templated conditions of tasks of the step keyed by the task name, a task is applied only when its
+	// condition renders to `true`, e.g. `{{ eq .Params.TLS "true" }}`. Conditions are rendered with the same values as the
+	// templates of the step, except for `.Item` of steps iterating over a list. A step whose tasks are all skipped is
+	// complete without applying anything.
+	TaskConditions map[string]string `json:"taskConditions,omitempty"` // validated by the plan execution
+
+	// PreTasks are applied before the tasks of the step, the tasks of the step are applied only once all the objects of
+	// the pre tasks are healthy. An error of a pre task (e.g. a failed command) fails the step without applying its tasks.
+	PreTasks []string `json:"preTasks,omitempty" validate:"dive,required"` // makes field optional and checks if items are non empty
+	// PostTasks are applied once all the objects of the step tasks are healthy, the step is complete when they are healthy too.
+	PostTasks []string `json:"postTasks,omitempty" validate:"dive,required"` // makes field optional and checks if items are non empty
+
+	// Scale makes the step scale the Deployments and StatefulSets rendered by its tasks instead of applying them, e.g. to
+	// quiesce workloads during maintenance. `Down` scales them to zero and remembers their replica count in the plan
+	// status, `Restore` scales them back to the remembered count. Workloads that do not exist are skipped. Only steps of
+	// serial phases without pre tasks, post tasks or a barrier can scale workloads.
+	Scale ScaleMode `json:"scale,omitempty"` // field optional, validated by the controller
+
+	// ForEach names a list parameter the step iterates over, its tasks are rendered once per item of the list with the
+	// item exposed to templates as `.Item` and its index as `.ItemIndex`. Names of the objects rendered for an item are
+	// suffixed with the index of the item so that each item gets its own objects. An empty list renders no objects.
+	ForEach string `json:"forEach,omitempty"` // field optional, validated by the controller
+
+	// Barrier makes the step wait until all its conditions hold before its tasks are applied, e.g. until objects deleted
+	// by an earlier phase are gone or objects managed outside of the instance are healthy. A barrier step does not need
+	// any tasks, the steps and phases following it do not start before it completes. Plan validation of the controller
+	// accepts barrier steps without tasks even though the tasks are otherwise mandatory.
+	Barrier *Barrier `json:"barrier,omitempty"` // field optional, no need to validate
+
 	// Objects will be serialized for each instance as the params and defaults are provided.
 	Objects []runtime.Object `json:"-"` // no checks needed
 }
 
+// ScaleMode is the way a step scales its workloads.
+type ScaleMode string
+
+const (
+	// ScaleDown scales workloads to zero replicas.
+	ScaleDown ScaleMode = "Down"
+	// ScaleRestore scales workloads back to the replica count they had before they were scaled down.
+	ScaleRestore ScaleMode = "Restore"
+)
+
+// Barrier defines the conditions a barrier step waits for.
+type Barrier struct {
+	Conditions []BarrierCondition `json:"conditions" validate:"required,gt=0,dive"` // makes field mandatory and checks its items
+	// TimeoutSeconds is the time after the start of the step after which the step fails if the conditions still do not
+	// hold. When not set, the step waits indefinitely.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty" validate:"omitempty,gte=1"` // makes field optional and checks if its gte 1
+}
+
+// BarrierCondition is the state a single object has to be in for the barrier to be passed.
+type BarrierCondition struct {
+	APIVersion string `json:"apiVersion" validate:"required"` // makes field mandatory and checks if set and non empty
+	Kind       string `json:"kind" validate:"required"`       // makes field mandatory and checks if set and non empty
+	// Name is a template rendered like the templates of the tasks, e.g. `{{ .Name }}-data`. Objects created by the
+	// instance are prefixed with the instance name, so the rendered name has to include the prefix.
+	Name string `json:"name" validate:"required"` // makes field mandatory and checks if set and non empty
+	// Namespace of the object, the namespace of the instance when not set. It is ignored for cluster scoped kinds.
+	Namespace string `json:"namespace,omitempty"` // no checks needed
+	// State the object has to be in, Healthy by default.
+	State BarrierState `json:"state,omitempty"` // no checks needed
+}
+
+// BarrierState is the state of an object a barrier waits for.
+type BarrierState string
+
+const (
+	// BarrierHealthy waits until the object exists and is healthy.
+	BarrierHealthy BarrierState = "Healthy"
+	// BarrierAbsent waits until the object does not exist.
+	BarrierAbsent BarrierState = "Absent"
+)
+
+// ForceDelete defines when and how the deletion of objects stuck terminating is forced.
+type ForceDelete struct {
+	// AfterSeconds is the time an object can be terminating before its deletion is forced.
+	AfterSeconds int32 `json:"afterSeconds" validate:"required,gte=1"` // makes field mandatory and checks if its gte 1
+	// Finalizers lists the finalizers that are removed from objects whose deletion is forced, other finalizers are kept.
+	// The objects are deleted again with zero grace period either way.
+	Finalizers []string `json:"finalizers,omitempty"` // field optional, no need to validate
+}
+
+// WaitForDeletion defines how long a deleting step waits for its objects to be gone.
+type WaitForDeletion struct {
+	// TimeoutSeconds is the time after the start of the step after which the step fails if its objects are still not gone.
+	// When not set, the step waits indefinitely.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty" validate:"omitempty,gte=1"` // makes field optional and checks if its gte 1
+}
+
+// StepDeadline defines how long a step can be in progress and what happens when it takes longer.
+type StepDeadline struct {
+	// TimeoutSeconds is the time after the start of the step after which the step fails if it is still not healthy.
+	TimeoutSeconds int32 `json:"timeoutSeconds" validate:"gte=1"` // makes field mandatory and checks if its gte 1
+
+	// Rollback makes the step delete the objects it created in the current execution of the plan before it fails. Objects
+	// that existed before the step are left as they are. The step fails only once all of them are deleted, failed
+	// deletions are retried like any other error of the step.
+	Rollback bool `json:"rollback,omitempty"` // no checks needed
+}
+
+// DeleteSelector selects objects of one kind that belong to an instance.
+type DeleteSelector struct {
+	APIVersion string `json:"apiVersion" validate:"required"` // makes field mandatory and checks if set and non empty
+	Kind       string `json:"kind" validate:"required"`       // makes field mandatory and checks if set and non empty
+
+	// MatchLabels are templated labels the objects have to match on top of the labels KUDO puts on all objects of the instance.
+	MatchLabels map[string]string `json:"matchLabels,omitempty"` // no checks needed
+}
+
+// PodHealth selects the pods of an instance whose readiness decides the health of a step.
+type PodHealth struct {
+	// MatchLabels are templated labels the pods have to match on top of the labels KUDO puts on all objects of the instance.
+	MatchLabels map[string]string `json:"matchLabels,omitempty"` // no checks needed
+
+	// AllowNone makes the step healthy when no pod matches the selector, e.g. a workload scaled to zero. By default the
+	// step waits until at least one pod matches.
+	AllowNone bool `json:"allowNone,omitempty"` // no checks needed
+}
+
 // OperatorVersionStatus defines the observed state of OperatorVersion.
 type OperatorVersionStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
@@ -19,6 +19,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -38,6 +39,92 @@ func (in *AggregatedStatus) DeepCopy() *AggregatedStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppliedObject) DeepCopyInto(out *AppliedObject) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppliedObject.
+func (in *AppliedObject) DeepCopy() *AppliedObject {
+	if in == nil {
+		return nil
+	}
+	out := new(AppliedObject)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Barrier) DeepCopyInto(out *Barrier) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]BarrierCondition, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Barrier.
+func (in *Barrier) DeepCopy() *Barrier {
+	if in == nil {
+		return nil
+	}
+	out := new(Barrier)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BarrierCondition) DeepCopyInto(out *BarrierCondition) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BarrierCondition.
+func (in *BarrierCondition) DeepCopy() *BarrierCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(BarrierCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlueGreenSpec) DeepCopyInto(out *BlueGreenSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueGreenSpec.
+func (in *BlueGreenSpec) DeepCopy() *BlueGreenSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BlueGreenSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlueGreenStatus) DeepCopyInto(out *BlueGreenStatus) {
+	*out = *in
+	in.RolloutStarted.DeepCopyInto(&out.RolloutStarted)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlueGreenStatus.
+func (in *BlueGreenStatus) DeepCopy() *BlueGreenStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BlueGreenStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Command) DeepCopyInto(out *Command) {
 	*out = *in
@@ -54,6 +141,27 @@ func (in *Command) DeepCopy() *Command {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CommandSpec) DeepCopyInto(out *CommandSpec) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CommandSpec.
+func (in *CommandSpec) DeepCopy() *CommandSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CommandSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Config) DeepCopyInto(out *Config) {
 	*out = *in
@@ -80,6 +188,29 @@ func (in *Config) DeepCopy() *Config {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeleteSelector) DeepCopyInto(out *DeleteSelector) {
+	*out = *in
+	if in.MatchLabels != nil {
+		in, out := &in.MatchLabels, &out.MatchLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeleteSelector.
+func (in *DeleteSelector) DeepCopy() *DeleteSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(DeleteSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Discovery) DeepCopyInto(out *Discovery) {
 	*out = *in
@@ -106,6 +237,43 @@ func (in *Discovery) DeepCopy() *Discovery {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecutionError) DeepCopyInto(out *ExecutionError) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecutionError.
+func (in *ExecutionError) DeepCopy() *ExecutionError {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecutionError)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForceDelete) DeepCopyInto(out *ForceDelete) {
+	*out = *in
+	if in.Finalizers != nil {
+		in, out := &in.Finalizers, &out.Finalizers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForceDelete.
+func (in *ForceDelete) DeepCopy() *ForceDelete {
+	if in == nil {
+		return nil
+	}
+	out := new(ForceDelete)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HealthCheck) DeepCopyInto(out *HealthCheck) {
 	*out = *in
@@ -127,6 +295,29 @@ func (in *HealthCheck) DeepCopy() *HealthCheck {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HelmSpec) DeepCopyInto(out *HelmSpec) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HelmSpec.
+func (in *HelmSpec) DeepCopy() *HelmSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HelmSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostVolume) DeepCopyInto(out *HostVolume) {
 	*out = *in
@@ -249,6 +440,13 @@ func (in *InstanceStatus) DeepCopyInto(out *InstanceStatus) {
 		}
 	}
 	out.AggregatedStatus = in.AggregatedStatus
+	if in.AppliedParameters != nil {
+		in, out := &in.AppliedParameters, &out.AppliedParameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -591,6 +789,11 @@ func (in *Parameter) DeepCopyInto(out *Parameter) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.RequiredWhen != nil {
+		in, out := &in.RequiredWhen, &out.RequiredWhen
+		*out = make([]ParameterCondition, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -604,6 +807,48 @@ func (in *Parameter) DeepCopy() *Parameter {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ParameterChange) DeepCopyInto(out *ParameterChange) {
+	*out = *in
+	if in.Old != nil {
+		in, out := &in.Old, &out.Old
+		*out = new(string)
+		**out = **in
+	}
+	if in.New != nil {
+		in, out := &in.New, &out.New
+		*out = new(string)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ParameterChange.
+func (in *ParameterChange) DeepCopy() *ParameterChange {
+	if in == nil {
+		return nil
+	}
+	out := new(ParameterChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ParameterCondition) DeepCopyInto(out *ParameterCondition) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ParameterCondition.
+func (in *ParameterCondition) DeepCopy() *ParameterCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(ParameterCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Phase) DeepCopyInto(out *Phase) {
 	*out = *in
@@ -614,6 +859,11 @@ func (in *Phase) DeepCopyInto(out *Phase) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.BlueGreen != nil {
+		in, out := &in.BlueGreen, &out.BlueGreen
+		*out = new(BlueGreenSpec)
+		**out = **in
+	}
 	return
 }
 
@@ -633,7 +883,9 @@ func (in *PhaseStatus) DeepCopyInto(out *PhaseStatus) {
 	if in.Steps != nil {
 		in, out := &in.Steps, &out.Steps
 		*out = make([]StepStatus, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	return
 }
@@ -658,6 +910,18 @@ func (in *Plan) DeepCopyInto(out *Plan) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Profiles != nil {
+		in, out := &in.Profiles, &out.Profiles
+		*out = make([]PlanProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Dependencies != nil {
+		in, out := &in.Dependencies, &out.Dependencies
+		*out = make([]PlanDependency, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -671,6 +935,65 @@ func (in *Plan) DeepCopy() *Plan {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlanCondition) DeepCopyInto(out *PlanCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlanCondition.
+func (in *PlanCondition) DeepCopy() *PlanCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(PlanCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlanDependency) DeepCopyInto(out *PlanDependency) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlanDependency.
+func (in *PlanDependency) DeepCopy() *PlanDependency {
+	if in == nil {
+		return nil
+	}
+	out := new(PlanDependency)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlanProfile) DeepCopyInto(out *PlanProfile) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlanProfile.
+func (in *PlanProfile) DeepCopy() *PlanProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(PlanProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PlanStatus) DeepCopyInto(out *PlanStatus) {
 	*out = *in
@@ -682,6 +1005,47 @@ func (in *PlanStatus) DeepCopyInto(out *PlanStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.BlueGreen != nil {
+		in, out := &in.BlueGreen, &out.BlueGreen
+		*out = make(map[string]BlueGreenStatus, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Partitions != nil {
+		in, out := &in.Partitions, &out.Partitions
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ScaledReplicas != nil {
+		in, out := &in.ScaledReplicas, &out.ScaledReplicas
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LastError != nil {
+		in, out := &in.LastError, &out.LastError
+		*out = new(ExecutionError)
+		**out = **in
+	}
+	if in.ParameterChanges != nil {
+		in, out := &in.ParameterChanges, &out.ParameterChanges
+		*out = make([]ParameterChange, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.LastProgressTime.DeepCopyInto(&out.LastProgressTime)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]PlanCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -847,6 +1211,29 @@ func (in *Pod) DeepCopy() *Pod {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodHealth) DeepCopyInto(out *PodHealth) {
+	*out = *in
+	if in.MatchLabels != nil {
+		in, out := &in.MatchLabels, &out.MatchLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodHealth.
+func (in *PodHealth) DeepCopy() *PodHealth {
+	if in == nil {
+		return nil
+	}
+	out := new(PodHealth)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Port) DeepCopyInto(out *Port) {
 	*out = *in
@@ -1092,6 +1479,58 @@ func (in *Step) DeepCopyInto(out *Step) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.DeleteSelector != nil {
+		in, out := &in.DeleteSelector, &out.DeleteSelector
+		*out = new(DeleteSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ForceDelete != nil {
+		in, out := &in.ForceDelete, &out.ForceDelete
+		*out = new(ForceDelete)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WaitForDeletion != nil {
+		in, out := &in.WaitForDeletion, &out.WaitForDeletion
+		*out = new(WaitForDeletion)
+		**out = **in
+	}
+	if in.GracePeriodSeconds != nil {
+		in, out := &in.GracePeriodSeconds, &out.GracePeriodSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Deadline != nil {
+		in, out := &in.Deadline, &out.Deadline
+		*out = new(StepDeadline)
+		**out = **in
+	}
+	if in.PodHealth != nil {
+		in, out := &in.PodHealth, &out.PodHealth
+		*out = new(PodHealth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TaskConditions != nil {
+		in, out := &in.TaskConditions, &out.TaskConditions
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PreTasks != nil {
+		in, out := &in.PreTasks, &out.PreTasks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PostTasks != nil {
+		in, out := &in.PostTasks, &out.PostTasks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Barrier != nil {
+		in, out := &in.Barrier, &out.Barrier
+		*out = new(Barrier)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Objects != nil {
 		in, out := &in.Objects, &out.Objects
 		*out = make([]runtime.Object, len(*in))
@@ -1114,9 +1553,66 @@ func (in *Step) DeepCopy() *Step {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StepDeadline) DeepCopyInto(out *StepDeadline) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StepDeadline.
+func (in *StepDeadline) DeepCopy() *StepDeadline {
+	if in == nil {
+		return nil
+	}
+	out := new(StepDeadline)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StepStatus) DeepCopyInto(out *StepStatus) {
 	*out = *in
+	in.StartedAt.DeepCopyInto(&out.StartedAt)
+	if in.AppliedAt != nil {
+		in, out := &in.AppliedAt, &out.AppliedAt
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ResourceAttempts != nil {
+		in, out := &in.ResourceAttempts, &out.ResourceAttempts
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Drift != nil {
+		in, out := &in.Drift, &out.Drift
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Created != nil {
+		in, out := &in.Created, &out.Created
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Applied != nil {
+		in, out := &in.Applied, &out.Applied
+		*out = make(map[string]AppliedObject, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Healthy != nil {
+		in, out := &in.Healthy, &out.Healthy
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -1252,6 +1748,22 @@ func (in *Task) DeepCopy() *Task {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskOwner) DeepCopyInto(out *TaskOwner) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskOwner.
+func (in *TaskOwner) DeepCopy() *TaskOwner {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskOwner)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TaskSpec) DeepCopyInto(out *TaskSpec) {
 	*out = *in
@@ -1260,6 +1772,21 @@ func (in *TaskSpec) DeepCopyInto(out *TaskSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = new(CommandSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Helm != nil {
+		in, out := &in.Helm, &out.Helm
+		*out = new(HelmSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Owner != nil {
+		in, out := &in.Owner, &out.Owner
+		*out = new(TaskOwner)
+		**out = **in
+	}
 	return
 }
 
@@ -1459,3 +1986,19 @@ func (in *Volume) DeepCopy() *Volume {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WaitForDeletion) DeepCopyInto(out *WaitForDeletion) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WaitForDeletion.
+func (in *WaitForDeletion) DeepCopy() *WaitForDeletion {
+	if in == nil {
+		return nil
+	}
+	out := new(WaitForDeletion)
+	in.DeepCopyInto(out)
+	return out
+}
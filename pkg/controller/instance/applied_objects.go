@@ -0,0 +1,47 @@
+package instance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apijson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// objectDigest returns the sha256 digest of the object serialized as JSON
+func objectDigest(obj runtime.Object) (string, error) {
+	data, err := apijson.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// isUnchanged returns true if the step applied the object with the same content before and nobody changed the object
+// since, patching it would not change anything then. Objects are compared by the digest of their rendered content, the
+// version of the live object tells whether it was changed after it was applied, e.g. by another plan or by a user.
+func isUnchanged(state *v1alpha1.StepStatus, objKey string, digest string, existing runtime.Object) bool {
+	applied, ok := state.Applied[objKey]
+	if !ok || applied.Digest != digest {
+		return false
+	}
+	return applied.ResourceVersion == existing.(metav1.Object).GetResourceVersion()
+}
+
+// recordApplied remembers the digest the object was applied with together with the version of the object the API
+// server returned, see isUnchanged. Objects without a version cannot be told apart from ones changed since, nothing is
+// recorded for them and they are always patched.
+func recordApplied(state *v1alpha1.StepStatus, objKey string, digest string, current runtime.Object) {
+	version := current.(metav1.Object).GetResourceVersion()
+	if version == "" {
+		delete(state.Applied, objKey)
+		return
+	}
+	if state.Applied == nil {
+		state.Applied = make(map[string]v1alpha1.AppliedObject)
+	}
+	state.Applied[objKey] = v1alpha1.AppliedObject{Digest: digest, ResourceVersion: version}
+}
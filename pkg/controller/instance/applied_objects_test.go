@@ -0,0 +1,73 @@
+package instance
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecuteStepPatchesOnlyChangedObjects(t *testing.T) {
+	rendered := func(name, value string) *corev1.ConfigMap {
+		cm := getConfigMap(name, "default", nil)
+		cm.Data = map[string]string{"value": value}
+		return cm
+	}
+	live := func(name, value, version string) *corev1.ConfigMap {
+		cm := rendered(name, value)
+		cm.ResourceVersion = version
+		return cm
+	}
+	digest := func(obj runtime.Object) string {
+		d, err := objectDigest(obj)
+		if err != nil {
+			t.Fatalf("Expecting no error computing digest but got %v", err)
+		}
+		return d
+	}
+
+	objs := []runtime.Object{rendered("unchanged", "same"), rendered("changed", "new"), rendered("modified", "same")}
+	testClient := &patchRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		live("unchanged", "same", "1"),
+		live("changed", "old", "1"),
+		// changed outside of KUDO after the step applied it
+		live("modified", "other", "2"),
+	)}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress, Applied: map[string]v1alpha1.AppliedObject{
+		"ConfigMap/default/unchanged": {Digest: digest(objs[0]), ResourceVersion: "1"},
+		"ConfigMap/default/changed":   {Digest: digest(rendered("changed", "old")), ResourceVersion: "1"},
+		"ConfigMap/default/modified":  {Digest: digest(objs[2]), ResourceVersion: "1"},
+	}}
+
+	if err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, objs, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step to be completed but got %v", state.Status)
+	}
+	if !reflect.DeepEqual(testClient.patched, []string{"changed", "modified"}) {
+		t.Errorf("Expecting only changed and modified to be patched but got %v", testClient.patched)
+	}
+	expected := map[string]v1alpha1.AppliedObject{
+		"ConfigMap/default/unchanged": {Digest: digest(objs[0]), ResourceVersion: "1"},
+		"ConfigMap/default/changed":   {Digest: digest(objs[1]), ResourceVersion: "1"},
+		"ConfigMap/default/modified":  {Digest: digest(objs[2]), ResourceVersion: "2"},
+	}
+	if !reflect.DeepEqual(state.Applied, expected) {
+		t.Errorf("Expecting applied objects %v but got %v", expected, state.Applied)
+	}
+}
+
+func TestRecordAppliedWithoutVersion(t *testing.T) {
+	state := &v1alpha1.StepStatus{Name: "step", Applied: map[string]v1alpha1.AppliedObject{"ConfigMap/default/config": {Digest: "old", ResourceVersion: "1"}}}
+	recordApplied(state, "ConfigMap/default/config", "new", getConfigMap("config", "default", nil))
+	if _, ok := state.Applied["ConfigMap/default/config"]; ok {
+		t.Errorf("Expecting object without a version not to be recorded but got %v", state.Applied)
+	}
+}
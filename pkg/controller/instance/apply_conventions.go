@@ -2,16 +2,27 @@ package instance
 
 import (
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 
 	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/json"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 
 	"github.com/pkg/errors"
 
 	"github.com/kudobuilder/kudo/pkg/util/template"
 	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/kustomize/k8sdeps/kunstruct"
 	"sigs.k8s.io/kustomize/k8sdeps/transformer"
 	"sigs.k8s.io/kustomize/pkg/fs"
@@ -21,6 +32,7 @@ import (
 	"sigs.k8s.io/kustomize/pkg/resource"
 	"sigs.k8s.io/kustomize/pkg/target"
 	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
 const basePath = "/kustomize"
@@ -34,6 +46,59 @@ type metadata struct {
 	PlanName        string
 	PhaseName       string
 	StepName        string
+	TaskName        string
+	// Color is set for objects of blue-green phases, it is added to names and labels so that both colors can coexist
+	Color string
+	// Item is set for objects rendered for an item of a list parameter, it is added to names so that each item gets its
+	// own objects
+	Item string
+	// Containers are merged into the pod templates of the objects by their name, see addContainersPatches
+	Containers []interface{}
+	// SchedulingPolicy is merged into the pod specs of the objects, see mergeSchedulingPolicy
+	SchedulingPolicy *SchedulingPolicy
+	// LabelKeys are the keys of the labels and annotations added to the objects
+	LabelKeys LabelKeys
+}
+
+// nameSuffix returns the suffix added to names of all the objects, it is made of the item and the color, if set
+func (m metadata) nameSuffix() string {
+	suffix := ""
+	if m.Item != "" {
+		suffix += "-" + m.Item
+	}
+	if m.Color != "" {
+		suffix += "-" + m.Color
+	}
+	return suffix
+}
+
+// conventionLabels returns the labels KUDO adds to all the objects
+func conventionLabels(m metadata) map[string]string {
+	keys := m.LabelKeys.withDefaults()
+	labels := map[string]string{
+		keys.Heritage: "kudo",
+		keys.Operator: m.OperatorName,
+		keys.Instance: m.InstanceName,
+	}
+	if m.Color != "" {
+		labels[keys.Color] = m.Color
+	}
+	return labels
+}
+
+// conventionAnnotations returns the annotations KUDO adds to all the objects
+func conventionAnnotations(m metadata) map[string]string {
+	keys := m.LabelKeys.withDefaults()
+	annotations := map[string]string{
+		keys.Plan:            m.PlanName,
+		keys.Phase:           m.PhaseName,
+		keys.Step:            m.StepName,
+		keys.OperatorVersion: m.OperatorVersion,
+	}
+	if m.TaskName != "" {
+		annotations[keys.Task] = m.TaskName
+	}
+	return annotations
 }
 
 // kubernetesObjectEnhancer takes your kubernetes template and kudo related metadata and applies them to all resources in form of labels
@@ -46,48 +111,122 @@ type kubernetesObjectEnhancer interface {
 // kustomizeEnhancer is implementation of kubernetesObjectEnhancer that uses kustomize to apply the defined conventions
 type kustomizeEnhancer struct {
 	scheme *runtime.Scheme
+	// log receives the generated kustomization and the kustomize output at verbosity 1 for debugging of the conventions
+	// the controller-runtime logger is used when not set
+	log logr.Logger
+	// scopes tells which objects are cluster scoped, all objects are treated as namespaced when not set
+	scopes *scopeCache
+	// fallback makes the conventions be applied directly to the parsed objects when kustomize fails, see
+	// applyConventionsDirectly
+	fallback bool
 }
 
 // ApplyConventions accepts templates to be rendered in kubernetes and enhances them with our own KUDO conventions
 // These include the way we name our objects and what labels we apply to them
-func (k *kustomizeEnhancer) applyConventionsToTemplates(templates map[string]string, metadata metadata, owner v1.Object) (objsToAdd []runtime.Object, err error) {
-	fsys := fs.MakeFakeFS()
-
-	templateNames := make([]string, 0, len(templates))
+func (k *kustomizeEnhancer) applyConventionsToTemplates(templates map[string]string, metadata metadata, owner v1.Object) ([]runtime.Object, error) {
+	objs, err := k.kustomize(templates, metadata)
+	if err != nil {
+		if !k.fallback {
+			return nil, err
+		}
+		log.Printf("PlanExecution: Warning: kustomize failed to apply conventions to step %s of plan %s of instance %s/%s, applying them directly: %v", metadata.StepName, metadata.PlanName, metadata.Namespace, metadata.InstanceName, err)
+		objs, err = applyConventionsDirectly(templates, metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for k, v := range templates {
-		templateNames = append(templateNames, k)
-		err := fsys.WriteFile(fmt.Sprintf("%s/%s", basePath, k), []byte(v))
+	for _, o := range objs {
+		namespaced, err := k.isNamespaced(o)
 		if err != nil {
-			return nil, errors.Wrapf(err, "error when writing templates to filesystem before applying kustomize")
+			return nil, err
+		}
+		if !namespaced {
+			// kustomize knows the scope of built-in kinds only, so cluster scoped custom resources got the namespace too
+			o.(v1.Object).SetNamespace("")
+			// the namespaced instance cannot own cluster scoped objects, they are not garbage collected with it
+			continue
+		}
+		if o.(v1.Object).GetAnnotations()[kudo.OwnerReferenceAnnotation] == kudo.OwnerReferenceNoneValue {
+			continue
+		}
+		err = setOwnerReference(owner, o, k.scheme)
+		if err != nil {
+			return nil, errors.Wrapf(err, "setting owner reference on parsed object")
 		}
 	}
 
+	return objs, nil
+}
+
+// kustomize renders the templates with kustomize that adds the conventions to the objects and rewrites references
+// between them to the new names, objects are not owned by anything yet
+func (k *kustomizeEnhancer) kustomize(templates map[string]string, metadata metadata) (objsToAdd []runtime.Object, err error) {
+	fsys := fs.MakeFakeFS()
+
 	kustomization := &ktypes.Kustomization{
-		NamePrefix: metadata.InstanceName + "-",
-		Namespace:  metadata.Namespace,
-		CommonLabels: map[string]string{
-			kudo.HeritageLabel: "kudo",
-			kudo.OperatorLabel: metadata.OperatorName,
-			kudo.InstanceLabel: metadata.InstanceName,
-		},
-		CommonAnnotations: map[string]string{
-			kudo.PlanAnnotation:            metadata.PlanName,
-			kudo.PhaseAnnotation:           metadata.PhaseName,
-			kudo.StepAnnotation:            metadata.StepName,
-			kudo.OperatorVersionAnnotation: metadata.OperatorVersion,
-		},
+		NamePrefix:        metadata.InstanceName + "-",
+		NameSuffix:        metadata.nameSuffix(),
+		Namespace:         metadata.Namespace,
+		CommonLabels:      conventionLabels(metadata),
+		CommonAnnotations: conventionAnnotations(metadata),
 		GeneratorOptions: &ktypes.GeneratorOptions{
 			DisableNameSuffixHash: true,
 		},
-		Resources:             templateNames,
+		Resources:             make([]string, 0, len(templates)),
 		PatchesStrategicMerge: []patch.StrategicMerge{},
 	}
 
+	var hashed []v1.Object
+	for k, v := range templates {
+		obj, err := hashedObject(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing template %s", k)
+		}
+		if obj != nil {
+			// generators of kustomize drop the annotations, they are restored from the object by restoreHashedMetadata
+			obj.SetAnnotations(withAnnotation(obj.GetAnnotations(), kudo.TemplateAnnotation, k))
+			// only objects produced by generators get the hash suffix, so it's safe to enable it for the whole kustomization
+			if err := addHashedGenerator(fsys, kustomization, k, obj); err != nil {
+				return nil, err
+			}
+			kustomization.GeneratorOptions.DisableNameSuffixHash = false
+			hashed = append(hashed, obj)
+			continue
+		}
+
+		// kustomize keeps annotations of the resources, so the template name added here survives it
+		v, err = annotateTemplate(v, k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing template %s", k)
+		}
+		if v == "" {
+			// e.g. an empty list
+			continue
+		}
+		kustomization.Resources = append(kustomization.Resources, k)
+		err = fsys.WriteFile(fmt.Sprintf("%s/%s", basePath, k), []byte(v))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error when writing templates to filesystem before applying kustomize")
+		}
+		if len(metadata.Containers) > 0 {
+			if err := addContainersPatches(fsys, kustomization, k, v, metadata.Containers); err != nil {
+				return nil, err
+			}
+		}
+		if metadata.SchedulingPolicy != nil {
+			if err := addSchedulingPatches(fsys, kustomization, k, v, metadata.SchedulingPolicy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	yamlBytes, err := yaml.Marshal(kustomization)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error marshalling kustomize yaml")
 	}
+	debugLog := k.debugLogger(metadata)
+	debugLog.Info("Generated kustomization", "kustomization", string(yamlBytes))
 
 	err = fsys.WriteFile(fmt.Sprintf("%s/kustomization.yaml", basePath), yamlBytes)
 	if err != nil {
@@ -124,20 +263,181 @@ func (k *kustomizeEnhancer) applyConventionsToTemplates(templates map[string]str
 	if err != nil {
 		return nil, errors.Wrapf(err, "error parsing kubernetes objects after applying kustomize")
 	}
+	if debugLog.Enabled() {
+		// the output contains data of secrets, so the parsed objects are logged redacted instead
+		debugLog.Info("Kustomize output", "output", redactedYAML(objsToAdd))
+	}
+	restoreHashedMetadata(objsToAdd, hashed, metadata)
+	return objsToAdd, nil
+}
 
-	for _, o := range objsToAdd {
-		err = setControllerReference(owner, o, k.scheme)
+// annotateTemplate adds the annotation with the name of the template to all the objects of the rendered template
+// lists of objects, e.g. a `v1/List`, are replaced by their items so that the conventions and the owner reference are
+// applied to the items, a list itself cannot be applied
+func annotateTemplate(rendered string, name string) (string, error) {
+	var docs []string
+	for _, doc := range strings.Split(rendered, "---") {
+		jsonDoc, err := k8syaml.ToJSON([]byte(doc))
 		if err != nil {
-			return nil, errors.Wrapf(err, "setting controller reference on parsed object")
+			return "", err
+		}
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(jsonDoc, &obj); err != nil {
+			return "", err
+		}
+		if len(obj) == 0 {
+			// empty document
+			continue
+		}
+		for _, item := range listItems(obj) {
+			annotations, _, err := unstructured.NestedStringMap(item, "metadata", "annotations")
+			if err != nil {
+				return "", err
+			}
+			if err := unstructured.SetNestedStringMap(item, withAnnotation(annotations, kudo.TemplateAnnotation, name), "metadata", "annotations"); err != nil {
+				return "", err
+			}
+			annotated, err := json.Marshal(item)
+			if err != nil {
+				return "", err
+			}
+			// kustomize reads files starting like JSON as a single JSON document
+			annotated, err = sigsyaml.JSONToYAML(annotated)
+			if err != nil {
+				return "", err
+			}
+			docs = append(docs, string(annotated))
 		}
 	}
+	return strings.Join(docs, "---\n"), nil
+}
 
-	return objsToAdd, nil
+// listItems returns the items of a list of objects, nested lists are flattened too, any other object is returned as is
+func listItems(obj map[string]interface{}) []map[string]interface{} {
+	if !(&unstructured.Unstructured{Object: obj}).IsList() {
+		return []map[string]interface{}{obj}
+	}
+	var items []map[string]interface{}
+	for _, item := range obj["items"].([]interface{}) {
+		if itemObj, ok := item.(map[string]interface{}); ok && len(itemObj) > 0 {
+			items = append(items, listItems(itemObj)...)
+		}
+	}
+	return items
+}
+
+// withAnnotation returns the annotations with the given one added
+func withAnnotation(annotations map[string]string, key, value string) map[string]string {
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[key] = value
+	return annotations
+}
+
+// isNamespaced returns true if the object lives in a namespace
+func (k *kustomizeEnhancer) isNamespaced(obj runtime.Object) (bool, error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if k.scopes == nil || gvk.Empty() {
+		return true, nil
+	}
+	namespaced, err := k.scopes.isNamespaced(gvk)
+	if err != nil {
+		return false, errors.Wrapf(err, "error determining scope of %s %s", gvk.Kind, obj.(v1.Object).GetName())
+	}
+	return namespaced, nil
+}
+
+// debugLogger returns logger enabled only at verbosity 1 with the metadata of the rendered step as values
+func (k *kustomizeEnhancer) debugLogger(metadata metadata) logr.InfoLogger {
+	l := k.log
+	if l == nil {
+		l = logf.Log.WithName("kustomize")
+	}
+	return l.WithValues("instance", metadata.InstanceName, "namespace", metadata.Namespace, "plan", metadata.PlanName, "phase", metadata.PhaseName, "step", metadata.StepName).V(1)
 }
 
-func setControllerReference(owner v1.Object, obj runtime.Object, scheme *runtime.Scheme) error {
-	if err := controllerutil.SetControllerReference(owner, obj.(v1.Object), scheme); err != nil {
+// setOwnerReference adds the owner to the owner references of the object, the owner is the controller of the object and
+// blocks its own deletion until the object is gone unless the annotations of the object ask otherwise, see
+// kudo.OwnerReferenceAnnotation and kudo.BlockOwnerDeletionAnnotation. A reference to the same owner already listed in
+// the template is replaced. An object can have at most one controller.
+func setOwnerReference(owner v1.Object, obj runtime.Object, scheme *runtime.Scheme) error {
+	objMeta := obj.(v1.Object)
+	annotations := objMeta.GetAnnotations()
+
+	controller := true
+	switch value := annotations[kudo.OwnerReferenceAnnotation]; value {
+	case "", kudo.OwnerReferenceControllerValue:
+	case kudo.OwnerReferenceOwnerValue:
+		controller = false
+	default:
+		return fmt.Errorf("%s annotation of %s has unknown value %q", kudo.OwnerReferenceAnnotation, objMeta.GetName(), value)
+	}
+	blockOwnerDeletion := true
+	if value, ok := annotations[kudo.BlockOwnerDeletionAnnotation]; ok {
+		var err error
+		if blockOwnerDeletion, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s annotation of %s must be true or false but is %q", kudo.BlockOwnerDeletionAnnotation, objMeta.GetName(), value)
+		}
+	}
+
+	ownerObj, ok := owner.(runtime.Object)
+	if !ok {
+		return fmt.Errorf("owner %s is not a runtime.Object", owner.GetName())
+	}
+	gvk, err := apiutil.GVKForObject(ownerObj, scheme)
+	if err != nil {
 		return err
 	}
+	ref := v1.OwnerReference{
+		APIVersion:         gvk.GroupVersion().String(),
+		Kind:               gvk.Kind,
+		Name:               owner.GetName(),
+		UID:                owner.GetUID(),
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
+
+	refs := []v1.OwnerReference{}
+	controllers := 0
+	for _, r := range objMeta.GetOwnerReferences() {
+		if r.Kind == ref.Kind && r.Name == ref.Name && groupOf(r.APIVersion) == gvk.Group {
+			continue
+		}
+		if r.Controller != nil && *r.Controller {
+			controllers++
+		}
+		refs = append(refs, r)
+	}
+	if controller {
+		controllers++
+	}
+	if controllers > 1 {
+		return fmt.Errorf("%s would have %d controller owners, at most one is allowed, set %s annotation to %q to make %s %s only an owner", objMeta.GetName(), controllers, kudo.OwnerReferenceAnnotation, kudo.OwnerReferenceOwnerValue, gvk.Kind, owner.GetName())
+	}
+	objMeta.SetOwnerReferences(append(refs, ref))
 	return nil
 }
+
+// groupOf returns the group of the API version, empty for the core group
+func groupOf(apiVersion string) string {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return ""
+	}
+	return gv.Group
+}
+
+// instanceMetadata returns the labels or annotations of the instance that templates can copy to the objects they render
+// keys reserved by KUDO are left out, and KUDO labels and annotations set by the conventions win over the ones copied by
+// templates anyway, so an instance cannot change how KUDO tracks the objects it owns
+func instanceMetadata(m map[string]string, keys LabelKeys) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		if keys.isReserved(k) || k == corev1.LastAppliedConfigAnnotation {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
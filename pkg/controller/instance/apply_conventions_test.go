@@ -0,0 +1,473 @@
+package instance
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// recordingLogger keeps messages logged up to the given verbosity
+type recordingLogger struct {
+	verbosity int
+	level     int
+	messages  *[]string
+}
+
+func (l recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	if l.Enabled() {
+		*l.messages = append(*l.messages, fmt.Sprint(msg, keysAndValues))
+	}
+}
+
+func (l recordingLogger) Enabled() bool { return l.level <= l.verbosity }
+
+func (l recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {}
+
+func (l recordingLogger) V(level int) logr.InfoLogger {
+	return recordingLogger{verbosity: l.verbosity, level: level, messages: l.messages}
+}
+
+func (l recordingLogger) WithValues(keysAndValues ...interface{}) logr.Logger { return l }
+
+func (l recordingLogger) WithName(name string) logr.Logger { return l }
+
+func TestApplyConventionsDebugOutput(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{
+		InstanceName:    "instance",
+		Namespace:       "default",
+		OperatorName:    "operator",
+		OperatorVersion: "0.1.0",
+		PlanName:        "deploy",
+		PhaseName:       "phase",
+		StepName:        "step",
+	}
+	templates := map[string]string{"pod.yaml": getResourceAsString(getPod("pod", "default"))}
+
+	tests := []struct {
+		name      string
+		verbosity int
+		expected  []string
+	}{
+		{"debug output", 1, []string{
+			"Generated kustomization",
+			fmt.Sprintf("%s: instance", kudo.InstanceLabel),
+			fmt.Sprintf("%s: operator", kudo.OperatorLabel),
+			fmt.Sprintf("%s: kudo", kudo.HeritageLabel),
+			"Kustomize output",
+			"name: instance-pod",
+		}},
+		{"no debug output by default", 0, nil},
+	}
+
+	for _, tt := range tests {
+		var messages []string
+		enhancer := &kustomizeEnhancer{scheme: s, log: recordingLogger{verbosity: tt.verbosity, messages: &messages}}
+
+		_, err := enhancer.applyConventionsToTemplates(templates, meta, owner)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if len(tt.expected) == 0 && len(messages) != 0 {
+			t.Errorf("%s: Expecting no debug output but got %v", tt.name, messages)
+		}
+		output := strings.Join(messages, "\n")
+		for _, expected := range tt.expected {
+			if !strings.Contains(output, expected) {
+				t.Errorf("%s: Expecting debug output to contain %q but got %s", tt.name, expected, output)
+			}
+		}
+	}
+}
+
+func TestApplyConventionsSkipsOwnerReference(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", PlanName: "deploy", PhaseName: "phase", StepName: "step"}
+	enhancer := &kustomizeEnhancer{scheme: s}
+
+	retained := getConfigMap("data", "default", nil)
+	retained.Annotations = map[string]string{kudo.OwnerReferenceAnnotation: kudo.OwnerReferenceNoneValue}
+	templates := map[string]string{
+		"data.yaml":   getResourceAsString(retained),
+		"config.yaml": getResourceAsString(getConfigMap("config", "default", nil)),
+	}
+
+	objs, err := enhancer.applyConventionsToTemplates(templates, meta, owner)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	for _, o := range objs {
+		objMeta := o.(metav1.Object)
+		controller := metav1.GetControllerOf(objMeta)
+		switch objMeta.GetName() {
+		case "instance-data":
+			if len(objMeta.GetOwnerReferences()) != 0 {
+				t.Errorf("Expecting no owner reference on %s but got %v", objMeta.GetName(), objMeta.GetOwnerReferences())
+			}
+			if objMeta.GetLabels()[kudo.InstanceLabel] != "instance" {
+				t.Errorf("Expecting KUDO labels on %s but got %v", objMeta.GetName(), objMeta.GetLabels())
+			}
+		case "instance-config":
+			if controller == nil || controller.UID != owner.UID {
+				t.Errorf("Expecting instance to be the controller of %s but got %v", objMeta.GetName(), controller)
+			}
+		default:
+			t.Errorf("Expecting only the two ConfigMaps but got %s", objMeta.GetName())
+		}
+	}
+}
+
+func TestApplyConventionsOwnerReferenceFlags(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", PlanName: "deploy", PhaseName: "phase", StepName: "step"}
+	isController := true
+	otherController := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "other", UID: "other-uid", Controller: &isController}
+
+	tests := []struct {
+		name               string
+		annotations        map[string]string
+		existing           []metav1.OwnerReference
+		expectedController bool
+		expectedBlock      bool
+		expectedRefs       int
+		expectedErr        string
+	}{
+		{"default", nil, nil, true, true, 1, ""},
+		{"explicit controller", map[string]string{kudo.OwnerReferenceAnnotation: kudo.OwnerReferenceControllerValue}, nil, true, true, 1, ""},
+		{"not blocking deletion", map[string]string{kudo.BlockOwnerDeletionAnnotation: "false"}, nil, true, false, 1, ""},
+		{"owner next to another controller", map[string]string{kudo.OwnerReferenceAnnotation: kudo.OwnerReferenceOwnerValue}, []metav1.OwnerReference{otherController}, false, true, 2, ""},
+		{"two controllers", nil, []metav1.OwnerReference{otherController}, false, false, 0, "at most one is allowed"},
+		{"unknown owner reference", map[string]string{kudo.OwnerReferenceAnnotation: "parent"}, nil, false, false, 0, "unknown value"},
+		{"invalid block owner deletion", map[string]string{kudo.BlockOwnerDeletionAnnotation: "maybe"}, nil, false, false, 0, "must be true or false"},
+	}
+
+	for _, tt := range tests {
+		cm := getConfigMap("config", "default", nil)
+		cm.Annotations = tt.annotations
+		cm.OwnerReferences = tt.existing
+		objs, err := (&kustomizeEnhancer{scheme: s}).applyConventionsToTemplates(map[string]string{"config.yaml": getResourceAsString(cm)}, meta, owner)
+		if tt.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("%s: Expecting error containing %q but got %v", tt.name, tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+
+		refs := objs[0].(metav1.Object).GetOwnerReferences()
+		if len(refs) != tt.expectedRefs {
+			t.Errorf("%s: Expecting %d owner references but got %v", tt.name, tt.expectedRefs, refs)
+			continue
+		}
+		var ref *metav1.OwnerReference
+		for i := range refs {
+			if refs[i].UID == owner.UID {
+				ref = &refs[i]
+			}
+		}
+		if ref == nil || ref.Kind != "Instance" || ref.Name != "instance" {
+			t.Errorf("%s: Expecting owner reference to the instance but got %v", tt.name, refs)
+			continue
+		}
+		if ref.Controller == nil || *ref.Controller != tt.expectedController || ref.BlockOwnerDeletion == nil || *ref.BlockOwnerDeletion != tt.expectedBlock {
+			t.Errorf("%s: Expecting controller %v and blockOwnerDeletion %v but got %v and %v", tt.name, tt.expectedController, tt.expectedBlock, ref.Controller, ref.BlockOwnerDeletion)
+		}
+	}
+}
+
+// countingMapper counts the lookups of REST mappings
+type countingMapper struct {
+	apimeta.RESTMapper
+	lookups int
+}
+
+func (m *countingMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*apimeta.RESTMapping, error) {
+	m.lookups++
+	return m.RESTMapper.RESTMapping(gk, versions...)
+}
+
+func TestApplyConventionsRespectsScopeOfObjects(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", PlanName: "deploy", PhaseName: "phase", StepName: "step"}
+
+	restMapper := apimeta.NewDefaultRESTMapper(nil)
+	restMapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, apimeta.RESTScopeRoot)
+	restMapper.Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "ClusterBackup"}, apimeta.RESTScopeRoot)
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, apimeta.RESTScopeNamespace)
+	mapper := &countingMapper{RESTMapper: restMapper}
+	enhancer := &kustomizeEnhancer{scheme: s, scopes: newScopeCache(mapper)}
+
+	templates := map[string]string{
+		"role.yaml":       "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: reader\nrules: []\n",
+		"backup.yaml":     "apiVersion: example.com/v1\nkind: ClusterBackup\nmetadata:\n  name: backup\n",
+		"deployment.yaml": getResourceAsString(getDeployment("web", "", 1)),
+	}
+
+	for i := 0; i < 2; i++ {
+		objs, err := enhancer.applyConventionsToTemplates(templates, meta, owner)
+		if err != nil {
+			t.Fatalf("Expecting no error but got %v", err)
+		}
+		for _, o := range objs {
+			objMeta := o.(metav1.Object)
+			switch objMeta.GetName() {
+			case "instance-reader", "instance-backup":
+				if objMeta.GetNamespace() != "" {
+					t.Errorf("Expecting cluster scoped %s to have no namespace but got %s", objMeta.GetName(), objMeta.GetNamespace())
+				}
+				if len(objMeta.GetOwnerReferences()) != 0 {
+					t.Errorf("Expecting no owner reference on cluster scoped %s but got %v", objMeta.GetName(), objMeta.GetOwnerReferences())
+				}
+			case "instance-web":
+				if objMeta.GetNamespace() != "default" {
+					t.Errorf("Expecting namespaced %s to be in the instance namespace but got %s", objMeta.GetName(), objMeta.GetNamespace())
+				}
+				if controller := metav1.GetControllerOf(objMeta); controller == nil || controller.UID != owner.UID {
+					t.Errorf("Expecting instance to be the controller of %s but got %v", objMeta.GetName(), controller)
+				}
+			default:
+				t.Errorf("Unexpected object %s", objMeta.GetName())
+			}
+		}
+	}
+
+	if mapper.lookups != 3 {
+		t.Errorf("Expecting scope of each kind to be looked up once but got %d lookups", mapper.lookups)
+	}
+}
+
+func TestScopeCacheAssumesUnknownKindsAreNamespaced(t *testing.T) {
+	mapper := &countingMapper{RESTMapper: apimeta.NewDefaultRESTMapper(nil)}
+	scopes := newScopeCache(mapper)
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Backup"}
+
+	for i := 0; i < 2; i++ {
+		namespaced, err := scopes.isNamespaced(gvk)
+		if err != nil {
+			t.Fatalf("Expecting no error but got %v", err)
+		}
+		if !namespaced {
+			t.Error("Expecting unknown kind to be treated as namespaced")
+		}
+	}
+	if mapper.lookups != 2 {
+		t.Errorf("Expecting unknown kind to be looked up every time but got %d lookups", mapper.lookups)
+	}
+}
+
+// resettingMapper knows the kinds added to it only once it is reset, like a mapper discovering kinds again
+type resettingMapper struct {
+	*countingMapper
+	added  map[schema.GroupVersionKind]apimeta.RESTScope
+	resets int
+}
+
+func (m *resettingMapper) Reset() {
+	m.resets++
+	for gvk, scope := range m.added {
+		m.countingMapper.RESTMapper.(*apimeta.DefaultRESTMapper).Add(gvk, scope)
+	}
+}
+
+func TestScopeCacheDiscoversKindsAgain(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "ClusterBackup"}
+	mapper := &resettingMapper{countingMapper: &countingMapper{RESTMapper: apimeta.NewDefaultRESTMapper(nil)}}
+	scopes := newScopeCache(mapper)
+
+	// the kind is not known before its CRD is created
+	if namespaced, err := scopes.isNamespaced(gvk); err != nil || !namespaced {
+		t.Fatalf("Expecting unknown kind to be treated as namespaced but got %v, %v", namespaced, err)
+	}
+
+	// the CRD is created, the mapper discovers the kind once it is reset
+	mapper.added = map[schema.GroupVersionKind]apimeta.RESTScope{gvk: apimeta.RESTScopeRoot}
+	if namespaced, err := scopes.isNamespaced(gvk); err != nil || namespaced {
+		t.Errorf("Expecting the kind of the created CRD to be cluster scoped but got %v, %v", namespaced, err)
+	}
+	if mapper.resets != 2 {
+		t.Errorf("Expecting the mapper to be reset for each lookup of an unknown kind but got %d resets", mapper.resets)
+	}
+}
+
+func TestApplyConventionsAnnotatesSourceTemplate(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", PlanName: "deploy", PhaseName: "phase", StepName: "step", TaskName: "app"}
+	enhancer := &kustomizeEnhancer{scheme: s}
+
+	ignored := getPod("web", "default")
+	ignored.Annotations = map[string]string{kudo.HealthAnnotation: kudo.HealthIgnoreValue}
+	hashed := getConfigMap("settings", "default", nil)
+	hashed.Annotations = map[string]string{kudo.HashSuffixAnnotation: "true"}
+	hashed.Data = map[string]string{"key": "value"}
+	templates := map[string]string{
+		"app.yaml":      getResourceAsString(getDeployment("web", "default", 1)) + "\n---\n" + getResourceAsString(ignored),
+		"config.yaml":   getResourceAsString(getConfigMap("config", "default", nil)),
+		"settings.yaml": getResourceAsString(hashed),
+	}
+	expected := map[string]string{"Deployment/instance-web": "app.yaml", "Pod/instance-web": "app.yaml", "ConfigMap/instance-config": "config.yaml", "ConfigMap/instance-settings": "settings.yaml"}
+
+	objs, err := enhancer.applyConventionsToTemplates(templates, meta, owner)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if len(objs) != len(expected) {
+		t.Fatalf("Expecting %d objects but got %d", len(expected), len(objs))
+	}
+	for _, o := range objs {
+		objMeta := o.(metav1.Object)
+		name := objMeta.GetName()
+		if strings.HasPrefix(name, "instance-settings-") {
+			// without the hash suffix
+			name = "instance-settings"
+		}
+		source := expected[o.GetObjectKind().GroupVersionKind().Kind+"/"+name]
+		annotations := objMeta.GetAnnotations()
+		if annotations[kudo.TemplateAnnotation] != source || annotations[kudo.TaskAnnotation] != "app" {
+			t.Errorf("Expecting %s to be annotated with template %s of task app but got %v", objMeta.GetName(), source, annotations)
+		}
+		if annotations[kudo.StepAnnotation] != "step" {
+			t.Errorf("Expecting %s to keep the step annotation but got %v", objMeta.GetName(), annotations)
+		}
+	}
+	for _, o := range objs {
+		if o.GetObjectKind().GroupVersionKind().Kind == "Pod" && o.(metav1.Object).GetAnnotations()[kudo.HealthAnnotation] != kudo.HealthIgnoreValue {
+			t.Errorf("Expecting annotations of the template to be kept but got %v", o.(metav1.Object).GetAnnotations())
+		}
+	}
+}
+
+func TestPrepareKubeResourcesCopiesInstanceLabels(t *testing.T) {
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+{{- range $k, $v := .InstanceLabels }}
+    {{ $k }}: {{ $v | quote }}
+{{- end }}
+  annotations:
+    owner: {{ index .InstanceAnnotations "owner" }}
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+`
+	plan := &activePlan{
+		Name: "deploy",
+		Spec: &kudov1alpha1.Plan{
+			Strategy: "serial",
+			Phases:   []kudov1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []kudov1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		PlanStatus: &kudov1alpha1.PlanStatus{
+			Name:   "deploy",
+			Phases: []kudov1alpha1.PhaseStatus{{Name: "phase", Steps: []kudov1alpha1.StepStatus{{Name: "step"}}}},
+		},
+		Tasks:     map[string]kudov1alpha1.TaskSpec{"task": {Resources: []string{"deployment.yaml"}}},
+		Templates: map[string]string{"deployment.yaml": deployment},
+	}
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	_ = appsv1.AddToScheme(s)
+	meta := &executionMetadata{
+		instanceName:        "instance",
+		instanceNamespace:   "default",
+		operatorName:        "operator",
+		instanceLabels:      map[string]string{"cost-center": "42", kudo.InstanceLabel: "other", kudo.OperatorLabel: "other"},
+		instanceAnnotations: map[string]string{"owner": "team-a", kudo.BreakpointsAnnotation: "step"},
+		resourcesOwner:      &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}},
+	}
+
+	resources, err := prepareKubeResources(plan, meta, &kustomizeEnhancer{scheme: s})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	objs := resources.PhaseResources["phase"].StepResources["step"]
+	if len(objs) != 1 {
+		t.Fatalf("Expecting one rendered object but got %d", len(objs))
+	}
+	objMeta := objs[0].(metav1.Object)
+	labels := objMeta.GetLabels()
+	if labels["cost-center"] != "42" {
+		t.Errorf("Expecting label of the instance to be copied but got %v", labels)
+	}
+	if labels[kudo.InstanceLabel] != "instance" || labels[kudo.OperatorLabel] != "operator" {
+		t.Errorf("Expecting KUDO labels to take precedence over labels of the instance but got %v", labels)
+	}
+	if annotations := objMeta.GetAnnotations(); annotations["owner"] != "team-a" || annotations[kudo.BreakpointsAnnotation] != "" {
+		t.Errorf("Expecting annotation of the instance to be copied without reserved ones but got %v", annotations)
+	}
+}
+
+func TestApplyConventionsFlattensLists(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", PlanName: "deploy", PhaseName: "phase", StepName: "step"}
+	enhancer := &kustomizeEnhancer{scheme: s}
+
+	list := fmt.Sprintf(`apiVersion: v1
+kind: List
+items:
+- %s
+- apiVersion: v1
+  kind: ConfigMapList
+  items:
+  - %s
+`, indent(getResourceAsString(getConfigMap("one", "default", nil))), indent(indent(getResourceAsString(getConfigMap("two", "default", nil)))))
+	templates := map[string]string{
+		"list.yaml":  list,
+		"empty.yaml": "apiVersion: v1\nkind: List\nitems: []\n",
+	}
+
+	objs, err := enhancer.applyConventionsToTemplates(templates, meta, owner)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("Expecting the items of the lists but got %v", objs)
+	}
+	for _, o := range objs {
+		objMeta := o.(metav1.Object)
+		if kind := o.GetObjectKind().GroupVersionKind().Kind; kind != "ConfigMap" {
+			t.Errorf("Expecting only the items of the list but got %s %s", kind, objMeta.GetName())
+		}
+		if !strings.HasPrefix(objMeta.GetName(), "instance-") || objMeta.GetLabels()[kudo.InstanceLabel] != "instance" {
+			t.Errorf("Expecting conventions to be applied to %s but got labels %v", objMeta.GetName(), objMeta.GetLabels())
+		}
+		if objMeta.GetAnnotations()[kudo.TemplateAnnotation] != "list.yaml" {
+			t.Errorf("Expecting %s to be annotated with its template but got %v", objMeta.GetName(), objMeta.GetAnnotations())
+		}
+		if refs := objMeta.GetOwnerReferences(); len(refs) != 1 || refs[0].UID != owner.UID {
+			t.Errorf("Expecting %s to be owned by the instance but got %v", objMeta.GetName(), refs)
+		}
+	}
+}
+
+// indent indents all but the first line of the YAML document so that it can be used as an item of a YAML list
+func indent(doc string) string {
+	return strings.Replace(strings.TrimSpace(doc), "\n", "\n  ", -1)
+}
@@ -0,0 +1,164 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/health"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	errwrap "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// barrierPollInterval is the time after which conditions of a barrier are checked again, objects a barrier waits for are
+// usually not owned by the instance, so their changes do not trigger the next execution
+const barrierPollInterval = 10 * time.Second
+
+// barrierCondition is a rendered v1alpha1.BarrierCondition
+type barrierCondition struct {
+	gvk   schema.GroupVersionKind
+	key   client.ObjectKey
+	state v1alpha1.BarrierState
+}
+
+func (b barrierCondition) String() string {
+	return fmt.Sprintf("%s %s/%s to be %s", b.gvk.Kind, b.key.Namespace, b.key.Name, b.state)
+}
+
+// renderBarrier renders names of the objects the barrier waits for, objects without namespace are looked up in the
+// namespace of the instance
+func renderBarrier(barrier *v1alpha1.Barrier, meta *executionMetadata, engine *kudoengine.Engine, configs map[string]interface{}) ([]barrierCondition, error) {
+	conditions := make([]barrierCondition, 0, len(barrier.Conditions))
+	for _, c := range barrier.Conditions {
+		gv, err := schema.ParseGroupVersion(c.APIVersion)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error parsing apiVersion of barrier condition")
+		}
+		name, err := engine.Render(c.Name, configs)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error expanding name of barrier condition")
+		}
+		namespace := c.Namespace
+		if namespace == "" {
+			namespace = meta.instanceNamespace
+		}
+		state := c.State
+		if state == "" {
+			state = v1alpha1.BarrierHealthy
+		}
+		conditions = append(conditions, barrierCondition{
+			gvk:   gv.WithKind(c.Kind),
+			key:   client.ObjectKey{Namespace: namespace, Name: name},
+			state: state,
+		})
+	}
+	return conditions, nil
+}
+
+// passBarrier returns true once all the conditions of the barrier of the step hold, until then the step stays in progress
+// and its message tells which condition it is waiting for. The step fails once it waited for longer than the barrier allows.
+func passBarrier(step v1alpha1.Step, state *v1alpha1.StepStatus, conditions []barrierCondition, now time.Time, c client.Client) (bool, error) {
+	state.Status = v1alpha1.ExecutionInProgress
+	state.Message = ""
+	for _, condition := range conditions {
+		problem, err := checkBarrierCondition(condition, c)
+		if err != nil {
+			log.Printf("PlanExecution: Error checking barrier condition of step %s: %v", step.Name, err)
+			return false, err
+		}
+		if problem == "" {
+			continue
+		}
+		state.Message = fmt.Sprintf("waiting for %s: %s", condition, problem)
+		if isBarrierTimedOut(step.Barrier, state, now) {
+			return false, &executionError{err: fmt.Errorf("barrier of step %s did not pass within %ds, %s", step.Name, step.Barrier.TimeoutSeconds, state.Message), fatal: true, eventName: kudo.String("BarrierTimedOut")}
+		}
+		log.Printf("PlanExecution: Barrier of step %s is %s", step.Name, state.Message)
+		return false, nil
+	}
+	return true, nil
+}
+
+// checkBarrierCondition returns the reason why the condition does not hold, empty string if it does
+func checkBarrierCondition(condition barrierCondition, c client.Client) (string, error) {
+	obj := newObject(condition.gvk)
+	err := c.Get(context.TODO(), condition.key, obj)
+	if apierrors.IsNotFound(err) {
+		if condition.state == v1alpha1.BarrierAbsent {
+			return "", nil
+		}
+		return "object does not exist", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if condition.state == v1alpha1.BarrierAbsent {
+		return "object still exists", nil
+	}
+	if err := health.IsHealthy(c, obj); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// newObject returns typed object for kubernetes native kinds and unstructured object for everything else
+func newObject(gvk schema.GroupVersionKind) runtime.Object {
+	if obj, err := scheme.Scheme.New(gvk); err == nil {
+		return obj
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	return obj
+}
+
+// isBarrierTimedOut returns true if the step waited for its barrier for longer than it allows
+func isBarrierTimedOut(barrier *v1alpha1.Barrier, state *v1alpha1.StepStatus, now time.Time) bool {
+	if barrier == nil || barrier.TimeoutSeconds == 0 || state.StartedAt.IsZero() {
+		return false
+	}
+	return !now.Before(state.StartedAt.Add(time.Duration(barrier.TimeoutSeconds) * time.Second))
+}
+
+// barrierRequeueAfter returns the time after which conditions of a barrier the plan waits for are checked again, zero if
+// no step waits for its barrier. The barrier is checked again sooner if it times out before the poll interval passes.
+func barrierRequeueAfter(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus, now time.Time) time.Duration {
+	var after time.Duration
+	for _, ph := range plan.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			if st.Barrier == nil {
+				continue
+			}
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || stepState.Status != v1alpha1.ExecutionInProgress || stepState.Stage != v1alpha1.BarrierStage {
+				continue
+			}
+			wait := barrierPollInterval
+			if st.Barrier.TimeoutSeconds > 0 && !stepState.StartedAt.IsZero() {
+				if timeout := stepState.StartedAt.Add(time.Duration(st.Barrier.TimeoutSeconds) * time.Second).Sub(now); timeout < wait {
+					wait = timeout
+				}
+				if wait <= 0 {
+					wait = time.Second
+				}
+			}
+			if after == 0 || wait < after {
+				after = wait
+			}
+		}
+	}
+	return after
+}
@@ -0,0 +1,201 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRenderBarrier(t *testing.T) {
+	barrier := &v1alpha1.Barrier{Conditions: []v1alpha1.BarrierCondition{
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "{{ .Name }}-db"},
+		{APIVersion: "v1", Kind: "PersistentVolumeClaim", Name: "data", Namespace: "storage", State: v1alpha1.BarrierAbsent},
+	}}
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	conditions, err := renderBarrier(barrier, meta, kudoengine.New(), map[string]interface{}{"Name": "instance"})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	expected := []barrierCondition{
+		{gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, key: client.ObjectKey{Namespace: "default", Name: "instance-db"}, state: v1alpha1.BarrierHealthy},
+		{gvk: schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"}, key: client.ObjectKey{Namespace: "storage", Name: "data"}, state: v1alpha1.BarrierAbsent},
+	}
+	if len(conditions) != len(expected) {
+		t.Fatalf("Expecting %v but got %v", expected, conditions)
+	}
+	for i := range expected {
+		if conditions[i] != expected[i] {
+			t.Errorf("Expecting %v but got %v", expected[i], conditions[i])
+		}
+	}
+}
+
+func TestBarrierHoldsUntilConditionFlips(t *testing.T) {
+	db := getDeployment("db", "default", 1)
+	testClient := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, db, getConfigMap("migration", "default", nil))}
+	step := v1alpha1.Step{Name: "step", Tasks: []string{"app"}, Barrier: &v1alpha1.Barrier{}}
+	resources := phaseResources{
+		StepResources: map[string][]runtime.Object{"step": {getConfigMap("app", "default", nil)}},
+		StepBarriers: map[string][]barrierCondition{"step": {
+			{gvk: appsv1.SchemeGroupVersion.WithKind("Deployment"), key: client.ObjectKey{Namespace: "default", Name: "db"}, state: v1alpha1.BarrierHealthy},
+			{gvk: corev1.SchemeGroupVersion.WithKind("ConfigMap"), key: client.ObjectKey{Namespace: "default", Name: "migration"}, state: v1alpha1.BarrierAbsent},
+		}},
+	}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	if err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress || state.Stage != v1alpha1.BarrierStage || len(testClient.created) != 0 {
+		t.Fatalf("Expecting barrier to hold while the deployment is not ready but got %v in stage %v with %v created: %s", state.Status, state.Stage, testClient.created, state.Message)
+	}
+
+	db.Status.ReadyReplicas = 1
+	if err := testClient.Update(context.TODO(), db); err != nil {
+		t.Fatal(err)
+	}
+	if err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Stage != v1alpha1.BarrierStage || len(testClient.created) != 0 {
+		t.Fatalf("Expecting barrier to hold while the config map exists but got stage %v with %v created: %s", state.Stage, testClient.created, state.Message)
+	}
+
+	if err := testClient.Delete(context.TODO(), getConfigMap("migration", "default", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete || len(testClient.created) != 1 || testClient.created[0] != "app" {
+		t.Errorf("Expecting tasks of the step to be applied once the barrier passed but got %v with %v created: %s", state.Status, testClient.created, state.Message)
+	}
+}
+
+func TestBarrierWithoutTasks(t *testing.T) {
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+	step := v1alpha1.Step{Name: "step", Barrier: &v1alpha1.Barrier{}}
+	resources := phaseResources{StepBarriers: map[string][]barrierCondition{"step": {
+		{gvk: corev1.SchemeGroupVersion.WithKind("ConfigMap"), key: client.ObjectKey{Namespace: "default", Name: "gone"}, state: v1alpha1.BarrierAbsent},
+	}}}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	if err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting barrier step to complete once its conditions hold but got %v: %s", state.Status, state.Message)
+	}
+}
+
+func TestBarrierTimeout(t *testing.T) {
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+	step := v1alpha1.Step{Name: "step", Barrier: &v1alpha1.Barrier{TimeoutSeconds: 60}}
+	resources := phaseResources{StepBarriers: map[string][]barrierCondition{"step": {
+		{gvk: corev1.SchemeGroupVersion.WithKind("ConfigMap"), key: client.ObjectKey{Namespace: "default", Name: "missing"}, state: v1alpha1.BarrierHealthy},
+	}}}
+
+	tests := []struct {
+		name        string
+		startedAt   time.Time
+		expectFatal bool
+	}{
+		{"within timeout", time.Now().Add(-30 * time.Second), false},
+		{"timed out", time.Now().Add(-2 * time.Minute), true},
+	}
+
+	for _, tt := range tests {
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress, StartedAt: metav1.NewTime(tt.startedAt)}
+		err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient)
+		if tt.expectFatal {
+			if exErr := asExecutionError(err); exErr == nil || !exErr.Fatal() {
+				t.Errorf("%s: Expecting fatal error but got %v", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+	}
+}
+
+func TestBarrierRequeueAfter(t *testing.T) {
+	now := time.Now()
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "waiting", Barrier: &v1alpha1.Barrier{}},
+		{Name: "timing-out", Barrier: &v1alpha1.Barrier{TimeoutSeconds: 60}},
+	}}}}
+
+	tests := []struct {
+		name     string
+		steps    []v1alpha1.StepStatus
+		expected time.Duration
+	}{
+		{"no barrier waiting", []v1alpha1.StepStatus{{Name: "waiting", Status: v1alpha1.ExecutionComplete}, {Name: "timing-out", Status: v1alpha1.ExecutionPending}}, 0},
+		{"barrier waiting", []v1alpha1.StepStatus{{Name: "waiting", Status: v1alpha1.ExecutionInProgress, Stage: v1alpha1.BarrierStage}, {Name: "timing-out", Status: v1alpha1.ExecutionPending}}, barrierPollInterval},
+		{"barrier passed", []v1alpha1.StepStatus{{Name: "waiting", Status: v1alpha1.ExecutionInProgress, Stage: v1alpha1.TasksStage}, {Name: "timing-out", Status: v1alpha1.ExecutionPending}}, 0},
+		{"barrier about to time out", []v1alpha1.StepStatus{{Name: "waiting", Status: v1alpha1.ExecutionComplete},
+			{Name: "timing-out", Status: v1alpha1.ExecutionInProgress, Stage: v1alpha1.BarrierStage, StartedAt: metav1.NewTime(now.Add(-55 * time.Second))}}, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		state := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: tt.steps}}}
+		if actual := barrierRequeueAfter(plan, state, now); actual != tt.expected {
+			t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+func TestExecutePlanBarrierTimesOutWithFakeClock(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC))
+	plan := &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases: []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "wait", Barrier: &v1alpha1.Barrier{
+				Conditions:     []v1alpha1.BarrierCondition{{APIVersion: "v1", Kind: "ConfigMap", Name: "external"}},
+				TimeoutSeconds: 60,
+			}}}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Status: v1alpha1.ExecutionPending,
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Name: "wait", Status: v1alpha1.ExecutionPending}}}},
+		},
+	}
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", clock: fakeClock}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+	tests := []struct {
+		name           string
+		advance        time.Duration
+		expectedStatus v1alpha1.ExecutionStatus
+	}{
+		{"barrier starts waiting", 0, v1alpha1.ExecutionInProgress},
+		{"just before the timeout", 59 * time.Second, v1alpha1.ExecutionInProgress},
+		{"timeout passed", 2 * time.Second, v1alpha1.ExecutionFatalError},
+	}
+
+	for _, tt := range tests {
+		fakeClock.Step(tt.advance)
+		result, _ := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+		plan.PlanStatus = result.PlanStatus
+		if result.PlanStatus.Status != tt.expectedStatus {
+			t.Fatalf("%s: Expecting plan to be %v but got %v: %v", tt.name, tt.expectedStatus, result.PlanStatus.Status, result.PlanStatus.Phases)
+		}
+	}
+	if started := plan.PlanStatus.Phases[0].Steps[0].StartedAt; !started.Time.Equal(time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expecting step to be started at the time of the fake clock but got %v", started)
+	}
+}
@@ -0,0 +1,154 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	errwrap "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	blueColor  = "blue"
+	greenColor = "green"
+)
+
+// blueGreenColors returns the color that is live and the color that is rolled out by the given blue-green phase
+// the first rollout of a phase is always blue
+func blueGreenColors(planStatus *v1alpha1.PlanStatus, phaseName string) (live string, target string) {
+	status := planStatus.BlueGreen[phaseName]
+	if status.TargetColor != "" {
+		return status.LiveColor, status.TargetColor
+	}
+	if status.LiveColor == blueColor {
+		return status.LiveColor, greenColor
+	}
+	return status.LiveColor, blueColor
+}
+
+// executeBlueGreenPhase rolls out steps of the phase serially as the target color next to the live color, once all of them are
+// healthy the service of the phase is switched to the target color and objects of the previously live color are deleted
+// when the target color does not become healthy before the progress deadline or one of the steps fails with fatal error,
+// the target color is deleted and the live color is kept (rollback)
+// returns true if the target color is live
+func executeBlueGreenPhase(phase v1alpha1.Phase, planState *v1alpha1.PlanStatus, phaseState *v1alpha1.PhaseStatus, resources phaseResources, clk clock.Clock, c client.Client) (bool, error) {
+	live, target := blueGreenColors(planState, phase.Name)
+	status := planState.BlueGreen[phase.Name]
+	if status.TargetColor == "" {
+		log.Printf("PlanExecution: Starting rollout of color %s in phase %s, live color is %q", target, phase.Name, live)
+		status.TargetColor = target
+		status.RolloutStarted = metav1.NewTime(clk.Now())
+		setBlueGreenStatus(planState, phase.Name, status)
+	}
+
+	for _, st := range phase.Steps {
+		stepState, _ := getStepFromStatus(st.Name, phaseState)
+		log.Printf("PlanExecution: Executing step %s of blue-green phase %s as color %s - it's in %s state", st.Name, phase.Name, target, stepState.Status)
+
+		err := executeStepWithHooks(context.TODO(), st, stepState, resources, clk, c)
+		if err != nil {
+			if statusForError(err) == v1alpha1.ExecutionFatalError {
+				return false, rollbackBlueGreen(phase, planState, phaseState, stepState, resources, err, c)
+			}
+			return false, failStep(phaseState, stepState, err)
+		}
+
+		if !isFinished(stepState.Status) {
+			if progressDeadlineExceeded(phase.BlueGreen, status.RolloutStarted, clk.Now()) {
+				err := fmt.Errorf("color %s did not become healthy within %d seconds", target, phase.BlueGreen.ProgressDeadlineSeconds)
+				return false, rollbackBlueGreen(phase, planState, phaseState, stepState, resources, err, c)
+			}
+			// we cannot proceed to the next step
+			return false, nil
+		}
+	}
+
+	// target color is healthy, switch the traffic to it
+	err := switchServiceColor(resources.BlueGreenService, resources.BlueGreenColorLabel, target, c)
+	if err != nil {
+		return false, err
+	}
+	for _, st := range phase.Steps {
+		for _, r := range resources.StepPreviousResources[st.Name] {
+			err := deleteObject(r, c)
+			if err != nil {
+				log.Printf("PlanExecution: Error deleting objects of previous color %s in phase %s: %v", live, phase.Name, err)
+				return false, err
+			}
+		}
+	}
+
+	log.Printf("PlanExecution: Color %s is live in phase %s", target, phase.Name)
+	setBlueGreenStatus(planState, phase.Name, v1alpha1.BlueGreenStatus{LiveColor: target})
+	return true, nil
+}
+
+// rollbackBlueGreen deletes all the objects of the target color and keeps the live color, the step that caused the rollback
+// is marked as failed with the returned fatal error
+func rollbackBlueGreen(phase v1alpha1.Phase, planState *v1alpha1.PlanStatus, phaseState *v1alpha1.PhaseStatus, stepState *v1alpha1.StepStatus, resources phaseResources, cause error, c client.Client) error {
+	live, target := blueGreenColors(planState, phase.Name)
+	log.Printf("PlanExecution: Rolling back color %s in phase %s: %v", target, phase.Name, cause)
+
+	for _, st := range phase.Steps {
+		for _, r := range resources.StepResources[st.Name] {
+			err := deleteObject(r, c)
+			if err != nil {
+				// status of the rollout is kept so that the rollback is retried
+				log.Printf("PlanExecution: Error deleting objects of color %s in phase %s: %v", target, phase.Name, err)
+				return err
+			}
+		}
+	}
+	setBlueGreenStatus(planState, phase.Name, v1alpha1.BlueGreenStatus{LiveColor: live})
+
+	err := fmt.Errorf("rolled back color %s, live color %q was kept: %v", target, live, cause)
+	return failStep(phaseState, stepState, &executionError{err: err, fatal: true, eventName: kudo.String("BlueGreenRollback")})
+}
+
+// switchServiceColor points selector of the service to pods of the given color, labeled with the color label
+func switchServiceColor(key types.NamespacedName, colorLabel string, color string, c client.Client) error {
+	service := &corev1.Service{}
+	err := c.Get(context.TODO(), key, service)
+	if err != nil {
+		return errwrap.Wrapf(err, "error getting service %s to switch to color %s", key, color)
+	}
+	if service.Spec.Selector == nil {
+		service.Spec.Selector = make(map[string]string)
+	}
+	service.Spec.Selector[colorLabel] = color
+
+	log.Printf("PlanExecution: Switching service %s to color %s", key, color)
+	return c.Update(context.TODO(), service)
+}
+
+func setBlueGreenStatus(planState *v1alpha1.PlanStatus, phaseName string, status v1alpha1.BlueGreenStatus) {
+	if planState.BlueGreen == nil {
+		planState.BlueGreen = make(map[string]v1alpha1.BlueGreenStatus)
+	}
+	planState.BlueGreen[phaseName] = status
+}
+
+func progressDeadlineExceeded(spec *v1alpha1.BlueGreenSpec, started metav1.Time, now time.Time) bool {
+	if spec == nil || spec.ProgressDeadlineSeconds <= 0 {
+		return false
+	}
+	return now.Sub(started.Time) > time.Duration(spec.ProgressDeadlineSeconds)*time.Second
+}
+
+func deleteObject(obj runtime.Object, c client.Client) error {
+	err := c.Delete(context.TODO(), obj, client.PropagationPolicy(metav1.DeletePropagationForeground))
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBlueGreenColors(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         map[string]v1alpha1.BlueGreenStatus
+		expectedLive   string
+		expectedTarget string
+	}{
+		{"first rollout", nil, "", blueColor},
+		{"blue is live", map[string]v1alpha1.BlueGreenStatus{"phase": {LiveColor: blueColor}}, blueColor, greenColor},
+		{"green is live", map[string]v1alpha1.BlueGreenStatus{"phase": {LiveColor: greenColor}}, greenColor, blueColor},
+		{"rollout in progress", map[string]v1alpha1.BlueGreenStatus{"phase": {LiveColor: blueColor, TargetColor: greenColor}}, blueColor, greenColor},
+		{"other phase", map[string]v1alpha1.BlueGreenStatus{"other": {LiveColor: blueColor}}, "", blueColor},
+	}
+
+	for _, tt := range tests {
+		live, target := blueGreenColors(&v1alpha1.PlanStatus{BlueGreen: tt.status}, "phase")
+		if live != tt.expectedLive || target != tt.expectedTarget {
+			t.Errorf("%s: Expecting live %q and target %q but got %q and %q", tt.name, tt.expectedLive, tt.expectedTarget, live, target)
+		}
+	}
+}
+
+func TestExecutePlanBlueGreenSwitchesService(t *testing.T) {
+	plan := blueGreenPlan(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-{{ .Color }}
+  namespace: default
+`, map[string]v1alpha1.BlueGreenStatus{"deploy": {LiveColor: blueColor}}, 0)
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, getService("web", "default", blueColor), getConfigMap("app-blue", "default", nil))
+
+	newStatus, err := executePlan(plan, &executionMetadata{instanceName: "instance", instanceNamespace: "default"}, testClient, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting plan to be completed but got %v", newStatus.Status)
+	}
+	if newStatus.BlueGreen["deploy"].LiveColor != greenColor || newStatus.BlueGreen["deploy"].TargetColor != "" {
+		t.Errorf("Expecting green to be live with no rollout in progress but got %+v", newStatus.BlueGreen["deploy"])
+	}
+
+	service := &corev1.Service{}
+	_ = testClient.Get(context.TODO(), types.NamespacedName{Name: "web", Namespace: "default"}, service)
+	if service.Spec.Selector[kudo.ColorLabel] != greenColor {
+		t.Errorf("Expecting service to select %s but got %v", greenColor, service.Spec.Selector)
+	}
+	if service.Spec.Selector["app"] != "web" {
+		t.Errorf("Expecting other labels of the service selector to be kept but got %v", service.Spec.Selector)
+	}
+
+	assertExists(t, testClient, &corev1.ConfigMap{}, "app-green", true)
+	assertExists(t, testClient, &corev1.ConfigMap{}, "app-blue", false)
+}
+
+func TestExecutePlanBlueGreenRollback(t *testing.T) {
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app-{{ .Color }}
+  namespace: default
+spec:
+  replicas: 1
+`
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, getService("web", "default", blueColor), getDeployment("app-blue", "default", 1))
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	// green is not healthy yet but there is still time
+	plan := blueGreenPlan(deployment, map[string]v1alpha1.BlueGreenStatus{"deploy": {LiveColor: blueColor}}, 60)
+	newStatus, err := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting plan to be in progress but got %v", newStatus.Status)
+	}
+	if newStatus.BlueGreen["deploy"].TargetColor != greenColor {
+		t.Errorf("Expecting rollout of green to be in progress but got %+v", newStatus.BlueGreen["deploy"])
+	}
+	assertExists(t, testClient, &appsv1.Deployment{}, "app-green", true)
+
+	// green did not become healthy before the deadline
+	plan = blueGreenPlan(deployment, map[string]v1alpha1.BlueGreenStatus{"deploy": {
+		LiveColor:      blueColor,
+		TargetColor:    greenColor,
+		RolloutStarted: metav1.NewTime(time.Now().Add(-time.Hour)),
+	}}, 60)
+	newStatus, err = executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Fatal("Expecting rollback error but got none")
+	}
+	if exErr, ok := err.(*executionError); !ok || !exErr.fatal {
+		t.Errorf("Expecting fatal execution error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting plan status to be %v but got %v", v1alpha1.ExecutionFatalError, newStatus.Status)
+	}
+	if newStatus.BlueGreen["deploy"].LiveColor != blueColor || newStatus.BlueGreen["deploy"].TargetColor != "" {
+		t.Errorf("Expecting blue to stay live with no rollout in progress but got %+v", newStatus.BlueGreen["deploy"])
+	}
+
+	service := &corev1.Service{}
+	_ = testClient.Get(context.TODO(), types.NamespacedName{Name: "web", Namespace: "default"}, service)
+	if service.Spec.Selector[kudo.ColorLabel] != blueColor {
+		t.Errorf("Expecting service to keep selecting %s but got %v", blueColor, service.Spec.Selector)
+	}
+	assertExists(t, testClient, &appsv1.Deployment{}, "app-green", false)
+	assertExists(t, testClient, &appsv1.Deployment{}, "app-blue", true)
+}
+
+func blueGreenPlan(template string, status map[string]v1alpha1.BlueGreenStatus, deadline int) *activePlan {
+	return &activePlan{
+		Name: "deploy",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:      "deploy",
+			Status:    v1alpha1.ExecutionPending,
+			Phases:    []v1alpha1.PhaseStatus{{Name: "deploy", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Name: "app", Status: v1alpha1.ExecutionPending}}}},
+			BlueGreen: status,
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: v1alpha1.Serial,
+			Phases: []v1alpha1.Phase{{
+				Name:      "deploy",
+				Strategy:  v1alpha1.BlueGreen,
+				BlueGreen: &v1alpha1.BlueGreenSpec{Service: "web", ProgressDeadlineSeconds: deadline},
+				Steps:     []v1alpha1.Step{{Name: "app", Tasks: []string{"app"}}},
+			}},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{"app": {Resources: []string{"app"}}},
+		Templates: map[string]string{"app": template},
+	}
+}
+
+func getService(name string, namespace string, color string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "web", kudo.ColorLabel: color},
+		},
+	}
+}
+
+func assertExists(t *testing.T, c client.Client, obj runtime.Object, name string, expected bool) {
+	err := c.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "default"}, obj)
+	if expected && err != nil {
+		t.Errorf("Expecting %s to exist but got %v", name, err)
+	}
+	if !expected && !apierrors.IsNotFound(err) {
+		t.Errorf("Expecting %s to be deleted but got %v", name, err)
+	}
+}
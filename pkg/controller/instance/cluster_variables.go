@@ -0,0 +1,127 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ClusterConfigMapName is the name of the ConfigMap in the namespace of the KUDO manager that holds cluster wide variables
+// (e.g. cluster domain, registry mirror, default storage class)
+// all the keys of this ConfigMap are available to all templates of all operators under `.Cluster`
+//
+// cluster variables live next to the instance parameters (`.Params`), they never override each other - operators that
+// want to let instances override a cluster wide value should do so explicitly with a parameter that has an empty default
+// e.g. `{{ .Params.STORAGE_CLASS | default .Cluster.StorageClass }}`
+const ClusterConfigMapName = "kudo-cluster-config"
+
+// NodeCapacityReferenceVariable is the cluster variable naming the node whose allocatable resources are exposed as the
+// node capacity, by default the smallest allocatable resources of all schedulable nodes are exposed
+const NodeCapacityReferenceVariable = "NodeCapacityReference"
+
+// nodeCapacityVariables are the cluster variables holding the node capacity and the resources they are taken from
+var nodeCapacityVariables = map[string]corev1.ResourceName{
+	"NodeCPU":              corev1.ResourceCPU,
+	"NodeMemory":           corev1.ResourceMemory,
+	"NodeEphemeralStorage": corev1.ResourceEphemeralStorage,
+}
+
+// getClusterVariables returns data of the cluster config map, missing config map is not an error, there are just no variables
+// the node capacity is added to the variables unless the config map sets it
+func getClusterVariables(c client.Client, configMap types.NamespacedName) (map[string]string, error) {
+	variables := make(map[string]string)
+	if configMap.Name != "" {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(context.TODO(), configMap, cm)
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("InstanceController: Error getting cluster config map %v: %v", configMap, err)
+			return nil, err
+		}
+		for k, v := range cm.Data {
+			variables[k] = v
+		}
+	}
+
+	capacity, err := getNodeCapacity(c, variables[NodeCapacityReferenceVariable])
+	if err != nil {
+		log.Printf("InstanceController: Error getting node capacity: %v", err)
+		return nil, err
+	}
+	for k, v := range capacity {
+		if _, ok := variables[k]; !ok {
+			variables[k] = v
+		}
+	}
+	return variables, nil
+}
+
+// getNodeCapacity returns the allocatable resources of the reference node, or the smallest allocatable resources of all
+// schedulable nodes when there is no reference, so that objects sized by them fit on any node of a heterogeneous
+// cluster. Each resource is the minimum on its own, so they do not have to come from the same node.
+func getNodeCapacity(c client.Client, reference string) (map[string]string, error) {
+	nodes := &corev1.NodeList{}
+	if reference != "" {
+		node := corev1.Node{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Name: reference}, &node); err != nil {
+			return nil, fmt.Errorf("error getting reference node %s: %v", reference, err)
+		}
+		nodes.Items = []corev1.Node{node}
+	} else if err := c.List(context.TODO(), nodes); err != nil {
+		return nil, err
+	}
+
+	capacity := make(map[string]string)
+	for variable, name := range nodeCapacityVariables {
+		var min *resource.Quantity
+		for _, node := range nodes.Items {
+			if node.Spec.Unschedulable && reference == "" {
+				continue
+			}
+			q, ok := node.Status.Allocatable[name]
+			if ok && (min == nil || q.Cmp(*min) < 0) {
+				q := q.DeepCopy()
+				min = &q
+			}
+		}
+		if min != nil {
+			capacity[variable] = min.String()
+		}
+	}
+	return capacity, nil
+}
+
+// clusterConfigToInstances maps change of the cluster config map to reconcile requests for all instances as all of them can
+// possibly use the cluster variables
+func clusterConfigToInstances(c client.Client, configMap types.NamespacedName) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		if obj.Meta.GetName() != configMap.Name || obj.Meta.GetNamespace() != configMap.Namespace {
+			return nil
+		}
+
+		instances := &kudov1alpha1.InstanceList{}
+		err := c.List(context.TODO(), instances)
+		if err != nil {
+			log.Printf("InstanceController: Error fetching instances list after change of cluster config map %v: %v", configMap, err)
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(instances.Items))
+		for _, instance := range instances.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      instance.Name,
+					Namespace: instance.Namespace,
+				},
+			})
+		}
+		return requests
+	}
+}
@@ -0,0 +1,238 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetClusterVariables(t *testing.T) {
+	configMap := getConfigMap(ClusterConfigMapName, "kudo-system", nil)
+	configMap.Data = map[string]string{"StorageClass": "fast", "ClusterDomain": "cluster.local"}
+
+	tests := []struct {
+		name     string
+		ref      types.NamespacedName
+		expected map[string]string
+	}{
+		{"existing config map", types.NamespacedName{Name: ClusterConfigMapName, Namespace: "kudo-system"}, configMap.Data},
+		{"missing config map", types.NamespacedName{Name: ClusterConfigMapName, Namespace: "other"}, map[string]string{}},
+		{"not configured", types.NamespacedName{}, map[string]string{}},
+	}
+
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, configMap)
+	for _, tt := range tests {
+		variables, err := getClusterVariables(testClient, tt.ref)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if len(variables) != len(tt.expected) {
+			t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, variables)
+		}
+		for k, v := range tt.expected {
+			if variables[k] != v {
+				t.Errorf("%s: Expecting %s to be %s but got %s", tt.name, k, v, variables[k])
+			}
+		}
+	}
+}
+
+func TestPrepareKubeResourcesRendersClusterVariables(t *testing.T) {
+	pvc := `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: default
+spec:
+  storageClassName: {{ .Params.STORAGE_CLASS | default .Cluster.StorageClass }}
+`
+	tests := []struct {
+		name             string
+		clusterVariables map[string]string
+		params           map[string]string
+		expected         string
+	}{
+		{"cluster variable used", map[string]string{"StorageClass": "fast"}, map[string]string{"STORAGE_CLASS": ""}, "fast"},
+		{"instance parameter takes precedence", map[string]string{"StorageClass": "fast"}, map[string]string{"STORAGE_CLASS": "slow"}, "slow"},
+	}
+
+	for _, tt := range tests {
+		plan := &activePlan{
+			Name: "deploy",
+			Spec: &v1alpha1.Plan{
+				Strategy: "serial",
+				Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+			},
+			PlanStatus: &v1alpha1.PlanStatus{
+				Name:   "deploy",
+				Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+			},
+			Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"pvc"}}},
+			Templates: map[string]string{"pvc": pvc},
+			params:    tt.params,
+		}
+		meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", clusterVariables: tt.clusterVariables}
+
+		resources, err := prepareKubeResources(plan, meta, &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		objs := resources.PhaseResources["phase"].StepResources["step"]
+		if len(objs) != 1 {
+			t.Errorf("%s: Expecting one rendered object but got %d", tt.name, len(objs))
+			continue
+		}
+		claim, ok := objs[0].(*corev1.PersistentVolumeClaim)
+		if !ok {
+			t.Errorf("%s: Expecting PersistentVolumeClaim but got %T", tt.name, objs[0])
+			continue
+		}
+		if claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName != tt.expected {
+			t.Errorf("%s: Expecting storage class %s but got %v", tt.name, tt.expected, claim.Spec.StorageClassName)
+		}
+	}
+}
+
+func TestPrepareKubeResourcesFailsOnMissingClusterVariable(t *testing.T) {
+	plan := &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"cm"}}},
+		Templates: map[string]string{"cm": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Cluster.Missing }}\n"},
+	}
+
+	_, err := prepareKubeResources(plan, &executionMetadata{instanceName: "instance", instanceNamespace: "default"}, &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Error("Expecting error when rendering undefined cluster variable but got none")
+	}
+}
+
+func getNode(name string, cpu, memory string, unschedulable bool) *corev1.Node {
+	return &corev1.Node{
+		TypeMeta:   metav1.TypeMeta{Kind: "Node", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}},
+	}
+}
+
+func TestGetClusterVariablesNodeCapacity(t *testing.T) {
+	nodes := []runtime.Object{
+		getNode("large", "16", "64Gi", false),
+		getNode("small-cpu", "4", "32Gi", false),
+		getNode("small-memory", "8", "16Gi", false),
+		getNode("cordoned", "2", "4Gi", true),
+	}
+
+	tests := []struct {
+		name     string
+		data     map[string]string
+		expected map[string]string
+	}{
+		{"smallest resources of schedulable nodes", nil, map[string]string{"NodeCPU": "4", "NodeMemory": "16Gi"}},
+		{"reference node", map[string]string{NodeCapacityReferenceVariable: "large"}, map[string]string{"NodeCPU": "16", "NodeMemory": "64Gi"}},
+		{"capacity set by the config map", map[string]string{"NodeMemory": "8Gi"}, map[string]string{"NodeCPU": "4", "NodeMemory": "8Gi"}},
+	}
+
+	for _, tt := range tests {
+		configMap := getConfigMap(ClusterConfigMapName, "kudo-system", nil)
+		configMap.Data = tt.data
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme, append([]runtime.Object{configMap}, nodes...)...)
+
+		variables, err := getClusterVariables(testClient, types.NamespacedName{Name: ClusterConfigMapName, Namespace: "kudo-system"})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		for k, v := range tt.expected {
+			if variables[k] != v {
+				t.Errorf("%s: Expecting %s to be %s but got %s", tt.name, k, v, variables[k])
+			}
+		}
+		if _, ok := variables["NodeEphemeralStorage"]; ok {
+			t.Errorf("%s: Expecting no capacity of resources the nodes do not report but got %s", tt.name, variables["NodeEphemeralStorage"])
+		}
+	}
+
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, nodes...)
+	if _, err := getClusterVariables(testClient, types.NamespacedName{}); err != nil {
+		t.Errorf("Expecting no error without cluster config map but got %v", err)
+	}
+	configMap := getConfigMap(ClusterConfigMapName, "kudo-system", nil)
+	configMap.Data = map[string]string{NodeCapacityReferenceVariable: "gone"}
+	testClient = fake.NewFakeClientWithScheme(scheme.Scheme, append([]runtime.Object{configMap}, nodes...)...)
+	if _, err := getClusterVariables(testClient, types.NamespacedName{Name: ClusterConfigMapName, Namespace: "kudo-system"}); err == nil {
+		t.Error("Expecting error with missing reference node but got none")
+	}
+}
+
+func TestPrepareKubeResourcesSizesFromNodeCapacity(t *testing.T) {
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        resources:
+          requests:
+            cpu: {{ .Cluster.NodeCPU | percentOf 25 }}
+            memory: {{ .Cluster.NodeMemory | percentOf .Params.MEMORY_PERCENT }}
+`
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, getNode("small", "2", "8Gi", false), getNode("large", "8", "32Gi", false))
+	variables, err := getClusterVariables(testClient, types.NamespacedName{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	plan := &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"deployment"}}},
+		Templates: map[string]string{"deployment": deployment},
+		params:    map[string]string{"MEMORY_PERCENT": "50"},
+	}
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", clusterVariables: variables}
+
+	resources, err := prepareKubeResources(plan, meta, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	requests := resources.PhaseResources["phase"].StepResources["step"][0].(*appsv1.Deployment).Spec.Template.Spec.Containers[0].Resources.Requests
+	if cpu := requests[corev1.ResourceCPU]; cpu.String() != "500m" {
+		t.Errorf("Expecting a quarter of the smallest node cpu but got %s", cpu.String())
+	}
+	if memory := requests[corev1.ResourceMemory]; memory.String() != "4Gi" {
+		t.Errorf("Expecting half of the smallest node memory but got %s", memory.String())
+	}
+}
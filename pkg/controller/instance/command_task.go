@@ -0,0 +1,130 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/ghodss/yaml"
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	errwrap "github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// jobNameLabel is the label that kubernetes puts on all pods created by a Job
+const jobNameLabel = "job-name"
+
+// renderCommandJob renders the Job that runs the command of a command task into yaml so that it can be enhanced
+// the same way as any other template
+// image and all the command arguments are templates rendered with the same configs as the task resources
+func renderCommandJob(name string, taskName string, command *v1alpha1.CommandSpec, engine *kudoengine.Engine, configs map[string]interface{}) (string, error) {
+	image, err := engine.Render(command.Image, configs)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "error expanding image of command task %s", taskName)
+	}
+	args := make([]string, 0, len(command.Command))
+	for _, c := range command.Command {
+		arg, err := engine.Render(c, configs)
+		if err != nil {
+			return "", errwrap.Wrapf(err, "error expanding command of command task %s", taskName)
+		}
+		args = append(args, arg)
+	}
+
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				kudo.CommandTaskAnnotation:   taskName,
+				kudo.CaptureOutputAnnotation: strconv.FormatBool(command.CaptureOutput),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:                     "command",
+						Image:                    image,
+						Command:                  args,
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+				},
+			},
+		},
+	}
+
+	bytes, err := yaml.Marshal(job)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "error marshalling job of command task %s", taskName)
+	}
+	return string(bytes), nil
+}
+
+// isCommandJob returns true if the object is a Job running command of a command task
+func isCommandJob(obj runtime.Object) bool {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return false
+	}
+	_, ok = job.Annotations[kudo.CommandTaskAnnotation]
+	return ok
+}
+
+// checkCommandJob checks the state of the Job running a command task
+// failed command results in a fatal error as re-running the same Job will not make it pass
+// once the command succeeded, its output is stored in the step status if requested
+func checkCommandJob(job *batchv1.Job, state *v1alpha1.StepStatus, c client.Client) error {
+	taskName := job.Annotations[kudo.CommandTaskAnnotation]
+	if job.Status.Failed > 0 {
+		output := commandOutput(job, c)
+		return &executionError{err: fmt.Errorf("command of task %s failed: %s", taskName, output), fatal: true, eventName: kudo.String("CommandFailed")}
+	}
+	if job.Status.Succeeded > 0 && job.Annotations[kudo.CaptureOutputAnnotation] == "true" {
+		state.Output = commandOutput(job, c)
+	}
+	return nil
+}
+
+// cleanupCommandJob deletes the Job of a successfully finished command task
+// this must happen only after the whole step is healthy, otherwise the Job would be created and run again
+func cleanupCommandJob(job *batchv1.Job, c client.Client) {
+	log.Printf("PlanExecution: Command of task %s finished, deleting job %s/%s", job.Annotations[kudo.CommandTaskAnnotation], job.Namespace, job.Name)
+	err := c.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("PlanExecution: Error when deleting job %s/%s: %v", job.Namespace, job.Name, err)
+	}
+}
+
+// commandOutput returns the termination message of the command container, when the command fails without writing
+// a termination message, it contains the tail of the container logs
+func commandOutput(job *batchv1.Job, c client.Client) string {
+	pods := &corev1.PodList{}
+	err := c.List(context.TODO(), pods, client.InNamespace(job.Namespace), client.MatchingLabels{jobNameLabel: job.Name})
+	if err != nil {
+		log.Printf("PlanExecution: Error when listing pods of job %s/%s: %v", job.Namespace, job.Name, err)
+		return ""
+	}
+	for _, p := range pods.Items {
+		for _, s := range p.Status.ContainerStatuses {
+			if s.State.Terminated != nil {
+				return s.State.Terminated.Message
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"github.com/kudobuilder/kudo/pkg/util/template"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRenderCommandJob(t *testing.T) {
+	command := &v1alpha1.CommandSpec{
+		Image:         "busybox:{{ .Params.VERSION }}",
+		Command:       []string{"sh", "-c", "echo {{ .Name }}"},
+		CaptureOutput: true,
+	}
+	configs := map[string]interface{}{"Name": "instance", "Params": map[string]string{"VERSION": "1.31"}}
+
+	rendered, err := renderCommandJob("deploy-register-task", "task", command, kudoengine.New(), configs)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	objs, err := template.ParseKubernetesObjects(rendered)
+	if err != nil {
+		t.Fatalf("Expecting rendered job to be parseable but got %v", err)
+	}
+	job := objs[0].(*batchv1.Job)
+
+	container := job.Spec.Template.Spec.Containers[0]
+	if container.Image != "busybox:1.31" {
+		t.Errorf("Expecting image busybox:1.31 but got %s", container.Image)
+	}
+	if container.Command[2] != "echo instance" {
+		t.Errorf("Expecting templated command but got %v", container.Command)
+	}
+	if !isCommandJob(job) {
+		t.Errorf("Expecting rendered job to be recognized as command job")
+	}
+	if job.Annotations[kudo.CaptureOutputAnnotation] != "true" {
+		t.Errorf("Expecting output of the job to be captured")
+	}
+}
+
+func TestExecuteStepWithCommandJob(t *testing.T) {
+	tests := []struct {
+		name            string
+		jobStatus       batchv1.JobStatus
+		expectedStatus  v1alpha1.ExecutionStatus
+		expectedOutput  string
+		expectFatal     bool
+		expectJobExists bool
+	}{
+		{"running command", batchv1.JobStatus{Active: 1}, v1alpha1.ExecutionInProgress, "", false, true},
+		{"successful command", batchv1.JobStatus{Succeeded: 1}, v1alpha1.ExecutionComplete, "registered", false, false},
+		{"command with non-zero exit", batchv1.JobStatus{Failed: 1}, v1alpha1.ExecutionInProgress, "", true, true},
+	}
+
+	for _, tt := range tests {
+		job := getCommandJob("command", "default")
+		existing := job.DeepCopy()
+		existing.Status = tt.jobStatus
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme, existing, getCommandPod("command-pod", "default", "command", "registered"))
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{job}, nil, clock.RealClock{}, testClient)
+		if tt.expectFatal {
+			if exErr := asExecutionError(err); exErr == nil || !exErr.Fatal() {
+				t.Errorf("%s: Expecting fatal error but got %v", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting step status %v but got %v", tt.name, tt.expectedStatus, state.Status)
+		}
+		if state.Output != tt.expectedOutput {
+			t.Errorf("%s: Expecting output %q but got %q", tt.name, tt.expectedOutput, state.Output)
+		}
+
+		err = testClient.Get(context.TODO(), client.ObjectKey{Name: "command", Namespace: "default"}, &batchv1.Job{})
+		if tt.expectJobExists && err != nil {
+			t.Errorf("%s: Expecting job to exist but got %v", tt.name, err)
+		}
+		if !tt.expectJobExists && !apierrors.IsNotFound(err) {
+			t.Errorf("%s: Expecting job to be deleted but got %v", tt.name, err)
+		}
+	}
+}
+
+func getCommandJob(name string, namespace string) *batchv1.Job {
+	job := getJob(name, namespace)
+	job.Annotations = map[string]string{
+		kudo.CommandTaskAnnotation:   "task",
+		kudo.CaptureOutputAnnotation: "true",
+	}
+	return job
+}
+
+func getCommandPod(name string, namespace string, jobName string, message string) *corev1.Pod {
+	pod := getPod(name, namespace)
+	pod.ObjectMeta.Labels = map[string]string{jobNameLabel: jobName}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name: "command",
+		State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{Message: message, FinishedAt: metav1.Now()},
+		},
+	}}
+	return pod
+}
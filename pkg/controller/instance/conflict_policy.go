@@ -0,0 +1,119 @@
+package instance
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	apijson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// conflictPolicy returns the policy deciding which fields KUDO asserts when patching the object, it is set by the
+// ConflictPolicyAnnotation of its template. Server side apply would express the same as forcing the apply (KUDO wins)
+// versus respecting field ownership of other managers, the patches used here emulate that by leaving fields out.
+func conflictPolicy(obj runtime.Object) (string, error) {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	policy, ok := objMeta.GetAnnotations()[kudo.ConflictPolicyAnnotation]
+	if !ok {
+		return kudo.ConflictPolicyKudoWins, nil
+	}
+	switch policy {
+	case kudo.ConflictPolicyKudoWins, kudo.ConflictPolicyOtherWins, kudo.ConflictPolicyMerge, kudo.ConflictPolicyDetectDrift:
+		return policy, nil
+	}
+	return "", &executionError{err: fmt.Errorf("%s %s has unknown %s %q", obj.GetObjectKind().GroupVersionKind().Kind, objMeta.GetName(), kudo.ConflictPolicyAnnotation, policy), fatal: true}
+}
+
+// recordLastApplied stores the rendered object in the LastAppliedAnnotation of objects with the merge policy, so that
+// the next patch can tell the fields KUDO changed from the ones changed by someone else
+func recordLastApplied(obj runtime.Object) error {
+	policy, err := conflictPolicy(obj)
+	if err != nil || policy != kudo.ConflictPolicyMerge {
+		return err
+	}
+	objMeta, _ := meta.Accessor(obj)
+	annotations := objMeta.GetAnnotations()
+	delete(annotations, kudo.LastAppliedAnnotation)
+	objMeta.SetAnnotations(annotations)
+	applied, err := apijson.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	objMeta.SetAnnotations(withAnnotation(annotations, kudo.LastAppliedAnnotation, string(applied)))
+	return nil
+}
+
+// patchData returns the body of the patch applying the rendered object to the existing one, it contains only the
+// fields the conflict policy of the object lets KUDO assert
+func patchData(rendered runtime.Object, existing runtime.Object) ([]byte, error) {
+	policy, err := conflictPolicy(rendered)
+	if err != nil {
+		return nil, err
+	}
+	renderedJSON, err := apijson.Marshal(rendered)
+	if err != nil || policy == kudo.ConflictPolicyKudoWins || policy == kudo.ConflictPolicyDetectDrift {
+		// objects with drift are only patched when the drift is reconciled, KUDO wins then
+		return renderedJSON, err
+	}
+
+	desired := map[string]interface{}{}
+	if err := json.Unmarshal(renderedJSON, &desired); err != nil {
+		return nil, err
+	}
+	live, err := runtime.DefaultUnstructuredConverter.ToUnstructured(existing)
+	if err != nil {
+		return nil, err
+	}
+	var lastApplied map[string]interface{}
+	if policy == kudo.ConflictPolicyMerge {
+		existingMeta, _ := meta.Accessor(existing)
+		if applied, ok := existingMeta.GetAnnotations()[kudo.LastAppliedAnnotation]; ok {
+			// an unreadable annotation is treated as if nothing was applied before, so KUDO wins once and records it again
+			_ = json.Unmarshal([]byte(applied), &lastApplied)
+		}
+	}
+
+	owned := ownedFields(desired, live, lastApplied, policy == kudo.ConflictPolicyMerge)
+	owned["apiVersion"], owned["kind"] = desired["apiVersion"], desired["kind"]
+	return json.Marshal(owned)
+}
+
+// ownedFields returns the rendered fields KUDO asserts, fields the existing object does not have are always asserted
+// with the other-wins policy, fields the existing object has are left to whoever set them
+// with the merge policy, fields the existing object has are asserted only when KUDO did not apply them before or their
+// rendered value differs from the one KUDO applied last time
+// nested objects are compared field by field, lists are compared as a whole
+func ownedFields(rendered map[string]interface{}, existing map[string]interface{}, lastApplied map[string]interface{}, merge bool) map[string]interface{} {
+	owned := make(map[string]interface{})
+	for k, v := range rendered {
+		live, exists := existing[k]
+		if !exists {
+			owned[k] = v
+			continue
+		}
+		last, applied := lastApplied[k]
+		if merge && !applied {
+			owned[k] = v
+			continue
+		}
+		renderedMap, ok := v.(map[string]interface{})
+		liveMap, liveOk := live.(map[string]interface{})
+		if ok && liveOk {
+			lastMap, _ := last.(map[string]interface{})
+			if nested := ownedFields(renderedMap, liveMap, lastMap, merge); len(nested) > 0 {
+				owned[k] = nested
+			}
+			continue
+		}
+		if merge && !reflect.DeepEqual(v, last) {
+			owned[k] = v
+		}
+	}
+	return owned
+}
@@ -0,0 +1,96 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func conflictingDeployment(policy string, replicas int32, image string, labels map[string]string) *appsv1.Deployment {
+	d := getDeployment("web", "default", replicas)
+	d.Labels = labels
+	if policy != "" {
+		d.Annotations = map[string]string{kudo.ConflictPolicyAnnotation: policy}
+	}
+	d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "web", Image: image}}
+	return d
+}
+
+func TestConflictPolicy(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           string
+		renderedReplicas int32
+		expectedReplicas int32
+		expectedImage    string
+	}{
+		{"kudo wins by default", "", 3, 3, "nginx:1.17"},
+		{"kudo wins", kudo.ConflictPolicyKudoWins, 3, 3, "nginx:1.17"},
+		{"other wins", kudo.ConflictPolicyOtherWins, 3, 5, "nginx:1.16"},
+		{"merge keeps field changed only by someone else", kudo.ConflictPolicyMerge, 3, 5, "nginx:1.17"},
+		{"merge asserts field changed by KUDO", kudo.ConflictPolicyMerge, 4, 4, "nginx:1.17"},
+	}
+
+	for _, tt := range tests {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme)
+		step := v1alpha1.Step{Name: "step"}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress}
+
+		// first execution creates the deployment
+		created := conflictingDeployment(tt.policy, 3, "nginx:1.16", nil)
+		if err := executeStep(context.TODO(), step, state, []runtime.Object{created}, nil, clock.RealClock{}, c); err != nil {
+			t.Errorf("%s: Expecting no error creating the deployment but got %v", tt.name, err)
+			continue
+		}
+
+		// an autoscaler changes the replicas
+		live := &appsv1.Deployment{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, live); err != nil {
+			t.Fatal(err)
+		}
+		scaled := int32(5)
+		live.Spec.Replicas = &scaled
+		if err := c.Update(context.TODO(), live); err != nil {
+			t.Fatal(err)
+		}
+
+		// next execution renders a new image and label
+		patched := conflictingDeployment(tt.policy, tt.renderedReplicas, "nginx:1.17", map[string]string{"tier": "web"})
+		if err := executeStep(context.TODO(), step, state, []runtime.Object{patched}, nil, clock.RealClock{}, c); err != nil {
+			t.Errorf("%s: Expecting no error patching the deployment but got %v", tt.name, err)
+			continue
+		}
+
+		if err := c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, live); err != nil {
+			t.Fatal(err)
+		}
+		if *live.Spec.Replicas != tt.expectedReplicas {
+			t.Errorf("%s: Expecting %d replicas but got %d", tt.name, tt.expectedReplicas, *live.Spec.Replicas)
+		}
+		if image := live.Spec.Template.Spec.Containers[0].Image; image != tt.expectedImage {
+			t.Errorf("%s: Expecting image %s but got %s", tt.name, tt.expectedImage, image)
+		}
+		if live.Labels["tier"] != "web" {
+			t.Errorf("%s: Expecting label KUDO added to be patched with any policy but got %v", tt.name, live.Labels)
+		}
+	}
+}
+
+func TestUnknownConflictPolicy(t *testing.T) {
+	c := fake.NewFakeClientWithScheme(scheme.Scheme)
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress}
+
+	err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{conflictingDeployment("mine", 3, "nginx:1.16", nil)}, nil, clock.RealClock{}, c)
+	if exErr := asExecutionError(err); exErr == nil || !exErr.Fatal() {
+		t.Errorf("Expecting fatal error for unknown conflict policy but got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package instance
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/kudobuilder/kudo/pkg/util/template"
+	"github.com/pkg/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/pkg/fs"
+	"sigs.k8s.io/kustomize/pkg/patch"
+	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// podTemplateKinds are the kinds with a pod template at `spec.template` the containers from parameters are merged into
+var podTemplateKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"ReplicaSet":  true,
+	"Job":         true,
+}
+
+// parseContainers parses value of a parameter with container specs, it has to be a YAML list of maps with a name as
+// containers are merged by their name, an empty value is no containers
+func parseContainers(value string) ([]interface{}, error) {
+	containers, err := parseList(value)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("container %d is not a map", i)
+		}
+		if name, ok := container["name"].(string); !ok || name == "" {
+			return nil, fmt.Errorf("container %d has no name", i)
+		}
+	}
+	return containers, nil
+}
+
+// addContainersPatches adds a strategic merge patch to the kustomization for each object of the rendered template with a
+// pod template, the patch merges the containers into the containers of the pod template by their name
+func addContainersPatches(fsys fs.FileSystem, kustomization *ktypes.Kustomization, templateName string, rendered string, containers []interface{}) error {
+	objs, err := template.ParseKubernetesObjects(rendered)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing template %s", templateName)
+	}
+	for _, o := range objs {
+		gvk := o.GetObjectKind().GroupVersionKind()
+		if !podTemplateKinds[gvk.Kind] {
+			continue
+		}
+		name := o.(v1.Object).GetName()
+		overlay, err := sigsyaml.Marshal(map[string]interface{}{
+			"apiVersion": gvk.GroupVersion().String(),
+			"kind":       gvk.Kind,
+			"metadata":   map[string]interface{}{"name": name},
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{"containers": containers},
+				},
+			},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error generating containers patch of %s %s", gvk.Kind, name)
+		}
+		file := fmt.Sprintf("%s.containers/%s-%s.yaml", templateName, gvk.Kind, name)
+		if err := fsys.WriteFile(path.Join(basePath, file), overlay); err != nil {
+			return errors.Wrapf(err, "error when writing containers patch of %s %s to filesystem before applying kustomize", gvk.Kind, name)
+		}
+		kustomization.PatchesStrategicMerge = append(kustomization.PatchesStrategicMerge, patch.StrategicMerge(file))
+	}
+	return nil
+}
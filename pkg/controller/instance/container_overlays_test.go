@@ -0,0 +1,109 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const webDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx
+        env:
+        - name: PORT
+          value: "80"
+`
+
+func TestContainersFromParameterMergedIntoPodTemplate(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = appsv1.AddToScheme(s)
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		operatorName:      "operator",
+		resourcesOwner:    &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}},
+	}
+
+	tests := []struct {
+		name       string
+		containers string
+		expected   map[string]corev1.Container
+	}{
+		{"no containers", "", map[string]corev1.Container{
+			"web": {Name: "web", Image: "nginx", Env: []corev1.EnvVar{{Name: "PORT", Value: "80"}}},
+		}},
+		{"sidecar added and container of the template merged", "[{name: proxy, image: envoy}, {name: web, env: [{name: DEBUG, value: 'true'}]}]", map[string]corev1.Container{
+			"web":   {Name: "web", Image: "nginx", Env: []corev1.EnvVar{{Name: "DEBUG", Value: "true"}, {Name: "PORT", Value: "80"}}},
+			"proxy": {Name: "proxy", Image: "envoy"},
+		}},
+	}
+
+	for _, tt := range tests {
+		plan := generatedValuesPlan("deploy", webDeployment, map[string]string{"SIDECARS": tt.containers})
+		plan.Tasks["task"] = v1alpha1.TaskSpec{Resources: []string{"template.yaml"}, Containers: "SIDECARS"}
+
+		resources, err := prepareKubeResources(plan, meta, &kustomizeEnhancer{scheme: s})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		deployment := resources.PhaseResources["phase"].StepResources["step"][0].(*appsv1.Deployment)
+		containers := deployment.Spec.Template.Spec.Containers
+		if len(containers) != len(tt.expected) {
+			t.Errorf("%s: Expecting containers %v but got %v", tt.name, tt.expected, containers)
+			continue
+		}
+		for _, c := range containers {
+			expected := tt.expected[c.Name]
+			if c.Image != expected.Image || len(c.Env) != len(expected.Env) {
+				t.Errorf("%s: Expecting container %+v but got %+v", tt.name, expected, c)
+				continue
+			}
+			for i := range c.Env {
+				if c.Env[i] != expected.Env[i] {
+					t.Errorf("%s: Expecting env of container %s to be %v but got %v", tt.name, c.Name, expected.Env, c.Env)
+				}
+			}
+		}
+	}
+}
+
+func TestParseContainers(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		expectedError bool
+	}{
+		{"empty", "", false},
+		{"containers", "[{name: proxy, image: envoy}]", false},
+		{"not a list", "name: proxy", true},
+		{"not a container", "[proxy]", true},
+		{"container without name", "[{image: envoy}]", true},
+	}
+
+	for _, tt := range tests {
+		_, err := parseContainers(tt.value)
+		if (err != nil) != tt.expectedError {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.expectedError, err)
+		}
+	}
+}
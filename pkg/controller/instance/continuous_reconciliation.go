@@ -0,0 +1,87 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/health"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reopenBrokenSteps starts the completed steps of the plan again whose objects are gone or no longer healthy, together
+// with their phases, the execution then applies the objects of the steps again in the order of the plan
+func reopenBrokenSteps(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus, resources *planResources, c client.Client) error {
+	for _, ph := range plan.Phases {
+		if ph.Strategy == v1alpha1.Partitioned || ph.Strategy == v1alpha1.BlueGreen {
+			continue
+		}
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || stepState.Status != v1alpha1.ExecutionComplete || st.Delete || st.Scale != "" {
+				continue
+			}
+			reason, err := brokenObject(st, resources.PhaseResources[ph.Name], c)
+			if err != nil {
+				return err
+			}
+			if reason == "" {
+				continue
+			}
+			log.Printf("PlanExecution: Starting completed step %s of phase %s again, %s", st.Name, ph.Name, reason)
+			stepState.Status = v1alpha1.ExecutionPending
+			stepState.Message = fmt.Sprintf("started again, %s", reason)
+			stepState.Stage = ""
+			stepState.StartedAt = metav1.Time{}
+			stepState.AppliedAt = nil
+			stepState.ResourceAttempts = nil
+			stepState.Created = nil
+			phaseState.Status = v1alpha1.ExecutionInProgress
+		}
+	}
+	return nil
+}
+
+// brokenObject returns why the step has to run again because of the first of its objects that is gone or unhealthy,
+// empty if all its objects are fine. Health of objects of steps with pod health or a minimum of ready replicas is not
+// checked, the step decides it as a whole, neither is health of objects with sticky health.
+func brokenObject(step v1alpha1.Step, resources phaseResources, c client.Client) (string, error) {
+	for _, objs := range [][]runtime.Object{resources.StepPreResources[step.Name], resources.StepResources[step.Name], resources.StepPostResources[step.Name]} {
+		for _, obj := range objs {
+			if isCommandJob(obj) {
+				// running the command again is not what a deleted job asks for, jobs are often cleaned up once finished
+				continue
+			}
+			key, err := client.ObjectKeyFromObject(obj)
+			if err != nil {
+				return "", err
+			}
+			existing := emptyObject(obj)
+			err = c.Get(context.TODO(), key, existing)
+			if apierrors.IsNotFound(err) {
+				return fmt.Sprintf("%s/%s is gone", key.Namespace, key.Name), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			if isTerminating(existing) {
+				return fmt.Sprintf("%s/%s is being deleted", key.Namespace, key.Name), nil
+			}
+			if isHealthCheckIgnored(obj) || isHealthSticky(obj) || step.PodHealth != nil || step.MinReadyReplicas > 0 {
+				continue
+			}
+			if err := health.IsHealthy(c, existing); err != nil {
+				return fmt.Sprintf("%s/%s is not healthy: %v", key.Namespace, key.Name, err), nil
+			}
+		}
+	}
+	return "", nil
+}
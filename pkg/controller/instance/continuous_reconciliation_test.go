@@ -0,0 +1,116 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecutePlanRecreatesObjectsOfCompletedSteps(t *testing.T) {
+	tests := []struct {
+		name              string
+		continuous        bool
+		expectedRecreated bool
+	}{
+		{"one-shot execution", false, false},
+		{"continuous reconciliation", true, true},
+	}
+
+	for _, tt := range tests {
+		plan := &activePlan{
+			Name: "deploy",
+			PlanStatus: &v1alpha1.PlanStatus{
+				Name:   "deploy",
+				Status: v1alpha1.ExecutionInProgress,
+				Phases: []v1alpha1.PhaseStatus{
+					{Name: "first", Status: v1alpha1.ExecutionComplete, Steps: []v1alpha1.StepStatus{{Name: "config", Status: v1alpha1.ExecutionComplete}}},
+					{Name: "second", Status: v1alpha1.ExecutionInProgress, Steps: []v1alpha1.StepStatus{{Name: "app", Status: v1alpha1.ExecutionPending}}},
+				},
+			},
+			Spec: &v1alpha1.Plan{
+				Strategy:                 v1alpha1.Serial,
+				ContinuousReconciliation: tt.continuous,
+				Phases: []v1alpha1.Phase{
+					{Name: "first", Strategy: v1alpha1.Serial, Steps: []v1alpha1.Step{{Name: "config", Tasks: []string{"config"}}}},
+					{Name: "second", Strategy: v1alpha1.Serial, Steps: []v1alpha1.Step{{Name: "app", Tasks: []string{"app"}}}},
+				},
+			},
+			Tasks: map[string]v1alpha1.TaskSpec{
+				"config": {Resources: []string{"config"}},
+				"app":    {Resources: []string{"app"}},
+			},
+			Templates: map[string]string{
+				"config": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: default\n",
+				"app":    "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n  namespace: default\n",
+			},
+		}
+		// the config map of the completed step was deleted by hand
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+		meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+		newStatus, err := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if newStatus.Status != v1alpha1.ExecutionComplete {
+			t.Errorf("%s: Expecting plan to be completed but got %v", tt.name, newStatus.Status)
+		}
+		assertExists(t, testClient, &corev1.ConfigMap{}, "app", true)
+		if tt.expectedRecreated {
+			assertExists(t, testClient, &corev1.ConfigMap{}, "config", true)
+		} else {
+			assertExists(t, testClient, &corev1.ConfigMap{}, "config", false)
+		}
+	}
+}
+
+func TestReopenBrokenSteps(t *testing.T) {
+	unhealthy := getDeployment("web", "default", 3)
+	unhealthy.Status = appsv1.DeploymentStatus{ReadyReplicas: 1}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, getConfigMap("config", "default", nil), unhealthy)
+
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "healthy"},
+		{Name: "unhealthy"},
+		{Name: "gone"},
+		{Name: "cleanup", Delete: true},
+	}}}}
+	planState := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionComplete, Steps: []v1alpha1.StepStatus{
+		{Name: "healthy", Status: v1alpha1.ExecutionComplete},
+		{Name: "unhealthy", Status: v1alpha1.ExecutionComplete},
+		{Name: "gone", Status: v1alpha1.ExecutionComplete},
+		{Name: "cleanup", Status: v1alpha1.ExecutionComplete},
+	}}}}
+	resources := &planResources{PhaseResources: map[string]phaseResources{"phase": {StepResources: map[string][]runtime.Object{
+		"healthy":   {getConfigMap("config", "default", nil)},
+		"unhealthy": {getDeployment("web", "default", 3)},
+		"gone":      {getConfigMap("missing", "default", nil)},
+		"cleanup":   {getConfigMap("deleted", "default", nil)},
+	}}}}
+
+	if err := reopenBrokenSteps(plan, planState, resources, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	expected := map[string]v1alpha1.ExecutionStatus{
+		"healthy":   v1alpha1.ExecutionComplete,
+		"unhealthy": v1alpha1.ExecutionPending,
+		"gone":      v1alpha1.ExecutionPending,
+		"cleanup":   v1alpha1.ExecutionComplete,
+	}
+	for _, st := range planState.Phases[0].Steps {
+		if st.Status != expected[st.Name] {
+			t.Errorf("Expecting step %s to be %v but got %v (%s)", st.Name, expected[st.Name], st.Status, st.Message)
+		}
+	}
+	if msg := planState.Phases[0].Steps[2].Message; msg != "started again, default/missing is gone" {
+		t.Errorf("Expecting message telling why the step started again but got %q", msg)
+	}
+	if planState.Phases[0].Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting phase to be in progress again but got %v", planState.Phases[0].Status)
+	}
+}
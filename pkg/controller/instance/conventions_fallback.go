@@ -0,0 +1,72 @@
+package instance
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kudobuilder/kudo/pkg/util/template"
+	"github.com/pkg/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// applyConventionsDirectly adds the names, labels and annotations of the KUDO conventions to the objects parsed from the
+// templates without kustomize, it is the fallback used when kustomize fails on templates it should accept
+// unlike kustomize, it does not rewrite references between the objects to their new names or add the labels to selectors
+// and pod templates, objects with hashed names and containers from parameters are not supported as their names and
+// pod templates are generated by kustomize
+func applyConventionsDirectly(templates map[string]string, metadata metadata) ([]runtime.Object, error) {
+	if len(metadata.Containers) > 0 {
+		return nil, fmt.Errorf("containers from parameters can only be merged into pod templates by kustomize")
+	}
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objs []runtime.Object
+	for _, name := range names {
+		if hasHashSuffix(templates[name]) {
+			return nil, fmt.Errorf("template %s defines objects with hashed names, they can only be named by kustomize", name)
+		}
+		annotated, err := annotateTemplate(templates[name], name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing template %s", name)
+		}
+		if annotated == "" {
+			continue
+		}
+		parsed, err := template.ParseKubernetesObjects(annotated)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing template %s", name)
+		}
+		objs = append(objs, parsed...)
+	}
+
+	for _, o := range objs {
+		objMeta := o.(v1.Object)
+		objMeta.SetName(metadata.InstanceName + "-" + objMeta.GetName() + metadata.nameSuffix())
+		objMeta.SetNamespace(metadata.Namespace)
+		labels := objMeta.GetLabels()
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		for k, v := range conventionLabels(metadata) {
+			labels[k] = v
+		}
+		objMeta.SetLabels(labels)
+		annotations := objMeta.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for k, v := range conventionAnnotations(metadata) {
+			annotations[k] = v
+		}
+		objMeta.SetAnnotations(annotations)
+	}
+	if metadata.SchedulingPolicy != nil {
+		return applySchedulingPolicy(objs, metadata.SchedulingPolicy)
+	}
+	return objs, nil
+}
@@ -0,0 +1,62 @@
+package instance
+
+import (
+	"testing"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestApplyConventionsFallback(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", OperatorVersion: "1.0", PlanName: "deploy", PhaseName: "phase", StepName: "step", TaskName: "task", Color: blueColor}
+	// kustomize refuses the same object defined by two templates
+	templates := map[string]string{
+		"config.yaml": getResourceAsString(getConfigMap("config", "", map[string]string{"app": "web"})),
+		"copy.yaml":   getResourceAsString(getConfigMap("config", "", map[string]string{"app": "web"})),
+	}
+
+	_, err := (&kustomizeEnhancer{scheme: s}).applyConventionsToTemplates(templates, meta, owner)
+	if err == nil {
+		t.Fatal("Expecting kustomize to fail without the fallback but got no error")
+	}
+
+	objs, err := (&kustomizeEnhancer{scheme: s, fallback: true}).applyConventionsToTemplates(templates, meta, owner)
+	if err != nil {
+		t.Fatalf("Expecting no error with the fallback but got %v", err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("Expecting an object for each template but got %v", objs)
+	}
+	for i, template := range []string{"config.yaml", "copy.yaml"} {
+		cm := objs[i].(*corev1.ConfigMap)
+		if cm.Name != "instance-config-blue" || cm.Namespace != "default" {
+			t.Errorf("%s: Expecting object default/instance-config-blue but got %s/%s", template, cm.Namespace, cm.Name)
+		}
+		expectedLabels := map[string]string{"app": "web", kudo.HeritageLabel: "kudo", kudo.OperatorLabel: "operator", kudo.InstanceLabel: "instance", kudo.ColorLabel: blueColor}
+		for k, v := range expectedLabels {
+			if cm.Labels[k] != v {
+				t.Errorf("%s: Expecting label %s=%s but got %v", template, k, v, cm.Labels)
+			}
+		}
+		expectedAnnotations := map[string]string{kudo.PlanAnnotation: "deploy", kudo.PhaseAnnotation: "phase", kudo.StepAnnotation: "step", kudo.OperatorVersionAnnotation: "1.0", kudo.TaskAnnotation: "task", kudo.TemplateAnnotation: template}
+		for k, v := range expectedAnnotations {
+			if cm.Annotations[k] != v {
+				t.Errorf("%s: Expecting annotation %s=%s but got %v", template, k, v, cm.Annotations)
+			}
+		}
+		if ref := metav1.GetControllerOf(cm); ref == nil || ref.UID != "uid" {
+			t.Errorf("%s: Expecting object to be owned by the instance but got %v", template, cm.OwnerReferences)
+		}
+	}
+
+	templates["copy.yaml"] = hashedConfigMap
+	if _, err := (&kustomizeEnhancer{scheme: s, fallback: true}).applyConventionsToTemplates(templates, meta, owner); err == nil {
+		t.Error("Expecting error for hashed names the fallback cannot generate but got none")
+	}
+}
@@ -0,0 +1,135 @@
+package instance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// cronDescriptors are the predefined schedules a CronJob accepts instead of the five fields
+var cronDescriptors = map[string]bool{
+	"@yearly":   true,
+	"@annually": true,
+	"@monthly":  true,
+	"@weekly":   true,
+	"@daily":    true,
+	"@midnight": true,
+	"@hourly":   true,
+}
+
+// cronField is a field of a cron schedule with the range of its values and the names it accepts instead of numbers
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 6, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// validateCronSchedule returns an error if the CronJob controller would not accept the schedule, it accepts the five
+// standard fields with ranges, lists, steps and names, and the predefined descriptors like `@daily` or `@every 1h`
+func validateCronSchedule(schedule string) error {
+	schedule = strings.TrimSpace(schedule)
+	if strings.HasPrefix(schedule, "@") {
+		if cronDescriptors[schedule] {
+			return nil
+		}
+		if every := strings.TrimPrefix(schedule, "@every "); every != schedule {
+			if d, err := time.ParseDuration(strings.TrimSpace(every)); err != nil || d <= 0 {
+				return fmt.Errorf("%q is not a valid duration", every)
+			}
+			return nil
+		}
+		return fmt.Errorf("unknown descriptor %s", schedule)
+	}
+
+	fields := strings.Fields(schedule)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("expected %d fields but got %d", len(cronFields), len(fields))
+	}
+	for i, f := range fields {
+		if err := cronFields[i].validate(f); err != nil {
+			return fmt.Errorf("invalid %s %q: %v", cronFields[i].name, f, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a comma separated list of ranges like `*`, `1-5/2` or `mon-fri`
+func (f cronField) validate(value string) error {
+	for _, r := range strings.Split(value, ",") {
+		rangeAndStep := strings.SplitN(r, "/", 2)
+		if len(rangeAndStep) == 2 {
+			step, err := strconv.Atoi(rangeAndStep[1])
+			if err != nil || step < 1 {
+				return fmt.Errorf("step %q is not a positive number", rangeAndStep[1])
+			}
+		}
+		if rangeAndStep[0] == "*" || rangeAndStep[0] == "?" {
+			continue
+		}
+		bounds := strings.SplitN(rangeAndStep[0], "-", 2)
+		var values []int
+		for _, b := range bounds {
+			v, err := f.value(b)
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+		}
+		if len(values) == 2 && values[0] > values[1] {
+			return fmt.Errorf("range %s starts after it ends", rangeAndStep[0])
+		}
+	}
+	return nil
+}
+
+// value parses a number or a name of the field and checks it is within the range of the field
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// validateCronJobs checks schedules of the rendered CronJobs, an invalid schedule would be rejected by the API server
+// only when the step applies the CronJob, after the objects of the previous steps were applied
+func validateCronJobs(objs []runtime.Object) error {
+	for _, obj := range objs {
+		if obj.GetObjectKind().GroupVersionKind().Kind != "CronJob" {
+			continue
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		schedule, _, _ := unstructured.NestedString(content, "spec", "schedule")
+		if err := validateCronSchedule(schedule); err != nil {
+			name, _, _ := unstructured.NestedString(content, "metadata", "name")
+			return &executionError{err: fmt.Errorf("CronJob %s has invalid schedule %q: %v", name, schedule, err), fatal: true, eventName: kudo.String("InvalidSchedule")}
+		}
+	}
+	return nil
+}
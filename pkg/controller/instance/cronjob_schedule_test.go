@@ -0,0 +1,94 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func getCronJob(name string, schedule string) *batchv1beta1.CronJob {
+	return &batchv1beta1.CronJob{
+		TypeMeta:   metav1.TypeMeta{Kind: "CronJob", APIVersion: "batch/v1beta1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       batchv1beta1.CronJobSpec{Schedule: schedule},
+	}
+}
+
+func TestValidateCronSchedule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		valid    bool
+	}{
+		{"0 3 * * *", true},
+		{"*/15 * * * *", true},
+		{"0 0-23/2 1,15 jan-jun mon-fri", true},
+		{"30 2 ? * SUN", true},
+		{"@daily", true},
+		{"@every 90m", true},
+		{"0 3 * *", false},
+		{"60 3 * * *", false},
+		{"0 3 * * 7", false},
+		{"0 3 32 * *", false},
+		{"*/0 * * * *", false},
+		{"0 5-1 * * *", false},
+		{"0 3 * foo *", false},
+		{"@fortnightly", false},
+		{"@every soon", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		err := validateCronSchedule(tt.schedule)
+		if tt.valid && err != nil {
+			t.Errorf("%q: Expecting schedule to be valid but got %v", tt.schedule, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%q: Expecting schedule to be invalid but it is valid", tt.schedule)
+		}
+	}
+}
+
+func TestInvalidCronJobScheduleFailsRendering(t *testing.T) {
+	plan := generatedValuesPlan("deploy", getResourceAsString(getCronJob("backup", "0 25 * * *")), nil)
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	_, err := prepareKubeResources(plan, meta, &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Fatal("Expecting an error for invalid schedule but got none")
+	}
+	if statusForError(err) != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting a fatal error but got %v", err)
+	}
+	if status := plan.PlanStatus.Phases[0].Steps[0].Status; status != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting step to fail before anything is applied but got %v", status)
+	}
+}
+
+func TestCronJobStepCompletesOnceCreated(t *testing.T) {
+	plan := generatedValuesPlan("deploy", getResourceAsString(getCronJob("backup", "0 3 * * *")), nil)
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+	resources, err := prepareKubeResources(plan, meta, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	objs := resources.PhaseResources["phase"].StepResources["step"]
+	if _, ok := objs[0].(*batchv1beta1.CronJob); !ok {
+		t.Fatalf("Expecting a CronJob to be rendered but got %T", objs[0])
+	}
+
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+	err = executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{objs[0]}, nil, clock.RealClock{}, fake.NewFakeClientWithScheme(scheme.Scheme))
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step with a CronJob that never ran to complete but got %v: %s", state.Status, state.Message)
+	}
+}
@@ -0,0 +1,95 @@
+package instance
+
+import (
+	"context"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	errwrap "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// deleteSelector is a rendered v1alpha1.DeleteSelector with all the labels that selected objects have to match
+type deleteSelector struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	labels    map[string]string
+}
+
+// renderDeleteSelector renders labels of the selector and adds the KUDO labels so that only objects of the current
+// instance can ever be selected
+func renderDeleteSelector(selector *v1alpha1.DeleteSelector, meta *executionMetadata, engine *kudoengine.Engine, configs map[string]interface{}) (*deleteSelector, error) {
+	gv, err := schema.ParseGroupVersion(selector.APIVersion)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error parsing apiVersion of delete selector")
+	}
+
+	labels, err := renderSelectorLabels(selector.MatchLabels, meta, engine, configs)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error expanding delete selector")
+	}
+
+	return &deleteSelector{
+		gvk:       gv.WithKind(selector.Kind),
+		namespace: meta.instanceNamespace,
+		labels:    labels,
+	}, nil
+}
+
+// renderSelectorLabels renders the labels and adds the KUDO labels of the instance to them
+func renderSelectorLabels(matchLabels map[string]string, meta *executionMetadata, engine *kudoengine.Engine, configs map[string]interface{}) (map[string]string, error) {
+	labels := make(map[string]string)
+	for k, v := range matchLabels {
+		value, err := engine.Render(v, configs)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error expanding label %s", k)
+		}
+		labels[k] = value
+	}
+	keys := meta.labelKeys.withDefaults()
+	labels[keys.Heritage] = "kudo"
+	labels[keys.Operator] = meta.operatorName
+	labels[keys.Instance] = meta.instanceName
+	return labels, nil
+}
+
+// deleteBySelector deletes all the objects matching the selector, no matching objects is not considered an error
+func deleteBySelector(selector *deleteSelector, c client.Client, opts ...client.DeleteOption) error {
+	list := newList(selector.gvk)
+	err := c.List(context.TODO(), list, client.InNamespace(selector.namespace), client.MatchingLabels(selector.labels))
+	if err != nil {
+		return errwrap.Wrapf(err, "error listing %s objects to delete", selector.gvk.Kind)
+	}
+
+	objs, err := meta.ExtractList(list)
+	if err != nil {
+		return errwrap.Wrapf(err, "error extracting %s objects to delete", selector.gvk.Kind)
+	}
+	for _, obj := range objs {
+		objMeta, _ := meta.Accessor(obj)
+		log.Printf("PlanExecution: Deleting %s %s/%s matching labels %v", selector.gvk.Kind, objMeta.GetNamespace(), objMeta.GetName(), selector.labels)
+		err := c.Delete(context.TODO(), obj, opts...)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// newList returns typed list for kubernetes native kinds and unstructured list for everything else
+func newList(gvk schema.GroupVersionKind) runtime.Object {
+	listGVK := gvk.GroupVersion().WithKind(gvk.Kind + "List")
+	if list, err := scheme.Scheme.New(listGVK); err == nil {
+		return list
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(listGVK)
+	return list
+}
@@ -0,0 +1,100 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecuteStepDeletesBySelector(t *testing.T) {
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", operatorName: "operator"}
+	instanceLabels := map[string]string{kudo.HeritageLabel: "kudo", kudo.OperatorLabel: "operator", kudo.InstanceLabel: "instance"}
+	withLabels := func(extra map[string]string) map[string]string {
+		result := map[string]string{}
+		for k, v := range instanceLabels {
+			result[k] = v
+		}
+		for k, v := range extra {
+			result[k] = v
+		}
+		return result
+	}
+
+	objects := []runtime.Object{
+		getConfigMap("old-1", "default", withLabels(map[string]string{"revision": "1"})),
+		getConfigMap("old-2", "default", withLabels(map[string]string{"revision": "1"})),
+		getConfigMap("current", "default", withLabels(map[string]string{"revision": "2"})),
+		getConfigMap("other-instance", "default", map[string]string{"revision": "1", kudo.InstanceLabel: "other"}),
+		getConfigMap("unlabeled", "default", nil),
+	}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, objects...)
+
+	selector, err := renderDeleteSelector(&v1alpha1.DeleteSelector{
+		APIVersion:  "v1",
+		Kind:        "ConfigMap",
+		MatchLabels: map[string]string{"revision": "{{ .Params.REVISION }}"},
+	}, meta, kudoengine.New(), map[string]interface{}{"Params": map[string]string{"REVISION": "1"}})
+	if err != nil {
+		t.Fatalf("Expecting no error when rendering selector but got %v", err)
+	}
+
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+	err = executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, nil, selector, clock.RealClock{}, testClient)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step to be completed but got %v", state.Status)
+	}
+
+	remaining := &corev1.ConfigMapList{}
+	_ = testClient.List(context.TODO(), remaining, client.InNamespace("default"))
+	names := map[string]bool{}
+	for _, cm := range remaining.Items {
+		names[cm.Name] = true
+	}
+	for _, deleted := range []string{"old-1", "old-2"} {
+		if names[deleted] {
+			t.Errorf("Expecting config map %s to be deleted", deleted)
+		}
+	}
+	for _, kept := range []string{"current", "other-instance", "unlabeled"} {
+		if !names[kept] {
+			t.Errorf("Expecting config map %s to survive", kept)
+		}
+	}
+
+	// nothing left to delete is still a success
+	state = &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+	err = executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, nil, selector, clock.RealClock{}, testClient)
+	if err != nil {
+		t.Errorf("Expecting no error when nothing matches but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step to be completed when nothing matches but got %v", state.Status)
+	}
+}
+
+func getConfigMap(name string, namespace string, labels map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package instance
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apijson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// detectDrift returns true if the existing object has the detect-drift conflict policy and differs from the rendered one,
+// the changed fields are recorded in the step status under the given key and the object should be left untouched
+// objects are never drifted while the step reconciles drift, their drift is cleared once they match the rendered ones
+func detectDrift(step v1alpha1.Step, state *v1alpha1.StepStatus, rendered runtime.Object, existing runtime.Object, key string) (bool, error) {
+	policy, err := conflictPolicy(rendered)
+	if err != nil || policy != kudo.ConflictPolicyDetectDrift || state.ReconcileDrift {
+		delete(state.Drift, key)
+		return false, err
+	}
+
+	renderedJSON, err := apijson.Marshal(rendered)
+	if err != nil {
+		return false, err
+	}
+	fields, err := patchedFields(existing, renderedJSON)
+	if err != nil {
+		return false, err
+	}
+	if len(fields) == 0 {
+		delete(state.Drift, key)
+		return false, nil
+	}
+
+	log.Printf("PlanExecution: Step %s leaves %s untouched, it drifted from its template: %s", step.Name, key, strings.Join(fields, ", "))
+	if state.Drift == nil {
+		state.Drift = make(map[string]string)
+	}
+	state.Drift[key] = strings.Join(fields, ", ")
+	return true, nil
+}
+
+// updateDriftCondition sets the drifted condition of the plan when any of its steps found drift and clears it once there
+// is none anymore
+func updateDriftCondition(state *v1alpha1.PlanStatus, now time.Time) {
+	var drifted []string
+	for _, ph := range state.Phases {
+		for _, st := range ph.Steps {
+			for key, fields := range st.Drift {
+				drifted = append(drifted, fmt.Sprintf("%s (%s)", key, fields))
+			}
+		}
+	}
+
+	if len(drifted) == 0 {
+		if c := state.Condition(v1alpha1.PlanDrifted); c != nil && c.Status == corev1.ConditionTrue {
+			state.SetCondition(v1alpha1.PlanCondition{Type: v1alpha1.PlanDrifted, Status: corev1.ConditionFalse, Reason: "NoDrift", LastTransitionTime: metav1.NewTime(now)})
+		}
+		return
+	}
+	sort.Strings(drifted)
+	message := fmt.Sprintf("%d objects drifted from their templates: %s", len(drifted), strings.Join(drifted, "; "))
+	state.SetCondition(v1alpha1.PlanCondition{Type: v1alpha1.PlanDrifted, Status: corev1.ConditionTrue, Reason: "Drifted", Message: message, LastTransitionTime: metav1.NewTime(now)})
+}
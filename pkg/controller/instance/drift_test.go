@@ -0,0 +1,131 @@
+package instance
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func driftDetectingDeployment(replicas int32) *appsv1.Deployment {
+	deployment := getDeployment("web", "default", replicas)
+	deployment.Annotations = map[string]string{kudo.ConflictPolicyAnnotation: kudo.ConflictPolicyDetectDrift}
+	return deployment
+}
+
+func TestExecuteStepDetectsDrift(t *testing.T) {
+	driftKey := "Deployment/default/web"
+	tests := []struct {
+		name             string
+		reconcile        bool
+		liveReplicas     int32
+		expectedPatched  bool
+		expectedReplicas int32
+		expectedDrift    string
+	}{
+		{"drift is reported and left untouched", false, 5, false, 5, "spec.replicas: 5 -> 3"},
+		{"object without drift", false, 3, true, 3, ""},
+		{"drift is reconciled", true, 5, true, 3, ""},
+	}
+
+	for _, tt := range tests {
+		live := driftDetectingDeployment(tt.liveReplicas)
+		live.Status.ReadyReplicas = tt.liveReplicas
+		testClient := &patchRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, live)}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending, ReconcileDrift: tt.reconcile, Drift: map[string]string{driftKey: "spec.replicas: 4 -> 3"}}
+
+		rendered := driftDetectingDeployment(3)
+		rendered.Status.ReadyReplicas = 3
+		err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{rendered}, nil, clock.RealClock{}, testClient)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if state.Status != v1alpha1.ExecutionComplete {
+			t.Errorf("%s: Expecting step to complete but got %v: %s", tt.name, state.Status, state.Message)
+		}
+		if patched := len(testClient.patched) > 0; patched != tt.expectedPatched {
+			t.Errorf("%s: Expecting object to be patched %v but got %v", tt.name, tt.expectedPatched, patched)
+		}
+		deployment := &appsv1.Deployment{}
+		_ = testClient.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, deployment)
+		if *deployment.Spec.Replicas != tt.expectedReplicas {
+			t.Errorf("%s: Expecting %d replicas but got %d", tt.name, tt.expectedReplicas, *deployment.Spec.Replicas)
+		}
+		if state.Drift[driftKey] != tt.expectedDrift {
+			t.Errorf("%s: Expecting drift %q but got %q", tt.name, tt.expectedDrift, state.Drift[driftKey])
+		}
+	}
+}
+
+func TestUpdateDriftCondition(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	state := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+		{Name: "a", Drift: map[string]string{"Deployment/default/web": "spec.replicas: 5 -> 3"}},
+		{Name: "b"},
+	}}}}
+
+	updateDriftCondition(state, now)
+	condition := state.Condition(v1alpha1.PlanDrifted)
+	if condition == nil || condition.Status != corev1.ConditionTrue || !strings.Contains(condition.Message, "Deployment/default/web (spec.replicas: 5 -> 3)") {
+		t.Errorf("Expecting drifted condition listing the drifted object but got %+v", condition)
+	}
+
+	state.Phases[0].Steps[0].Drift = nil
+	updateDriftCondition(state, now)
+	if condition := state.Condition(v1alpha1.PlanDrifted); condition == nil || condition.Status != corev1.ConditionFalse {
+		t.Errorf("Expecting drifted condition to be cleared but got %+v", condition)
+	}
+}
+
+func TestReconcileDrift(t *testing.T) {
+	instance := &v1alpha1.Instance{Status: v1alpha1.InstanceStatus{PlanStatus: map[string]v1alpha1.PlanStatus{
+		"deploy": {Name: "deploy", Status: v1alpha1.ExecutionComplete, Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+			{Name: "step", Status: v1alpha1.ExecutionComplete, Drift: map[string]string{"Deployment/default/web": "spec.replicas: 5 -> 3"}},
+		}}}},
+	}}}
+
+	if err := instance.ReconcileDrift("deploy", &v1alpha1.OperatorVersion{}); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	step := instance.Status.PlanStatus["deploy"].Phases[0].Steps[0]
+	if instance.Status.PlanStatus["deploy"].Status != v1alpha1.ExecutionPending || !step.ReconcileDrift || step.Drift != nil {
+		t.Errorf("Expecting plan to be started reconciling drift but got %+v", instance.Status.PlanStatus["deploy"])
+	}
+}
+
+func TestDriftOfSecretDoesNotRevealData(t *testing.T) {
+	live := getSecret("secret")
+	live.StringData = nil
+	live.Annotations = map[string]string{kudo.ConflictPolicyAnnotation: kudo.ConflictPolicyDetectDrift}
+	rendered := live.DeepCopy()
+	rendered.Data = map[string][]byte{"password": []byte("n3w-" + secretValue)}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, live)
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	output := captureLog(func() {
+		if err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{rendered}, nil, clock.RealClock{}, testClient); err != nil {
+			t.Errorf("Expecting no error but got %v", err)
+		}
+	})
+	planState := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{*state}}}}
+	updateDriftCondition(planState, time.Now())
+
+	expected := "data.password: " + v1alpha1.SensitiveValueMask + " -> " + v1alpha1.SensitiveValueMask
+	if drift := state.Drift["Secret/default/secret"]; drift != expected {
+		t.Errorf("Expecting drift %q but got %q", expected, drift)
+	}
+	assertNoSecret(t, "drift", state.Drift["Secret/default/secret"])
+	assertNoSecret(t, "drift condition", planState.Condition(v1alpha1.PlanDrifted).Message)
+	assertNoSecret(t, "log", output)
+}
@@ -0,0 +1,17 @@
+package instance
+
+import "strings"
+
+// isEmptyManifest returns true if the rendered template defines no object, which is the case when a template guarded
+// by a condition like {{ if gt .Params.REPLICAS 1 }} renders to nothing. A manifest is empty when every line is blank,
+// a YAML comment or a document separator.
+func isEmptyManifest(rendered string) bool {
+	for _, line := range strings.Split(rendered, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "---" || line == "..." || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const conditionalPDB = `{{ if gt (atoi .Params.REPLICAS) 1 }}
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: pdb
+spec:
+  maxUnavailable: 1
+{{ end }}
+`
+
+func TestIsEmptyManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		rendered string
+		expected bool
+	}{
+		{"empty", "", true},
+		{"whitespace", "\n  \n\t\n", true},
+		{"comments and separators", "# disabled\n---\n  # also disabled\n...\n", true},
+		{"object", "\napiVersion: v1\nkind: ConfigMap\n", false},
+		{"object after separator", "---\nkind: ConfigMap\n", false},
+	}
+
+	for _, tt := range tests {
+		if actual := isEmptyManifest(tt.rendered); actual != tt.expected {
+			t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+func TestPrepareKubeResourcesSkipsEmptyTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas string
+		expected []string
+	}{
+		{"condition met", "3", []string{"ConfigMap", "PodDisruptionBudget"}},
+		{"template rendered to nothing", "1", []string{"ConfigMap"}},
+	}
+
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = policyv1beta1.AddToScheme(s)
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		resourcesOwner:    &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default"}},
+	}
+	for _, tt := range tests {
+		plan := &activePlan{
+			Name: "deploy",
+			Spec: &v1alpha1.Plan{
+				Strategy: "serial",
+				Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+			},
+			PlanStatus: &v1alpha1.PlanStatus{
+				Name:   "deploy",
+				Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+			},
+			Tasks: map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"config.yaml", "pdb.yaml"}}},
+			Templates: map[string]string{
+				"config.yaml": getResourceAsString(getConfigMap("config", "default", nil)),
+				"pdb.yaml":    conditionalPDB,
+			},
+			params: map[string]string{"REPLICAS": tt.replicas},
+		}
+
+		resources, err := prepareKubeResources(plan, meta, &kustomizeEnhancer{scheme: s})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		objs := resources.PhaseResources["phase"].StepResources["step"]
+		kinds := map[string]bool{}
+		for _, o := range objs {
+			switch o.(type) {
+			case *corev1.ConfigMap:
+				kinds["ConfigMap"] = true
+			case *policyv1beta1.PodDisruptionBudget:
+				kinds["PodDisruptionBudget"] = true
+			}
+		}
+		if len(objs) != len(tt.expected) {
+			t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, kinds)
+			continue
+		}
+		for _, k := range tt.expected {
+			if !kinds[k] {
+				t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, kinds)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package instance
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ExecutionEvent is a change of the status of a plan, one of its phases or steps during an execution
+// Phase and Step are empty for changes of the plan status, Step is empty for changes of a phase status
+type ExecutionEvent struct {
+	Namespace string                   `json:"namespace"`
+	Instance  string                   `json:"instance"`
+	Plan      string                   `json:"plan"`
+	Phase     string                   `json:"phase,omitempty"`
+	Step      string                   `json:"step,omitempty"`
+	OldStatus v1alpha1.ExecutionStatus `json:"oldStatus"`
+	NewStatus v1alpha1.ExecutionStatus `json:"newStatus"`
+	Time      time.Time                `json:"time"`
+}
+
+// executionEventsBuffer is the number of events kept for a client of the HTTP endpoint until it reads them
+const executionEventsBuffer = 100
+
+// ExecutionEvents streams execution events to subscribers in process, e.g. to feed a watch endpoint or a websocket
+// publishing never blocks, events are dropped for subscribers that do not keep up instead of stalling the reconciliation
+type ExecutionEvents struct {
+	mu          sync.Mutex
+	subscribers map[chan ExecutionEvent]struct{}
+}
+
+// NewExecutionEvents returns a stream of execution events without any subscribers
+func NewExecutionEvents() *ExecutionEvents {
+	return &ExecutionEvents{subscribers: make(map[chan ExecutionEvent]struct{})}
+}
+
+// Subscribe returns a channel receiving all the events published from now on, up to buffer events are kept for the
+// subscriber until it reads them, later ones are dropped
+// the returned function unsubscribes and closes the channel, it is safe to call it more than once
+func (e *ExecutionEvents) Subscribe(buffer int) (<-chan ExecutionEvent, func()) {
+	ch := make(chan ExecutionEvent, buffer)
+	e.mu.Lock()
+	e.subscribers[ch] = struct{}{}
+	e.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			delete(e.subscribers, ch)
+			e.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// ServeHTTP streams the events published from now on to the client as JSON, one event per line, until the client
+// disconnects, see Subscribe for events of clients that do not keep up
+func (e *ExecutionEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := e.Subscribe(executionEventsBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ServeExecutionEvents returns a runnable for the manager serving the events over HTTP at the address until the manager
+// stops, see ExecutionEvents.ServeHTTP
+func ServeExecutionEvents(address string, events *ExecutionEvents) manager.Runnable {
+	return manager.RunnableFunc(func(stop <-chan struct{}) error {
+		server := &http.Server{Addr: address, Handler: events}
+		go func() {
+			<-stop
+			server.Close()
+		}()
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+}
+
+// publish sends the events to all the subscribers in order, events that do not fit the buffer of a subscriber are dropped
+func (e *ExecutionEvents) publish(events []ExecutionEvent) {
+	if e == nil || len(events) == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers {
+		for _, event := range events {
+			select {
+			case ch <- event:
+			default:
+				log.Printf("PlanExecution: Dropping event of plan %s of instance %s/%s, the subscriber is not keeping up", event.Plan, event.Namespace, event.Instance)
+			}
+		}
+	}
+}
+
+// executionStatuses returns the statuses of the plan, its phases and steps, keyed by "", "phase" and "phase/step"
+func executionStatuses(status *v1alpha1.PlanStatus) map[string]v1alpha1.ExecutionStatus {
+	statuses := map[string]v1alpha1.ExecutionStatus{"": status.Status}
+	for _, ph := range status.Phases {
+		statuses[ph.Name] = ph.Status
+		for _, st := range ph.Steps {
+			statuses[ph.Name+"/"+st.Name] = st.Status
+		}
+	}
+	return statuses
+}
+
+// executionEvents returns an event for each status that changed since before, ordered from the plan down to its phases
+// and their steps in the order they are defined in
+func executionEvents(meta *executionMetadata, before map[string]v1alpha1.ExecutionStatus, after *v1alpha1.PlanStatus, now time.Time) []ExecutionEvent {
+	var events []ExecutionEvent
+	add := func(phase, step, key string, status v1alpha1.ExecutionStatus) {
+		if before[key] == status {
+			return
+		}
+		events = append(events, ExecutionEvent{
+			Namespace: meta.instanceNamespace,
+			Instance:  meta.instanceName,
+			Plan:      after.Name,
+			Phase:     phase,
+			Step:      step,
+			OldStatus: before[key],
+			NewStatus: status,
+			Time:      now,
+		})
+	}
+
+	add("", "", "", after.Status)
+	for _, ph := range after.Phases {
+		add(ph.Name, "", ph.Name, ph.Status)
+		for _, st := range ph.Steps {
+			add(ph.Name, st.Name, ph.Name+"/"+st.Name, st.Status)
+		}
+	}
+	return events
+}
@@ -0,0 +1,114 @@
+package instance
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecutePlanPublishesEvents(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	events := NewExecutionEvents()
+	received, unsubscribe := events.Subscribe(10)
+	defer unsubscribe()
+
+	plan := generatedValuesPlan("deploy", getResourceAsString(getConfigMap("config", "default", nil)), nil)
+	plan.PlanStatus.Status = v1alpha1.ExecutionPending
+	plan.PlanStatus.Phases[0].Status = v1alpha1.ExecutionPending
+	plan.PlanStatus.Phases[0].Steps[0].Status = v1alpha1.ExecutionPending
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", clock: clock.NewFakeClock(now), events: events}
+
+	_, err := executePlan(plan, meta, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	expected := []ExecutionEvent{
+		{Namespace: "default", Instance: "instance", Plan: "deploy", OldStatus: v1alpha1.ExecutionPending, NewStatus: v1alpha1.ExecutionComplete, Time: now},
+		{Namespace: "default", Instance: "instance", Plan: "deploy", Phase: "phase", OldStatus: v1alpha1.ExecutionPending, NewStatus: v1alpha1.ExecutionComplete, Time: now},
+		{Namespace: "default", Instance: "instance", Plan: "deploy", Phase: "phase", Step: "step", OldStatus: v1alpha1.ExecutionPending, NewStatus: v1alpha1.ExecutionComplete, Time: now},
+	}
+	for i, e := range expected {
+		select {
+		case event := <-received:
+			if !reflect.DeepEqual(event, e) {
+				t.Errorf("Expecting event %d to be %+v but got %+v", i, e, event)
+			}
+		default:
+			t.Fatalf("Expecting event %d to be %+v but got none", i, e)
+		}
+	}
+	select {
+	case event := <-received:
+		t.Errorf("Expecting no more events but got %+v", event)
+	default:
+	}
+}
+
+func TestExecutionEventsDoNotBlockOnSlowSubscribers(t *testing.T) {
+	events := NewExecutionEvents()
+	slow, unsubscribeSlow := events.Subscribe(1)
+	defer unsubscribeSlow()
+	fast, unsubscribeFast := events.Subscribe(3)
+	defer unsubscribeFast()
+
+	published := []ExecutionEvent{{Plan: "deploy", Step: "a"}, {Plan: "deploy", Step: "b"}, {Plan: "deploy", Step: "c"}}
+	done := make(chan struct{})
+	go func() {
+		events.publish(published)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expecting publishing not to block on a full subscriber")
+	}
+
+	if event := <-slow; event.Step != "a" {
+		t.Errorf("Expecting the slow subscriber to get the first event but got %+v", event)
+	}
+	if len(slow) != 0 {
+		t.Errorf("Expecting the events the slow subscriber did not keep up with to be dropped but %d are left", len(slow))
+	}
+	if len(fast) != 3 {
+		t.Errorf("Expecting the other subscriber to get all the events but got %d", len(fast))
+	}
+
+	unsubscribeFast()
+	unsubscribeFast()
+	events.publish(published)
+	if len(fast) != 3 {
+		t.Errorf("Expecting no events after unsubscribing but got %d", len(fast))
+	}
+}
+
+func TestExecutionEventsServeHTTP(t *testing.T) {
+	events := NewExecutionEvents()
+	server := httptest.NewServer(events)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expecting no error watching the events but got %v", err)
+	}
+	defer resp.Body.Close()
+
+	// the watcher is subscribed once the response started
+	events.publish([]ExecutionEvent{{Namespace: "default", Instance: "instance", Plan: "deploy", Phase: "phase", OldStatus: v1alpha1.ExecutionPending, NewStatus: v1alpha1.ExecutionInProgress}})
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Expecting an event but got %v", err)
+	}
+	expected := `{"namespace":"default","instance":"instance","plan":"deploy","phase":"phase","oldStatus":"PENDING","newStatus":"IN_PROGRESS","time":"0001-01-01T00:00:00Z"}` + "\n"
+	if line != expected {
+		t.Errorf("Expecting event %s but got %s", expected, line)
+	}
+}
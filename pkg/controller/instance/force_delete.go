@@ -0,0 +1,142 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// deleteOptions returns the options objects deleted by the step are deleted with, dependents are deleted in the
+// foreground and the grace period of the step overrides the default one of the objects
+func deleteOptions(step v1alpha1.Step) []client.DeleteOption {
+	opts := []client.DeleteOption{client.PropagationPolicy(metav1.DeletePropagationForeground)}
+	if step.GracePeriodSeconds != nil {
+		opts = append(opts, client.GracePeriodSeconds(*step.GracePeriodSeconds))
+	}
+	return opts
+}
+
+// waitForDeletion returns true once the deleted object is gone
+// an object terminating for longer than the force delete of the step allows is stripped of the finalizers the step lists
+// and deleted again with zero grace period, without force delete the step just keeps waiting
+func waitForDeletion(force *v1alpha1.ForceDelete, obj runtime.Object, now time.Time, c client.Client) (bool, error) {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return false, err
+	}
+	existing := obj.DeepCopyObject()
+	err = c.Get(context.TODO(), key, existing)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	objMeta, err := meta.Accessor(existing)
+	if err != nil {
+		return false, err
+	}
+	deletedAt := objMeta.GetDeletionTimestamp()
+	if force == nil || deletedAt == nil || now.Before(deletedAt.Add(time.Duration(force.AfterSeconds)*time.Second)) {
+		return false, nil
+	}
+
+	log.Printf("PlanExecution: WARNING: %s is terminating since %v, forcing its deletion and removing finalizers %v", prettyPrint(key), deletedAt, force.Finalizers)
+	if finalizers := withoutFinalizers(objMeta.GetFinalizers(), force.Finalizers); len(finalizers) != len(objMeta.GetFinalizers()) {
+		objMeta.SetFinalizers(finalizers)
+		if err := c.Update(context.TODO(), existing); err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("removing finalizers of %s: %v", prettyPrint(key), err)
+		}
+	}
+	err = c.Delete(context.TODO(), existing, client.GracePeriodSeconds(0))
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("forcing deletion of %s: %v", prettyPrint(key), err)
+	}
+	return false, nil
+}
+
+// withoutFinalizers returns the finalizers except the removed ones
+func withoutFinalizers(finalizers []string, removed []string) []string {
+	kept := []string{}
+	for _, f := range finalizers {
+		remove := false
+		for _, r := range removed {
+			remove = remove || f == r
+		}
+		if !remove {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// forceDeleteRequeueAfter returns the shortest time after which a step of the plan forces the deletion of its objects,
+// zero if no step is waiting for deletion. Objects stuck terminating do not change, so nothing else triggers the next execution
+func forceDeleteRequeueAfter(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus) time.Duration {
+	var after time.Duration
+	for _, ph := range plan.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			if !st.Delete || st.ForceDelete == nil {
+				continue
+			}
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || stepState.Status != v1alpha1.ExecutionInProgress {
+				continue
+			}
+			if wait := time.Duration(st.ForceDelete.AfterSeconds) * time.Second; after == 0 || wait < after {
+				after = wait
+			}
+		}
+	}
+	return after
+}
+
+// isDeletionTimedOut returns true if the step waited for the deletion of its objects for longer than it allows
+func isDeletionTimedOut(wait *v1alpha1.WaitForDeletion, state *v1alpha1.StepStatus, now time.Time) bool {
+	if wait == nil || wait.TimeoutSeconds == 0 || state.StartedAt.IsZero() {
+		return false
+	}
+	return !now.Before(state.StartedAt.Add(time.Duration(wait.TimeoutSeconds) * time.Second))
+}
+
+// deletionTimeoutRequeueAfter returns the shortest time after which a step of the plan waiting for deletion times out,
+// zero if no step waits with a timeout. Objects that never go away do not trigger the next execution on their own
+func deletionTimeoutRequeueAfter(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus, now time.Time) time.Duration {
+	var after time.Duration
+	for _, ph := range plan.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			if !st.Delete || st.WaitForDeletion == nil || st.WaitForDeletion.TimeoutSeconds == 0 {
+				continue
+			}
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || stepState.Status != v1alpha1.ExecutionInProgress || stepState.StartedAt.IsZero() {
+				continue
+			}
+			wait := stepState.StartedAt.Add(time.Duration(st.WaitForDeletion.TimeoutSeconds) * time.Second).Sub(now)
+			if wait <= 0 {
+				wait = time.Second
+			}
+			if after == 0 || wait < after {
+				after = wait
+			}
+		}
+	}
+	return after
+}
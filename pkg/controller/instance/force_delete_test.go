@@ -0,0 +1,261 @@
+package instance
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// terminatingClient keeps deleted objects that have finalizers until the finalizers are removed, like the API server does
+type terminatingClient struct {
+	client.Client
+	gracePeriods []*int64
+	propagations []*metav1.DeletionPropagation
+}
+
+func (c *terminatingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	options := (&client.DeleteOptions{}).ApplyOptions(opts)
+	c.gracePeriods = append(c.gracePeriods, options.GracePeriodSeconds)
+	c.propagations = append(c.propagations, options.PropagationPolicy)
+	existing := obj.DeepCopyObject()
+	key, _ := client.ObjectKeyFromObject(obj)
+	if err := c.Client.Get(ctx, key, existing); err != nil {
+		return err
+	}
+	if len(existing.(metav1.Object).GetFinalizers()) > 0 {
+		return nil
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *terminatingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if err := c.Client.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	objMeta := obj.(metav1.Object)
+	if objMeta.GetDeletionTimestamp() != nil && len(objMeta.GetFinalizers()) == 0 {
+		return c.Client.Delete(ctx, obj)
+	}
+	return nil
+}
+
+func getTerminatingPVC(terminatingFor time.Duration, finalizers ...string) *corev1.PersistentVolumeClaim {
+	deletedAt := metav1.NewTime(time.Now().Add(-terminatingFor))
+	return &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{Kind: "PersistentVolumeClaim", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "data",
+			Namespace:         "default",
+			DeletionTimestamp: &deletedAt,
+			Finalizers:        finalizers,
+		},
+	}
+}
+
+func TestExecuteStepForcesDeletionOfStuckObjects(t *testing.T) {
+	force := &v1alpha1.ForceDelete{AfterSeconds: 60, Finalizers: []string{"kubernetes.io/pvc-protection"}}
+	zero := int64(0)
+
+	tests := []struct {
+		name               string
+		force              *v1alpha1.ForceDelete
+		existing           *corev1.PersistentVolumeClaim
+		expectedStatus     v1alpha1.ExecutionStatus
+		expectedFinalizers []string
+		expectedForced     bool
+	}{
+		{"deletion is not awaited by default", nil, getTerminatingPVC(time.Hour, "kubernetes.io/pvc-protection"), v1alpha1.ExecutionComplete, []string{"kubernetes.io/pvc-protection"}, false},
+		{"object terminating for a short time", force, getTerminatingPVC(time.Second, "kubernetes.io/pvc-protection"), v1alpha1.ExecutionInProgress, []string{"kubernetes.io/pvc-protection"}, false},
+		{"object stuck terminating", force, getTerminatingPVC(2*time.Minute, "kubernetes.io/pvc-protection"), v1alpha1.ExecutionInProgress, nil, true},
+		{"object stuck on finalizer not listed", force, getTerminatingPVC(2*time.Minute, "kubernetes.io/pvc-protection", "example.com/backup"), v1alpha1.ExecutionInProgress, []string{"example.com/backup"}, true},
+	}
+
+	for _, tt := range tests {
+		testClient := &terminatingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, tt.existing)}
+		step := v1alpha1.Step{Name: "step", Delete: true, ForceDelete: tt.force}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		pvc := tt.existing.DeepCopy()
+		pvc.DeletionTimestamp = nil
+		if err := executeStep(context.TODO(), step, state, []runtime.Object{pvc}, nil, clock.RealClock{}, testClient); err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting step status %v but got %v", tt.name, tt.expectedStatus, state.Status)
+		}
+		if tt.expectedStatus == v1alpha1.ExecutionInProgress && state.Message != "waiting for deletion of default/data" {
+			t.Errorf("%s: Expecting step to report the object it waits for but got %s", tt.name, state.Message)
+		}
+
+		forced := false
+		for _, grace := range testClient.gracePeriods {
+			forced = forced || reflect.DeepEqual(grace, &zero)
+		}
+		if forced != tt.expectedForced {
+			t.Errorf("%s: Expecting forced deletion to be %v but got grace periods %v", tt.name, tt.expectedForced, testClient.gracePeriods)
+		}
+
+		current := &corev1.PersistentVolumeClaim{}
+		err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "data"}, current)
+		if tt.expectedFinalizers == nil {
+			if err == nil {
+				t.Errorf("%s: Expecting object to be gone once its finalizers are removed but it has %v", tt.name, current.Finalizers)
+			}
+			// the next execution sees the object is gone
+			if err := executeStep(context.TODO(), step, state, []runtime.Object{pvc}, nil, clock.RealClock{}, testClient); err != nil {
+				t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			}
+			if state.Status != v1alpha1.ExecutionComplete {
+				t.Errorf("%s: Expecting step to be complete once the object is gone but got %v", tt.name, state.Status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Expecting object to still exist but got %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(current.Finalizers, tt.expectedFinalizers) {
+			t.Errorf("%s: Expecting finalizers %v but got %v", tt.name, tt.expectedFinalizers, current.Finalizers)
+		}
+	}
+}
+
+func TestForceDeleteRequeueAfter(t *testing.T) {
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "waiting", Delete: true, ForceDelete: &v1alpha1.ForceDelete{AfterSeconds: 300}},
+		{Name: "waiting-shorter", Delete: true, ForceDelete: &v1alpha1.ForceDelete{AfterSeconds: 30}},
+		{Name: "pending", Delete: true, ForceDelete: &v1alpha1.ForceDelete{AfterSeconds: 10}},
+	}}}}
+	status := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+		{Name: "waiting", Status: v1alpha1.ExecutionInProgress},
+		{Name: "waiting-shorter", Status: v1alpha1.ExecutionInProgress},
+		{Name: "pending", Status: v1alpha1.ExecutionPending},
+	}}}}
+
+	if after := forceDeleteRequeueAfter(plan, status); after != 30*time.Second {
+		t.Errorf("Expecting requeue after the shortest force delete timeout of 30s but got %v", after)
+	}
+	if after := forceDeleteRequeueAfter(plan, &v1alpha1.PlanStatus{}); after != 0 {
+		t.Errorf("Expecting no requeue without steps waiting for deletion but got %v", after)
+	}
+}
+
+func TestExecuteStepWaitsForDeletion(t *testing.T) {
+	pvc := getTerminatingPVC(time.Second, "kubernetes.io/pvc-protection")
+	testClient := &terminatingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, pvc)}
+	step := v1alpha1.Step{Name: "step", Delete: true, WaitForDeletion: &v1alpha1.WaitForDeletion{TimeoutSeconds: 60}}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending, StartedAt: metav1.Now()}
+	deleted := pvc.DeepCopy()
+	deleted.DeletionTimestamp = nil
+
+	// the object is still terminating
+	if err := executeStep(context.TODO(), step, state, []runtime.Object{deleted}, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress || state.Message != "waiting for deletion of default/data" {
+		t.Errorf("Expecting step to wait for deletion of default/data but got %v: %s", state.Status, state.Message)
+	}
+	for _, grace := range testClient.gracePeriods {
+		if grace != nil {
+			t.Errorf("Expecting deletion not to be forced but got grace period %d", *grace)
+		}
+	}
+
+	// the deletion completes
+	if err := testClient.Client.Delete(context.TODO(), deleted); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if err := executeStep(context.TODO(), step, state, []runtime.Object{deleted}, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step to be complete once the object is gone but got %v", state.Status)
+	}
+}
+
+func TestExecuteStepDeletionTimeout(t *testing.T) {
+	pvc := getTerminatingPVC(time.Hour, "kubernetes.io/pvc-protection")
+	testClient := &terminatingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, pvc)}
+	step := v1alpha1.Step{Name: "step", Delete: true, WaitForDeletion: &v1alpha1.WaitForDeletion{TimeoutSeconds: 60}}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress, StartedAt: metav1.NewTime(time.Now().Add(-2 * time.Minute))}
+	deleted := pvc.DeepCopy()
+	deleted.DeletionTimestamp = nil
+
+	err := executeStep(context.TODO(), step, state, []runtime.Object{deleted}, nil, clock.RealClock{}, testClient)
+	if err == nil {
+		t.Fatal("Expecting step to fail once the deletion timed out but got no error")
+	}
+	if statusForError(err) != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting timed out deletion to be a fatal error but got %v", statusForError(err))
+	}
+}
+
+func TestExecuteStepDeleteGracePeriod(t *testing.T) {
+	long, zero := int64(300), int64(0)
+	selector := &deleteSelector{gvk: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, namespace: "default", labels: map[string]string{"app": "old"}}
+
+	tests := []struct {
+		name     string
+		grace    *int64
+		selector *deleteSelector
+	}{
+		{"default grace period", nil, nil},
+		{"long drain window", &long, nil},
+		{"immediate deletion", &zero, nil},
+		{"grace period of objects deleted by selector", &long, selector},
+	}
+
+	for _, tt := range tests {
+		testClient := &terminatingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, getConfigMap("config", "default", nil), getConfigMap("old", "default", map[string]string{"app": "old"}))}
+		step := v1alpha1.Step{Name: "step", Delete: true, GracePeriodSeconds: tt.grace}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		if err := executeStep(context.TODO(), step, state, []runtime.Object{getConfigMap("config", "default", nil)}, tt.selector, clock.RealClock{}, testClient); err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		expectedDeletes := 1
+		if tt.selector != nil {
+			expectedDeletes = 2
+		}
+		if len(testClient.gracePeriods) != expectedDeletes {
+			t.Fatalf("%s: Expecting %d deletes but got %d", tt.name, expectedDeletes, len(testClient.gracePeriods))
+		}
+		foreground := metav1.DeletePropagationForeground
+		for i, grace := range testClient.gracePeriods {
+			if !reflect.DeepEqual(grace, tt.grace) {
+				t.Errorf("%s: Expecting grace period %v but got %v", tt.name, tt.grace, grace)
+			}
+			if !reflect.DeepEqual(testClient.propagations[i], &foreground) {
+				t.Errorf("%s: Expecting foreground propagation along the grace period but got %v", tt.name, testClient.propagations[i])
+			}
+		}
+	}
+}
+
+func TestDeletionTimeoutRequeueAfter(t *testing.T) {
+	now := time.Now()
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "waiting", Delete: true, WaitForDeletion: &v1alpha1.WaitForDeletion{TimeoutSeconds: 300}},
+		{Name: "waiting-without-timeout", Delete: true, WaitForDeletion: &v1alpha1.WaitForDeletion{}},
+		{Name: "pending", Delete: true, WaitForDeletion: &v1alpha1.WaitForDeletion{TimeoutSeconds: 10}},
+	}}}}
+	status := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+		{Name: "waiting", Status: v1alpha1.ExecutionInProgress, StartedAt: metav1.NewTime(now.Add(-time.Minute))},
+		{Name: "waiting-without-timeout", Status: v1alpha1.ExecutionInProgress, StartedAt: metav1.NewTime(now)},
+		{Name: "pending", Status: v1alpha1.ExecutionPending},
+	}}}}
+
+	if after := deletionTimeoutRequeueAfter(plan, status, now); after != 4*time.Minute {
+		t.Errorf("Expecting requeue once the deletion times out in 4m but got %v", after)
+	}
+}
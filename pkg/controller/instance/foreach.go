@@ -0,0 +1,45 @@
+package instance
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// renderStep renders the resources of the step, a step iterating over a list parameter is rendered once per item
+func renderStep(plan *activePlan, meta *executionMetadata, phase v1alpha1.Phase, step v1alpha1.Step, engine *kudoengine.Engine, templates kudoengine.Renderer, configs map[string]interface{}, renderer kubernetesObjectEnhancer, color string, changedParams map[string]bool) ([]runtime.Object, []runtime.Object, error) {
+	if step.ForEach == "" {
+		return renderStepResources(plan, meta, phase, step, engine, templates, configs, renderer, color, "", changedParams)
+	}
+
+	items, err := parseList(plan.params[step.ForEach])
+	if err != nil {
+		err := fmt.Errorf("parameter %s iterated over by step %s of phase %s is not a valid YAML list: %v", step.ForEach, step.Name, phase.Name, err)
+		log.Print(err)
+		return nil, nil, &executionError{err: err, fatal: true, eventName: kudo.String("InvalidParameter")}
+	}
+	if changedParams[step.ForEach] {
+		// objects of all the items depend on the list even when their templates do not reference it
+		changedParams = nil
+	}
+
+	defer delete(configs, "Item")
+	defer delete(configs, "ItemIndex")
+	var resources, unchanged []runtime.Object
+	for i, item := range items {
+		configs["Item"] = item
+		configs["ItemIndex"] = i
+		itemResources, itemUnchanged, err := renderStepResources(plan, meta, phase, step, engine, templates, configs, renderer, color, strconv.Itoa(i), changedParams)
+		if err != nil {
+			return nil, nil, err
+		}
+		resources = append(resources, itemResources...)
+		unchanged = append(unchanged, itemUnchanged...)
+	}
+	return resources, unchanged, nil
+}
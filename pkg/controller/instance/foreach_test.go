@@ -0,0 +1,83 @@
+package instance
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const shardTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: shard
+data:
+  zone: {{ .Item.zone }}
+  index: "{{ .ItemIndex }}"
+`
+
+func forEachPlan(shards string) *activePlan {
+	plan := generatedValuesPlan("deploy", shardTemplate, map[string]string{"SHARDS": shards})
+	plan.parameters = []v1alpha1.Parameter{{Name: "SHARDS", Type: v1alpha1.ListParameterType}}
+	plan.Spec.Phases[0].Steps[0].ForEach = "SHARDS"
+	return plan
+}
+
+func TestForEachRendersOneShardPerItem(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		operatorName:      "operator",
+		resourcesOwner:    &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}},
+	}
+
+	tests := []struct {
+		name     string
+		shards   string
+		expected map[string]string
+	}{
+		{"three shards", "[{zone: a}, {zone: b}, {zone: c}]", map[string]string{"instance-shard-0": "a", "instance-shard-1": "b", "instance-shard-2": "c"}},
+		{"empty list", "[]", map[string]string{}},
+		{"unset list", "", map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		resources, err := prepareKubeResources(forEachPlan(tt.shards), meta, &kustomizeEnhancer{scheme: s})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		rendered := make(map[string]string)
+		for i, obj := range resources.PhaseResources["phase"].StepResources["step"] {
+			cm := obj.(*corev1.ConfigMap)
+			rendered[cm.Name] = cm.Data["zone"]
+			if cm.Data["index"] != strconv.Itoa(i) {
+				t.Errorf("%s: Expecting %s to be rendered with index %d but got %s", tt.name, cm.Name, i, cm.Data["index"])
+			}
+		}
+		if len(rendered) != len(tt.expected) {
+			t.Errorf("%s: Expecting %d shards but got %v", tt.name, len(tt.expected), rendered)
+		}
+		for name, zone := range tt.expected {
+			if rendered[name] != zone {
+				t.Errorf("%s: Expecting shard %s in zone %s but got %v", tt.name, name, zone, rendered)
+			}
+		}
+	}
+}
+
+func TestForEachRejectsMalformedList(t *testing.T) {
+	_, err := prepareKubeResources(forEachPlan("zone: a"), &executionMetadata{instanceName: "instance", instanceNamespace: "default"}, &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Fatal("Expecting error rendering a malformed list but got none")
+	}
+	if exErr, ok := err.(*executionError); !ok || !exErr.fatal {
+		t.Errorf("Expecting fatal execution error but got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getGeneratedValues returns data of the ConfigMaps and Secrets of the instance labeled with kudo.GeneratedLabel, keyed
+// by the value of the label, e.g. a password Secret rendered by the deploy plan with `kudo.dev/generated: credentials`
+// is available to templates of a later backup plan as `{{ .Generated.credentials.password }}`.
+//
+// The value of the label is the lookup contract between plans, unlike the name of the object it does not change with
+// the instance name, color or hash suffix. Values are read from the objects that exist when the plan is executed, so an
+// object is only available to plans executed after the one creating it. Referencing values of an object that does not
+// exist fails the rendering like any other missing key, templates that can run before it exists guard the usage with
+// `{{ if hasKey .Generated "credentials" }}`.
+func getGeneratedValues(c client.Client, namespace string, instanceName string, labelKeys LabelKeys) (map[string]interface{}, error) {
+	keys := labelKeys.withDefaults()
+	selector := client.MatchingLabels{keys.Heritage: "kudo", keys.Instance: instanceName}
+	values := make(map[string]interface{})
+	sources := make(map[string]string)
+	add := func(kind string, name string, labels map[string]string, data map[string]string) error {
+		key, ok := labels[kudo.GeneratedLabel]
+		if !ok {
+			return nil
+		}
+		source := fmt.Sprintf("%s %s", kind, name)
+		if other, ok := sources[key]; ok {
+			return fmt.Errorf("both %s and %s expose generated values as %s", other, source, key)
+		}
+		sources[key] = source
+		values[key] = data
+		return nil
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	if err := c.List(context.TODO(), configMaps, client.InNamespace(namespace), selector); err != nil {
+		log.Printf("InstanceController: Error listing config maps of instance %s/%s: %v", namespace, instanceName, err)
+		return nil, err
+	}
+	for _, cm := range configMaps.Items {
+		if err := add("ConfigMap", cm.Name, cm.Labels, cm.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := c.List(context.TODO(), secrets, client.InNamespace(namespace), selector); err != nil {
+		log.Printf("InstanceController: Error listing secrets of instance %s/%s: %v", namespace, instanceName, err)
+		return nil, err
+	}
+	for _, s := range secrets.Items {
+		data := make(map[string]string, len(s.Data))
+		for k, v := range s.Data {
+			data[k] = string(v)
+		}
+		if err := add("Secret", s.Name, s.Labels, data); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,176 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const generatedSecret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+  labels:
+    kudo.dev/generated: credentials
+stringData:
+  password: {{ .Params.PASSWORD }}
+`
+
+const backupConfig = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: backup
+data:
+  password: {{ .Generated.credentials.password }}
+`
+
+const guardedBackupConfig = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: backup
+data:
+  password: {{ if hasKey .Generated "credentials" }}{{ .Generated.credentials.password }}{{ end }}
+`
+
+func generatedValuesPlan(name string, template string, params map[string]string) *activePlan {
+	return &activePlan{
+		Name: name,
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   name,
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"template.yaml"}}},
+		Templates: map[string]string{"template.yaml": template},
+		params:    params,
+	}
+}
+
+func TestGeneratedValuesPassedToLaterPlan(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		operatorName:      "operator",
+		resourcesOwner:    &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}},
+	}
+	c := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+	// deploy plan creates the secret
+	resources, err := prepareKubeResources(generatedValuesPlan("deploy", generatedSecret, map[string]string{"PASSWORD": "s3cr3t"}), meta, &kustomizeEnhancer{scheme: s})
+	if err != nil {
+		t.Fatalf("Expecting no error rendering deploy plan but got %v", err)
+	}
+	for _, obj := range resources.PhaseResources["phase"].StepResources["step"] {
+		// the API server moves string data to data
+		secret := obj.(*corev1.Secret)
+		secret.Data = map[string][]byte{}
+		for k, v := range secret.StringData {
+			secret.Data[k] = []byte(v)
+		}
+		secret.StringData = nil
+		if err := c.Create(context.TODO(), secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// backup plan reads the password
+	meta.generatedValues, err = getGeneratedValues(c, "default", "instance", LabelKeys{})
+	if err != nil {
+		t.Fatalf("Expecting no error reading generated values but got %v", err)
+	}
+	resources, err = prepareKubeResources(generatedValuesPlan("backup", backupConfig, nil), meta, &kustomizeEnhancer{scheme: s})
+	if err != nil {
+		t.Fatalf("Expecting no error rendering backup plan but got %v", err)
+	}
+	cm := resources.PhaseResources["phase"].StepResources["step"][0].(*corev1.ConfigMap)
+	if cm.Data["password"] != "s3cr3t" {
+		t.Errorf("Expecting generated password to be passed to the backup plan but got %v", cm.Data)
+	}
+}
+
+func TestGeneratedValuesMissingSource(t *testing.T) {
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	_, err := prepareKubeResources(generatedValuesPlan("backup", backupConfig, nil), meta, &testKubernetesObjectEnhancer{})
+	if exErr := asExecutionError(err); exErr == nil || !exErr.Fatal() {
+		t.Errorf("Expecting fatal error when the source of generated values does not exist but got %v", err)
+	}
+
+	resources, err := prepareKubeResources(generatedValuesPlan("backup", guardedBackupConfig, nil), meta, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting guarded template to render without the source but got %v", err)
+	}
+	if cm := resources.PhaseResources["phase"].StepResources["step"][0].(*corev1.ConfigMap); cm.Data["password"] != "" {
+		t.Errorf("Expecting no password without the source but got %v", cm.Data)
+	}
+}
+
+func TestGetGeneratedValues(t *testing.T) {
+	labels := func(generated string, instance string) map[string]string {
+		l := map[string]string{kudo.HeritageLabel: "kudo", kudo.InstanceLabel: instance}
+		if generated != "" {
+			l[kudo.GeneratedLabel] = generated
+		}
+		return l
+	}
+	secret := func(name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Data:       map[string][]byte{"password": []byte(name)},
+		}
+	}
+	config := getConfigMap("settings", "default", labels("settings", "instance"))
+	config.Data = map[string]string{"url": "http://db"}
+
+	tests := []struct {
+		name        string
+		existing    []runtime.Object
+		expected    map[string]map[string]string
+		expectError bool
+	}{
+		{"nothing generated", []runtime.Object{secret("plain", labels("", "instance"))}, map[string]map[string]string{}, false},
+		{"config map and secret", []runtime.Object{config, secret("credentials", labels("credentials", "instance"))},
+			map[string]map[string]string{"settings": {"url": "http://db"}, "credentials": {"password": "credentials"}}, false},
+		{"other instance", []runtime.Object{secret("credentials", labels("credentials", "other"))}, map[string]map[string]string{}, false},
+		{"same key twice", []runtime.Object{secret("first", labels("credentials", "instance")), secret("second", labels("credentials", "instance"))}, nil, true},
+	}
+
+	for _, tt := range tests {
+		values, err := getGeneratedValues(fake.NewFakeClientWithScheme(scheme.Scheme, tt.existing...), "default", "instance", LabelKeys{})
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("%s: Expecting error but got %v", tt.name, values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if len(values) != len(tt.expected) {
+			t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, values)
+			continue
+		}
+		for k, data := range tt.expected {
+			actual, _ := values[k].(map[string]string)
+			for dk, dv := range data {
+				if actual[dk] != dv {
+					t.Errorf("%s: Expecting %s.%s to be %s but got %v", tt.name, k, dk, dv, values[k])
+				}
+			}
+		}
+	}
+}
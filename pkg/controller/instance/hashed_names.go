@@ -0,0 +1,151 @@
+package instance
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"github.com/kudobuilder/kudo/pkg/util/template"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/kustomize/pkg/fs"
+	ktypes "sigs.k8s.io/kustomize/pkg/types"
+)
+
+// hasHashSuffix returns true if the rendered template possibly contains an object asking for a name suffix hashed from its content
+func hasHashSuffix(rendered string) bool {
+	return strings.Contains(rendered, kudo.HashSuffixAnnotation)
+}
+
+// hashedObject returns the ConfigMap or Secret defined by the template if it asks for a name suffix hashed from its content
+// such an object has to be the only object of its template, nil is returned for templates without hashed objects
+func hashedObject(rendered string) (v1.Object, error) {
+	if !hasHashSuffix(rendered) {
+		return nil, nil
+	}
+	objs, err := template.ParseKubernetesObjects(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashed v1.Object
+	for _, o := range objs {
+		objMeta := o.(v1.Object)
+		if objMeta.GetAnnotations()[kudo.HashSuffixAnnotation] != "true" {
+			continue
+		}
+		switch o.(type) {
+		case *corev1.ConfigMap, *corev1.Secret:
+		default:
+			return nil, fmt.Errorf("%s annotation of %s is supported only for ConfigMaps and Secrets", kudo.HashSuffixAnnotation, objMeta.GetName())
+		}
+		if len(objs) != 1 {
+			return nil, fmt.Errorf("%s with %s annotation has to be the only object in its template", objMeta.GetName(), kudo.HashSuffixAnnotation)
+		}
+		hashed = objMeta
+	}
+	return hashed, nil
+}
+
+// addHashedGenerator adds the hashed object to the kustomization as a generator instead of a resource, kustomize then adds
+// the hash of the content to its name and rewrites all the references to it in other objects of the kustomization
+// data of the object is written to the filesystem next to the template, one file per key
+func addHashedGenerator(fsys fs.FileSystem, kustomization *ktypes.Kustomization, templateName string, obj v1.Object) error {
+	data := make(map[string][]byte)
+	var secret *corev1.Secret
+	switch o := obj.(type) {
+	case *corev1.ConfigMap:
+		if len(o.BinaryData) > 0 {
+			return fmt.Errorf("binary data of ConfigMap %s is not supported with %s annotation", o.Name, kudo.HashSuffixAnnotation)
+		}
+		for k, v := range o.Data {
+			data[k] = []byte(v)
+		}
+	case *corev1.Secret:
+		for k, v := range o.Data {
+			data[k] = v
+		}
+		for k, v := range o.StringData {
+			data[k] = []byte(v)
+		}
+		secret = o
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sources := ktypes.DataSources{}
+	for _, k := range keys {
+		file := path.Join(fmt.Sprintf("%s.data", templateName), k)
+		if err := fsys.WriteFile(path.Join(basePath, file), data[k]); err != nil {
+			return errors.Wrapf(err, "error when writing data of %s to filesystem before applying kustomize", obj.GetName())
+		}
+		sources.FileSources = append(sources.FileSources, fmt.Sprintf("%s=%s", k, file))
+	}
+
+	args := ktypes.GeneratorArgs{Name: obj.GetName(), DataSources: sources}
+	if secret != nil {
+		kustomization.SecretGenerator = append(kustomization.SecretGenerator, ktypes.SecretArgs{GeneratorArgs: args, Type: string(secret.Type)})
+	} else {
+		kustomization.ConfigMapGenerator = append(kustomization.ConfigMapGenerator, ktypes.ConfigMapArgs{GeneratorArgs: args})
+	}
+	return nil
+}
+
+// restoreHashedMetadata copies labels and annotations of the hashed objects as defined in the templates to the generated
+// objects, generators of kustomize only take the data over
+// the generated objects are found by their kind and name without the hash suffix
+func restoreHashedMetadata(objs []runtime.Object, hashed []v1.Object, metadata metadata) {
+	suffix := metadata.nameSuffix()
+	for _, o := range objs {
+		objMeta := o.(v1.Object)
+		name := objMeta.GetName()
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			name = name[:i]
+		}
+		for _, h := range hashed {
+			if kindOf(h) != kindOf(objMeta) || metadata.InstanceName+"-"+h.GetName()+suffix != name {
+				continue
+			}
+			labels := objMeta.GetLabels()
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			for k, v := range h.GetLabels() {
+				if _, ok := labels[k]; !ok {
+					labels[k] = v
+				}
+			}
+			objMeta.SetLabels(labels)
+
+			annotations := objMeta.GetAnnotations()
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			for k, v := range h.GetAnnotations() {
+				if _, ok := annotations[k]; !ok && k != kudo.HashSuffixAnnotation {
+					annotations[k] = v
+				}
+			}
+			objMeta.SetAnnotations(annotations)
+		}
+	}
+}
+
+func kindOf(obj v1.Object) string {
+	switch obj.(type) {
+	case *corev1.ConfigMap:
+		return "ConfigMap"
+	case *corev1.Secret:
+		return "Secret"
+	default:
+		return ""
+	}
+}
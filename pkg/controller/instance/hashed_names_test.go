@@ -0,0 +1,138 @@
+package instance
+
+import (
+	"strings"
+	"testing"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const hashedConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  labels:
+    app: web
+  annotations:
+    kudo.dev/hash-suffix: "true"
+data:
+  setting: %s
+`
+
+const deploymentUsingConfig = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx
+      volumes:
+      - name: config
+        configMap:
+          name: config
+`
+
+func TestApplyConventionsHashedNames(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", PlanName: "deploy", PhaseName: "phase", StepName: "step"}
+	enhancer := &kustomizeEnhancer{scheme: s}
+
+	render := func(setting string) (*corev1.ConfigMap, *appsv1.Deployment) {
+		objs, err := enhancer.applyConventionsToTemplates(map[string]string{
+			"config.yaml":     strings.Replace(hashedConfigMap, "%s", setting, 1),
+			"deployment.yaml": deploymentUsingConfig,
+		}, meta, owner)
+		if err != nil {
+			t.Fatalf("Expecting no error but got %v", err)
+		}
+		var cm *corev1.ConfigMap
+		var deployment *appsv1.Deployment
+		for _, o := range objs {
+			switch o := o.(type) {
+			case *corev1.ConfigMap:
+				cm = o
+			case *appsv1.Deployment:
+				deployment = o
+			}
+		}
+		if cm == nil || deployment == nil {
+			t.Fatalf("Expecting a ConfigMap and a Deployment but got %v", objs)
+		}
+		return cm, deployment
+	}
+
+	first, deployment := render("one")
+	if !strings.HasPrefix(first.Name, "instance-config-") {
+		t.Errorf("Expecting ConfigMap name with a hash suffix but got %s", first.Name)
+	}
+	if first.Data["setting"] != "one" {
+		t.Errorf("Expecting ConfigMap data to be kept but got %v", first.Data)
+	}
+	if first.Labels["app"] != "web" || first.Labels[kudo.InstanceLabel] != "instance" {
+		t.Errorf("Expecting labels from the template and KUDO labels but got %v", first.Labels)
+	}
+	if _, ok := first.Annotations[kudo.HashSuffixAnnotation]; ok {
+		t.Errorf("Expecting %s annotation to be removed but got %v", kudo.HashSuffixAnnotation, first.Annotations)
+	}
+	if deployment.Name != "instance-web" {
+		t.Errorf("Expecting other objects not to be hashed but got %s", deployment.Name)
+	}
+	if ref := deployment.Spec.Template.Spec.Volumes[0].ConfigMap.Name; ref != first.Name {
+		t.Errorf("Expecting deployment to reference %s but got %s", first.Name, ref)
+	}
+
+	same, _ := render("one")
+	if same.Name != first.Name {
+		t.Errorf("Expecting the same content to produce the same name %s but got %s", first.Name, same.Name)
+	}
+
+	changed, deployment := render("two")
+	if changed.Name == first.Name {
+		t.Errorf("Expecting changed content to produce a new name but got %s", changed.Name)
+	}
+	if ref := deployment.Spec.Template.Spec.Volumes[0].ConfigMap.Name; ref != changed.Name {
+		t.Errorf("Expecting deployment to reference %s but got %s", changed.Name, ref)
+	}
+
+	// objects rendered for an item of a list parameter keep their labels too
+	meta.Item = "1"
+	item, _ := render("one")
+	if !strings.HasPrefix(item.Name, "instance-config-1-") || item.Labels["app"] != "web" {
+		t.Errorf("Expecting hashed ConfigMap of the item with labels from the template but got %s with %v", item.Name, item.Labels)
+	}
+}
+
+func TestHashedObjectValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		hashed      bool
+		expectedErr bool
+	}{
+		{"no annotation", deploymentUsingConfig, false, false},
+		{"hashed configmap", strings.Replace(hashedConfigMap, "%s", "one", 1), true, false},
+		{"not the only object", strings.Replace(hashedConfigMap, "%s", "one", 1) + "---\n" + deploymentUsingConfig, false, true},
+		{"unsupported kind", strings.Replace(deploymentUsingConfig, "name: web\n", "name: web\n  annotations:\n    kudo.dev/hash-suffix: \"true\"\n", 1), false, true},
+	}
+
+	for _, tt := range tests {
+		obj, err := hashedObject(tt.template)
+		if tt.expectedErr != (err != nil) {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.expectedErr, err)
+		}
+		if tt.hashed != (obj != nil) {
+			t.Errorf("%s: Expecting hashed object %v but got %v", tt.name, tt.hashed, obj)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package instance
+
+import (
+	"log"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// healthWatchedTypes are the kinds whose health is evaluated from their status, a change of the status of an object of
+// these kinds labeled with an instance triggers the execution of the instance right away instead of on the next requeue
+var healthWatchedTypes = []runtime.Object{
+	&appsv1.Deployment{},
+	&appsv1.StatefulSet{},
+	&appsv1.DaemonSet{},
+	&batchv1.Job{},
+	&corev1.PersistentVolumeClaim{},
+}
+
+// labeledObjectToInstance maps an object to the instance named by its instance label, objects are rendered into the
+// namespace of their instance
+func labeledObjectToInstance(keys LabelKeys) handler.ToRequestsFunc {
+	instanceLabel := keys.withDefaults().Instance
+	return func(obj handler.MapObject) []reconcile.Request {
+		instance, ok := obj.Meta.GetLabels()[instanceLabel]
+		if !ok || instance == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: instance, Namespace: obj.Meta.GetNamespace()}}}
+	}
+}
+
+// statusChangeHandler passes only the events that can change the health of the object to the wrapped handler, updates
+// that change the status of the object and deletions. Creations are left out as a new object has no status yet and the
+// instance that created it is executed anyway.
+type statusChangeHandler struct {
+	handler.EventHandler
+}
+
+// Create implements handler.EventHandler
+func (h *statusChangeHandler) Create(event.CreateEvent, workqueue.RateLimitingInterface) {}
+
+// Update implements handler.EventHandler
+func (h *statusChangeHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	if !statusChanged(e.ObjectOld, e.ObjectNew) {
+		return
+	}
+	h.EventHandler.Update(e, q)
+}
+
+// statusChanged returns true if the status of the objects differs, objects that cannot be compared are considered changed
+func statusChanged(old, new runtime.Object) bool {
+	if old == nil || new == nil {
+		return true
+	}
+	oldContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		log.Printf("InstanceController: Error converting %v to compare its status: %v", old.GetObjectKind().GroupVersionKind(), err)
+		return true
+	}
+	newContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(new)
+	if err != nil {
+		log.Printf("InstanceController: Error converting %v to compare its status: %v", new.GetObjectKind().GroupVersionKind(), err)
+		return true
+	}
+	return !reflect.DeepEqual(oldContent["status"], newContent["status"])
+}
@@ -0,0 +1,64 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestStatusChangeOfLabeledObjectEnqueuesInstance(t *testing.T) {
+	labeled := getDeployment("kafka-broker", "default", 3)
+	labeled.Labels = map[string]string{kudo.InstanceLabel: "kafka"}
+	ready := labeled.DeepCopy()
+	ready.Status.ReadyReplicas = 3
+	annotated := labeled.DeepCopy()
+	annotated.Annotations = map[string]string{"touched": "true"}
+	unlabeled := getDeployment("other", "default", 3)
+	unlabeledReady := unlabeled.DeepCopy()
+	unlabeledReady.Status.ReadyReplicas = 3
+
+	update := func(old, new *appsv1.Deployment) func(h handler.EventHandler, q workqueue.RateLimitingInterface) {
+		return func(h handler.EventHandler, q workqueue.RateLimitingInterface) {
+			h.Update(event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: new, ObjectNew: new}, q)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		send     func(h handler.EventHandler, q workqueue.RateLimitingInterface)
+		expected []reconcile.Request
+	}{
+		{"status changed", update(labeled, ready), []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "kafka"}}}},
+		{"only metadata changed", update(labeled, annotated), nil},
+		{"object without instance label", update(unlabeled, unlabeledReady), nil},
+		{"object created", func(h handler.EventHandler, q workqueue.RateLimitingInterface) {
+			h.Create(event.CreateEvent{Meta: labeled, Object: labeled}, q)
+		}, nil},
+		{"object deleted", func(h handler.EventHandler, q workqueue.RateLimitingInterface) {
+			h.Delete(event.DeleteEvent{Meta: labeled, Object: labeled}, q)
+		}, []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "kafka"}}}},
+	}
+
+	for _, tt := range tests {
+		h := &statusChangeHandler{&handler.EnqueueRequestsFromMapFunc{ToRequests: labeledObjectToInstance(LabelKeys{})}}
+		q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		tt.send(h, q)
+
+		var actual []reconcile.Request
+		for q.Len() > 0 {
+			item, _ := q.Get()
+			actual = append(actual, item.(reconcile.Request))
+			q.Done(item)
+		}
+		if len(actual) != len(tt.expected) || (len(actual) > 0 && actual[0] != tt.expected[0]) {
+			t.Errorf("%s: Expecting %v to be enqueued but got %v", tt.name, tt.expected, actual)
+		}
+		q.ShutDown()
+	}
+}
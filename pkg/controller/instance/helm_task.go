@@ -0,0 +1,208 @@
+package instance
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	errwrap "github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// helmHookAnnotation marks objects of a chart that Helm runs as hooks instead of installing them
+const helmHookAnnotation = "helm.sh/hook"
+
+// chartMetadata is the part of Chart.yaml exposed to the chart templates as `.Chart`
+type chartMetadata struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// renderHelmChart renders the chart of a Helm task and returns the rendered manifests keyed by their file name
+// the templates are rendered the way Helm does it, with `.Values`, `.Release` and `.Chart` and the Helm specific
+// functions `toYaml`, `include`, `tpl` and `required`, objects of Helm hooks are left out
+func renderHelmChart(taskName string, spec *v1alpha1.HelmSpec, templates map[string]string, engine *kudoengine.Engine, configs map[string]interface{}) (map[string]string, error) {
+	prefix := spec.Chart + "/"
+	chartFile, ok := templates[prefix+"Chart.yaml"]
+	if !ok {
+		return nil, fmt.Errorf("chart %s of task %s not found", spec.Chart, taskName)
+	}
+	chart := chartMetadata{}
+	if err := yaml.Unmarshal([]byte(chartFile), &chart); err != nil {
+		return nil, errwrap.Wrapf(err, "error parsing Chart.yaml of chart %s", spec.Chart)
+	}
+
+	values, err := chartValues(spec, templates[prefix+"values.yaml"], engine, configs)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error computing values of chart %s", spec.Chart)
+	}
+
+	vals := map[string]interface{}{
+		"Values": values,
+		"Release": map[string]interface{}{
+			"Name":      configs["Name"],
+			"Namespace": configs["Namespace"],
+			"Service":   "KUDO",
+			"IsInstall": configs["PlanName"] == v1alpha1.DeployPlanName,
+			"IsUpgrade": configs["PlanName"] != v1alpha1.DeployPlanName,
+		},
+		"Chart": map[string]interface{}{
+			"Name":        chart.Name,
+			"Version":     chart.Version,
+			"AppVersion":  chart.AppVersion,
+			"Description": chart.Description,
+		},
+	}
+
+	t := template.New(spec.Chart).Option("missingkey=zero")
+	t.Funcs(helmFuncMap(engine, t))
+
+	var files []string
+	for name, content := range templates {
+		if !strings.HasPrefix(name, prefix) || name == prefix+"Chart.yaml" || name == prefix+"values.yaml" {
+			continue
+		}
+		if _, err := t.New(name).Parse(content); err != nil {
+			return nil, errwrap.Wrapf(err, "error parsing template %s", name)
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	manifests := make(map[string]string)
+	for _, name := range files {
+		// partials like _helpers.tpl only define templates for the others
+		if strings.HasPrefix(path.Base(name), "_") {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, name, vals); err != nil {
+			return nil, errwrap.Wrapf(err, "error rendering template %s", name)
+		}
+		manifest, err := withoutHooks(strings.Replace(buf.String(), "<no value>", "", -1))
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error parsing rendered template %s", name)
+		}
+		if manifest != "" {
+			manifests[name] = manifest
+		}
+	}
+	return manifests, nil
+}
+
+// chartValues returns the default values of the chart overridden by the rendered values of the task
+func chartValues(spec *v1alpha1.HelmSpec, defaults string, engine *kudoengine.Engine, configs map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(defaults), &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+
+	keys := make([]string, 0, len(spec.Values))
+	for k := range spec.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		rendered, err := engine.Render(spec.Values[k], configs)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error rendering value %s", k)
+		}
+		var value interface{} = rendered
+		if err := yaml.Unmarshal([]byte(rendered), &value); err != nil || value == nil {
+			value = rendered
+		}
+
+		current := values
+		parts := strings.Split(k, ".")
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := current[p]
+			if !ok {
+				next = make(map[string]interface{})
+				current[p] = next
+			}
+			nextMap, ok := next.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("value %s cannot be set, %s is not a map", k, p)
+			}
+			current = nextMap
+		}
+		current[parts[len(parts)-1]] = value
+	}
+	return values, nil
+}
+
+// helmFuncMap returns the functions of the KUDO engine with the functions Helm adds on top of sprig
+func helmFuncMap(engine *kudoengine.Engine, t *template.Template) template.FuncMap {
+	funcs := template.FuncMap{}
+	for k, v := range engine.FuncMap {
+		funcs[k] = v
+	}
+	funcs["toYaml"] = func(v interface{}) string {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSuffix(string(data), "\n")
+	}
+	funcs["include"] = func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		err := t.ExecuteTemplate(&buf, name, data)
+		return buf.String(), err
+	}
+	funcs["tpl"] = func(tpl string, data interface{}) (string, error) {
+		nested, err := t.Clone()
+		if err != nil {
+			return "", err
+		}
+		nested, err = nested.New("tpl").Parse(tpl)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		err = nested.Execute(&buf, data)
+		return buf.String(), err
+	}
+	funcs["required"] = func(msg string, v interface{}) (interface{}, error) {
+		if v == nil || v == "" {
+			return nil, errwrap.New(msg)
+		}
+		return v, nil
+	}
+	return funcs
+}
+
+// withoutHooks removes objects of Helm hooks from the rendered manifest
+func withoutHooks(manifest string) (string, error) {
+	var docs []string
+	for _, doc := range strings.Split("\n"+manifest, "\n---") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return "", err
+		}
+		if hook, ok := obj.Metadata.Annotations[helmHookAnnotation]; ok {
+			log.Printf("PlanExecution: Skipping object of Helm hook %s, use pre or post tasks of the step instead", hook)
+			continue
+		}
+		docs = append(docs, strings.Trim(doc, "\n"))
+	}
+	return strings.Join(docs, "\n---\n"), nil
+}
@@ -0,0 +1,128 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+var testChart = map[string]string{
+	"web/Chart.yaml": `apiVersion: v1
+name: web
+version: 0.1.0
+appVersion: "1.16"
+`,
+	"web/values.yaml": `replicaCount: 1
+image:
+  repository: nginx
+  tag: latest
+ingress:
+  enabled: false
+`,
+	"web/_helpers.tpl": `{{- define "web.fullname" -}}
+{{ .Release.Name }}-{{ .Chart.Name }}
+{{- end -}}
+`,
+	"web/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ include "web.fullname" . }}
+  labels:
+    chart: {{ .Chart.Name }}-{{ .Chart.Version }}
+spec:
+  replicas: {{ .Values.replicaCount }}
+  template:
+    spec:
+      containers:
+      - name: web
+        image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"
+`,
+	"web/ingress.yaml": `{{- if .Values.ingress.enabled }}
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: {{ include "web.fullname" . }}
+{{- end }}
+`,
+	"web/hook.yaml": `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ include "web.fullname" . }}-migrate
+  annotations:
+    helm.sh/hook: pre-upgrade
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ include "web.fullname" . }}-config
+`,
+}
+
+func TestRenderHelmChart(t *testing.T) {
+	spec := &v1alpha1.HelmSpec{
+		Chart: "web",
+		Values: map[string]string{
+			"replicaCount": "{{ .Params.REPLICAS }}",
+			"image.tag":    "{{ .Params.VERSION }}",
+		},
+	}
+	configs := map[string]interface{}{
+		"Name":      "instance",
+		"Namespace": "default",
+		"PlanName":  "deploy",
+		"Params":    map[string]string{"REPLICAS": "3", "VERSION": "1.16"},
+	}
+
+	manifests, err := renderHelmChart("web", spec, testChart, kudoengine.New(), configs)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if _, ok := manifests["web/ingress.yaml"]; ok {
+		t.Errorf("Expecting disabled template to be left out but got %v", manifests["web/ingress.yaml"])
+	}
+	if _, ok := manifests["web/_helpers.tpl"]; ok {
+		t.Errorf("Expecting partials to be left out")
+	}
+	if manifests["web/hook.yaml"] != "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: instance-web-config" {
+		t.Errorf("Expecting object of the hook to be left out but got %q", manifests["web/hook.yaml"])
+	}
+
+	objs, err := (&testKubernetesObjectEnhancer{}).applyConventionsToTemplates(map[string]string{"deployment": manifests["web/deployment.yaml"]}, metadata{}, nil)
+	if err != nil {
+		t.Fatalf("Expecting rendered deployment to be parseable but got %v", err)
+	}
+	deployment := objs[0].(*appsv1.Deployment)
+	if deployment.Name != "instance-web" {
+		t.Errorf("Expecting name from the helper template but got %s", deployment.Name)
+	}
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("Expecting replicas from the parameter but got %d", *deployment.Spec.Replicas)
+	}
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.16" {
+		t.Errorf("Expecting image with the default repository and the tag from the parameter but got %s", image)
+	}
+	if deployment.Labels["chart"] != "web-0.1.0" {
+		t.Errorf("Expecting chart metadata in labels but got %v", deployment.Labels)
+	}
+}
+
+func TestRenderHelmChartErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   *v1alpha1.HelmSpec
+		params map[string]string
+	}{
+		{"missing chart", &v1alpha1.HelmSpec{Chart: "db"}, nil},
+		{"value under a scalar", &v1alpha1.HelmSpec{Chart: "web", Values: map[string]string{"replicaCount.min": "1"}}, nil},
+		{"missing parameter", &v1alpha1.HelmSpec{Chart: "web", Values: map[string]string{"replicaCount": "{{ .Params.REPLICAS }}"}}, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		configs := map[string]interface{}{"Name": "instance", "Params": tt.params}
+		if _, err := renderHelmChart("web", tt.spec, testChart, kudoengine.New(), configs); err == nil {
+			t.Errorf("%s: Expecting error but got none", tt.name)
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/source"
@@ -30,6 +31,7 @@ import (
 	"github.com/kudobuilder/kudo/pkg/util/kudo"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/tools/record"
 
 	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
@@ -46,11 +48,40 @@ type Reconciler struct {
 	client.Client
 	Recorder record.EventRecorder
 	Scheme   *runtime.Scheme
+	// ClusterConfig references the config map with variables available to all templates under `.Cluster`, optional
+	ClusterConfig types.NamespacedName
+	// Mutators are applied in the given order to all objects rendered from templates before they are applied, optional
+	Mutators []ObjectMutator
+	// StallTimeout is the time a plan can make no progress before a warning is reported, DefaultStallTimeout when not set
+	StallTimeout time.Duration
+	// KindPolicy restricts the kinds of objects plans may apply, optional
+	KindPolicy KindPolicy
+	// Events receives status changes of plans, phases and steps as they are executed, optional
+	Events *ExecutionEvents
+	// ConventionsFallback makes KUDO conventions be applied without kustomize to templates kustomize fails on, optional
+	ConventionsFallback bool
+	// ResultSink persists records of finished plans beyond the status of the instance, optional
+	ResultSink ResultSink
+	// LabelKeys override the keys of the labels and annotations KUDO adds to the objects, the `kudo.dev/` keys are used
+	// for the ones not set
+	LabelKeys LabelKeys
+
+	// scopes caches whether kinds of the rendered objects are namespaced, it is set up with the manager
+	scopes *scopeCache
+	// locks make sure only one plan execution runs for an instance at a time
+	locks instanceLocks
 }
 
 // SetupWithManager registers this reconciler with the controller manager
 func (r *Reconciler) SetupWithManager(
 	mgr ctrl.Manager) error {
+	// the mapper of the manager never discovers kinds whose CRDs are created after the manager started
+	scopes, err := newDiscoveryScopeCache(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.scopes = scopes
+
 	addOvRelatedInstancesToReconcile := handler.ToRequestsFunc(
 		func(obj handler.MapObject) []reconcile.Request {
 			requests := make([]reconcile.Request, 0)
@@ -80,15 +111,23 @@ func (r *Reconciler) SetupWithManager(
 			return requests
 		})
 
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&kudov1alpha1.Instance{}).
 		Owns(&kudov1alpha1.Instance{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&batchv1.Job{}).
 		Owns(&appsv1.StatefulSet{}).
-		Watches(&source.Kind{Type: &kudov1alpha1.OperatorVersion{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: addOvRelatedInstancesToReconcile}).
-		Complete(r)
+		Watches(&source.Kind{Type: &kudov1alpha1.OperatorVersion{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: addOvRelatedInstancesToReconcile})
+	for _, t := range healthWatchedTypes {
+		// objects are usually owned by the instance too, but the label also covers the ones without an owner reference
+		builder = builder.Watches(&source.Kind{Type: t}, &statusChangeHandler{&handler.EnqueueRequestsFromMapFunc{ToRequests: labeledObjectToInstance(r.LabelKeys)}})
+	}
+	if r.ClusterConfig.Name != "" {
+		// change of cluster variables means all instances are re-rendered
+		builder = builder.Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: clusterConfigToInstances(mgr.GetClient(), r.ClusterConfig)})
+	}
+	return builder.Complete(r)
 }
 
 // Reconcile is the main controller method that gets called every time something about the instance changes
@@ -121,6 +160,9 @@ func (r *Reconciler) Reconcile(request ctrl.Request) (ctrl.Result, error) {
 	// ---------- 1. Query the current state ----------
 
 	log.Printf("InstanceController: Received Reconcile request for instance \"%+v\"", request.Name)
+	unlock := r.locks.lock(request.NamespacedName)
+	defer unlock()
+
 	instance, err := r.getInstance(request)
 	if err != nil {
 		if apierrors.IsNotFound(err) { // not retrying if instance not found, probably someone manually removed it?
@@ -129,6 +171,8 @@ func (r *Reconciler) Reconcile(request ctrl.Request) (ctrl.Result, error) {
 		}
 		return reconcile.Result{}, err
 	}
+	// all the changes done during this reconciliation are persisted as a patch against the instance as we read it
+	original := instance.DeepCopy()
 
 	ov, err := r.getOperatorVersion(instance)
 	if err != nil {
@@ -145,9 +189,38 @@ func (r *Reconciler) Reconcile(request ctrl.Request) (ctrl.Result, error) {
 		log.Printf("InstanceController: Going to start execution of plan %s on instance %s/%s", kudo.StringValue(planToBeExecuted), instance.Namespace, instance.Name)
 		err = instance.StartPlanExecution(kudo.StringValue(planToBeExecuted), ov)
 		if err != nil {
-			return reconcile.Result{}, r.handleError(err, instance)
+			return reconcile.Result{}, r.handleError(err, instance, original)
 		}
+		recordParameterChanges(instance, ov, kudo.StringValue(planToBeExecuted))
 		r.Recorder.Event(instance, "Normal", "PlanStarted", fmt.Sprintf("Execution of plan %s started", kudo.StringValue(planToBeExecuted)))
+	} else if planName, ok := instance.Annotations[kudo.RetryPlanAnnotation]; ok {
+		// a retry is a one time request, so the annotation is removed no matter whether the plan can be retried
+		delete(instance.Annotations, kudo.RetryPlanAnnotation)
+		if err := instance.RetryFailedSteps(planName); err != nil {
+			log.Printf("InstanceController: Not retrying plan %s on instance %s/%s: %v", planName, instance.Namespace, instance.Name, err)
+			r.Recorder.Event(instance, "Warning", "PlanNotRetried", err.Error())
+			if err := r.updateInstance(instance, original); err != nil {
+				return reconcile.Result{}, err
+			}
+			original = instance.DeepCopy()
+		} else {
+			log.Printf("InstanceController: Going to retry failed steps of plan %s on instance %s/%s", planName, instance.Namespace, instance.Name)
+			r.Recorder.Event(instance, "Normal", "PlanRetried", fmt.Sprintf("Execution of failed steps of plan %s restarted", planName))
+		}
+	} else if planName, ok := instance.Annotations[kudo.ReconcileDriftAnnotation]; ok {
+		// like a retry, reconciling drift is a one time request
+		delete(instance.Annotations, kudo.ReconcileDriftAnnotation)
+		if err := instance.ReconcileDrift(planName, ov); err != nil {
+			log.Printf("InstanceController: Not reconciling drift of plan %s on instance %s/%s: %v", planName, instance.Namespace, instance.Name, err)
+			r.Recorder.Event(instance, "Warning", "DriftNotReconciled", err.Error())
+			if err := r.updateInstance(instance, original); err != nil {
+				return reconcile.Result{}, err
+			}
+			original = instance.DeepCopy()
+		} else {
+			log.Printf("InstanceController: Going to reconcile drift of plan %s on instance %s/%s", planName, instance.Namespace, instance.Name)
+			r.Recorder.Event(instance, "Normal", "PlanStarted", fmt.Sprintf("Execution of plan %s started to reconcile drift", planName))
+		}
 	}
 
 	// ---------- 3. If there's currently active plan, continue with the execution ----------
@@ -160,23 +233,63 @@ func (r *Reconciler) Reconcile(request ctrl.Request) (ctrl.Result, error) {
 
 	activePlan, metadata, err := preparePlanExecution(instance, ov, activePlanStatus)
 	if err != nil {
-		err = r.handleError(err, instance)
+		err = r.handleError(err, instance, original)
 		return reconcile.Result{}, err
 	}
+	metadata.clusterVariables, err = getClusterVariables(r.Client, r.ClusterConfig)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	metadata.labelKeys = r.LabelKeys
+	metadata.generatedValues, err = getGeneratedValues(r.Client, instance.Namespace, instance.Name, r.LabelKeys)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	metadata.mutators = r.Mutators
+	metadata.ownerResolver = clientOwnerResolver(r.Client)
+	metadata.clock = clock.RealClock{}
+	metadata.kindPolicy = r.KindPolicy
+	metadata.events = r.Events
+	metadata.resultSink = r.ResultSink
+	metadata.stallTimeout = r.StallTimeout
+	if metadata.stallTimeout == 0 {
+		metadata.stallTimeout = DefaultStallTimeout
+	}
+	metadata.flushStatus = func(status *kudov1alpha1.PlanStatus) error {
+		instance.UpdateInstanceStatus(status)
+		if err := r.updateInstance(instance, original); err != nil {
+			return err
+		}
+		// following changes are patched against the flushed instance
+		original = instance.DeepCopy()
+		return nil
+	}
 	log.Printf("InstanceController: Going to proceed in execution of active plan %s on instance %s/%s", activePlan.Name, instance.Namespace, instance.Name)
-	newStatus, err := executePlan(activePlan, metadata, r.Client, &kustomizeEnhancer{r.Scheme})
+	result, err := executePlan(activePlan, metadata, r.Client, &kustomizeEnhancer{scheme: r.Scheme, scopes: r.scopes, fallback: r.ConventionsFallback})
 
 	// ---------- 4. Update status of instance after the execution proceeded ----------
 
-	if newStatus != nil {
-		instance.UpdateInstanceStatus(newStatus)
+	if result != nil && result.Stalled {
+		r.Recorder.Event(instance, "Warning", "PlanStalled", fmt.Sprintf("Execution of plan %s made no progress for %v", activePlan.Name, metadata.stallTimeout))
+	}
+	if result != nil && result.PlanStatus != nil {
+		instance.UpdateInstanceStatus(result.PlanStatus)
+		if result.Status == kudov1alpha1.ExecutionComplete {
+			// remember the effective parameters so that they survive an upgrade to a version with different defaults
+			instance.Status.AppliedParameters = activePlan.params
+			instance.Status.AppliedOperatorVersion = ov.Name
+		}
 	}
 	if err != nil {
-		err = r.handleError(err, instance)
-		return reconcile.Result{}, err
+		retryErr := r.handleError(err, instance, original)
+		if retryErr == err && result != nil && result.RequeueAfter > 0 {
+			// the execution engine knows how many times the plan failed, so it decides when to retry instead of the default rate limiting
+			return reconcile.Result{RequeueAfter: result.RequeueAfter}, nil
+		}
+		return reconcile.Result{}, retryErr
 	}
 
-	err = r.Client.Update(context.TODO(), instance)
+	err = r.updateInstance(instance, original)
 	if err != nil {
 		log.Printf("InstanceController: Error when updating instance state. %v", err)
 		return reconcile.Result{}, err
@@ -186,6 +299,9 @@ func (r *Reconciler) Reconcile(request ctrl.Request) (ctrl.Result, error) {
 		r.Recorder.Event(instance, "Normal", "PlanFinished", fmt.Sprintf("Execution of plan %s finished with status %s", activePlanStatus.Name, instance.Status.AggregatedStatus.Status))
 	}
 
+	if result != nil && result.RequeueAfter > 0 {
+		return reconcile.Result{RequeueAfter: result.RequeueAfter}, nil
+	}
 	return reconcile.Result{}, nil
 }
 
@@ -197,16 +313,20 @@ func preparePlanExecution(instance *kudov1alpha1.Instance, ov *kudov1alpha1.Oper
 
 	planSpec, ok := ov.Spec.Plans[activePlanStatus.Name]
 	if !ok {
-		return nil, nil, &executionError{fmt.Errorf("could not find required plan (%v)", activePlanStatus.Name), false, kudo.String("InvalidPlan")}
+		return nil, nil, &executionError{err: fmt.Errorf("could not find required plan (%v)", activePlanStatus.Name), fatal: false, eventName: kudo.String("InvalidPlan")}
 	}
 
 	return &activePlan{
-			Name:       activePlanStatus.Name,
-			Spec:       &planSpec,
-			PlanStatus: activePlanStatus,
-			Tasks:      ov.Spec.Tasks,
-			Templates:  ov.Spec.Templates,
-			params:     params,
+			Name:               activePlanStatus.Name,
+			Spec:               &planSpec,
+			PlanStatus:         activePlanStatus,
+			Tasks:              ov.Spec.Tasks,
+			Templates:          ov.Spec.Templates,
+			templatingLanguage: ov.Spec.TemplatingLanguage,
+			params:             params,
+			parameters:         ov.Spec.Parameters,
+			// only resources depending on changed parameters are patched
+			changedParams: changedParameters(instance, ov, params),
 		}, &executionMetadata{
 			operatorVersionName: ov.Name,
 			operatorVersion:     ov.Spec.Version,
@@ -214,29 +334,45 @@ func preparePlanExecution(instance *kudov1alpha1.Instance, ov *kudov1alpha1.Oper
 			operatorName:        ov.Spec.Operator.Name,
 			instanceNamespace:   instance.Namespace,
 			instanceName:        instance.Name,
+			breakpoints:         getBreakpoints(instance),
+			planTrigger:         planTrigger(instance, ov, activePlanStatus.Name),
+			instanceLabels:      instance.Labels,
+			instanceAnnotations: instance.Annotations,
 		}, nil
 }
 
+// getBreakpoints returns names of the steps listed in the breakpoints annotation of the instance
+func getBreakpoints(instance *kudov1alpha1.Instance) map[string]bool {
+	breakpoints := make(map[string]bool)
+	for _, step := range strings.Split(instance.Annotations[kudo.BreakpointsAnnotation], ",") {
+		if step = strings.TrimSpace(step); step != "" {
+			breakpoints[step] = true
+		}
+	}
+	return breakpoints
+}
+
 // handleError handles execution error by logging, updating the plan status and optionally publishing an event
 // specify eventReason as nil if you don't wish to publish a warning event
 // returns err if this err should be retried, nil otherwise
-func (r *Reconciler) handleError(err error, instance *kudov1alpha1.Instance) error {
+func (r *Reconciler) handleError(err error, instance *kudov1alpha1.Instance, original *kudov1alpha1.Instance) error {
 	log.Printf("InstanceController: %v", err)
 
 	// first update instance as we want to propagate errors also to the `Instance.Status.PlanStatus`
-	clientErr := r.Client.Update(context.TODO(), instance)
+	clientErr := r.updateInstance(instance, original)
 	if clientErr != nil {
 		log.Printf("InstanceController: Error when updating instance state. %v", clientErr)
 		return clientErr
 	}
 
 	// determine if retry is necessary based on the error type
-	if exErr, ok := err.(*executionError); ok {
+	if exErr := asExecutionError(err); exErr != nil {
+		log.Printf("InstanceController: Execution error on instance %s/%s (fatal: %v), caused by: %v", instance.Namespace, instance.Name, exErr.Fatal(), exErr.Unwrap())
 		if exErr.eventName != nil {
 			r.Recorder.Event(instance, "Warning", kudo.StringValue(exErr.eventName), err.Error())
 		}
 
-		if exErr.fatal {
+		if exErr.Fatal() {
 			return nil // not retrying fatal error
 		}
 	}
@@ -251,6 +387,14 @@ func (r *Reconciler) handleError(err error, instance *kudov1alpha1.Instance) err
 	return err
 }
 
+// updateInstance persists changes done to the instance during reconciliation
+// instead of updating the whole object, it sends a merge patch computed against the original instance as it was read at the
+// beginning of the reconciliation, so only the fields changed by this reconciliation are written and concurrent changes to
+// other fields (e.g. annotations or status of other plans written by someone else) are not overwritten
+func (r *Reconciler) updateInstance(instance *kudov1alpha1.Instance, original *kudov1alpha1.Instance) error {
+	return r.Client.Patch(context.TODO(), instance, client.MergeFrom(original))
+}
+
 // getInstance retrieves the instance by namespaced name
 // returns nil, nil when instance is not found (not found is not considered an error)
 func (r *Reconciler) getInstance(request ctrl.Request) (instance *kudov1alpha1.Instance, err error) {
@@ -287,6 +431,10 @@ func (r *Reconciler) getOperatorVersion(instance *kudov1alpha1.Instance) (ov *ku
 	return ov, nil
 }
 
+// getParameters merges parameters set on the instance with defaults, the precedence is:
+// 1. parameters explicitly set on the instance
+// 2. parameters applied by the last finished plan
+// 3. defaults defined in the OperatorVersion
 func getParameters(instance *kudov1alpha1.Instance, operatorVersion *kudov1alpha1.OperatorVersion) (map[string]string, error) {
 	params := make(map[string]string)
 
@@ -294,6 +442,17 @@ func getParameters(instance *kudov1alpha1.Instance, operatorVersion *kudov1alpha
 		params[k] = v
 	}
 
+	// parameters the user did not set keep their previous values instead of being reset to the defaults, also by plans
+	// run after an upgrade, when the applied parameters were already recorded with the new OperatorVersion
+	for _, param := range operatorVersion.Spec.Parameters {
+		if _, ok := params[param.Name]; ok {
+			continue
+		}
+		if v, ok := instance.Status.AppliedParameters[param.Name]; ok {
+			params[param.Name] = v
+		}
+	}
+
 	missingRequiredParameters := make([]string, 0)
 	// Merge defaults with customizations
 	for _, param := range operatorVersion.Spec.Parameters {
@@ -338,6 +497,9 @@ type executionError struct {
 	err       error
 	fatal     bool    // these errors should not be retried
 	eventName *string // nil if no warn even should be created
+	// phase and step the error occurred in, empty if the error is not specific to a phase or step
+	phase string
+	step  string
 }
 
 func (e *executionError) Error() string {
@@ -346,3 +508,53 @@ func (e *executionError) Error() string {
 	}
 	return fmt.Sprintf("Error during execution: %v", e.err)
 }
+
+// Fatal returns true if the execution should not be retried because the error is not recoverable
+func (e *executionError) Fatal() bool {
+	return e.fatal
+}
+
+// Unwrap returns the underlying cause of the execution error
+func (e *executionError) Unwrap() error {
+	return e.err
+}
+
+// code returns the machine readable kind of the error, it is the name of the event when there is one
+func (e *executionError) code() string {
+	if e.eventName != nil {
+		return strings.Replace(strings.Title(*e.eventName), " ", "", -1)
+	}
+	if e.fatal {
+		return "FatalError"
+	}
+	return "ExecutionError"
+}
+
+// Status converts the error to its representation stored in the plan status
+func (e *executionError) Status() *kudov1alpha1.ExecutionError {
+	return &kudov1alpha1.ExecutionError{
+		Code:      e.code(),
+		Message:   e.err.Error(),
+		Phase:     e.phase,
+		Step:      e.step,
+		Retryable: !e.fatal,
+	}
+}
+
+// errorStatus returns the representation of any error returned by the plan execution stored in the plan status, errors
+// that are not execution errors are retried
+func errorStatus(err error) *kudov1alpha1.ExecutionError {
+	if exErr := asExecutionError(err); exErr != nil {
+		return exErr.Status()
+	}
+	return (&executionError{err: err}).Status()
+}
+
+// asExecutionError returns the first executionError in the chain of the given error or nil if there is none
+func asExecutionError(err error) *executionError {
+	var exErr *executionError
+	if errors.As(err, &exErr) {
+		return exErr
+	}
+	return nil
+}
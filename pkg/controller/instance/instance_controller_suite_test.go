@@ -16,9 +16,19 @@ limitations under the License.
 package instance
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
 	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestSpecParameterDifference(t *testing.T) {
@@ -44,3 +54,193 @@ func TestSpecParameterDifference(t *testing.T) {
 		g.Expect(diff).Should(gomega.Equal(test.diff), test.name)
 	}
 }
+
+func TestExecutionErrorStatusMapping(t *testing.T) {
+	cause := fmt.Errorf("something went wrong")
+	tests := []struct {
+		name            string
+		err             error
+		fatal           bool
+		expectedStatus  kudov1alpha1.ExecutionStatus
+		expectedMessage string
+	}{
+		{"fatal execution error", &executionError{err: cause, fatal: true}, true, kudov1alpha1.ExecutionFatalError, "fatal error, manual intervention required: something went wrong"},
+		{"recoverable execution error", &executionError{err: cause, fatal: false}, false, kudov1alpha1.ErrorStatus, "recoverable error, will be retried: something went wrong"},
+		{"wrapped fatal execution error", fmt.Errorf("wrapped: %w", &executionError{err: cause, fatal: true}), true, kudov1alpha1.ExecutionFatalError, "fatal error, manual intervention required: something went wrong"},
+		{"plain error", cause, false, kudov1alpha1.ErrorStatus, "recoverable error, will be retried: something went wrong"},
+	}
+
+	g := gomega.NewGomegaWithT(t)
+
+	for _, test := range tests {
+		exErr := asExecutionError(test.err)
+		if exErr != nil {
+			g.Expect(exErr.Fatal()).Should(gomega.Equal(test.fatal), test.name)
+			g.Expect(errors.Is(test.err, cause)).Should(gomega.BeTrue(), test.name)
+		}
+		g.Expect(statusForError(test.err)).Should(gomega.Equal(test.expectedStatus), test.name)
+		g.Expect(stepErrorMessage(test.err)).Should(gomega.Equal(test.expectedMessage), test.name)
+	}
+}
+
+func TestExecutionErrorSerialization(t *testing.T) {
+	cause := fmt.Errorf("something went wrong")
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"fatal error with event", &executionError{err: cause, fatal: true, eventName: kudo.String("InvalidPlan")},
+			`{"code":"InvalidPlan","message":"something went wrong","retryable":false}`},
+		{"event name with spaces", &executionError{err: cause, fatal: true, eventName: kudo.String("Missing parameter")},
+			`{"code":"MissingParameter","message":"something went wrong","retryable":false}`},
+		{"fatal error", &executionError{err: cause, fatal: true},
+			`{"code":"FatalError","message":"something went wrong","retryable":false}`},
+		{"recoverable error of a step", &executionError{err: cause, phase: "phase", step: "step"},
+			`{"code":"ExecutionError","message":"something went wrong","phase":"phase","step":"step","retryable":true}`},
+		{"wrapped error", fmt.Errorf("wrapped: %w", &executionError{err: cause, fatal: true, eventName: kudo.String("CommandFailed"), phase: "phase", step: "step"}),
+			`{"code":"CommandFailed","message":"something went wrong","phase":"phase","step":"step","retryable":false}`},
+		{"plain error", cause,
+			`{"code":"ExecutionError","message":"something went wrong","retryable":true}`},
+		{"plain error of a step", atStep("phase", "step", cause),
+			`{"code":"ExecutionError","message":"something went wrong","phase":"phase","step":"step","retryable":true}`},
+	}
+
+	g := gomega.NewGomegaWithT(t)
+
+	for _, test := range tests {
+		data, err := json.Marshal(errorStatus(test.err))
+		g.Expect(err).ShouldNot(gomega.HaveOccurred(), test.name)
+		g.Expect(string(data)).Should(gomega.Equal(test.expected), test.name)
+	}
+}
+
+func TestGetParametersPrecedence(t *testing.T) {
+	ov := &kudov1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-2.0"},
+		Spec: kudov1alpha1.OperatorVersionSpec{
+			Parameters: []kudov1alpha1.Parameter{
+				{Name: "explicit", Default: kudo.String("default")},
+				{Name: "omitted", Default: kudo.String("default")},
+				{Name: "new", Default: kudo.String("default")},
+			},
+		},
+	}
+
+	var tests = []struct {
+		name                   string
+		appliedOperatorVersion string
+		expected               map[string]string
+	}{
+		{"upgrade keeps previous values of omitted parameters", "operator-1.0", map[string]string{"explicit": "explicit", "omitted": "previous", "new": "default"}},
+		{"update of the same version keeps previous values of omitted parameters", "operator-2.0", map[string]string{"explicit": "explicit", "omitted": "previous", "new": "default"}},
+	}
+
+	g := gomega.NewGomegaWithT(t)
+
+	for _, test := range tests {
+		instance := &kudov1alpha1.Instance{
+			Spec: kudov1alpha1.InstanceSpec{Parameters: map[string]string{"explicit": "explicit"}},
+			Status: kudov1alpha1.InstanceStatus{
+				AppliedOperatorVersion: test.appliedOperatorVersion,
+				AppliedParameters:      map[string]string{"explicit": "previous", "omitted": "previous", "removed": "previous"},
+			},
+		}
+
+		params, err := getParameters(instance, ov)
+		g.Expect(err).Should(gomega.BeNil(), test.name)
+		g.Expect(params).Should(gomega.Equal(test.expected), test.name)
+	}
+}
+
+func TestGetParametersFirstDeployUsesDefaults(t *testing.T) {
+	ov := &kudov1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-1.0"},
+		Spec:       kudov1alpha1.OperatorVersionSpec{Parameters: []kudov1alpha1.Parameter{{Name: "omitted", Default: kudo.String("default")}}},
+	}
+
+	g := gomega.NewGomegaWithT(t)
+
+	params, err := getParameters(&kudov1alpha1.Instance{}, ov)
+	g.Expect(err).Should(gomega.BeNil())
+	g.Expect(params).Should(gomega.Equal(map[string]string{"omitted": "default"}))
+}
+
+func TestGetParametersKeepsPreviousValuesAfterUpgrade(t *testing.T) {
+	ov := &kudov1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-2.0"},
+		Spec: kudov1alpha1.OperatorVersionSpec{
+			Parameters: []kudov1alpha1.Parameter{
+				{Name: "omitted", Default: kudo.String("default")},
+				{Name: "new", Default: kudo.String("default")},
+			},
+		},
+	}
+	instance := &kudov1alpha1.Instance{
+		Status: kudov1alpha1.InstanceStatus{
+			AppliedOperatorVersion: "operator-1.0",
+			AppliedParameters:      map[string]string{"omitted": "previous"},
+		},
+	}
+
+	g := gomega.NewGomegaWithT(t)
+
+	// the upgrade plan finishes and its parameters are recorded with the new version, as the reconciler does
+	params, err := getParameters(instance, ov)
+	g.Expect(err).Should(gomega.BeNil())
+	instance.Status.AppliedParameters = params
+	instance.Status.AppliedOperatorVersion = ov.Name
+
+	// the next plan still runs with the values carried over by the upgrade
+	params, err = getParameters(instance, ov)
+	g.Expect(err).Should(gomega.BeNil())
+	g.Expect(params).Should(gomega.Equal(map[string]string{"omitted": "previous", "new": "default"}))
+}
+
+func TestUpdateInstanceKeepsConcurrentChanges(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	s := runtime.NewScheme()
+	g.Expect(kudov1alpha1.AddToScheme(s)).Should(gomega.Succeed())
+	instance := &kudov1alpha1.Instance{
+		TypeMeta:   metav1.TypeMeta{Kind: "Instance", APIVersion: "kudo.dev/v1alpha1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Status: kudov1alpha1.InstanceStatus{
+			PlanStatus: map[string]kudov1alpha1.PlanStatus{
+				"deploy": {Name: "deploy", Status: kudov1alpha1.ExecutionInProgress},
+				"backup": {Name: "backup", Status: kudov1alpha1.ExecutionNeverRun},
+			},
+		},
+	}
+	c := fake.NewFakeClientWithScheme(s, instance)
+	r := &Reconciler{Client: c}
+	key := types.NamespacedName{Name: "test", Namespace: "default"}
+
+	// reconciliation reads the instance
+	reconciled := &kudov1alpha1.Instance{}
+	g.Expect(c.Get(context.TODO(), key, reconciled)).Should(gomega.Succeed())
+	original := reconciled.DeepCopy()
+
+	// meanwhile someone else changes the instance
+	concurrent := &kudov1alpha1.Instance{}
+	g.Expect(c.Get(context.TODO(), key, concurrent)).Should(gomega.Succeed())
+	concurrent.Annotations = map[string]string{"owner": "someone-else"}
+	backup := concurrent.Status.PlanStatus["backup"]
+	backup.Status = kudov1alpha1.ExecutionPending
+	concurrent.Status.PlanStatus["backup"] = backup
+	g.Expect(c.Update(context.TODO(), concurrent)).Should(gomega.Succeed())
+
+	// reconciliation finishes and persists its own changes
+	deploy := reconciled.Status.PlanStatus["deploy"]
+	deploy.Status = kudov1alpha1.ExecutionComplete
+	reconciled.Status.PlanStatus["deploy"] = deploy
+	reconciled.Status.AggregatedStatus.Status = kudov1alpha1.ExecutionComplete
+	g.Expect(r.updateInstance(reconciled, original)).Should(gomega.Succeed())
+
+	result := &kudov1alpha1.Instance{}
+	g.Expect(c.Get(context.TODO(), key, result)).Should(gomega.Succeed())
+	g.Expect(result.Status.PlanStatus["deploy"].Status).Should(gomega.Equal(kudov1alpha1.ExecutionComplete))
+	g.Expect(result.Status.AggregatedStatus.Status).Should(gomega.Equal(kudov1alpha1.ExecutionComplete))
+	g.Expect(result.Status.PlanStatus["backup"].Status).Should(gomega.Equal(kudov1alpha1.ExecutionPending), "concurrent status change was overwritten")
+	g.Expect(result.Annotations).Should(gomega.HaveKeyWithValue("owner", "someone-else"), "concurrent annotation was overwritten")
+}
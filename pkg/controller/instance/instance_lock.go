@@ -0,0 +1,50 @@
+package instance
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// instanceLocks serializes plan executions of the same instance while executions of different instances run concurrently.
+// The workqueue of controller-runtime already never hands the same request to two workers at once, so within a single
+// controller the lock is uncontended. It protects against reconciles of one instance overlapping nevertheless, e.g.
+// when several reconcilers are registered for instances or Reconcile is called directly, in which case two executions
+// would race creating and patching the same objects. The zero value is ready to use.
+type instanceLocks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*instanceLock
+}
+
+type instanceLock struct {
+	sync.Mutex
+	// waiters counts the holder and the executions waiting for the lock, the lock is dropped once nobody needs it
+	waiters int
+}
+
+// lock blocks until no other execution holds the lock of the instance, the returned function releases it
+func (l *instanceLocks) lock(instance types.NamespacedName) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[types.NamespacedName]*instanceLock)
+	}
+	il, ok := l.locks[instance]
+	if !ok {
+		il = &instanceLock{}
+		l.locks[instance] = il
+	}
+	il.waiters++
+	l.mu.Unlock()
+
+	il.Lock()
+	return func() {
+		il.Unlock()
+
+		l.mu.Lock()
+		il.waiters--
+		if il.waiters == 0 {
+			delete(l.locks, instance)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,63 @@
+package instance
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestInstanceLocksSerializeSameInstance(t *testing.T) {
+	var locks instanceLocks
+	instance := types.NamespacedName{Namespace: "default", Name: "instance"}
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := locks.lock(instance)
+			defer unlock()
+
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning != 1 {
+		t.Errorf("Expecting one execution at a time but got %d", maxRunning)
+	}
+	if len(locks.locks) != 0 {
+		t.Errorf("Expecting released locks to be dropped but got %v", locks.locks)
+	}
+}
+
+func TestInstanceLocksDoNotBlockOtherInstances(t *testing.T) {
+	var locks instanceLocks
+	unlock := locks.lock(types.NamespacedName{Namespace: "default", Name: "first"})
+	defer unlock()
+
+	locked := make(chan struct{})
+	go func() {
+		unlock := locks.lock(types.NamespacedName{Namespace: "default", Name: "second"})
+		unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expecting lock of another instance not to wait for the first one")
+	}
+}
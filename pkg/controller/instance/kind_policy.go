@@ -0,0 +1,86 @@
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// KindPolicy restricts the kinds of objects plans may apply, e.g. to keep operators of tenants from creating
+// ClusterRoleBindings. The kind `*` matches all kinds of its group.
+type KindPolicy struct {
+	// Allowed lists the only kinds plans may apply, all kinds are allowed when empty
+	Allowed []schema.GroupKind
+	// Denied lists the kinds plans may not apply, it takes precedence over Allowed
+	Denied []schema.GroupKind
+}
+
+// ParseKinds parses a comma separated list of kinds given as `Kind.group`, e.g.
+// `ClusterRoleBinding.rbac.authorization.k8s.io,Secret`, kinds of the core group are given without the group
+func ParseKinds(kinds string) []schema.GroupKind {
+	var parsed []schema.GroupKind
+	for _, kind := range strings.Split(kinds, ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			parsed = append(parsed, schema.ParseGroupKind(kind))
+		}
+	}
+	return parsed
+}
+
+// isAllowed returns true if plans may apply objects of the given kind
+func (p KindPolicy) isAllowed(gk schema.GroupKind) bool {
+	if matchesKind(p.Denied, gk) {
+		return false
+	}
+	return len(p.Allowed) == 0 || matchesKind(p.Allowed, gk)
+}
+
+func matchesKind(kinds []schema.GroupKind, gk schema.GroupKind) bool {
+	for _, k := range kinds {
+		if k.Group == gk.Group && (k.Kind == "*" || k.Kind == gk.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKindPolicy returns a fatal error if the plan applies objects of kinds the policy does not allow, objects deleted
+// by the plan are not checked
+func checkKindPolicy(policy KindPolicy, plan *v1alpha1.Plan, resources *planResources) error {
+	if len(policy.Allowed) == 0 && len(policy.Denied) == 0 {
+		return nil
+	}
+
+	var forbidden []string
+	for _, ph := range plan.Phases {
+		phaseRes := resources.PhaseResources[ph.Name]
+		for _, st := range ph.Steps {
+			if st.Delete {
+				continue
+			}
+			for _, stage := range [][]runtime.Object{phaseRes.StepPreResources[st.Name], phaseRes.StepResources[st.Name], phaseRes.StepPostResources[st.Name]} {
+				for _, obj := range stage {
+					gk := obj.GetObjectKind().GroupVersionKind().GroupKind()
+					if policy.isAllowed(gk) {
+						continue
+					}
+					name := ""
+					if objMeta, err := meta.Accessor(obj); err == nil {
+						name = objMeta.GetName()
+					}
+					forbidden = append(forbidden, fmt.Sprintf("%s %s in step %s", gk, name, st.Name))
+				}
+			}
+		}
+	}
+
+	if len(forbidden) > 0 {
+		return &executionError{err: fmt.Errorf("plan applies objects of kinds not allowed in this cluster: %s", strings.Join(forbidden, ", ")), fatal: true, eventName: kudo.String("KindNotAllowed")}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package instance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const clusterRoleBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: admin
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-admin
+subjects:
+- kind: ServiceAccount
+  name: default
+  namespace: default
+`
+
+func TestParseKinds(t *testing.T) {
+	kinds := ParseKinds(" ClusterRoleBinding.rbac.authorization.k8s.io, Secret,,*.apps ")
+	expected := []schema.GroupKind{{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}, {Kind: "Secret"}, {Group: "apps", Kind: "*"}}
+	if len(kinds) != len(expected) {
+		t.Fatalf("Expecting %v but got %v", expected, kinds)
+	}
+	for i := range expected {
+		if kinds[i] != expected[i] {
+			t.Errorf("Expecting %v but got %v", expected[i], kinds[i])
+		}
+	}
+}
+
+func TestKindPolicyIsAllowed(t *testing.T) {
+	crb := schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}
+	deployment := schema.GroupKind{Group: "apps", Kind: "Deployment"}
+
+	tests := []struct {
+		name     string
+		policy   KindPolicy
+		kind     schema.GroupKind
+		expected bool
+	}{
+		{"no policy", KindPolicy{}, crb, true},
+		{"denied", KindPolicy{Denied: ParseKinds("ClusterRoleBinding.rbac.authorization.k8s.io")}, crb, false},
+		{"other kind than denied", KindPolicy{Denied: ParseKinds("ClusterRoleBinding.rbac.authorization.k8s.io")}, deployment, true},
+		{"allowed", KindPolicy{Allowed: ParseKinds("Deployment.apps")}, deployment, true},
+		{"not allowed", KindPolicy{Allowed: ParseKinds("Deployment.apps")}, crb, false},
+		{"denied takes precedence", KindPolicy{Allowed: ParseKinds("*.rbac.authorization.k8s.io"), Denied: ParseKinds("ClusterRoleBinding.rbac.authorization.k8s.io")}, crb, false},
+		{"whole group denied", KindPolicy{Denied: ParseKinds("*.rbac.authorization.k8s.io")}, crb, false},
+	}
+
+	for _, tt := range tests {
+		if allowed := tt.policy.isAllowed(tt.kind); allowed != tt.expected {
+			t.Errorf("%s: Expecting allowed to be %v but got %v", tt.name, tt.expected, allowed)
+		}
+	}
+}
+
+func TestExecutePlanWithDeniedKind(t *testing.T) {
+	plan := generatedValuesPlan("deploy", clusterRoleBinding, nil)
+	plan.PlanStatus.Status = v1alpha1.ExecutionPending
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		kindPolicy:        KindPolicy{Denied: ParseKinds("ClusterRoleBinding.rbac.authorization.k8s.io")},
+	}
+	testClient := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+
+	result, err := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+	if err == nil || !strings.Contains(err.Error(), "ClusterRoleBinding.rbac.authorization.k8s.io admin in step step") {
+		t.Errorf("Expecting error naming the denied object but got %v", err)
+	}
+	if result.Status != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting plan to fail fatally but got %s", result.Status)
+	}
+	if len(testClient.created) != 0 {
+		t.Errorf("Expecting nothing to be created but got %v", testClient.created)
+	}
+}
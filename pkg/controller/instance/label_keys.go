@@ -0,0 +1,107 @@
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// LabelKeys are the keys of the labels and annotations KUDO adds to all the objects it renders, e.g. for clusters whose
+// label conventions conflict with the `kudo.dev/` ones. Only the keys change, the values stay the same. The label keys
+// are also the ones objects of an instance are selected and watched by, so changing them on a running cluster makes
+// KUDO lose track of the objects labeled with the old keys until they are applied again.
+type LabelKeys struct {
+	// Heritage is the key of the label set to `kudo`, kudo.HeritageLabel when not set
+	Heritage string
+	// Operator is the key of the label set to the name of the operator, kudo.OperatorLabel when not set
+	Operator string
+	// Instance is the key of the label set to the name of the instance, kudo.InstanceLabel when not set
+	Instance string
+	// Color is the key of the label set to the color of objects of blue-green phases and selected by their service,
+	// kudo.ColorLabel when not set
+	Color string
+	// OperatorVersion is the key of the annotation set to the version of the operator, kudo.OperatorVersionAnnotation
+	// when not set
+	OperatorVersion string
+	// Plan, Phase and Step are the keys of the annotations set to the plan, phase and step that rendered the object,
+	// kudo.PlanAnnotation, kudo.PhaseAnnotation and kudo.StepAnnotation when not set
+	Plan  string
+	Phase string
+	Step  string
+	// Task is the key of the annotation set to the task that rendered the object, kudo.TaskAnnotation when not set
+	Task string
+}
+
+// withDefaults returns the keys with the KUDO keys in place of the ones that are not set
+func (k LabelKeys) withDefaults() LabelKeys {
+	defaults := map[*string]string{
+		&k.Heritage:        kudo.HeritageLabel,
+		&k.Operator:        kudo.OperatorLabel,
+		&k.Instance:        kudo.InstanceLabel,
+		&k.Color:           kudo.ColorLabel,
+		&k.OperatorVersion: kudo.OperatorVersionAnnotation,
+		&k.Plan:            kudo.PlanAnnotation,
+		&k.Phase:           kudo.PhaseAnnotation,
+		&k.Step:            kudo.StepAnnotation,
+		&k.Task:            kudo.TaskAnnotation,
+	}
+	for key, value := range defaults {
+		if *key == "" {
+			*key = value
+		}
+	}
+	return k
+}
+
+// isReserved returns true if KUDO sets or reads the label or annotation key, the configured keys and all the keys with
+// the kudo.ReservedKeyPrefix
+func (k LabelKeys) isReserved(key string) bool {
+	if strings.HasPrefix(key, kudo.ReservedKeyPrefix) {
+		return true
+	}
+	k = k.withDefaults()
+	for _, reserved := range []string{k.Heritage, k.Operator, k.Instance, k.Color, k.OperatorVersion, k.Plan, k.Phase, k.Step, k.Task} {
+		if key == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLabelKeys parses a comma separated list of `name=key` pairs overriding the keys, e.g.
+// `instance=example.com/instance,operator=example.com/operator`, names are the fields of LabelKeys in lower case
+func ParseLabelKeys(keys string) (LabelKeys, error) {
+	parsed := LabelKeys{}
+	fields := map[string]*string{
+		"heritage":        &parsed.Heritage,
+		"operator":        &parsed.Operator,
+		"instance":        &parsed.Instance,
+		"color":           &parsed.Color,
+		"operatorversion": &parsed.OperatorVersion,
+		"plan":            &parsed.Plan,
+		"phase":           &parsed.Phase,
+		"step":            &parsed.Step,
+		"task":            &parsed.Task,
+	}
+	for _, pair := range strings.Split(keys, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		nameAndKey := strings.SplitN(pair, "=", 2)
+		if len(nameAndKey) != 2 {
+			return LabelKeys{}, fmt.Errorf("%q is not a name=key pair", pair)
+		}
+		field, ok := fields[strings.ToLower(strings.TrimSpace(nameAndKey[0]))]
+		if !ok {
+			return LabelKeys{}, fmt.Errorf("unknown label key %s", nameAndKey[0])
+		}
+		key := strings.TrimSpace(nameAndKey[1])
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return LabelKeys{}, fmt.Errorf("%q is not a valid key: %s", key, strings.Join(errs, ", "))
+		}
+		*field = key
+	}
+	return parsed, nil
+}
@@ -0,0 +1,283 @@
+package instance
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestParseLabelKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		expected LabelKeys
+		wantErr  bool
+	}{
+		{"none", "", LabelKeys{}, false},
+		{"some keys", "instance=example.com/instance, Plan=example.com/plan", LabelKeys{Instance: "example.com/instance", Plan: "example.com/plan"}, false},
+		{"color and task", "color=example.com/color,task=example.com/task", LabelKeys{Color: "example.com/color", Task: "example.com/task"}, false},
+		{"unknown name", "template=example.com/template", LabelKeys{}, true},
+		{"missing key", "instance", LabelKeys{}, true},
+		{"invalid key", "instance=not a key", LabelKeys{}, true},
+	}
+
+	for _, tt := range tests {
+		keys, err := ParseLabelKeys(tt.keys)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.wantErr, err)
+		}
+		if keys != tt.expected {
+			t.Errorf("%s: Expecting %+v but got %+v", tt.name, tt.expected, keys)
+		}
+	}
+}
+
+func TestCustomLabelKeysUsedConsistently(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	keys := LabelKeys{Heritage: "example.com/managed-by", Instance: "example.com/instance", Operator: "example.com/operator", Plan: "example.com/plan"}
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		operatorName:      "operator",
+		resourcesOwner:    &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}},
+		labelKeys:         keys,
+	}
+
+	resources, err := prepareKubeResources(generatedValuesPlan("deploy", generatedSecret, map[string]string{"PASSWORD": "s3cr3t"}), meta, &kustomizeEnhancer{scheme: s})
+	if err != nil {
+		t.Fatalf("Expecting no error rendering the plan but got %v", err)
+	}
+	secret := resources.PhaseResources["phase"].StepResources["step"][0].(*corev1.Secret)
+
+	expectedLabels := map[string]string{
+		"example.com/managed-by": "kudo",
+		"example.com/instance":   "instance",
+		"example.com/operator":   "operator",
+		kudo.GeneratedLabel:      "credentials",
+	}
+	for k, v := range expectedLabels {
+		if secret.Labels[k] != v {
+			t.Errorf("Expecting label %s=%s but got labels %v", k, v, secret.Labels)
+		}
+	}
+	if _, ok := secret.Labels[kudo.InstanceLabel]; ok {
+		t.Errorf("Expecting no %s label but got labels %v", kudo.InstanceLabel, secret.Labels)
+	}
+	if secret.Annotations["example.com/plan"] != "deploy" || secret.Annotations[kudo.StepAnnotation] != "step" {
+		t.Errorf("Expecting the custom plan key and the default step key but got annotations %v", secret.Annotations)
+	}
+
+	secret.Data = map[string][]byte{"password": []byte("s3cr3t")}
+	secret.StringData = nil
+	c := fake.NewFakeClientWithScheme(scheme.Scheme, secret)
+
+	// generated values are read by the custom keys only
+	values, err := getGeneratedValues(c, "default", "instance", keys)
+	if err != nil {
+		t.Fatalf("Expecting no error reading generated values but got %v", err)
+	}
+	if _, ok := values["credentials"]; !ok {
+		t.Errorf("Expecting generated values to be read by the custom keys but got %v", values)
+	}
+	values, _ = getGeneratedValues(c, "default", "instance", LabelKeys{})
+	if len(values) != 0 {
+		t.Errorf("Expecting no generated values by the default keys but got %v", values)
+	}
+
+	// the watch maps the object to the instance by the custom instance key
+	requests := labeledObjectToInstance(keys)(handler.MapObject{Meta: secret, Object: secret})
+	expected := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "instance"}}}
+	if len(requests) != 1 || requests[0] != expected[0] {
+		t.Errorf("Expecting %v to be enqueued but got %v", expected, requests)
+	}
+
+	// delete selectors select the object by the custom keys
+	selector, err := renderDeleteSelector(&v1alpha1.DeleteSelector{APIVersion: "v1", Kind: "Secret"}, meta, nil, nil)
+	if err != nil {
+		t.Fatalf("Expecting no error rendering the delete selector but got %v", err)
+	}
+	if err := deleteBySelector(selector, c); err != nil {
+		t.Fatalf("Expecting no error deleting by selector but got %v", err)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "credentials"}, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expecting the secret to be deleted by the selector but got %v", err)
+	}
+}
+
+func TestDiffPlanUsesLabelKeys(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	ov := &v1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-1.0", Namespace: "default"},
+		Spec: v1alpha1.OperatorVersionSpec{
+			Operator:   corev1.ObjectReference{Name: "operator"},
+			Version:    "1.0",
+			Parameters: []v1alpha1.Parameter{{Name: "GREETING", Default: kudo.String("hello")}},
+			Templates:  map[string]string{"config.yaml": renderedConfig},
+			Tasks:      map[string]v1alpha1.TaskSpec{"config": {Resources: []string{"config.yaml"}}},
+			Plans: map[string]v1alpha1.Plan{"deploy": {Strategy: "serial", Phases: []v1alpha1.Phase{{
+				Name: "main", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "config", Tasks: []string{"config"}}},
+			}}}},
+		},
+	}
+	instance := &v1alpha1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default"},
+		Spec:       v1alpha1.InstanceSpec{OperatorVersion: corev1.ObjectReference{Name: "operator-1.0"}},
+	}
+	keys := LabelKeys{Instance: "example.com/instance"}
+
+	// the objects the controller applied with the custom keys
+	applied := instance.DeepCopy()
+	applied.EnsurePlanStatusInitialized(ov)
+	if err := applied.StartPlanExecution("deploy", ov); err != nil {
+		t.Fatal(err)
+	}
+	planStatus := applied.Status.PlanStatus["deploy"]
+	plan, meta, err := preparePlanExecution(applied, ov, &planStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.labelKeys = keys
+	resources, err := prepareKubeResources(plan, meta, &kustomizeEnhancer{scheme: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme.Scheme, resources.PhaseResources["main"].StepResources["config"]...)
+
+	tests := []struct {
+		name     string
+		keys     LabelKeys
+		expected ChangeType
+	}{
+		{"keys of the controller", keys, ChangeNone},
+		{"default keys", LabelKeys{}, ChangeUpdate},
+	}
+	for _, tt := range tests {
+		changes, err := DiffPlan(c, s, instance, ov, "deploy", DiffOptions{LabelKeys: tt.keys})
+		if err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if len(changes) != 1 || changes[0].Type != tt.expected {
+			t.Errorf("%s: Expecting the config map to be a %q change but got %+v", tt.name, tt.expected, changes)
+		}
+	}
+}
+
+func TestRenderPlanUsesLabelKeys(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	ov := &v1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-1.0", Namespace: "default"},
+		Spec: v1alpha1.OperatorVersionSpec{
+			Operator:   corev1.ObjectReference{Name: "operator"},
+			Version:    "1.0",
+			Parameters: []v1alpha1.Parameter{{Name: "GREETING", Default: kudo.String("hello")}},
+			Templates:  map[string]string{"config.yaml": renderedConfig},
+			Tasks:      map[string]v1alpha1.TaskSpec{"config": {Resources: []string{"config.yaml"}}},
+			Plans: map[string]v1alpha1.Plan{"deploy": {Strategy: "serial", Phases: []v1alpha1.Phase{{
+				Name: "main", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "config", Tasks: []string{"config"}}},
+			}}}},
+		},
+	}
+
+	steps, err := RenderPlan(s, ov, "deploy", "instance", "default", nil, LabelKeys{Instance: "example.com/instance"})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if len(steps) != 1 || len(steps[0].Objects) != 1 {
+		t.Fatalf("Expecting one step with the config map but got %+v", steps)
+	}
+	labels := steps[0].Objects[0].(metav1.Object).GetLabels()
+	if labels["example.com/instance"] != "instance" {
+		t.Errorf("Expecting the instance label under the custom key but got %v", labels)
+	}
+	if _, ok := labels[kudo.InstanceLabel]; ok {
+		t.Errorf("Expecting no instance label under the default key but got %v", labels)
+	}
+	if labels[kudo.OperatorLabel] != "operator" {
+		t.Errorf("Expecting keys that are not customized to stay the default ones but got %v", labels)
+	}
+}
+
+func TestCustomColorAndTaskKeys(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	plan := blueGreenPlan(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+`, map[string]v1alpha1.BlueGreenStatus{"deploy": {LiveColor: blueColor}}, 0)
+	service := getService("web", "default", "")
+	service.Spec.Selector = map[string]string{"app": "web", "example.com/color": blueColor}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, service)
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		operatorName:      "operator",
+		resourcesOwner:    &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}},
+		labelKeys:         LabelKeys{Color: "example.com/color", Task: "example.com/task"},
+	}
+
+	newStatus, err := executePlan(plan, meta, testClient, &kustomizeEnhancer{scheme: s})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionComplete {
+		t.Fatalf("Expecting plan to be completed but got %v", newStatus.Status)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := testClient.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "instance-app-green"}, configMap); err != nil {
+		t.Fatalf("Expecting the green config map to be applied but got %v", err)
+	}
+	if configMap.Labels["example.com/color"] != greenColor || configMap.Labels[kudo.ColorLabel] != "" {
+		t.Errorf("Expecting only the custom color key but got labels %v", configMap.Labels)
+	}
+	if configMap.Annotations["example.com/task"] != "app" || configMap.Annotations[kudo.TaskAnnotation] != "" {
+		t.Errorf("Expecting only the custom task key but got annotations %v", configMap.Annotations)
+	}
+
+	_ = testClient.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, service)
+	if service.Spec.Selector["example.com/color"] != greenColor || service.Spec.Selector[kudo.ColorLabel] != "" {
+		t.Errorf("Expecting the service to select %s by the custom color key but got %v", greenColor, service.Spec.Selector)
+	}
+}
+
+func TestInstanceMetadataLeavesOutConfiguredKeys(t *testing.T) {
+	instanceLabels := map[string]string{
+		"cost-center":          "42",
+		"example.com/instance": "other",
+		"example.com/color":    "other",
+		kudo.InstanceLabel:     "other",
+	}
+
+	labels := instanceMetadata(instanceLabels, LabelKeys{Instance: "example.com/instance", Color: "example.com/color"})
+	expected := map[string]string{"cost-center": "42"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expecting %v but got %v", expected, labels)
+	}
+
+	labels = instanceMetadata(instanceLabels, LabelKeys{})
+	expected = map[string]string{"cost-center": "42", "example.com/instance": "other", "example.com/color": "other"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expecting %v but got %v", expected, labels)
+	}
+}
@@ -0,0 +1,35 @@
+package instance
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// renderDuration is the time it takes to render the templates of a step and apply KUDO conventions to them
+	renderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kudo_step_render_duration_seconds",
+		Help:    "Time rendering the objects of a step takes, including KUDO conventions applied by kustomize.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"operator", "plan", "phase", "step"})
+
+	// applyDuration is the time one execution of a step takes, i.e. applying its objects and checking their health, steps
+	// of blue-green and partitioned phases are not observed
+	applyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kudo_step_apply_duration_seconds",
+		Help:    "Time applying the objects of a step and checking their health takes in one execution of the step.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"operator", "plan", "phase", "step"})
+)
+
+func init() {
+	// the registry is served by the metrics endpoint of the controller manager
+	metrics.Registry.MustRegister(renderDuration, applyDuration)
+}
+
+// observeDuration records the time elapsed since start in the histogram for the given step
+func observeDuration(histogram *prometheus.HistogramVec, meta *executionMetadata, plan, phase, step string, start time.Time) {
+	histogram.WithLabelValues(meta.operatorName, plan, phase, step).Observe(executionClock(meta).Since(start).Seconds())
+}
@@ -0,0 +1,58 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// observations returns the number of observations of the histogram with the given name and labels in the registry
+func observations(t *testing.T, name string, labels map[string]string) uint64 {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			matching := 0
+			for _, label := range m.GetLabel() {
+				if labels[label.GetName()] == label.GetValue() {
+					matching++
+				}
+			}
+			if matching == len(labels) {
+				return m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestExecutePlanObservesDurations(t *testing.T) {
+	plan := generatedValuesPlan("metrics", getResourceAsString(getConfigMap("config", "default", nil)), nil)
+	plan.PlanStatus.Status = v1alpha1.ExecutionPending
+	plan.PlanStatus.Phases[0].Status = v1alpha1.ExecutionPending
+	plan.PlanStatus.Phases[0].Steps[0].Status = v1alpha1.ExecutionPending
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", operatorName: "metrics-operator"}
+	labels := map[string]string{"operator": "metrics-operator", "plan": "metrics", "phase": "phase", "step": "step"}
+
+	renders := observations(t, "kudo_step_render_duration_seconds", labels)
+	applies := observations(t, "kudo_step_apply_duration_seconds", labels)
+
+	if _, err := executePlan(plan, meta, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{}); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	if observed := observations(t, "kudo_step_render_duration_seconds", labels); observed != renders+1 {
+		t.Errorf("Expecting rendering of the step to be observed once but got %d observations", observed-renders)
+	}
+	if observed := observations(t, "kudo_step_apply_duration_seconds", labels); observed != applies+1 {
+		t.Errorf("Expecting execution of the step to be observed once but got %d observations", observed-applies)
+	}
+}
@@ -0,0 +1,48 @@
+package instance
+
+import (
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// isTooYoung returns true if the object of the step was created less than the minimum age of the step ago, together with
+// the time until it is old enough. Objects without a creation timestamp were not read back from the API server yet, they
+// are considered just created.
+func isTooYoung(step v1alpha1.Step, obj runtime.Object, now time.Time) (bool, time.Duration) {
+	if step.MinAgeSeconds <= 0 {
+		return false, 0
+	}
+	minAge := time.Duration(step.MinAgeSeconds) * time.Second
+	created := obj.(metav1.Object).GetCreationTimestamp()
+	if created.IsZero() {
+		return true, minAge
+	}
+	remaining := created.Add(minAge).Sub(now)
+	return remaining > 0, remaining
+}
+
+// minAgeRequeueAfter returns the smallest minimum age of the steps in progress, zero if no step in progress has one.
+// Objects getting older do not trigger an execution, and none of the objects of a step can be further than the minimum
+// age away from being old enough.
+func minAgeRequeueAfter(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus) time.Duration {
+	var after time.Duration
+	for _, ph := range plan.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || st.MinAgeSeconds <= 0 || stepState.Status != v1alpha1.ExecutionInProgress {
+				continue
+			}
+			if minAge := time.Duration(st.MinAgeSeconds) * time.Second; after == 0 || minAge < after {
+				after = minAge
+			}
+		}
+	}
+	return after
+}
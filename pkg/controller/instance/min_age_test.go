@@ -0,0 +1,93 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecuteStepWaitsForMinAge(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	existing := getConfigMap("config", "default", nil)
+	existing.CreationTimestamp = metav1.NewTime(created)
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+	fakeClock := clock.NewFakeClock(created.Add(10 * time.Second))
+	step := v1alpha1.Step{Name: "step", MinAgeSeconds: 30}
+
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress}
+	if err := executeStep(context.TODO(), step, state, []runtime.Object{getConfigMap("config", "default", nil)}, nil, fakeClock, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting step to wait for the config map to be 30s old but got %v", state.Status)
+	}
+	if state.Message != "waiting for default/config to be 30s old" {
+		t.Errorf("Expecting message telling what the step waits for but got %q", state.Message)
+	}
+
+	fakeClock.Step(20 * time.Second)
+	if err := executeStep(context.TODO(), step, state, []runtime.Object{getConfigMap("config", "default", nil)}, nil, fakeClock, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step to complete once the config map is 30s old but got %v", state.Status)
+	}
+}
+
+func TestIsTooYoung(t *testing.T) {
+	now := time.Now()
+	step := v1alpha1.Step{Name: "step", MinAgeSeconds: 30}
+	withCreation := func(created time.Time) runtime.Object {
+		cm := getConfigMap("config", "default", nil)
+		cm.CreationTimestamp = metav1.NewTime(created)
+		return cm
+	}
+
+	tests := []struct {
+		name              string
+		step              v1alpha1.Step
+		obj               runtime.Object
+		expectedYoung     bool
+		expectedRemaining time.Duration
+	}{
+		{"no minimum age", v1alpha1.Step{Name: "step"}, withCreation(now), false, 0},
+		{"just created", step, withCreation(now), true, 30 * time.Second},
+		{"not old enough", step, withCreation(now.Add(-20 * time.Second)), true, 10 * time.Second},
+		{"old enough", step, withCreation(now.Add(-time.Minute)), false, -30 * time.Second},
+		{"not created by the API server yet", step, getConfigMap("config", "default", nil), true, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		young, remaining := isTooYoung(tt.step, tt.obj, now)
+		if young != tt.expectedYoung || remaining != tt.expectedRemaining {
+			t.Errorf("%s: Expecting %v with %v remaining but got %v with %v", tt.name, tt.expectedYoung, tt.expectedRemaining, young, remaining)
+		}
+	}
+}
+
+func TestMinAgeRequeueAfter(t *testing.T) {
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "waiting", MinAgeSeconds: 30},
+		{Name: "finished", MinAgeSeconds: 5},
+		{Name: "other"},
+	}}}}
+	status := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+		{Name: "waiting", Status: v1alpha1.ExecutionInProgress},
+		{Name: "finished", Status: v1alpha1.ExecutionComplete},
+		{Name: "other", Status: v1alpha1.ExecutionInProgress},
+	}}}}
+
+	if after := minAgeRequeueAfter(plan, status); after != 30*time.Second {
+		t.Errorf("Expecting requeue after the minimum age of the step in progress of 30s but got %v", after)
+	}
+	if after := minAgeRequeueAfter(plan, &v1alpha1.PlanStatus{}); after != 0 {
+		t.Errorf("Expecting no requeue without steps in progress but got %v", after)
+	}
+}
@@ -0,0 +1,134 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// cachingClient remembers the objects read during one execution of a plan, so that an object read several times, e.g.
+// to patch it and then to check its health, is fetched from the API server only once
+// a write replaces the cached object with the object returned by the API server, which is its state right after the
+// write, so health checks following a patch see the patched object; failed writes and deletes drop the cached object
+// the cache lives only as long as one execution of a plan, changes done by others are seen in the next one
+type cachingClient struct {
+	client.Client
+
+	mu      sync.Mutex
+	objects map[objectCacheKey]runtime.Object
+}
+
+// objectCacheKey identifies an object and the type it was read into
+// typed objects are told apart by their go type as their TypeMeta is often empty, unstructured ones by their kind
+type objectCacheKey struct {
+	typ       string
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+func newCachingClient(c client.Client) *cachingClient {
+	return &cachingClient{
+		Client:  c,
+		objects: make(map[objectCacheKey]runtime.Object),
+	}
+}
+
+func cacheKey(key client.ObjectKey, obj runtime.Object) objectCacheKey {
+	k := objectCacheKey{typ: fmt.Sprintf("%T", obj), namespace: key.Namespace, name: key.Name}
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		k.gvk = obj.GetObjectKind().GroupVersionKind()
+	}
+	return k
+}
+
+// Get returns a copy of the cached object or reads the object from the API server and caches it
+func (c *cachingClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	k := cacheKey(key, obj)
+	c.mu.Lock()
+	cached, ok := c.objects[k]
+	c.mu.Unlock()
+	if ok {
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(cached.DeepCopyObject()).Elem())
+		return nil
+	}
+
+	if err := c.Client.Get(ctx, key, obj); err != nil {
+		return err
+	}
+	c.store(obj, nil)
+	return nil
+}
+
+func (c *cachingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if (&client.CreateOptions{}).ApplyOptions(opts).DryRun != nil {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	err := c.Client.Create(ctx, obj, opts...)
+	c.store(obj, err)
+	return err
+}
+
+func (c *cachingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if (&client.UpdateOptions{}).ApplyOptions(opts).DryRun != nil {
+		return c.Client.Update(ctx, obj, opts...)
+	}
+	err := c.Client.Update(ctx, obj, opts...)
+	c.store(obj, err)
+	return err
+}
+
+func (c *cachingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if (&client.PatchOptions{}).ApplyOptions(opts).DryRun != nil {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	c.store(obj, err)
+	return err
+}
+
+func (c *cachingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	err := c.Client.Delete(ctx, obj, opts...)
+	c.forget(obj)
+	return err
+}
+
+func (c *cachingClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	err := c.Client.DeleteAllOf(ctx, obj, opts...)
+	c.mu.Lock()
+	c.objects = make(map[objectCacheKey]runtime.Object)
+	c.mu.Unlock()
+	return err
+}
+
+// store caches the object after a successful read or write, the cached object is dropped when the write failed
+func (c *cachingClient) store(obj runtime.Object, err error) {
+	if err != nil {
+		c.forget(obj)
+		return
+	}
+	key, keyErr := client.ObjectKeyFromObject(obj)
+	if keyErr != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[cacheKey(key, obj)] = obj.DeepCopyObject()
+}
+
+// forget drops the object from the cache so that it is read again next time
+func (c *cachingClient) forget(obj runtime.Object) {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.objects, cacheKey(key, obj))
+}
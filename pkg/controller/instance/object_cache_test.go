@@ -0,0 +1,97 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// getCountingClient counts reads sent to the API server
+type getCountingClient struct {
+	client.Client
+	gets int
+}
+
+func (c *getCountingClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	c.gets++
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestCachingClientReducesReadsOfStep(t *testing.T) {
+	names := []string{"one", "two", "three"}
+
+	tests := []struct {
+		name         string
+		cached       bool
+		expectedGets int
+	}{
+		{"read before patch and for health check", false, 2 * len(names)},
+		{"read once", true, len(names)},
+	}
+
+	for _, tt := range tests {
+		var existing, resources []runtime.Object
+		for _, name := range names {
+			existing = append(existing, getConfigMap(name, "default", nil))
+			resources = append(resources, getConfigMap(name, "default", map[string]string{"updated": "true"}))
+		}
+		counting := &getCountingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, existing...)}
+		var c client.Client = counting
+		if tt.cached {
+			c = newCachingClient(counting)
+		}
+
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+		if err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, resources, nil, clock.RealClock{}, c); err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != v1alpha1.ExecutionComplete {
+			t.Errorf("%s: Expecting step to be complete but got %v", tt.name, state.Status)
+		}
+		if counting.gets != tt.expectedGets {
+			t.Errorf("%s: Expecting %d reads but got %d", tt.name, tt.expectedGets, counting.gets)
+		}
+	}
+}
+
+func TestCachingClientSeesWrites(t *testing.T) {
+	counting := &getCountingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, getConfigMap("config", "default", nil))}
+	c := newCachingClient(counting)
+	key := client.ObjectKey{Namespace: "default", Name: "config"}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.TODO(), key, cm); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	// callers get copies, changing them does not change the cache
+	cm.Labels = map[string]string{"changed": "locally"}
+	cached := &corev1.ConfigMap{}
+	if err := c.Get(context.TODO(), key, cached); err != nil || cached.Labels != nil || counting.gets != 1 {
+		t.Errorf("Expecting unchanged object from the cache but got %v after %d reads (%v)", cached.Labels, counting.gets, err)
+	}
+
+	// the patched object is cached
+	patch := client.ConstantPatch("application/merge-patch+json", []byte(`{"metadata":{"labels":{"patched":"true"}}}`))
+	if err := c.Patch(context.TODO(), cached, patch); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	patched := &corev1.ConfigMap{}
+	if err := c.Get(context.TODO(), key, patched); err != nil || patched.Labels["patched"] != "true" || counting.gets != 1 {
+		t.Errorf("Expecting patched object from the cache but got %v after %d reads (%v)", patched.Labels, counting.gets, err)
+	}
+
+	// deleted objects are read again
+	if err := c.Delete(context.TODO(), patched); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if err := c.Get(context.TODO(), key, &corev1.ConfigMap{}); err == nil || counting.gets != 2 {
+		t.Errorf("Expecting deleted object to be read again and not found but got %v after %d reads", err, counting.gets)
+	}
+}
@@ -0,0 +1,33 @@
+package instance
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ObjectMutator post-processes every object rendered from the templates before it is applied to the cluster
+// it is an extension point for cluster policies like injecting image pull secrets or rewriting image registries
+type ObjectMutator interface {
+	// Mutate modifies the object in place, error fails the step that renders the object
+	Mutate(obj runtime.Object) error
+}
+
+// ObjectMutatorFunc is an adapter allowing to use ordinary functions as ObjectMutator
+type ObjectMutatorFunc func(obj runtime.Object) error
+
+// Mutate calls f(obj)
+func (f ObjectMutatorFunc) Mutate(obj runtime.Object) error {
+	return f(obj)
+}
+
+// applyMutators runs all the mutators on all the objects, mutators are applied in the order they were configured in
+// so that every mutator sees the output of the previous ones
+func applyMutators(mutators []ObjectMutator, objs []runtime.Object) error {
+	for _, obj := range objs {
+		for _, m := range mutators {
+			if err := m.Mutate(obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package instance
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// registryRewriter moves images of all pods to the given registry
+func registryRewriter(registry string) ObjectMutator {
+	return ObjectMutatorFunc(func(obj runtime.Object) error {
+		var spec *corev1.PodSpec
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			spec = &o.Spec.Template.Spec
+		case *corev1.Pod:
+			spec = &o.Spec
+		default:
+			return nil
+		}
+		for i, c := range spec.Containers {
+			image := c.Image
+			if parts := strings.SplitN(image, "/", 2); len(parts) == 2 && strings.ContainsAny(parts[0], ".:") {
+				image = parts[1]
+			}
+			spec.Containers[i].Image = fmt.Sprintf("%s/%s", registry, image)
+		}
+		return nil
+	})
+}
+
+func TestApplyMutators(t *testing.T) {
+	tests := []struct {
+		name     string
+		mutators []ObjectMutator
+		image    string
+		expected string
+	}{
+		{"no mutators", nil, "nginx:1.7.9", "nginx:1.7.9"},
+		{"registry added", []ObjectMutator{registryRewriter("mirror.local")}, "nginx:1.7.9", "mirror.local/nginx:1.7.9"},
+		{"registry replaced", []ObjectMutator{registryRewriter("mirror.local")}, "gcr.io/google/pause:3.1", "mirror.local/google/pause:3.1"},
+		{"applied in order", []ObjectMutator{registryRewriter("first.local"), registryRewriter("second.local")}, "nginx", "second.local/nginx"},
+	}
+
+	for _, tt := range tests {
+		pod := getPod("pod", "default")
+		pod.Spec.Containers = []corev1.Container{{Name: "main", Image: tt.image}}
+		deployment := getDeployment("deployment", "default", 1)
+		deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "main", Image: tt.image}}
+
+		err := applyMutators(tt.mutators, []runtime.Object{pod, deployment, getConfigMap("cm", "default", nil)})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if pod.Spec.Containers[0].Image != tt.expected {
+			t.Errorf("%s: Expecting pod image %s but got %s", tt.name, tt.expected, pod.Spec.Containers[0].Image)
+		}
+		if deployment.Spec.Template.Spec.Containers[0].Image != tt.expected {
+			t.Errorf("%s: Expecting deployment image %s but got %s", tt.name, tt.expected, deployment.Spec.Template.Spec.Containers[0].Image)
+		}
+	}
+}
+
+func TestPrepareKubeResourcesAppliesMutators(t *testing.T) {
+	pod := getPod("pod", "default")
+	pod.Spec.Containers = []corev1.Container{{Name: "main", Image: "nginx:1.7.9"}}
+	plan := &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"pod"}}},
+		Templates: map[string]string{"pod": getResourceAsString(pod)},
+	}
+
+	failing := ObjectMutatorFunc(func(obj runtime.Object) error { return fmt.Errorf("images from docker hub are not allowed") })
+	tests := []struct {
+		name          string
+		mutators      []ObjectMutator
+		expectedImage string
+		expectedError bool
+	}{
+		{"registry rewritten", []ObjectMutator{registryRewriter("mirror.local")}, "mirror.local/nginx:1.7.9", false},
+		{"mutator rejects object", []ObjectMutator{failing}, "", true},
+	}
+
+	for _, tt := range tests {
+		meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", mutators: tt.mutators}
+		resources, err := prepareKubeResources(plan, meta, &testKubernetesObjectEnhancer{})
+		if tt.expectedError {
+			if err == nil {
+				t.Errorf("%s: Expecting error but got none", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		rendered := resources.PhaseResources["phase"].StepResources["step"][0].(*corev1.Pod)
+		if rendered.Spec.Containers[0].Image != tt.expectedImage {
+			t.Errorf("%s: Expecting image %s but got %s", tt.name, tt.expectedImage, rendered.Spec.Containers[0].Image)
+		}
+	}
+}
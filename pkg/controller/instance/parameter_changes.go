@@ -0,0 +1,56 @@
+package instance
+
+import (
+	"sort"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+)
+
+// recordParameterChanges stores the parameters that changed since the last successfully finished plan in the status of
+// the plan that just started, nothing is recorded when no plan finished yet or when the parameters are not valid
+func recordParameterChanges(instance *kudov1alpha1.Instance, ov *kudov1alpha1.OperatorVersion, planName string) {
+	if instance.Status.AppliedParameters == nil {
+		return
+	}
+	params, err := getParameters(instance, ov)
+	if err != nil {
+		// the execution of the plan reports the error
+		return
+	}
+	planStatus, ok := instance.Status.PlanStatus[planName]
+	if !ok {
+		return
+	}
+	planStatus.ParameterChanges = parameterChanges(instance.Status.AppliedParameters, params, ov.Spec.Parameters)
+	instance.Status.PlanStatus[planName] = planStatus
+}
+
+// parameterChanges returns the parameters that were added, removed or changed between the applied and the new
+// parameters sorted by name, values of sensitive parameters are masked
+func parameterChanges(applied, params map[string]string, definitions []kudov1alpha1.Parameter) []kudov1alpha1.ParameterChange {
+	sensitive := make(map[string]bool)
+	for _, p := range definitions {
+		sensitive[p.Name] = p.Sensitive
+	}
+	value := func(name, v string) *string {
+		if sensitive[name] {
+			return kudo.String(kudov1alpha1.SensitiveValueMask)
+		}
+		return kudo.String(v)
+	}
+
+	changes := []kudov1alpha1.ParameterChange{}
+	for name := range parameterDifference(applied, params) {
+		change := kudov1alpha1.ParameterChange{Name: name}
+		if old, ok := applied[name]; ok {
+			change.Old = value(name, old)
+		}
+		if new, ok := params[name]; ok {
+			change.New = value(name, new)
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
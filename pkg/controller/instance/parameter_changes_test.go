@@ -0,0 +1,77 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParameterChanges(t *testing.T) {
+	definitions := []v1alpha1.Parameter{{Name: "REPLICAS"}, {Name: "PASSWORD", Sensitive: true}}
+	masked := kudo.String(v1alpha1.SensitiveValueMask)
+
+	tests := []struct {
+		name     string
+		applied  map[string]string
+		params   map[string]string
+		expected []v1alpha1.ParameterChange
+	}{
+		{"nothing changed", map[string]string{"REPLICAS": "3"}, map[string]string{"REPLICAS": "3"}, []v1alpha1.ParameterChange{}},
+		{"changed", map[string]string{"REPLICAS": "3"}, map[string]string{"REPLICAS": "5"}, []v1alpha1.ParameterChange{
+			{Name: "REPLICAS", Old: kudo.String("3"), New: kudo.String("5")},
+		}},
+		{"added", map[string]string{}, map[string]string{"REPLICAS": "3"}, []v1alpha1.ParameterChange{
+			{Name: "REPLICAS", New: kudo.String("3")},
+		}},
+		{"removed", map[string]string{"REPLICAS": "3"}, map[string]string{}, []v1alpha1.ParameterChange{
+			{Name: "REPLICAS", Old: kudo.String("3")},
+		}},
+		{"sensitive parameter changed", map[string]string{"PASSWORD": "secret", "REPLICAS": "3"}, map[string]string{"PASSWORD": "other", "REPLICAS": "3"}, []v1alpha1.ParameterChange{
+			{Name: "PASSWORD", Old: masked, New: masked},
+		}},
+		{"several changes sorted by name", map[string]string{"REPLICAS": "3", "ZONE": "a"}, map[string]string{"REPLICAS": "5", "PASSWORD": "secret"}, []v1alpha1.ParameterChange{
+			{Name: "PASSWORD", New: masked},
+			{Name: "REPLICAS", Old: kudo.String("3"), New: kudo.String("5")},
+			{Name: "ZONE", Old: kudo.String("a")},
+		}},
+	}
+
+	for _, tt := range tests {
+		changes := parameterChanges(tt.applied, tt.params, definitions)
+		if !reflect.DeepEqual(changes, tt.expected) {
+			t.Errorf("%s: Expecting changes %v but got %v", tt.name, tt.expected, changes)
+		}
+	}
+}
+
+func TestRecordParameterChanges(t *testing.T) {
+	ov := &v1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "op-1.0"},
+		Spec: v1alpha1.OperatorVersionSpec{
+			Parameters: []v1alpha1.Parameter{{Name: "REPLICAS", Default: kudo.String("3")}, {Name: "IMAGE", Default: kudo.String("nginx")}},
+		},
+	}
+	instance := &v1alpha1.Instance{
+		Spec: v1alpha1.InstanceSpec{Parameters: map[string]string{"REPLICAS": "5"}},
+		Status: v1alpha1.InstanceStatus{
+			PlanStatus: map[string]v1alpha1.PlanStatus{"update": {Name: "update"}},
+		},
+	}
+
+	// nothing was applied yet
+	recordParameterChanges(instance, ov, "update")
+	if changes := instance.Status.PlanStatus["update"].ParameterChanges; changes != nil {
+		t.Errorf("Expecting no changes before a plan finished but got %v", changes)
+	}
+
+	// defaults are part of the applied parameters, so only the parameter set on the instance changed
+	instance.Status.AppliedParameters = map[string]string{"REPLICAS": "3", "IMAGE": "nginx"}
+	recordParameterChanges(instance, ov, "update")
+	expected := []v1alpha1.ParameterChange{{Name: "REPLICAS", Old: kudo.String("3"), New: kudo.String("5")}}
+	if changes := instance.Status.PlanStatus["update"].ParameterChanges; !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expecting changes %v but got %v", expected, changes)
+	}
+}
@@ -0,0 +1,129 @@
+package instance
+
+import (
+	"context"
+	"log"
+	"regexp"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// paramReference matches all usages of `.Params` in a template, the name of the parameter is captured when it's accessed directly
+var paramReference = regexp.MustCompile(`\.Params\b(\.(\w+))?`)
+
+// templateAction matches the actions of a template, the only place values are referenced in
+var templateAction = regexp.MustCompile(`(?s){{(.*?)}}`)
+
+// topLevelReference matches references to the top level values of a template, e.g. `.Name` or `$.Params`, the name of
+// the value is captured. Fields of values, e.g. `.REPLICAS` of `.Params.REPLICAS`, are not matched.
+var topLevelReference = regexp.MustCompile(`(?:^|[^\w)\]])\$?\.([A-Za-z_]\w*)`)
+
+// trackedValues are the top level values templates can reference with known dependencies, `.Params` are tracked by the
+// names of the parameters, `.Item` and `.ItemIndex` by the list parameter the step iterates over, see renderStep, and
+// the others never change for an instance. All the other values, e.g. `.Values`, `.Generated`, `.Cluster` or
+// `.InstanceGeneration`, can change without a change of the referenced parameters.
+var trackedValues = map[string]bool{"Params": true, "Item": true, "ItemIndex": true, "Name": true, "Namespace": true, "OperatorName": true}
+
+// partialReference matches usages of named templates, they are defined in partials which can reference any parameter
+var partialReference = regexp.MustCompile(`\b(template|include)\s+"`)
+
+// pinDigestReference matches usages of `pinDigest`, the digest an image tag resolves to changes without any parameter change
+var pinDigestReference = regexp.MustCompile(`\bpinDigest\b`)
+
+// changedParameters returns parameters whose values differ from the ones applied by the last finished plan
+// nil means that it's not known what changed and all the resources have to be applied, that is the case when nothing was
+// applied yet, when the OperatorVersion (and so the templates) changed or when no parameter changed at all (e.g. the plan
+// was triggered manually)
+func changedParameters(instance *kudov1alpha1.Instance, ov *kudov1alpha1.OperatorVersion, params map[string]string) map[string]bool {
+	if instance.Status.AppliedParameters == nil || instance.Status.AppliedOperatorVersion != ov.Name {
+		return nil
+	}
+
+	diff := parameterDifference(instance.Status.AppliedParameters, params)
+	if len(diff) == 0 {
+		return nil
+	}
+	changed := make(map[string]bool)
+	for k := range diff {
+		changed[k] = true
+	}
+	return changed
+}
+
+// templateParameters returns names of all the parameters the template references
+// the second return value is false when that cannot be determined, e.g. when the template iterates over `.Params`, passes
+// it to a function, accesses it by a computed key, references any other value than the trackedValues, pins digests or
+// uses named templates of partials
+func templateParameters(template string) (map[string]bool, bool) {
+	if partialReference.MatchString(template) || pinDigestReference.MatchString(template) {
+		return nil, false
+	}
+	for _, action := range templateAction.FindAllStringSubmatch(template, -1) {
+		for _, match := range topLevelReference.FindAllStringSubmatch(action[1], -1) {
+			if !trackedValues[match[1]] {
+				return nil, false
+			}
+		}
+	}
+	params := make(map[string]bool)
+	for _, match := range paramReference.FindAllStringSubmatch(template, -1) {
+		if match[2] == "" {
+			return nil, false
+		}
+		params[match[2]] = true
+	}
+	return params, true
+}
+
+// isTemplateAffected returns true if the template has to be applied because it possibly depends on a changed parameter
+func isTemplateAffected(template string, changedParams map[string]bool) bool {
+	if changedParams == nil {
+		return true
+	}
+	params, known := templateParameters(template)
+	if !known {
+		return true
+	}
+	for p := range params {
+		if changedParams[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// skipUnchangedResources removes from the steps all the objects that do not depend on any changed parameter and already
+// exist, so that they are not patched again
+// objects that do not exist yet are kept, they are going to be created as they would be without the dependency tracking
+func skipUnchangedResources(resources phaseResources, c client.Client) error {
+	for step, unchanged := range resources.StepUnchangedResources {
+		if len(unchanged) == 0 {
+			continue
+		}
+		skip := make(map[runtime.Object]bool)
+		for _, obj := range unchanged {
+			key, _ := client.ObjectKeyFromObject(obj)
+			err := c.Get(context.TODO(), key, obj.DeepCopyObject())
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			log.Printf("PlanExecution: Object %v of step %s does not depend on any changed parameter, skipping it", key, step)
+			skip[obj] = true
+		}
+
+		filtered := make([]runtime.Object, 0, len(resources.StepResources[step]))
+		for _, obj := range resources.StepResources[step] {
+			if !skip[obj] {
+				filtered = append(filtered, obj)
+			}
+		}
+		resources.StepResources[step] = filtered
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package instance
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTemplateParameters(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected map[string]bool
+		known    bool
+	}{
+		{"no parameters", "name: {{ .Name }}", map[string]bool{}, true},
+		{"direct access", "replicas: {{ .Params.REPLICAS }}\nimage: {{ .Params.IMAGE | quote }}", map[string]bool{"REPLICAS": true, "IMAGE": true}, true},
+		{"range over parameters", "{{ range $k, $v := .Params }}{{ $k }}: {{ $v }}{{ end }}", nil, false},
+		{"computed key", `{{ index .Params "REPLICAS" }}`, nil, false},
+		{"similar name", "{{ .ParamsExtra.REPLICAS }}", nil, false},
+		{"instance values", "name: {{ $.Name }}-{{ .OperatorName }}\nnamespace: {{ .Namespace }}", map[string]bool{}, true},
+		{"dots outside of actions", "image: registry.example.com/.hidden", map[string]bool{}, true},
+		{"item of a list", "name: {{ .Item }}-{{ .ItemIndex }}", map[string]bool{}, true},
+		{"cluster variables", "class: {{ .Cluster.STORAGE_CLASS }}", nil, false},
+		{"instance labels", "team: {{ .InstanceLabels.team }}", nil, false},
+		{"plan trigger", "{{ if eq .PlanTrigger \"install\" }}init: true{{ end }}", nil, false},
+		{"instance generation", "generation: \"{{ .InstanceGeneration }}\"", nil, false},
+		{"pinned digest", "image: {{ pinDigest .Params.IMAGE }}", nil, false},
+		{"values", "replicas: {{ .Values.replicas }}", nil, false},
+		{"generated values", "password: {{ .Generated.credentials.password }}", nil, false},
+		{"named template", "labels:\n{{ template \"common.labels\" . }}", nil, false},
+		{"included named template", "labels:\n{{ include \"common.labels\" . | indent 2 }}", nil, false},
+	}
+
+	for _, tt := range tests {
+		params, known := templateParameters(tt.template)
+		if known != tt.known {
+			t.Errorf("%s: Expecting known to be %v but got %v", tt.name, tt.known, known)
+		}
+		if !reflect.DeepEqual(params, tt.expected) {
+			t.Errorf("%s: Expecting parameters %v but got %v", tt.name, tt.expected, params)
+		}
+	}
+}
+
+func TestIsTemplateAffected(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		changed  map[string]bool
+		expected bool
+	}{
+		{"unknown changes", "{{ .Params.REPLICAS }}", nil, true},
+		{"referenced parameter changed", "{{ .Params.REPLICAS }}", map[string]bool{"REPLICAS": true}, true},
+		{"other parameter changed", "{{ .Params.REPLICAS }}", map[string]bool{"IMAGE": true}, false},
+		{"no parameters referenced", "name: static", map[string]bool{"IMAGE": true}, false},
+		{"dependencies not known", "{{ range .Params }}{{ . }}{{ end }}", map[string]bool{"IMAGE": true}, true},
+	}
+
+	for _, tt := range tests {
+		if affected := isTemplateAffected(tt.template, tt.changed); affected != tt.expected {
+			t.Errorf("%s: Expecting affected to be %v but got %v", tt.name, tt.expected, affected)
+		}
+	}
+}
+
+func TestChangedParameters(t *testing.T) {
+	ov := &v1alpha1.OperatorVersion{ObjectMeta: metav1.ObjectMeta{Name: "ov-1.0"}}
+	tests := []struct {
+		name      string
+		appliedOV string
+		applied   map[string]string
+		params    map[string]string
+		expected  map[string]bool
+	}{
+		{"nothing applied yet", "", nil, map[string]string{"A": "1"}, nil},
+		{"operator version changed", "ov-0.9", map[string]string{"A": "1"}, map[string]string{"A": "1"}, nil},
+		{"no parameter changed", "ov-1.0", map[string]string{"A": "1"}, map[string]string{"A": "1"}, nil},
+		{"parameter changed", "ov-1.0", map[string]string{"A": "1", "B": "1"}, map[string]string{"A": "2", "B": "1"}, map[string]bool{"A": true}},
+		{"parameter added", "ov-1.0", map[string]string{"A": "1"}, map[string]string{"A": "1", "B": "1"}, map[string]bool{"B": true}},
+	}
+
+	for _, tt := range tests {
+		instance := &v1alpha1.Instance{Status: v1alpha1.InstanceStatus{AppliedOperatorVersion: tt.appliedOV, AppliedParameters: tt.applied}}
+		if changed := changedParameters(instance, ov, tt.params); !reflect.DeepEqual(changed, tt.expected) {
+			t.Errorf("%s: Expecting changed parameters %v but got %v", tt.name, tt.expected, changed)
+		}
+	}
+}
+
+func TestExecutePlanPatchesOnlyAffectedResources(t *testing.T) {
+	plan := &activePlan{
+		Name: "update",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "update",
+			Status: v1alpha1.ExecutionPending,
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Name: "step", Status: v1alpha1.ExecutionPending}}}},
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: v1alpha1.Serial,
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: v1alpha1.Serial, Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		Tasks: map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"first", "second", "third"}}},
+		Templates: map[string]string{
+			"first":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: first\n  namespace: default\ndata:\n  value: \"{{ .Params.FIRST }}\"\n",
+			"second": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: second\n  namespace: default\ndata:\n  value: \"{{ .Params.SECOND }}\"\n",
+			"third":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: third\n  namespace: default\ndata:\n  value: \"{{ .Params.SECOND }}\"\n",
+		},
+		params:        map[string]string{"FIRST": "changed", "SECOND": "same"},
+		changedParams: map[string]bool{"FIRST": true},
+	}
+	// third does not exist yet so it has to be created even though it does not depend on the changed parameter
+	first := getConfigMap("first", "default", nil)
+	first.Data = map[string]string{"value": "original"}
+	second := getConfigMap("second", "default", nil)
+	second.Data = map[string]string{"value": "same"}
+	testClient := &patchRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, first, second)}
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	newStatus, err := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting plan to be completed but got %v", newStatus.Status)
+	}
+	if !reflect.DeepEqual(testClient.patched, []string{"first"}) {
+		t.Errorf("Expecting only first to be patched but got %v", testClient.patched)
+	}
+	assertExists(t, testClient, getConfigMap("third", "default", nil), "third", true)
+}
+
+func TestExecutePlanPatchesResourcesWithUntrackedValues(t *testing.T) {
+	plan := &activePlan{
+		Name: "update",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "update",
+			Status: v1alpha1.ExecutionPending,
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Name: "step", Status: v1alpha1.ExecutionPending}}}},
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: v1alpha1.Serial,
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: v1alpha1.Serial, Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		Tasks: map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"generation"}}},
+		Templates: map[string]string{
+			"generation": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: generation\n  namespace: default\ndata:\n  value: \"{{ .InstanceGeneration }}\"\n",
+		},
+		params:        map[string]string{"FIRST": "changed"},
+		changedParams: map[string]bool{"FIRST": true},
+	}
+	existing := getConfigMap("generation", "default", nil)
+	existing.Data = map[string]string{"value": "1"}
+	testClient := &patchRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, existing)}
+	// the generation of the instance changed with the parameter
+	owner := &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid", Generation: 2}}
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", resourcesOwner: owner}
+
+	if _, err := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{}); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if !reflect.DeepEqual(testClient.patched, []string{"generation"}) {
+		t.Errorf("Expecting generation to be patched but got %v", testClient.patched)
+	}
+}
+
+// patchRecordingClient records names of the patched objects
+type patchRecordingClient struct {
+	client.Client
+	patched []string
+}
+
+func (c *patchRecordingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.patched = append(c.patched, obj.(metav1.Object).GetName())
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
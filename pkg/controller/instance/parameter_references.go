@@ -0,0 +1,145 @@
+package instance
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+)
+
+// ParameterReference is a place in a plan that uses a parameter, Template names the template of a task, or the field of
+// the plan, step or task that uses the parameter, e.g. "forEach" or "command". Phase, Step and Task are empty for fields
+// of the plan itself.
+type ParameterReference struct {
+	Plan     string
+	Phase    string
+	Step     string
+	Task     string
+	Template string
+	// Dynamic is true when the template might use the parameter but that cannot be told without rendering it, e.g. it
+	// ranges over `.Params`, uses `.Values` or named templates of partials, or it is not a go template
+	Dynamic bool
+}
+
+// ParameterReferences returns all the places in plans of the operator version that use the parameter, ordered by plan,
+// phase, step, task and template. It only looks at the templates and fields of the plans, the plan a parameter triggers
+// when it changes is in the parameter itself and is executed even if it does not use the parameter.
+func ParameterReferences(ov *v1alpha1.OperatorVersion, param string) []ParameterReference {
+	var refs []ParameterReference
+	add := func(ref ParameterReference, template string, goTemplate bool) {
+		if !goTemplate {
+			ref.Dynamic = true
+			refs = append(refs, ref)
+			return
+		}
+		params, known := templateParameters(template)
+		if !known {
+			ref.Dynamic = true
+			refs = append(refs, ref)
+			return
+		}
+		if params[param] {
+			refs = append(refs, ref)
+		}
+	}
+	resourcesAreGoTemplates := isGoTemplate(ov.Spec.TemplatingLanguage)
+
+	for planName, plan := range ov.Spec.Plans {
+		for _, p := range plan.Profiles {
+			if p.Parameter == param {
+				refs = append(refs, ParameterReference{Plan: planName, Template: "profile " + p.Name})
+			}
+		}
+		for _, d := range plan.Dependencies {
+			add(ParameterReference{Plan: planName, Template: "dependency"}, d.Name, true)
+		}
+		for _, ph := range plan.Phases {
+			for _, st := range ph.Steps {
+				stepRef := ParameterReference{Plan: planName, Phase: ph.Name, Step: st.Name}
+				if st.ForEach == param {
+					ref := stepRef
+					ref.Template = "forEach"
+					refs = append(refs, ref)
+				}
+				if st.Barrier != nil {
+					ref := stepRef
+					ref.Template = "barrier"
+					names := make([]string, 0, len(st.Barrier.Conditions))
+					for _, c := range st.Barrier.Conditions {
+						names = append(names, c.Name)
+					}
+					add(ref, strings.Join(names, "\n"), true)
+				}
+				for taskName, condition := range st.TaskConditions {
+					ref := stepRef
+					ref.Task = taskName
+					ref.Template = "condition"
+					add(ref, condition, true)
+				}
+				for _, taskName := range allStepTasks(st) {
+					task, ok := ov.Spec.Tasks[taskName]
+					if !ok {
+						continue
+					}
+					taskRef := stepRef
+					taskRef.Task = taskName
+					if task.Containers == param {
+						ref := taskRef
+						ref.Template = "containers"
+						refs = append(refs, ref)
+					}
+					for _, resource := range task.Resources {
+						ref := taskRef
+						ref.Template = resource
+						add(ref, ov.Spec.Templates[resource], resourcesAreGoTemplates)
+					}
+					if task.Command != nil {
+						ref := taskRef
+						ref.Template = "command"
+						add(ref, strings.Join(append([]string{task.Command.Image}, task.Command.Command...), "\n"), true)
+					}
+					if task.Helm != nil {
+						values := make([]string, 0, len(task.Helm.Values))
+						for _, v := range task.Helm.Values {
+							values = append(values, v)
+						}
+						ref := taskRef
+						ref.Template = "helm values"
+						add(ref, strings.Join(values, "\n"), true)
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		a, b := refs[i], refs[j]
+		for _, pair := range [][2]string{{a.Plan, b.Plan}, {a.Phase, b.Phase}, {a.Step, b.Step}, {a.Task, b.Task}, {a.Template, b.Template}} {
+			if pair[0] != pair[1] {
+				return pair[0] < pair[1]
+			}
+		}
+		return false
+	})
+	return refs
+}
+
+// PlansReferencingParameter returns sorted names of the plans that use the parameter, including the ones that might use
+// it, see ParameterReferences
+func PlansReferencingParameter(ov *v1alpha1.OperatorVersion, param string) []string {
+	var plans []string
+	for _, ref := range ParameterReferences(ov, param) {
+		if len(plans) == 0 || plans[len(plans)-1] != ref.Plan {
+			plans = append(plans, ref.Plan)
+		}
+	}
+	return plans
+}
+
+// allStepTasks returns the tasks of the step including its pre and post tasks
+func allStepTasks(step v1alpha1.Step) []string {
+	tasks := make([]string, 0, len(step.PreTasks)+len(step.Tasks)+len(step.PostTasks))
+	tasks = append(tasks, step.PreTasks...)
+	tasks = append(tasks, step.Tasks...)
+	return append(tasks, step.PostTasks...)
+}
@@ -0,0 +1,115 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+)
+
+func referencesTestOperatorVersion() *v1alpha1.OperatorVersion {
+	return &v1alpha1.OperatorVersion{
+		Spec: v1alpha1.OperatorVersionSpec{
+			Templates: map[string]string{
+				"deployment.yaml": "replicas: {{ .Params.REPLICAS }}\nimage: kafka:{{ .Params.VERSION }}\n",
+				"service.yaml":    "port: {{ .Params.PORT }}\n",
+				"config.yaml":     "{{ range $k, $v := .Params }}{{ $k }}: {{ $v }}\n{{ end }}",
+				"topic.yaml":      "name: {{ .Item }}\n",
+			},
+			Tasks: map[string]v1alpha1.TaskSpec{
+				"app":     {Resources: []string{"deployment.yaml", "service.yaml"}},
+				"config":  {Resources: []string{"config.yaml"}},
+				"topics":  {Resources: []string{"topic.yaml"}},
+				"sidecar": {Resources: []string{"deployment.yaml"}, Containers: "SIDECARS"},
+				"migrate": {Command: &v1alpha1.CommandSpec{Image: "kafka:{{ .Params.VERSION }}", Command: []string{"migrate"}}},
+			},
+			Plans: map[string]v1alpha1.Plan{
+				"deploy": {Phases: []v1alpha1.Phase{{Name: "main", Steps: []v1alpha1.Step{
+					{Name: "app", Tasks: []string{"app"}},
+					{Name: "topics", Tasks: []string{"topics"}, ForEach: "TOPICS"},
+				}}}},
+				"upgrade": {Phases: []v1alpha1.Phase{{Name: "main", Steps: []v1alpha1.Step{
+					{Name: "app", Tasks: []string{"app"}, PreTasks: []string{"migrate"}},
+				}}}},
+				"reconfigure": {Phases: []v1alpha1.Phase{{Name: "main", Steps: []v1alpha1.Step{
+					{Name: "config", Tasks: []string{"config"}},
+				}}}},
+				"sidecars": {Phases: []v1alpha1.Phase{{Name: "main", Steps: []v1alpha1.Step{
+					{Name: "sidecar", Tasks: []string{"sidecar"}},
+				}}}},
+			},
+		},
+	}
+}
+
+func TestParameterReferences(t *testing.T) {
+	ov := referencesTestOperatorVersion()
+
+	tests := []struct {
+		name     string
+		param    string
+		expected []ParameterReference
+	}{
+		{"template of one task used by several plans", "PORT", []ParameterReference{
+			{Plan: "deploy", Phase: "main", Step: "app", Task: "app", Template: "service.yaml"},
+			{Plan: "reconfigure", Phase: "main", Step: "config", Task: "config", Template: "config.yaml", Dynamic: true},
+			{Plan: "upgrade", Phase: "main", Step: "app", Task: "app", Template: "service.yaml"},
+		}},
+		{"command of a pre task", "VERSION", []ParameterReference{
+			{Plan: "deploy", Phase: "main", Step: "app", Task: "app", Template: "deployment.yaml"},
+			{Plan: "reconfigure", Phase: "main", Step: "config", Task: "config", Template: "config.yaml", Dynamic: true},
+			{Plan: "sidecars", Phase: "main", Step: "sidecar", Task: "sidecar", Template: "deployment.yaml"},
+			{Plan: "upgrade", Phase: "main", Step: "app", Task: "app", Template: "deployment.yaml"},
+			{Plan: "upgrade", Phase: "main", Step: "app", Task: "migrate", Template: "command"},
+		}},
+		{"list parameter of a step and a task", "TOPICS", []ParameterReference{
+			{Plan: "deploy", Phase: "main", Step: "topics", Template: "forEach"},
+			{Plan: "reconfigure", Phase: "main", Step: "config", Task: "config", Template: "config.yaml", Dynamic: true},
+		}},
+		{"containers parameter", "SIDECARS", []ParameterReference{
+			{Plan: "reconfigure", Phase: "main", Step: "config", Task: "config", Template: "config.yaml", Dynamic: true},
+			{Plan: "sidecars", Phase: "main", Step: "sidecar", Task: "sidecar", Template: "containers"},
+		}},
+	}
+
+	for _, tt := range tests {
+		actual := ParameterReferences(ov, tt.param)
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+func TestPlansReferencingParameter(t *testing.T) {
+	ov := referencesTestOperatorVersion()
+	if plans := PlansReferencingParameter(ov, "REPLICAS"); !reflect.DeepEqual(plans, []string{"deploy", "reconfigure", "sidecars", "upgrade"}) {
+		t.Errorf("Expecting all plans applying the deployment and the dynamic config but got %v", plans)
+	}
+
+	// without the template ranging over all parameters, only the plans really using the parameter are left
+	delete(ov.Spec.Plans, "reconfigure")
+	if plans := PlansReferencingParameter(ov, "PORT"); !reflect.DeepEqual(plans, []string{"deploy", "upgrade"}) {
+		t.Errorf("Expecting plans applying the service but got %v", plans)
+	}
+	if plans := PlansReferencingParameter(ov, "UNUSED"); len(plans) != 0 {
+		t.Errorf("Expecting no plans for unused parameter but got %v", plans)
+	}
+
+	// templates in other languages cannot be analyzed
+	ov.Spec.TemplatingLanguage = v1alpha1.Envsubst
+	if plans := PlansReferencingParameter(ov, "UNUSED"); !reflect.DeepEqual(plans, []string{"deploy", "sidecars", "upgrade"}) {
+		t.Errorf("Expecting all plans applying envsubst templates but got %v", plans)
+	}
+}
+
+func TestParameterReferencesOfTaskConditions(t *testing.T) {
+	ov := referencesTestOperatorVersion()
+	deploy := ov.Spec.Plans["deploy"]
+	deploy.Phases[0].Steps[0].TaskConditions = map[string]string{"app": `{{ eq .Params.TLS "true" }}`}
+
+	refs := ParameterReferences(ov, "TLS")
+	expected := ParameterReference{Plan: "deploy", Phase: "main", Step: "app", Task: "app", Template: "condition"}
+	if len(refs) != 2 || refs[0] != expected {
+		t.Errorf("Expecting the condition and the dynamic config to reference the parameter but got %v", refs)
+	}
+}
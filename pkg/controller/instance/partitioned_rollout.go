@@ -0,0 +1,137 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/health"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// executePartitionedPhase applies steps of the phase serially, StatefulSets of the steps that already exist are rolled out
+// one pod at a time, the partition of their rolling update is decremented once all the pods from the current partition up
+// are updated and all the pods are ready, the current partition is kept in the plan status between the executions
+// the step of a StatefulSet is healthy once the partition reaches zero and all the pods are ready, a pod that does not
+// become ready halts the rollout at the current partition
+// returns true if all the steps are healthy
+func executePartitionedPhase(phase v1alpha1.Phase, planState *v1alpha1.PlanStatus, phaseState *v1alpha1.PhaseStatus, resources phaseResources, clk clock.Clock, c client.Client) (bool, error) {
+	for _, st := range orderedSteps(phase) {
+		stepState, _ := getStepFromStatus(st.Name, phaseState)
+		if isFinished(stepState.Status) {
+			continue
+		}
+
+		for _, r := range resources.StepResources[st.Name] {
+			sts, ok := r.(*appsv1.StatefulSet)
+			if !ok || st.Delete {
+				continue
+			}
+			if err := advancePartition(sts, planState, c); err != nil {
+				return false, failStep(phaseState, stepState, err)
+			}
+		}
+
+		log.Printf("PlanExecution: Executing step %s of partitioned phase %s - it's in %s state", st.Name, phase.Name, stepState.Status)
+		resources.Partitioned = true
+		err := executeStepWithHooks(context.TODO(), st, stepState, resources, clk, c)
+		if err != nil {
+			return false, failStep(phaseState, stepState, err)
+		}
+		if !isFinished(stepState.Status) {
+			// we cannot proceed to the next step
+			return false, nil
+		}
+		for _, r := range resources.StepResources[st.Name] {
+			if sts, ok := r.(*appsv1.StatefulSet); ok {
+				delete(planState.Partitions, partitionKey(sts))
+			}
+		}
+	}
+	return true, nil
+}
+
+// statefulSetsRolledOut returns true once the StatefulSets among the objects are rolled out to all their pods, the
+// health check of a StatefulSet only waits for the pods from its current partition up
+func statefulSetsRolledOut(objs []runtime.Object, state *v1alpha1.StepStatus, c client.Client) (bool, error) {
+	for _, r := range objs {
+		sts, ok := r.(*appsv1.StatefulSet)
+		if !ok {
+			continue
+		}
+		existing := &appsv1.StatefulSet{}
+		if err := c.Get(context.TODO(), client.ObjectKey{Namespace: sts.Namespace, Name: sts.Name}, existing); err != nil {
+			return false, err
+		}
+		if err := health.StatefulSetRolledOutTo(existing, 0); err != nil {
+			log.Printf("PlanExecution: Statefulset %s is not rolled out yet: %v", partitionKey(sts), err)
+			state.Message = fmt.Sprintf("waiting for rollout of %s: %v", partitionKey(sts), err)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// partitionKey identifies the StatefulSet in the Partitions status of the plan
+func partitionKey(sts *appsv1.StatefulSet) string {
+	return fmt.Sprintf("%s/%s", sts.Namespace, sts.Name)
+}
+
+// advancePartition sets the partition the rendered StatefulSet is rolled out to, it is decremented by one when the
+// StatefulSet is rolled out to the current partition
+// StatefulSets that do not exist yet are created without a partition as there are no pods to update
+func advancePartition(sts *appsv1.StatefulSet, planState *v1alpha1.PlanStatus, c client.Client) error {
+	key := partitionKey(sts)
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+
+	partition, rolling := planState.Partitions[key]
+	existing := &appsv1.StatefulSet{}
+	err := c.Get(context.TODO(), client.ObjectKey{Namespace: sts.Namespace, Name: sts.Name}, existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		if !rolling {
+			return nil
+		}
+		// the StatefulSet was deleted in the middle of the rollout, it is created again with the current partition
+	case err != nil:
+		return err
+	case !rolling:
+		// no pod is updated until the StatefulSet controller sees the new template
+		partition = replicas
+		log.Printf("PlanExecution: Starting partitioned rollout of statefulset %s with %d replicas", key, replicas)
+	case partition > 0 && health.StatefulSetRolledOutTo(existing, partition) == nil:
+		if existing.Status.UpdatedReplicas >= replicas {
+			// all the pods are updated already, e.g. because the template did not change
+			partition = 0
+		} else {
+			partition--
+		}
+		log.Printf("PlanExecution: Statefulset %s is rolled out, continuing with partition %d", key, partition)
+	}
+
+	if partition > replicas {
+		partition = replicas
+	}
+	setPartition(planState, key, partition)
+	sts.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{
+		Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+	}
+	return nil
+}
+
+// setPartition stores the partition of the StatefulSet in the plan status
+func setPartition(planState *v1alpha1.PlanStatus, key string, partition int32) {
+	if planState.Partitions == nil {
+		planState.Partitions = make(map[string]int32)
+	}
+	planState.Partitions[key] = partition
+}
@@ -0,0 +1,144 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func getStatefulSet(name string, namespace string, replicas int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StatefulSet",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+		},
+	}
+}
+
+func TestExecutePartitionedPhaseRollsOutOnePodAtATime(t *testing.T) {
+	existing := getStatefulSet("zk", "default", 3)
+	existing.Status = appsv1.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+
+	phase := v1alpha1.Phase{Name: "phase", Strategy: v1alpha1.Partitioned, Steps: []v1alpha1.Step{{Name: "step"}}}
+	planState := &v1alpha1.PlanStatus{Name: "deploy"}
+	phaseState := &v1alpha1.PhaseStatus{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step", Status: v1alpha1.ExecutionPending}}}
+
+	// each reconcile sees the pods the StatefulSet controller updated in the meantime, pods are updated from the highest ordinal
+	tests := []struct {
+		name              string
+		updated           int32
+		ready             int32
+		expectedPartition int32
+		expectedComplete  bool
+	}{
+		{"rollout starts without updating any pod", 0, 3, 3, false},
+		{"first pod can be updated", 0, 3, 2, false},
+		{"updated pod is not ready yet", 1, 2, 2, false},
+		{"updated pod is ready", 1, 3, 1, false},
+		{"last pod is updated once the previous is ready", 2, 3, 0, false},
+		{"all pods updated and ready", 3, 3, 0, true},
+	}
+
+	for _, tt := range tests {
+		status := appsv1.StatefulSetStatus{Replicas: 3, UpdatedReplicas: tt.updated, ReadyReplicas: tt.ready}
+		current := &appsv1.StatefulSet{}
+		if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "zk"}, current); err != nil {
+			t.Fatalf("%s: Expecting statefulset to exist but got %v", tt.name, err)
+		}
+		current.Status = status
+		if err := testClient.Update(context.TODO(), current); err != nil {
+			t.Fatalf("%s: Expecting statefulset status to be updated but got %v", tt.name, err)
+		}
+		rendered := getStatefulSet("zk", "default", 3)
+		// the fake client does not ignore the status of the applied object
+		rendered.Status = status
+		resources := phaseResources{StepResources: map[string][]runtime.Object{"step": {rendered}}}
+
+		complete, err := executePartitionedPhase(phase, planState, phaseState, resources, clock.RealClock{}, testClient)
+		if err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if complete != tt.expectedComplete {
+			t.Errorf("%s: Expecting phase complete to be %v but got %v", tt.name, tt.expectedComplete, complete)
+		}
+
+		if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "zk"}, current); err != nil {
+			t.Fatalf("%s: Expecting statefulset to exist but got %v", tt.name, err)
+		}
+		partition := current.Spec.UpdateStrategy.RollingUpdate.Partition
+		if partition == nil || *partition != tt.expectedPartition {
+			t.Errorf("%s: Expecting statefulset to be applied with partition %d but got %v", tt.name, tt.expectedPartition, partition)
+		}
+
+		stored, rolling := planState.Partitions["default/zk"]
+		if tt.expectedComplete && rolling {
+			t.Errorf("%s: Expecting partition to be removed from the status once the rollout finished but got %d", tt.name, stored)
+		}
+		if !tt.expectedComplete && stored != tt.expectedPartition {
+			t.Errorf("%s: Expecting partition %d in the status but got %d", tt.name, tt.expectedPartition, stored)
+		}
+	}
+}
+
+func TestExecutePartitionedPhaseCreatesStatefulSetWithoutPartition(t *testing.T) {
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+	phase := v1alpha1.Phase{Name: "phase", Strategy: v1alpha1.Partitioned, Steps: []v1alpha1.Step{{Name: "step"}}}
+	planState := &v1alpha1.PlanStatus{Name: "deploy"}
+	phaseState := &v1alpha1.PhaseStatus{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step", Status: v1alpha1.ExecutionPending}}}
+	resources := phaseResources{StepResources: map[string][]runtime.Object{"step": {getStatefulSet("zk", "default", 3)}}}
+
+	if _, err := executePartitionedPhase(phase, planState, phaseState, resources, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	current := &appsv1.StatefulSet{}
+	if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "zk"}, current); err != nil {
+		t.Fatalf("Expecting statefulset to be created but got %v", err)
+	}
+	if current.Spec.UpdateStrategy.RollingUpdate != nil {
+		t.Errorf("Expecting new statefulset to be created without partition but got %v", current.Spec.UpdateStrategy)
+	}
+	if len(planState.Partitions) != 0 {
+		t.Errorf("Expecting no partition in the status for new statefulset but got %v", planState.Partitions)
+	}
+}
+
+func TestExecuteStepWithCanaryPartition(t *testing.T) {
+	canary := func() *appsv1.StatefulSet {
+		sts := getStatefulSet("zk", "default", 3)
+		partition := int32(2)
+		sts.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{
+			Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+			RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+		}
+		// the fake client does not ignore the status of the applied object
+		sts.Status = appsv1.StatefulSetStatus{Replicas: 3, ReadyReplicas: 3, UpdatedReplicas: 1}
+		return sts
+	}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, canary())
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	// outside of partitioned phases the partition of the template is kept, so only the canary pod is updated
+	if err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{canary()}, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step with the canary rolled out to be complete but got %v: %s", state.Status, state.Message)
+	}
+}
@@ -0,0 +1,79 @@
+package instance
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// patchTypes decides the patch type of the objects applied by all plan executions. It has a scheme of its own as the
+// client-go scheme is the scheme of the manager, which adds the kudo.dev kinds to it.
+var patchTypes = newPatchTypeCache(builtInScheme())
+
+// builtInScheme returns a scheme with only the kinds built into Kubernetes
+func builtInScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	return s
+}
+
+// patchTypeCache remembers the type of patch used for objects of each kind
+// strategic merge patches are supported only by kinds built into Kubernetes, custom resources have to be patched with
+// a merge patch, the kinds known to the scheme of the cache are considered built in. A kind that turns out not to support
+// strategic merge patches anyway, e.g. one served by an aggregated API server, is patched with a merge patch from then on.
+type patchTypeCache struct {
+	scheme *runtime.Scheme
+
+	mu    sync.Mutex
+	types map[schema.GroupVersionKind]types.PatchType
+}
+
+func newPatchTypeCache(s *runtime.Scheme) *patchTypeCache {
+	return &patchTypeCache{
+		scheme: s,
+		types:  make(map[schema.GroupVersionKind]types.PatchType),
+	}
+}
+
+// patchType returns the type of patch to use for the object
+func (p *patchTypeCache) patchType(obj runtime.Object) types.PatchType {
+	gvk := p.kind(obj)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if patchType, ok := p.types[gvk]; ok {
+		return patchType
+	}
+	patchType := types.MergePatchType
+	if p.scheme.Recognizes(gvk) {
+		patchType = types.StrategicMergePatchType
+	}
+	p.types[gvk] = patchType
+	return patchType
+}
+
+// strategicMergeUnsupported records that objects of the kind of the given object do not support strategic merge patches
+func (p *patchTypeCache) strategicMergeUnsupported(obj runtime.Object) {
+	gvk := p.kind(obj)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.types[gvk] = types.MergePatchType
+}
+
+// kind returns the kind of the object, typed objects do not always have their kind set
+func (p *patchTypeCache) kind(obj runtime.Object) schema.GroupVersionKind {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Empty() {
+		if gvks, _, err := p.scheme.ObjectKinds(obj); err == nil && len(gvks) > 0 {
+			gvk = gvks[0]
+		}
+	}
+	return gvk
+}
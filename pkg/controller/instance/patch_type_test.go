@@ -0,0 +1,107 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis"
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// patchTypeRecordingClient records the types of patches and rejects strategic merge patches of kinds that do not support them
+type patchTypeRecordingClient struct {
+	client.Client
+	patches         []types.PatchType
+	noStrategicKind string
+}
+
+func (c *patchTypeRecordingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.patches = append(c.patches, patch.Type())
+	if patch.Type() == types.StrategicMergePatchType && obj.GetObjectKind().GroupVersionKind().Kind == c.noStrategicKind {
+		return &apierrors.StatusError{ErrStatus: metav1.Status{Status: metav1.StatusFailure, Code: 415, Reason: metav1.StatusReasonUnsupportedMediaType}}
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func getCustomResource(kind string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": "custom", "namespace": "default"},
+		"spec":       map[string]interface{}{"size": int64(3)},
+	}}
+}
+
+func TestPatchTypeOfKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing runtime.Object
+		applied  runtime.Object
+		expected []types.PatchType
+	}{
+		{"built in kind", getDeployment("web", "default", 1), getDeployment("web", "default", 2), []types.PatchType{types.StrategicMergePatchType}},
+		{"custom resource", getCustomResource("Database"), getCustomResource("Database"), []types.PatchType{types.MergePatchType}},
+	}
+
+	for _, tt := range tests {
+		testClient := &patchTypeRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, tt.existing), noStrategicKind: "Database"}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		if err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{tt.applied}, nil, clock.RealClock{}, testClient); err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if len(testClient.patches) != len(tt.expected) || testClient.patches[0] != tt.expected[0] {
+			t.Errorf("%s: Expecting patches %v but got %v", tt.name, tt.expected, testClient.patches)
+		}
+	}
+}
+
+func TestPatchTypeFallsBackOnce(t *testing.T) {
+	// a kind the scheme knows whose API server does not accept strategic merge patches
+	s := runtime.NewScheme()
+	s.AddKnownTypeWithName(getCustomResource("Aggregated").GroupVersionKind(), &unstructured.Unstructured{})
+	cache := newPatchTypeCache(s)
+	obj := getCustomResource("Aggregated")
+
+	if patchType := cache.patchType(obj); patchType != types.StrategicMergePatchType {
+		t.Fatalf("Expecting strategic merge patch for a known kind but got %s", patchType)
+	}
+	cache.strategicMergeUnsupported(obj)
+	if patchType := cache.patchType(obj); patchType != types.MergePatchType {
+		t.Errorf("Expecting merge patch once strategic merge patch turned out to be unsupported but got %s", patchType)
+	}
+	if patchType := cache.patchType(getCustomResource("Other")); patchType != types.MergePatchType {
+		t.Errorf("Expecting merge patch for an unknown kind but got %s", patchType)
+	}
+}
+
+func TestPatchTypeOfKudoKindsWithManagerScheme(t *testing.T) {
+	// the manager adds the kudo.dev kinds to the client-go scheme it shares with the cache of patch types
+	if err := apis.AddToScheme(scheme.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	existing := &v1alpha1.Instance{
+		TypeMeta:   metav1.TypeMeta{Kind: "Instance", APIVersion: "kudo.dev/v1alpha1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "dependency", Namespace: "default"},
+	}
+	applied := existing.DeepCopy()
+	applied.Spec.Parameters = map[string]string{"REPLICAS": "3"}
+	testClient := &patchTypeRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, existing), noStrategicKind: "Instance"}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	if err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{applied}, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if len(testClient.patches) != 1 || testClient.patches[0] != types.MergePatchType {
+		t.Errorf("Expecting a single merge patch of the kudo.dev object but got %v", testClient.patches)
+	}
+}
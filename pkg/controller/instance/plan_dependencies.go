@@ -0,0 +1,118 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/health"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	errwrap "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// planDependency is a rendered v1alpha1.PlanDependency
+type planDependency struct {
+	key         client.ObjectKey
+	onUnhealthy v1alpha1.DependencyAction
+}
+
+// renderDependencies renders names of the dependency instances of the plan with the engine of the plan, like barriers
+// render names of the objects they wait for
+func renderDependencies(plan *v1alpha1.Plan, meta *executionMetadata, engine *kudoengine.Engine, configs map[string]interface{}) ([]planDependency, error) {
+	dependencies := make([]planDependency, 0, len(plan.Dependencies))
+	for _, d := range plan.Dependencies {
+		name, err := engine.Render(d.Name, configs)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error expanding name of dependency")
+		}
+		namespace := d.Namespace
+		if namespace == "" {
+			namespace = meta.instanceNamespace
+		}
+		onUnhealthy := d.OnUnhealthy
+		if onUnhealthy == "" {
+			onUnhealthy = v1alpha1.DependencyPause
+		}
+		dependencies = append(dependencies, planDependency{
+			key:         client.ObjectKey{Namespace: namespace, Name: name},
+			onUnhealthy: onUnhealthy,
+		})
+	}
+	return dependencies, nil
+}
+
+// checkDependencies returns true when the plan can proceed as all its dependencies are healthy. While one is not, the
+// dependency unhealthy condition of the plan tells which one and the plan is either paused, or aborted with a fatal
+// error. The condition turns false again once the dependencies of a paused plan recover.
+func checkDependencies(dependencies []planDependency, state *v1alpha1.PlanStatus, now time.Time, c client.Client) (bool, error) {
+	for _, d := range dependencies {
+		problem, err := checkDependency(d, c)
+		if err != nil {
+			log.Printf("PlanExecution: Error checking health of dependency %s of plan %s: %v", d.key, state.Name, err)
+			return false, err
+		}
+		if problem == "" {
+			continue
+		}
+		message := fmt.Sprintf("dependency %s is unhealthy: %s", d.key, problem)
+		if d.onUnhealthy == v1alpha1.DependencyAbort {
+			state.SetCondition(v1alpha1.PlanCondition{Type: v1alpha1.PlanDependencyUnhealthy, Status: corev1.ConditionTrue, Reason: "Aborted", Message: message, LastTransitionTime: metav1.NewTime(now)})
+			return false, &executionError{err: fmt.Errorf("plan %s aborted, %s", state.Name, message), fatal: true, eventName: kudo.String("DependencyUnhealthy")}
+		}
+		log.Printf("PlanExecution: Plan %s is paused, %s", state.Name, message)
+		state.SetCondition(v1alpha1.PlanCondition{Type: v1alpha1.PlanDependencyUnhealthy, Status: corev1.ConditionTrue, Reason: "Paused", Message: message, LastTransitionTime: metav1.NewTime(now)})
+		return false, nil
+	}
+
+	if cond := state.Condition(v1alpha1.PlanDependencyUnhealthy); cond != nil && cond.Status == corev1.ConditionTrue {
+		log.Printf("PlanExecution: Dependencies of plan %s are healthy again, resuming it", state.Name)
+		state.SetCondition(v1alpha1.PlanCondition{Type: v1alpha1.PlanDependencyUnhealthy, Status: corev1.ConditionFalse, Reason: "DependenciesHealthy", LastTransitionTime: metav1.NewTime(now)})
+	}
+	return true, nil
+}
+
+// checkDependency returns the reason why the dependency instance is unhealthy, empty string if it is healthy
+func checkDependency(dependency planDependency, c client.Client) (string, error) {
+	instance := &v1alpha1.Instance{}
+	err := c.Get(context.TODO(), dependency.key, instance)
+	if apierrors.IsNotFound(err) {
+		return "instance does not exist", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := health.IsReady(instance); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// dependencyRequeueAfter returns the time after which dependencies of a paused plan are checked again, zero if the plan
+// is not paused. Dependency instances are not owned by the instance, so their recovery does not trigger the next execution.
+func dependencyRequeueAfter(planState *v1alpha1.PlanStatus) time.Duration {
+	if cond := planState.Condition(v1alpha1.PlanDependencyUnhealthy); cond != nil && cond.Status == corev1.ConditionTrue {
+		return barrierPollInterval
+	}
+	return 0
+}
+
+// validateDependencies returns errors of dependencies that can never be checked
+func validateDependencies(plan *activePlan) []error {
+	var errs []error
+	for i, d := range plan.Spec.Dependencies {
+		if d.Name == "" {
+			errs = append(errs, fmt.Errorf("dependency %d of plan %s has no name", i, plan.Name))
+		}
+		if d.OnUnhealthy != "" && d.OnUnhealthy != v1alpha1.DependencyPause && d.OnUnhealthy != v1alpha1.DependencyAbort {
+			errs = append(errs, fmt.Errorf("dependency %s of plan %s has unknown action %q, must be %s or %s", d.Name, plan.Name, d.OnUnhealthy, v1alpha1.DependencyPause, v1alpha1.DependencyAbort))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,201 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const dependencyTestConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: default
+`
+
+// dependentPlan applies a config map and then waits for an external config map, so that it is in progress until the
+// external one is created
+func dependentPlan(onUnhealthy v1alpha1.DependencyAction) *activePlan {
+	return &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Strategy:     "serial",
+			Dependencies: []v1alpha1.PlanDependency{{Name: "{{ .Params.ZOOKEEPER }}", OnUnhealthy: onUnhealthy}},
+			Phases: []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{
+				{Name: "config", Tasks: []string{"config"}},
+				{Name: "wait", Barrier: &v1alpha1.Barrier{Conditions: []v1alpha1.BarrierCondition{{APIVersion: "v1", Kind: "ConfigMap", Name: "external"}}}},
+			}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Status: v1alpha1.ExecutionPending,
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{
+				{Name: "config", Status: v1alpha1.ExecutionPending},
+				{Name: "wait", Status: v1alpha1.ExecutionPending},
+			}}},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{"config": {Resources: []string{"config.yaml"}}},
+		Templates: map[string]string{"config.yaml": dependencyTestConfigMap},
+		params:    map[string]string{"ZOOKEEPER": "zk"},
+	}
+}
+
+func setDependencyStatus(t *testing.T, c client.Client, status v1alpha1.ExecutionStatus) {
+	zk := &v1alpha1.Instance{}
+	if err := c.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "zk"}, zk); err != nil {
+		t.Fatalf("Expecting no error getting dependency but got %v", err)
+	}
+	zk.Status.AggregatedStatus.Status = status
+	if err := c.Update(context.TODO(), zk); err != nil {
+		t.Fatalf("Expecting no error updating dependency but got %v", err)
+	}
+}
+
+func dependencyTestClient() client.Client {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	zk := &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "zk", Namespace: "default"}}
+	zk.Status.AggregatedStatus.Status = v1alpha1.ExecutionComplete
+	return fake.NewFakeClientWithScheme(s, zk)
+}
+
+func TestPlanPausedWhileDependencyIsUnhealthy(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC))
+	meta := &executionMetadata{instanceName: "kafka", instanceNamespace: "default", clock: fakeClock}
+	c := dependencyTestClient()
+	plan := dependentPlan("")
+
+	result, err := executePlan(plan, meta, c, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error while the dependency is healthy but got %v", err)
+	}
+	plan.PlanStatus = result.PlanStatus
+	if plan.PlanStatus.Phases[0].Steps[0].Status != v1alpha1.ExecutionComplete || plan.PlanStatus.Status != v1alpha1.ExecutionInProgress {
+		t.Fatalf("Expecting first step to be complete and the plan to wait for the barrier but got %v", plan.PlanStatus)
+	}
+	if cond := plan.PlanStatus.Condition(v1alpha1.PlanDependencyUnhealthy); cond != nil {
+		t.Errorf("Expecting no dependency condition while the dependency is healthy but got %v", cond)
+	}
+
+	// the dependency fails while the barrier would pass, the plan must not get any further
+	setDependencyStatus(t, c, v1alpha1.ExecutionFatalError)
+	if err := c.Create(context.TODO(), getConfigMap("external", "default", nil)); err != nil {
+		t.Fatalf("Expecting no error creating external config map but got %v", err)
+	}
+	fakeClock.Step(time.Minute)
+	result, err = executePlan(plan, meta, c, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error while the plan is paused but got %v", err)
+	}
+	plan.PlanStatus = result.PlanStatus
+	if plan.PlanStatus.Status != v1alpha1.ExecutionInProgress || plan.PlanStatus.Phases[0].Steps[1].Status == v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting paused plan to stay in progress without passing the barrier but got %v", plan.PlanStatus)
+	}
+	cond := plan.PlanStatus.Condition(v1alpha1.PlanDependencyUnhealthy)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != "Paused" || cond.Message == "" {
+		t.Errorf("Expecting dependency condition to report the paused plan but got %v", cond)
+	}
+	if result.RequeueAfter != barrierPollInterval {
+		t.Errorf("Expecting paused plan to be requeued after %v but got %v", barrierPollInterval, result.RequeueAfter)
+	}
+
+	// the dependency recovers, the plan continues where it stopped
+	setDependencyStatus(t, c, v1alpha1.ExecutionComplete)
+	fakeClock.Step(time.Minute)
+	result, err = executePlan(plan, meta, c, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error after the dependency recovered but got %v", err)
+	}
+	if result.PlanStatus.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting plan to complete after the dependency recovered but got %v", result.PlanStatus)
+	}
+	cond = result.PlanStatus.Condition(v1alpha1.PlanDependencyUnhealthy)
+	if cond == nil || cond.Status != corev1.ConditionFalse || cond.Reason != "DependenciesHealthy" {
+		t.Errorf("Expecting dependency condition to report the recovery but got %v", cond)
+	}
+}
+
+func TestPlanAbortedWhenDependencyIsUnhealthy(t *testing.T) {
+	meta := &executionMetadata{instanceName: "kafka", instanceNamespace: "default"}
+	c := dependencyTestClient()
+	plan := dependentPlan(v1alpha1.DependencyAbort)
+
+	result, _ := executePlan(plan, meta, c, &testKubernetesObjectEnhancer{})
+	plan.PlanStatus = result.PlanStatus
+
+	setDependencyStatus(t, c, v1alpha1.ExecutionInProgress)
+	result, err := executePlan(plan, meta, c, &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Fatalf("Expecting an error aborting the plan")
+	}
+	if result.PlanStatus.Status != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting aborted plan to fail fatally but got %v", result.PlanStatus.Status)
+	}
+	cond := result.PlanStatus.Condition(v1alpha1.PlanDependencyUnhealthy)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != "Aborted" {
+		t.Errorf("Expecting dependency condition to report the aborted plan but got %v", cond)
+	}
+}
+
+func TestPlanWaitsForMissingDependency(t *testing.T) {
+	meta := &executionMetadata{instanceName: "kafka", instanceNamespace: "other"}
+	c := dependencyTestClient()
+	plan := dependentPlan("")
+
+	result, err := executePlan(plan, meta, c, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error waiting for a missing dependency but got %v", err)
+	}
+	if result.PlanStatus.Status != v1alpha1.ExecutionPending {
+		t.Errorf("Expecting plan not to start without its dependency but got %v", result.PlanStatus.Status)
+	}
+	if cond := result.PlanStatus.Condition(v1alpha1.PlanDependencyUnhealthy); cond == nil || cond.Message != "dependency other/zk is unhealthy: instance does not exist" {
+		t.Errorf("Expecting dependency condition to report the missing instance but got %v", cond)
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies []v1alpha1.PlanDependency
+		errors       int
+	}{
+		{"default action", []v1alpha1.PlanDependency{{Name: "zk"}}, 0},
+		{"known actions", []v1alpha1.PlanDependency{{Name: "zk", OnUnhealthy: v1alpha1.DependencyPause}, {Name: "hdfs", OnUnhealthy: v1alpha1.DependencyAbort}}, 0},
+		{"unknown action", []v1alpha1.PlanDependency{{Name: "zk", OnUnhealthy: "Ignore"}}, 1},
+		{"no name", []v1alpha1.PlanDependency{{}}, 1},
+	}
+
+	for _, tt := range tests {
+		plan := &activePlan{Name: "deploy", Spec: &v1alpha1.Plan{Dependencies: tt.dependencies}}
+		if errs := validateDependencies(plan); len(errs) != tt.errors {
+			t.Errorf("%s: Expecting %d errors but got %v", tt.name, tt.errors, errs)
+		}
+	}
+}
+
+func TestRenderDependenciesWithPartials(t *testing.T) {
+	plan := dependentPlan("")
+	plan.Spec.Dependencies[0].Name = `{{ template "zookeeper" . }}`
+	plan.Templates["_helpers.tpl"] = `{{ define "zookeeper" }}{{ .Params.ZOOKEEPER }}-{{ .Name }}{{ end }}`
+
+	dependencies, err := renderDependencies(plan.Spec, &executionMetadata{instanceNamespace: "default"}, planEngine(plan, &executionMetadata{}), map[string]interface{}{
+		"Name":   "kafka",
+		"Params": plan.params,
+	})
+	if err != nil {
+		t.Fatalf("Expecting no error rendering the dependency with a partial but got %v", err)
+	}
+	if len(dependencies) != 1 || dependencies[0].key != (client.ObjectKey{Namespace: "default", Name: "zk-kafka"}) {
+		t.Errorf("Expecting dependency default/zk-kafka but got %v", dependencies)
+	}
+}
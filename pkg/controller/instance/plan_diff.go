@@ -0,0 +1,281 @@
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ChangeType is what executing a plan would do to an object.
+type ChangeType string
+
+const (
+	// ChangeCreate means that the object does not exist yet and would be created.
+	ChangeCreate ChangeType = "create"
+	// ChangeUpdate means that the object exists and patching it would change some of its fields.
+	ChangeUpdate ChangeType = "update"
+	// ChangeDelete means that the object exists and would be deleted.
+	ChangeDelete ChangeType = "delete"
+	// ChangeNone means that the object is already in the desired state.
+	ChangeNone ChangeType = "none"
+)
+
+// ResourceChange describes the change executing a step of a plan would make to one object.
+type ResourceChange struct {
+	Phase     string
+	Step      string
+	Kind      string
+	Namespace string
+	Name      string
+	Type      ChangeType
+	// Fields lists the fields an update changes with their old and new values, e.g. `spec.replicas: 3 -> 5`
+	Fields []string
+}
+
+// ignoredFields are maintained by the API server, patches never change them in a meaningful way
+var ignoredFields = []string{"status", "metadata.creationTimestamp", "metadata.resourceVersion", "metadata.generation", "metadata.uid", "metadata.selfLink", "metadata.managedFields"}
+
+// DiffOptions have to match the configuration of the Reconciler for the diff to render the objects the way the
+// controller applies them
+type DiffOptions struct {
+	// ClusterConfig references the config map with variables available to all templates under `.Cluster`, see Reconciler
+	ClusterConfig types.NamespacedName
+	// LabelKeys are the keys of the labels and annotations added to the objects, see Reconciler
+	LabelKeys LabelKeys
+	// Mutators are applied to all the rendered objects, see Reconciler
+	Mutators []ObjectMutator
+	// ConventionsFallback makes conventions be applied without kustomize to templates kustomize fails on, see Reconciler
+	ConventionsFallback bool
+	// Mapper tells which kinds are cluster scoped, all objects are treated as namespaced when not set
+	Mapper meta.RESTMapper
+}
+
+// DiffPlan renders all the objects of the plan the way executing the plan for the instance would and reports what would
+// happen to each of them. Nothing is changed in the cluster, creates and patches are sent to the API server as dry run
+// so that objects the API server would reject fail the diff.
+func DiffPlan(c client.Client, scheme *runtime.Scheme, instance *v1alpha1.Instance, ov *v1alpha1.OperatorVersion, planName string, options DiffOptions) ([]ResourceChange, error) {
+	// the plan starts in a copy of the instance, so that its status is fresh while the instance stays untouched
+	instance = instance.DeepCopy()
+	instance.EnsurePlanStatusInitialized(ov)
+	if err := instance.StartPlanExecution(planName, ov); err != nil {
+		return nil, err
+	}
+	planStatus := instance.Status.PlanStatus[planName]
+
+	plan, metadata, err := preparePlanExecution(instance, ov, &planStatus)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+	metadata.clusterVariables, err = getClusterVariables(c, options.ClusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	metadata.labelKeys = options.LabelKeys
+	metadata.generatedValues, err = getGeneratedValues(c, instance.Namespace, instance.Name, options.LabelKeys)
+	if err != nil {
+		return nil, err
+	}
+	metadata.mutators = options.Mutators
+	metadata.ownerResolver = clientOwnerResolver(c)
+
+	enhancer := &kustomizeEnhancer{scheme: scheme, fallback: options.ConventionsFallback}
+	if options.Mapper != nil {
+		enhancer.scopes = newScopeCache(options.Mapper)
+	}
+	resources, err := prepareKubeResources(plan, metadata, enhancer)
+	if err != nil {
+		return nil, err
+	}
+	return diffPlanResources(plan.Spec, resources, c)
+}
+
+// diffPlanResources returns the changes of all the objects of the plan in the order the steps are defined in
+func diffPlanResources(plan *v1alpha1.Plan, resources *planResources, c client.Client) ([]ResourceChange, error) {
+	changes := []ResourceChange{}
+	for _, ph := range plan.Phases {
+		phaseRes := resources.PhaseResources[ph.Name]
+		for _, st := range ph.Steps {
+			var objs []runtime.Object
+			for _, stage := range [][]runtime.Object{phaseRes.StepPreResources[st.Name], phaseRes.StepResources[st.Name], phaseRes.StepPostResources[st.Name]} {
+				objs = append(objs, stage...)
+			}
+			for _, obj := range objs {
+				change, err := diffResource(obj, st.Delete, c)
+				if err != nil {
+					return nil, fmt.Errorf("step %s of phase %s: %v", st.Name, ph.Name, err)
+				}
+				change.Phase = ph.Name
+				change.Step = st.Name
+				changes = append(changes, change)
+			}
+		}
+	}
+	return changes, nil
+}
+
+// diffResource classifies the change of one object
+func diffResource(obj runtime.Object, delete bool, c client.Client) (ResourceChange, error) {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return ResourceChange{}, err
+	}
+	change := ResourceChange{Kind: obj.GetObjectKind().GroupVersionKind().Kind, Namespace: key.Namespace, Name: key.Name, Type: ChangeNone}
+
+	existing := emptyObject(obj)
+	err = c.Get(context.TODO(), key, existing)
+	exists := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return change, err
+	}
+
+	switch {
+	case delete && exists:
+		change.Type = ChangeDelete
+	case delete:
+		// nothing to delete
+	case !exists:
+		change.Type = ChangeCreate
+		if err := c.Create(context.TODO(), obj.DeepCopyObject(), client.DryRunAll); err != nil {
+			return change, fmt.Errorf("creating %s/%s would fail: %v", key.Namespace, key.Name, err)
+		}
+	default:
+		desiredJSON, err := diffPatch(obj, existing)
+		if err != nil || desiredJSON == nil {
+			return change, err
+		}
+		if err := dryRunPatch(obj, existing, desiredJSON, c); err != nil {
+			return change, fmt.Errorf("patching %s/%s would fail: %v", key.Namespace, key.Name, err)
+		}
+		change.Fields, err = patchedFields(existing, desiredJSON)
+		if err != nil {
+			return change, err
+		}
+		if len(change.Fields) > 0 {
+			change.Type = ChangeUpdate
+		}
+	}
+	return change, nil
+}
+
+// diffPatch returns the patch executing the step would send for the existing object, it follows the conflict policy of
+// the object like patchExistingObject does, nil if the object is left untouched
+func diffPatch(obj runtime.Object, existing runtime.Object) ([]byte, error) {
+	policy, err := conflictPolicy(obj)
+	if err != nil {
+		return nil, err
+	}
+	if policy == kudo.ConflictPolicyDetectDrift {
+		// objects with the detect-drift policy are patched only when their drift is reconciled, see detectDrift
+		return nil, nil
+	}
+	return patchData(obj, existing)
+}
+
+// dryRunPatch sends the patch executing the step would send with dry run, see patchExistingObject
+func dryRunPatch(obj runtime.Object, existing runtime.Object, desiredJSON []byte, c client.Client) error {
+	err := c.Patch(context.TODO(), existing.DeepCopyObject(), client.ConstantPatch(types.StrategicMergePatchType, desiredJSON), client.DryRunAll)
+	if apierrors.IsUnsupportedMediaType(err) {
+		err = c.Patch(context.TODO(), obj.DeepCopyObject(), client.ConstantPatch(types.MergePatchType, desiredJSON), client.DryRunAll)
+	}
+	return err
+}
+
+// patchedFields applies the patch to the existing object locally and returns the fields that would change
+func patchedFields(existing runtime.Object, desiredJSON []byte) ([]string, error) {
+	existingJSON, err := json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+	var patchedJSON []byte
+	if _, ok := existing.(*unstructured.Unstructured); ok {
+		// custom resources are patched with a merge patch
+		patchedJSON, err = jsonpatch.MergePatch(existingJSON, desiredJSON)
+	} else {
+		patchedJSON, err = strategicpatch.StrategicMergePatch(existingJSON, desiredJSON, existing)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	before, after := map[string]interface{}{}, map[string]interface{}{}
+	if err := json.Unmarshal(existingJSON, &before); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patchedJSON, &after); err != nil {
+		return nil, err
+	}
+	for _, f := range ignoredFields {
+		path := strings.Split(f, ".")
+		unstructured.RemoveNestedField(before, path...)
+		unstructured.RemoveNestedField(after, path...)
+	}
+
+	oldValues, newValues := map[string]interface{}{}, map[string]interface{}{}
+	flattenFields("", before, oldValues)
+	flattenFields("", after, newValues)
+
+	// the fields end up in logs and in the status of the instance, so sensitive values are masked like in logged objects
+	sensitive := isSensitiveContent(before) || isSensitiveContent(after)
+	dataObject := isDataObject(existing)
+	value := func(path string, v interface{}) interface{} {
+		if isMaskedField(path, sensitive, dataObject) {
+			return v1alpha1.SensitiveValueMask
+		}
+		return v
+	}
+
+	fields := []string{}
+	for path, old := range oldValues {
+		if updated, ok := newValues[path]; !ok {
+			fields = append(fields, fmt.Sprintf("%s: %v -> <none>", path, value(path, old)))
+		} else if !reflect.DeepEqual(old, updated) {
+			fields = append(fields, fmt.Sprintf("%s: %v -> %v", path, value(path, old), value(path, updated)))
+		}
+	}
+	for path, added := range newValues {
+		if _, ok := oldValues[path]; !ok {
+			fields = append(fields, fmt.Sprintf("%s: <none> -> %v", path, value(path, added)))
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// flattenFields collects the leaf values of the object keyed by their path, e.g. `spec.containers[0].image`
+// null values and empty maps and lists are left out, the API server does not distinguish them from missing fields
+func flattenFields(path string, value interface{}, fields map[string]interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, child := range v {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			flattenFields(childPath, child, fields)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenFields(fmt.Sprintf("%s[%d]", path, i), child, fields)
+		}
+	default:
+		fields[path] = v
+	}
+}
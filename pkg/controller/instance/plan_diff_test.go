@@ -0,0 +1,200 @@
+package instance
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDiffPlanResources(t *testing.T) {
+	unchanged := getConfigMap("unchanged", "default", map[string]string{"app": "test"})
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme,
+		getDeployment("deployment", "default", 1),
+		unchanged.DeepCopy(),
+		getConfigMap("obsolete", "default", nil),
+	)
+
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "deploy"},
+		{Name: "cleanup", Delete: true},
+	}}}}
+	resources := &planResources{PhaseResources: map[string]phaseResources{"phase": {
+		StepResources: map[string][]runtime.Object{
+			"deploy":  {getDeployment("deployment", "default", 3), unchanged.DeepCopy(), getConfigMap("new", "default", nil)},
+			"cleanup": {getConfigMap("obsolete", "default", nil), getConfigMap("gone", "default", nil)},
+		},
+	}}}
+
+	changes, err := diffPlanResources(plan, resources, testClient)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	expected := []ResourceChange{
+		{Phase: "phase", Step: "deploy", Kind: "Deployment", Namespace: "default", Name: "deployment", Type: ChangeUpdate, Fields: []string{"spec.replicas: 1 -> 3"}},
+		{Phase: "phase", Step: "deploy", Kind: "ConfigMap", Namespace: "default", Name: "unchanged", Type: ChangeNone, Fields: []string{}},
+		{Phase: "phase", Step: "deploy", Kind: "ConfigMap", Namespace: "default", Name: "new", Type: ChangeCreate},
+		{Phase: "phase", Step: "cleanup", Kind: "ConfigMap", Namespace: "default", Name: "obsolete", Type: ChangeDelete},
+		{Phase: "phase", Step: "cleanup", Kind: "ConfigMap", Namespace: "default", Name: "gone", Type: ChangeNone},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expecting changes %+v but got %+v", expected, changes)
+	}
+
+	// the diff must not change anything in the cluster
+	deployment := &appsv1.Deployment{}
+	if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "deployment"}, deployment); err != nil || *deployment.Spec.Replicas != 1 {
+		t.Errorf("Expecting deployment to keep 1 replica but got %v (%v)", deployment.Spec.Replicas, err)
+	}
+	if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "new"}, &corev1.ConfigMap{}); err == nil {
+		t.Errorf("Expecting config map new not to be created")
+	}
+	if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "obsolete"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("Expecting config map obsolete not to be deleted but got %v", err)
+	}
+}
+
+func TestPatchedFields(t *testing.T) {
+	existing := getDeployment("deployment", "default", 1)
+	existing.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "nginx:1.16"}}
+	existing.Status.Replicas = 1
+
+	tests := []struct {
+		name     string
+		desired  string
+		expected []string
+	}{
+		{"identical", `{"spec":{"replicas":1}}`, []string{}},
+		{"changed field", `{"spec":{"replicas":2}}`, []string{"spec.replicas: 1 -> 2"}},
+		{"added field", `{"metadata":{"labels":{"app":"test"}}}`, []string{"metadata.labels.app: <none> -> test"}},
+		{"merged list", `{"spec":{"template":{"spec":{"containers":[{"name":"app","image":"nginx:1.17"}]}}}}`, []string{"spec.template.spec.containers[0].image: nginx:1.16 -> nginx:1.17"}},
+		{"status is ignored", `{"status":{"replicas":3}}`, []string{}},
+	}
+
+	for _, tt := range tests {
+		fields, err := patchedFields(existing, []byte(tt.desired))
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(fields, tt.expected) {
+			t.Errorf("%s: Expecting fields %v but got %v", tt.name, tt.expected, fields)
+		}
+	}
+}
+
+func TestDiffResourceFollowsConflictPolicy(t *testing.T) {
+	withPolicy := func(replicas int32, policy string) *appsv1.Deployment {
+		deployment := getDeployment("web", "default", replicas)
+		deployment.Annotations = map[string]string{kudo.ConflictPolicyAnnotation: policy}
+		return deployment
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		expected ResourceChange
+	}{
+		{"kudo wins", kudo.ConflictPolicyKudoWins, ResourceChange{Kind: "Deployment", Namespace: "default", Name: "web", Type: ChangeUpdate, Fields: []string{"spec.replicas: 5 -> 3"}}},
+		{"other wins", kudo.ConflictPolicyOtherWins, ResourceChange{Kind: "Deployment", Namespace: "default", Name: "web", Type: ChangeNone, Fields: []string{}}},
+		{"detect drift", kudo.ConflictPolicyDetectDrift, ResourceChange{Kind: "Deployment", Namespace: "default", Name: "web", Type: ChangeNone}},
+	}
+
+	for _, tt := range tests {
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme, withPolicy(5, tt.policy))
+		change, err := diffResource(withPolicy(3, tt.policy), false, testClient)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(change, tt.expected) {
+			t.Errorf("%s: Expecting change %+v but got %+v", tt.name, tt.expected, change)
+		}
+	}
+}
+
+func TestDiffPlanRendersLikeTheController(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = rbacv1.AddToScheme(s)
+	ov := &v1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-1.0", Namespace: "default"},
+		Spec: v1alpha1.OperatorVersionSpec{
+			Operator: corev1.ObjectReference{Name: "operator"},
+			Version:  "1.0",
+			Templates: map[string]string{
+				"config.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  greeting: \"{{ index .Cluster \"Greeting\" }}\"\n",
+				"role.yaml":   "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: reader\nrules: []\n",
+			},
+			Tasks: map[string]v1alpha1.TaskSpec{"app": {Resources: []string{"config.yaml", "role.yaml"}}},
+			Plans: map[string]v1alpha1.Plan{"deploy": {Strategy: "serial", Phases: []v1alpha1.Phase{{
+				Name: "main", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "app", Tasks: []string{"app"}}},
+			}}}},
+		},
+	}
+	instance := &v1alpha1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"},
+		Spec:       v1alpha1.InstanceSpec{OperatorVersion: corev1.ObjectReference{Name: "operator-1.0"}},
+	}
+	clusterConfig := getConfigMap(ClusterConfigMapName, "kudo-system", nil)
+	clusterConfig.Data = map[string]string{"Greeting": "hello"}
+	restMapper := apimeta.NewDefaultRESTMapper(nil)
+	restMapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, apimeta.RESTScopeRoot)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, apimeta.RESTScopeNamespace)
+	options := DiffOptions{ClusterConfig: types.NamespacedName{Namespace: "kudo-system", Name: ClusterConfigMapName}, Mapper: restMapper}
+
+	// the objects the controller applied with the cluster variables and the scopes of the kinds
+	applied := instance.DeepCopy()
+	applied.EnsurePlanStatusInitialized(ov)
+	if err := applied.StartPlanExecution("deploy", ov); err != nil {
+		t.Fatal(err)
+	}
+	planStatus := applied.Status.PlanStatus["deploy"]
+	plan, meta, err := preparePlanExecution(applied, ov, &planStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.clusterVariables = clusterConfig.Data
+	resources, err := prepareKubeResources(plan, meta, &kustomizeEnhancer{scheme: s, scopes: newScopeCache(restMapper)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme.Scheme, append(resources.PhaseResources["main"].StepResources["app"], clusterConfig)...)
+
+	changes, err := DiffPlan(c, s, instance, ov, "deploy", options)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	for _, change := range changes {
+		if change.Type != ChangeNone {
+			t.Errorf("Expecting no change of objects rendered like the controller but got %+v", change)
+		}
+	}
+
+	// without the configuration of the controller the objects are rendered differently
+	changes, err = DiffPlan(c, s, instance, ov, "deploy", DiffOptions{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	changeTypes := map[string]ChangeType{}
+	for _, change := range changes {
+		changeTypes[change.Kind] = change.Type
+	}
+	if changeTypes["ConfigMap"] != ChangeUpdate || changeTypes["ClusterRole"] != ChangeUpdate {
+		t.Errorf("Expecting the cluster variable and the owner of the cluster role to change but got %+v", changes)
+	}
+}
@@ -5,38 +5,84 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"k8s.io/apimachinery/pkg/types"
-
-	"errors"
+	"k8s.io/apimachinery/pkg/util/clock"
 
 	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
 	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
 	"github.com/kudobuilder/kudo/pkg/util/health"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
 	errwrap "github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	apijson "k8s.io/apimachinery/pkg/util/json"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// defaultMaxConcurrency is the number of steps of a parallel phase applied at the same time when the phase does not set its own limit
+const defaultMaxConcurrency = 5
+
+const (
+	// minRequeueAfter is the time to wait before retrying the execution of a plan after its first failure
+	minRequeueAfter = 5 * time.Second
+	// maxRequeueAfter is the longest time to wait before retrying the execution of a plan that keeps failing
+	maxRequeueAfter = 5 * time.Minute
+)
+
 type activePlan struct {
 	Name string
 	*v1alpha1.PlanStatus
 	Spec      *v1alpha1.Plan
 	Tasks     map[string]v1alpha1.TaskSpec
 	Templates map[string]string
-	params    map[string]string
+	// templatingLanguage is the language the templates are written in
+	templatingLanguage v1alpha1.TemplatingLanguage
+	params             map[string]string
+	// parameters are definitions of the parameters of the operator, used to validate params
+	parameters []v1alpha1.Parameter
+	// changedParams are parameters changed since the last finished plan, nil when all resources have to be applied
+	changedParams map[string]bool
 }
 
 type planResources struct {
 	PhaseResources map[string]phaseResources
+	// Dependencies contains rendered dependency instances of the plan
+	Dependencies []planDependency
 }
 
 type phaseResources struct {
 	StepResources map[string][]runtime.Object
+	// StepUnchangedResources contains those of StepResources that do not depend on any changed parameter
+	StepUnchangedResources map[string][]runtime.Object
+	// StepPreviousResources contains resources of the live color of a blue-green phase, deleted once the new color is live
+	StepPreviousResources map[string][]runtime.Object
+	// BlueGreenService is the service switched between the colors of a blue-green phase
+	BlueGreenService types.NamespacedName
+	// BlueGreenColorLabel is the key of the label the service selects the color by
+	BlueGreenColorLabel string
+	// StepPreResources and StepPostResources contain rendered objects of the pre and post tasks of the steps
+	StepPreResources  map[string][]runtime.Object
+	StepPostResources map[string][]runtime.Object
+	// StepDeleteSelectors contains rendered delete selectors of the steps that define one
+	StepDeleteSelectors map[string]*deleteSelector
+	// StepPodSelectors contains rendered pod selectors of the steps that define pod health
+	StepPodSelectors map[string]*podSelector
+	// StepBarriers contains rendered barrier conditions of the steps that define a barrier
+	StepBarriers map[string][]barrierCondition
+	// Partitioned is true while the steps of a partitioned phase are executed, their StatefulSets have to be rolled out to
+	// all the pods before the steps are healthy
+	Partitioned bool
 }
 
 type executionMetadata struct {
@@ -46,8 +92,57 @@ type executionMetadata struct {
 	operatorVersionName string
 	operatorVersion     string
 
+	// labels and annotations of the instance, exposed to templates as `.InstanceLabels` and `.InstanceAnnotations`
+	instanceLabels      map[string]string
+	instanceAnnotations map[string]string
+	// variables defined cluster wide by the KUDO admin, exposed to templates as `.Cluster`
+	clusterVariables map[string]string
+	// data of the ConfigMaps and Secrets of the instance exposing generated values, exposed to templates as `.Generated`
+	generatedValues map[string]interface{}
+	// whether the execution installs, upgrades or updates the instance, exposed to templates as `.PlanTrigger`, see
+	// planTrigger, executions are considered installs when not set
+	planTrigger string
+	// mutators applied to all the rendered objects before they are applied
+	mutators []ObjectMutator
+	// names of steps the execution is paused before, used when debugging a plan
+	breakpoints map[string]bool
+	// resolves image tags to digests, the default resolver of the engine is used when not set
+	digestResolver kudoengine.DigestResolver
+	// persists the plan status before irreversible actions, the status is only persisted by the caller when not set
+	flushStatus statusFlusher
+	// time a plan can make no progress before it is reported as stalled, stalled plans are not detected when not set
+	stallTimeout time.Duration
+	// source of the current time for timeouts and deadlines of the execution, the real clock is used when not set
+	clock clock.Clock
+	// kinds of objects the plan may apply, all kinds are allowed when not set
+	kindPolicy KindPolicy
+	// stream status changes of the execution are published to, they are not published when not set
+	events *ExecutionEvents
+	// persists records of plans that finished, records are not persisted when not set
+	resultSink ResultSink
+	// digests of the objects rendered by the execution, recorded for the result sink only
+	renderedDigests map[string]string
+	// keys of the labels and annotations added to the rendered objects and selecting them, the `kudo.dev/` keys are
+	// used for the ones not set
+	labelKeys LabelKeys
+
 	// the object that will own all the resources created by this execution
 	resourcesOwner metav1.Object
+	// reads owners of tasks that are not owned by the instance, tasks cannot name another owner when not set
+	ownerResolver ownerResolver
+}
+
+// planExecutionResult is the new state of the plan execution together with the time after which the execution should be
+// retried, the retry policy lives here with the state it is based on so that callers only follow the hint
+type planExecutionResult struct {
+	*v1alpha1.PlanStatus
+
+	// RequeueAfter is set when the execution failed with an error that is worth retrying, it grows with every failed
+	// attempt that did not make any progress, it is also set when the execution waits for status of objects to settle
+	RequeueAfter time.Duration
+
+	// Stalled is true if the plan was found to make no progress in this execution, see detectStall
+	Stalled bool
 }
 
 // executePlan takes a currently active plan and metadata from the underlying operator and executes next "step" in that execution
@@ -55,7 +150,90 @@ type executionMetadata struct {
 // result of running this function is new state of the execution that is returned to the caller (it can either be completed, or still in progress or errored)
 // in case of error, error is returned along with the state as well (so that it's possible to report which step caused the error)
 // in case of error, method returns ErrorStatus which has property to indicate unrecoverable error meaning if there is no point in retrying that execution
-func executePlan(plan *activePlan, metadata *executionMetadata, c client.Client, renderer kubernetesObjectEnhancer) (*v1alpha1.PlanStatus, error) {
+func executePlan(plan *activePlan, metadata *executionMetadata, c client.Client, renderer kubernetesObjectEnhancer) (*planExecutionResult, error) {
+	clk := executionClock(metadata)
+	progressBefore := planProgress(plan.PlanStatus)
+	stepsBefore := progressOf(plan.PlanStatus)
+	statusesBefore := executionStatuses(plan.PlanStatus)
+
+	// objects are read several times during the execution, e.g. before they are patched and to check their health
+	newState, err := proceedWithPlan(plan, metadata, newCachingClient(c), renderer)
+	result := &planExecutionResult{PlanStatus: newState}
+	var stallsAfter time.Duration
+	if newState != nil {
+		result.Stalled, stallsAfter = detectStall(stepsBefore, newState, metadata.stallTimeout, clk.Now())
+		metadata.events.publish(executionEvents(metadata, statusesBefore, newState, clk.Now()))
+		updateDriftCondition(newState, clk.Now())
+	}
+	if err != nil {
+		newState.LastError = errorStatus(err)
+	} else if newState.Status != v1alpha1.ExecutionFatalError {
+		// a plan that failed fatally keeps its error until it is started again
+		newState.LastError = nil
+	}
+	if newState != nil {
+		persistResult(metadata, statusesBefore[""], newState, err, clk.Now())
+	}
+	if err == nil || newState.Status.IsTerminal() {
+		newState.FailedAttempts = 0
+		if err == nil && !newState.Status.IsTerminal() {
+			result.RequeueAfter = settleRequeueAfter(plan.Spec, newState, clk.Now())
+			for _, after := range []time.Duration{forceDeleteRequeueAfter(plan.Spec, newState), deletionTimeoutRequeueAfter(plan.Spec, newState, clk.Now()), deadlineRequeueAfter(plan.Spec, newState, clk.Now()), minAgeRequeueAfter(plan.Spec, newState), podHealthRequeueAfter(plan.Spec, newState), barrierRequeueAfter(plan.Spec, newState, clk.Now()), dependencyRequeueAfter(newState), stallsAfter} {
+				if after > 0 && (result.RequeueAfter == 0 || after < result.RequeueAfter) {
+					result.RequeueAfter = after
+				}
+			}
+		}
+		return result, err
+	}
+
+	if planProgress(newState) > progressBefore {
+		// the execution got further before failing, so this is a new problem
+		newState.FailedAttempts = 0
+	}
+	newState.FailedAttempts++
+	result.RequeueAfter = requeueAfter(newState.FailedAttempts)
+	log.Printf("PlanExecution: Plan %s for instance %s failed %d times in a row, retrying in %v", plan.Name, metadata.instanceName, newState.FailedAttempts, result.RequeueAfter)
+	return result, err
+}
+
+// executionClock returns the source of the current time for the execution, tests replace the real clock with a fake one
+// to move past timeouts without waiting for them
+func executionClock(metadata *executionMetadata) clock.Clock {
+	if metadata.clock == nil {
+		return clock.RealClock{}
+	}
+	return metadata.clock
+}
+
+// requeueAfter returns the time to wait before retrying an execution that failed the given number of times in a row,
+// it doubles with every failure up to maxRequeueAfter
+func requeueAfter(failedAttempts int32) time.Duration {
+	after := minRequeueAfter
+	for i := int32(1); i < failedAttempts && after < maxRequeueAfter; i++ {
+		after *= 2
+	}
+	if after > maxRequeueAfter {
+		return maxRequeueAfter
+	}
+	return after
+}
+
+// planProgress returns the number of completed steps of the plan
+func planProgress(status *v1alpha1.PlanStatus) int {
+	completed := 0
+	for _, ph := range status.Phases {
+		for _, st := range ph.Steps {
+			if isFinished(st.Status) {
+				completed++
+			}
+		}
+	}
+	return completed
+}
+
+// proceedWithPlan executes next "step" of the plan and returns its new state, see executePlan
+func proceedWithPlan(plan *activePlan, metadata *executionMetadata, c client.Client, renderer kubernetesObjectEnhancer) (*v1alpha1.PlanStatus, error) {
 	if plan.Status.IsTerminal() {
 		log.Printf("PlanExecution: Plan %s for instance %s is terminal, nothing to do", plan.Name, metadata.instanceName)
 		return plan.PlanStatus, nil
@@ -64,18 +242,58 @@ func executePlan(plan *activePlan, metadata *executionMetadata, c client.Client,
 	// we don't want to modify the original state, and State does not contain any pointer, so shallow copy is enough
 	newState := &(*plan.PlanStatus)
 
+	// structural errors in the plan cannot be fixed by retrying, so there is no point in starting the execution
+	if err := validatePlan(plan); err != nil {
+		log.Printf("PlanExecution: Plan %s for instance %s is invalid: %v", plan.Name, metadata.instanceName, err)
+		newState.Status = v1alpha1.ExecutionFatalError
+		return newState, &executionError{err: err, fatal: true, eventName: kudo.String("InvalidPlan")}
+	}
+
+	skipUnselectedPhases(plan, newState)
+
 	// render kubernetes resources needed to execute this plan
 	planResources, err := prepareKubeResources(plan, metadata, renderer)
 	if err != nil {
-		var exErr *executionError
-		if errors.As(err, &exErr) {
-			newState.Status = v1alpha1.ExecutionFatalError
-		} else {
-			newState.Status = v1alpha1.ErrorStatus
+		newState.Status = statusForError(err)
+		return newState, err
+	}
+	if metadata.resultSink != nil {
+		metadata.renderedDigests = resourceDigests(planResources)
+	}
+
+	if err := checkKindPolicy(metadata.kindPolicy, plan.Spec, planResources); err != nil {
+		log.Printf("PlanExecution: Plan %s for instance %s is not allowed: %v", plan.Name, metadata.instanceName, err)
+		newState.Status = statusForError(err)
+		return newState, err
+	}
+
+	if plan.Spec.Verify {
+		return newState, verifyPlan(plan, metadata, newState, planResources, c)
+	}
+
+	clk := executionClock(metadata)
+	if proceed, err := checkDependencies(planResources.Dependencies, newState, clk.Now(), c); !proceed {
+		if err != nil {
+			newState.Status = statusForError(err)
 		}
 		return newState, err
 	}
 
+	if plan.Spec.CheckQuota && newState.Status == v1alpha1.ExecutionPending {
+		if err := checkQuota(plan.Spec, planResources, c); err != nil {
+			log.Printf("PlanExecution: Plan %s for instance %s does not fit into resource quota: %v", plan.Name, metadata.instanceName, err)
+			newState.Status = statusForError(err)
+			return newState, err
+		}
+	}
+
+	if plan.Spec.ContinuousReconciliation {
+		if err := reopenBrokenSteps(plan.Spec, newState, planResources, c); err != nil {
+			log.Printf("PlanExecution: Error when checking objects of completed steps of plan %s: %v", plan.Name, err)
+			return newState, err
+		}
+	}
+
 	// do a next step in the current plan execution
 	allPhasesCompleted := true
 	for _, ph := range plan.Spec.Phases {
@@ -91,27 +309,83 @@ func executePlan(plan *activePlan, metadata *executionMetadata, c client.Client,
 
 			// we're currently executing this phase
 			allStepsHealthy := true
-			for _, st := range ph.Steps {
-				currentStepState, _ := getStepFromStatus(st.Name, currentPhaseState)
-				resources := planResources.PhaseResources[ph.Name].StepResources[st.Name]
+			if err := skipUnchangedResources(planResources.PhaseResources[ph.Name], c); err != nil {
+				log.Printf("PlanExecution: Error when checking unchanged objects of phase %s: %v", ph.Name, err)
+				currentPhaseState.Status = v1alpha1.ErrorStatus
+				return newState, err
+			}
+			if ph.Strategy == v1alpha1.Parallel {
+				maxConcurrency := phaseMaxConcurrency(ph)
+				log.Printf("PlanExecution: Executing parallel phase %s on plan %s and instance %s with max concurrency %d", ph.Name, plan.Name, metadata.instanceName, maxConcurrency)
 
-				log.Printf("PlanExecution: Executing step %s on plan %s and instance %s - it's in %s state", st.Name, plan.Name, metadata.instanceName, currentStepState.Status)
-				err := executeStep(st, currentStepState, resources, c)
+				allStepsHealthy, err = executeParallelSteps(ph, newState, currentPhaseState, planResources.PhaseResources[ph.Name], maxConcurrency, metadata, c)
 				if err != nil {
-					currentPhaseState.Status = v1alpha1.ErrorStatus
-					currentStepState.Status = v1alpha1.ErrorStatus
+					currentPhaseState.Status = statusForError(err)
+					if currentPhaseState.Status == v1alpha1.ExecutionFatalError {
+						newState.Status = v1alpha1.ExecutionFatalError
+					}
 					return newState, err
 				}
+			} else if ph.Strategy == v1alpha1.Partitioned {
+				allStepsHealthy, err = executePartitionedPhase(ph, newState, currentPhaseState, planResources.PhaseResources[ph.Name], clk, c)
+				if err != nil {
+					currentPhaseState.Status = statusForError(err)
+					if currentPhaseState.Status == v1alpha1.ExecutionFatalError {
+						newState.Status = v1alpha1.ExecutionFatalError
+					}
+					return newState, err
+				}
+			} else if ph.Strategy == v1alpha1.BlueGreen {
+				allStepsHealthy, err = executeBlueGreenPhase(ph, newState, currentPhaseState, planResources.PhaseResources[ph.Name], clk, c)
+				if err != nil {
+					currentPhaseState.Status = statusForError(err)
+					if currentPhaseState.Status == v1alpha1.ExecutionFatalError {
+						newState.Status = v1alpha1.ExecutionFatalError
+					}
+					return newState, err
+				}
+			} else {
+				for _, st := range orderedSteps(ph) {
+					currentStepState, _ := getStepFromStatus(st.Name, currentPhaseState)
+
+					if pauseAtBreakpoint(currentStepState, metadata.breakpoints) {
+						log.Printf("PlanExecution: Execution of plan %s and instance %s is paused before step %s", plan.Name, metadata.instanceName, st.Name)
+						allStepsHealthy = false
+						break
+					}
 
-				if !isFinished(currentStepState.Status) {
-					allStepsHealthy = false
-					if ph.Strategy == v1alpha1.Serial {
+					if err := startSteps([]v1alpha1.Step{st}, []*v1alpha1.StepStatus{currentStepState}, planResources.PhaseResources[ph.Name], newState, clk, metadata.flushStatus); err != nil {
+						log.Printf("PlanExecution: Error persisting status before starting step %s: %v", st.Name, err)
+						return newState, err
+					}
+					log.Printf("PlanExecution: Executing step %s on plan %s and instance %s - it's in %s state", st.Name, plan.Name, metadata.instanceName, currentStepState.Status)
+					var err error
+					applyStart := clk.Now()
+					if st.Scale != "" {
+						err = executeScaleStep(st, currentStepState, newState, planResources.PhaseResources[ph.Name].StepResources[st.Name], c)
+					} else {
+						err = executeStepWithHooks(context.TODO(), st, currentStepState, planResources.PhaseResources[ph.Name], clk, c)
+					}
+					observeDuration(applyDuration, metadata, plan.Name, ph.Name, st.Name, applyStart)
+					if err != nil {
+						err = failStep(currentPhaseState, currentStepState, err)
+						if currentStepState.Status == v1alpha1.ExecutionFatalError {
+							newState.Status = v1alpha1.ExecutionFatalError
+						}
+						return newState, err
+					}
+
+					if !isFinished(currentStepState.Status) {
 						// we cannot proceed to the next step
+						allStepsHealthy = false
 						break
 					}
 				}
 			}
 
+			updatePhaseProgress(currentPhaseState)
+			log.Printf("PlanExecution: Phase %s on plan %s and instance %s has %d/%d steps completed", ph.Name, plan.Name, metadata.instanceName, currentPhaseState.CompletedSteps, currentPhaseState.TotalSteps)
+
 			if allStepsHealthy {
 				log.Printf("PlanExecution: All steps on phase %s plan %s and instance %s are healthy", ph.Name, plan.Name, metadata.instanceName)
 				currentPhaseState.Status = v1alpha1.ExecutionComplete
@@ -133,59 +407,457 @@ func executePlan(plan *activePlan, metadata *executionMetadata, c client.Client,
 	return newState, nil
 }
 
-func executeStep(step v1alpha1.Step, state *v1alpha1.StepStatus, resources []runtime.Object, c client.Client) error {
+// updatePhaseProgress counts the completed steps of the phase so that progress of long running phases can be reported
+func updatePhaseProgress(phaseState *v1alpha1.PhaseStatus) {
+	completed := 0
+	for _, st := range phaseState.Steps {
+		if isFinished(st.Status) {
+			completed++
+		}
+	}
+	phaseState.CompletedSteps = completed
+	phaseState.TotalSteps = len(phaseState.Steps)
+}
+
+// orderedSteps returns steps of a serial phase in the order they are executed in, sorted by their order and then by the
+// position in the phase
+// steps sharing an explicit order are most likely a mistake, this is not an error as the execution order is still well defined
+func orderedSteps(phase v1alpha1.Phase) []v1alpha1.Step {
+	steps := make([]v1alpha1.Step, len(phase.Steps))
+	copy(steps, phase.Steps)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Order < steps[j].Order
+	})
+
+	for i := 1; i < len(steps); i++ {
+		if steps[i].Order != 0 && steps[i].Order == steps[i-1].Order {
+			log.Printf("PlanExecution: Warning: steps %s and %s of phase %s have the same order %d, they are executed in the order they are defined in", steps[i-1].Name, steps[i].Name, phase.Name, steps[i].Order)
+		}
+	}
+	return steps
+}
+
+// orderedResources returns the objects of a step in the order they are applied or deleted in, sorted by their apply order
+// annotation and then by the position in the step, so objects without the annotation keep the order of the templates
+func orderedResources(resources []runtime.Object) ([]runtime.Object, error) {
+	orders := make(map[runtime.Object]int, len(resources))
+	for _, r := range resources {
+		objMeta, err := meta.Accessor(r)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := objMeta.GetAnnotations()[kudo.ApplyOrderAnnotation]
+		if !ok {
+			continue
+		}
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s annotation of %s/%s must be an integer but is %q", kudo.ApplyOrderAnnotation, objMeta.GetNamespace(), objMeta.GetName(), value)
+		}
+		orders[r] = order
+	}
+
+	ordered := make([]runtime.Object, len(resources))
+	copy(ordered, resources)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return orders[ordered[i]] < orders[ordered[j]]
+	})
+	return ordered, nil
+}
+
+// pauseAtBreakpoint marks the step as paused and returns true when there is a breakpoint set for a step that did not start yet
+// a step paused before is resumed once its breakpoint is removed
+func pauseAtBreakpoint(state *v1alpha1.StepStatus, breakpoints map[string]bool) bool {
+	notStarted := state.Status == v1alpha1.ExecutionPending || state.Status == v1alpha1.ExecutionPaused
+	if !notStarted {
+		return false
+	}
+	if breakpoints[state.Name] {
+		state.Status = v1alpha1.ExecutionPaused
+		state.Message = "paused at breakpoint"
+		return true
+	}
+	if state.Status == v1alpha1.ExecutionPaused {
+		state.Status = v1alpha1.ExecutionPending
+		state.Message = ""
+	}
+	return false
+}
+
+// phaseMaxConcurrency returns the number of steps of the given phase that can be applied at the same time
+func phaseMaxConcurrency(phase v1alpha1.Phase) int {
+	if phase.MaxConcurrency > 0 {
+		return phase.MaxConcurrency
+	}
+	return defaultMaxConcurrency
+}
+
+// executeParallelSteps executes all steps of a parallel phase making sure that no more than maxConcurrency of them are applied at the same time
+// it returns true if all the steps are healthy, in case of error, state of all the failed steps is set accordingly and the first error is returned
+func executeParallelSteps(phase v1alpha1.Phase, planState *v1alpha1.PlanStatus, phaseState *v1alpha1.PhaseStatus, resources phaseResources, maxConcurrency int, metadata *executionMetadata, c client.Client) (bool, error) {
+	stepStates := make([]*v1alpha1.StepStatus, len(phase.Steps))
+	errs := make([]error, len(phase.Steps))
+	semaphore := make(chan struct{}, maxConcurrency)
+
+	var steps []v1alpha1.Step
+	var states []*v1alpha1.StepStatus
+	for i, st := range phase.Steps {
+		stepStates[i], _ = getStepFromStatus(st.Name, phaseState)
+		if pauseAtBreakpoint(stepStates[i], metadata.breakpoints) {
+			log.Printf("PlanExecution: Step %s of phase %s is paused at a breakpoint", st.Name, phase.Name)
+			continue
+		}
+		steps = append(steps, st)
+		states = append(states, stepStates[i])
+	}
+	// all the steps start at once, so the status is persisted at most once for all of them
+	clk := executionClock(metadata)
+	if err := startSteps(steps, states, resources, planState, clk, metadata.flushStatus); err != nil {
+		return false, err
+	}
+
+	// with fail fast, the first failed step cancels the steps waiting for their turn and stops the running ones before they
+	// apply their next object
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var failedStep atomic.Value
+	aborted := make([]bool, len(steps))
+
+	var wg sync.WaitGroup
+	for i, st := range steps {
+		log.Printf("PlanExecution: Executing step %s of phase %s - it's in %s state", st.Name, phase.Name, states[i].Status)
+
+		wg.Add(1)
+		go func(i int, st v1alpha1.Step) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if ctx.Err() != nil {
+				aborted[i] = true
+				return
+			}
+			applyStart := clk.Now()
+			errs[i] = executeStepWithHooks(ctx, st, states[i], resources, clk, c)
+			observeDuration(applyDuration, metadata, planState.Name, phase.Name, st.Name, applyStart)
+			if errs[i] == context.Canceled {
+				aborted[i] = true
+				errs[i] = nil
+				return
+			}
+			if errs[i] != nil && phase.FailFast {
+				failedStep.Store(st.Name)
+				cancel()
+			}
+		}(i, st)
+	}
+	wg.Wait()
+
+	for i, st := range steps {
+		if aborted[i] {
+			abortStep(states[i], fmt.Sprintf("aborted because step %s failed", failedStep.Load()))
+			log.Printf("PlanExecution: Step %s of fail fast phase %s was aborted", st.Name, phase.Name)
+		}
+	}
+
+	var firstErr error
+	for i, err := range errs {
+		if err != nil {
+			states[i].Status = statusForError(err)
+			states[i].Message = stepErrorMessage(err)
+			if firstErr == nil {
+				firstErr = atStep(phase.Name, states[i].Name, err)
+			}
+		}
+	}
+	allStepsHealthy := true
+	for _, state := range stepStates {
+		if !isFinished(state.Status) {
+			allStepsHealthy = false
+		}
+	}
+	return allStepsHealthy, firstErr
+}
+
+// abortStep records why a step did not run, a step that did not start before stays pending so that it starts with the
+// next execution
+func abortStep(state *v1alpha1.StepStatus, reason string) {
+	if state.Status == v1alpha1.ExecutionPending {
+		state.StartedAt = metav1.Time{}
+	}
+	state.Message = reason
+}
+
+func executeStep(ctx context.Context, step v1alpha1.Step, state *v1alpha1.StepStatus, resources []runtime.Object, selector *deleteSelector, clk clock.Clock, c client.Client) error {
 	if isInProgress(state.Status) {
+		if isDeadlineExceeded(step.Deadline, state, clk.Now()) {
+			// the last execution did not get the step healthy in time, or its rollback failed
+			return failDeadline(step, state, resources, c)
+		}
 		state.Status = v1alpha1.ExecutionInProgress
+		state.Message = ""
+
+		resources, err := orderedResources(resources)
+		if err != nil {
+			return err
+		}
+
+		if selector != nil {
+			err := deleteBySelector(selector, c, deleteOptions(step)...)
+			if err != nil {
+				log.Printf("PlanExecution: Error when deleting objects by selector in step %v: %v", step.Name, err)
+				return err
+			}
+		}
 
 		// check if step is already healthy
 		allHealthy := true
+		var readyReplicas int32
+		var commandJobs []*batchv1.Job
+		var resourceErr error
 		for _, r := range resources {
+			if ctx.Err() != nil {
+				// the step was cancelled, the objects applied so far stay as they are
+				return ctx.Err()
+			}
 			if step.Delete {
 				// delete
-				log.Printf("PlanExecution: Step %s will delete object %v", step.Name, r)
-				err := c.Delete(context.TODO(), r, client.PropagationPolicy(metav1.DeletePropagationForeground))
+				log.Printf("PlanExecution: Step %s will delete object %s", step.Name, loggable(r))
+				err := c.Delete(context.TODO(), r, deleteOptions(step)...)
 				if !apierrors.IsNotFound(err) && err != nil {
 					return err
 				}
+				if step.ForceDelete == nil && step.WaitForDeletion == nil {
+					continue
+				}
+				gone, err := waitForDeletion(step.ForceDelete, r, clk.Now(), c)
+				if err != nil {
+					return err
+				}
+				if !gone {
+					allHealthy = false
+					if state.Message == "" {
+						key, _ := client.ObjectKeyFromObject(r)
+						state.Message = fmt.Sprintf("waiting for deletion of %s/%s", key.Namespace, key.Name)
+					}
+				}
 			} else {
 				// create or update
-				log.Printf("Going to create/update %v", r)
-				existingResource := r.DeepCopyObject()
+				log.Printf("Going to create/update %s", loggable(r))
+				if err := recordLastApplied(r); err != nil {
+					return err
+				}
 				key, _ := client.ObjectKeyFromObject(r)
-				err := c.Get(context.TODO(), key, existingResource)
-				if apierrors.IsNotFound(err) {
-					// create
-					err = c.Create(context.TODO(), r)
-					if err != nil {
-						log.Printf("PlanExecution: error when creating resource in step %v: %v", step.Name, err)
+				var stickyDigest string
+				if isHealthSticky(r) {
+					// the object is rendered before it is applied, the client fills in some of its fields
+					if stickyDigest, err = objectDigest(r); err != nil {
 						return err
 					}
-				} else if err != nil {
-					// other than not found error - raise it
-					return err
-				} else {
-					// update
-					err := patchExistingObject(r, existingResource, c)
-					if err != nil {
+				}
+				existingResource, applied, err := applyObject(step, state, r, key, c)
+				if err != nil {
+					if step.ResourceRetries <= 0 {
 						return err
 					}
+					if err := retryResource(step, state, appliedKey(r, key), err); err != nil {
+						return err
+					}
+					// the other objects of the step are applied before the execution fails with this error
+					allHealthy = false
+					resourceErr = err
+					continue
+				}
+				delete(state.ResourceAttempts, appliedKey(r, key))
+				if !applied {
+					// objects we decided not to touch are considered healthy
+					continue
 				}
 
-				err = health.IsHealthy(c, existingResource)
-				if err != nil {
+				if isTerminating(existingResource) {
+					// the object is created again once it is gone
 					allHealthy = false
-					log.Printf("PlanExecution: Obj is NOT healthy: %s", prettyPrint(key))
+					if state.Message == "" {
+						state.Message = fmt.Sprintf("waiting for %s/%s to be gone before creating it again", key.Namespace, key.Name)
+					}
+					continue
+				}
+
+				if young, remaining := isTooYoung(step, existingResource, clk.Now()); young {
+					// the object has to exist for a while before the step proceeds
+					allHealthy = false
+					log.Printf("PlanExecution: Waiting %v for %s to be %ds old", remaining, prettyPrint(key), step.MinAgeSeconds)
+					if state.Message == "" {
+						state.Message = fmt.Sprintf("waiting for %s/%s to be %ds old", key.Namespace, key.Name, step.MinAgeSeconds)
+					}
+					continue
+				}
+
+				if isHealthCheckIgnored(r) {
+					log.Printf("PlanExecution: Health check of %s is ignored because of %s annotation", prettyPrint(key), kudo.HealthAnnotation)
+					continue
+				}
+
+				if step.PodHealth != nil {
+					// the pods matching the selector decide the health of the step
+					continue
+				}
+
+				if isSettling(step, state, appliedKey(r, key), clk.Now()) {
+					// status of the object might be stale, so it is not trusted yet
+					allHealthy = false
+					log.Printf("PlanExecution: Waiting %ds for status of %s to settle before checking its health", step.SettleSeconds, prettyPrint(key))
+					if state.Message == "" {
+						state.Message = fmt.Sprintf("waiting for status of %s/%s to settle", key.Namespace, key.Name)
+					}
+					continue
+				}
+
+				if ready, ok := health.ReadyReplicas(existingResource); ok && step.MinReadyReplicas > 0 {
+					// workloads contribute to the quorum of the step instead of being checked one by one
+					readyReplicas += ready
+				} else if stickyDigest != "" && wasHealthy(state, appliedKey(r, key), stickyDigest) {
+					log.Printf("PlanExecution: %s was healthy before, its health is not checked again because of %s annotation", prettyPrint(key), kudo.HealthAnnotation)
+				} else {
+					err = health.IsHealthy(c, existingResource)
+					if err != nil {
+						allHealthy = false
+						log.Printf("PlanExecution: Obj is NOT healthy: %s", prettyPrint(key))
+						if state.Message == "" {
+							// tells what the step is waiting for, e.g. the nodes a DaemonSet is not ready on yet
+							state.Message = fmt.Sprintf("waiting for %s/%s: %v", key.Namespace, key.Name, err)
+						}
+					} else if stickyDigest != "" {
+						recordHealthy(state, appliedKey(r, key), stickyDigest)
+					}
+				}
+
+				if isCommandJob(existingResource) {
+					job := existingResource.(*batchv1.Job)
+					if err := checkCommandJob(job, state, c); err != nil {
+						return err
+					}
+					commandJobs = append(commandJobs, job)
 				}
 			}
 		}
 
+		if resourceErr != nil {
+			return resourceErr
+		}
+
+		if step.Delete && !allHealthy && isDeletionTimedOut(step.WaitForDeletion, state, clk.Now()) {
+			return &executionError{err: fmt.Errorf("objects of step %s are not gone after %ds: %s", step.Name, step.WaitForDeletion.TimeoutSeconds, state.Message), fatal: true, eventName: kudo.String("DeletionTimedOut")}
+		}
+
+		if step.MinReadyReplicas > 0 && !step.Delete && readyReplicas < step.MinReadyReplicas {
+			allHealthy = false
+			log.Printf("PlanExecution: Step %s has %d ready replicas, waiting for at least %d", step.Name, readyReplicas, step.MinReadyReplicas)
+		}
+
+		if !allHealthy && isDeadlineExceeded(step.Deadline, state, clk.Now()) {
+			return failDeadline(step, state, resources, c)
+		}
+
 		if allHealthy {
 			state.Status = v1alpha1.ExecutionComplete
+			for _, job := range commandJobs {
+				cleanupCommandJob(job, c)
+			}
 		}
 	}
 	return nil
 }
 
+// applyObject creates the object or patches the existing one, or recreates it, see isRecreated. It returns the current
+// state of the object and false if the patch condition of the step or drift of the object left the existing object untouched
+func applyObject(step v1alpha1.Step, state *v1alpha1.StepStatus, r runtime.Object, key client.ObjectKey, c client.Client) (runtime.Object, bool, error) {
+	digest, err := objectDigest(r)
+	if err != nil {
+		return nil, false, err
+	}
+	existingResource := emptyObject(r)
+	err = c.Get(context.TODO(), key, existingResource)
+	if apierrors.IsNotFound(err) {
+		// create
+		err = c.Create(context.TODO(), r)
+		if err != nil {
+			log.Printf("PlanExecution: error when creating resource in step %v: %v", step.Name, err)
+			return nil, false, err
+		}
+		recordCreated(step, state, appliedKey(r, key))
+		recordApplied(state, appliedKey(r, key), digest, r)
+		return r.DeepCopyObject(), true, nil
+	} else if err != nil {
+		// other than not found error - raise it
+		return nil, false, err
+	}
+
+	// update
+	shouldPatch, err := evaluatePatchCondition(step.PatchCondition, existingResource, r)
+	if err != nil {
+		log.Printf("PlanExecution: error when evaluating patch condition in step %v: %v", step.Name, err)
+		return nil, false, err
+	}
+	if !shouldPatch {
+		log.Printf("PlanExecution: Patch condition of step %s is not satisfied, skipping patch of %s", step.Name, prettyPrint(key))
+		return existingResource, false, nil
+	}
+
+	drifted, err := detectDrift(step, state, r, existingResource, appliedKey(r, key))
+	if err != nil || drifted {
+		return existingResource, false, err
+	}
+
+	if isUnchanged(state, appliedKey(r, key), digest, existingResource) {
+		// the health of the object is still checked
+		log.Printf("PlanExecution: %s did not change since step %s applied it, skipping patch", prettyPrint(key), step.Name)
+		return existingResource, true, nil
+	}
+
+	recreate, err := isRecreated(step, r)
+	if err != nil {
+		return nil, false, err
+	}
+	if recreate {
+		current, err := recreateObject(step, r, existingResource, key, c)
+		if err != nil {
+			return nil, false, err
+		}
+		recordApplied(state, appliedKey(r, key), digest, current)
+		return current, true, nil
+	}
+
+	err = patchExistingObject(r, existingResource, c)
+	if err != nil {
+		return nil, false, err
+	}
+	recordApplied(state, appliedKey(r, key), digest, existingResource)
+	return existingResource, true, nil
+}
+
+// isHealthCheckIgnored returns true if the template of the object opted out of health checking via annotation
+func isHealthCheckIgnored(obj runtime.Object) bool {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	return objMeta.GetAnnotations()[kudo.HealthAnnotation] == kudo.HealthIgnoreValue
+}
+
+// emptyObject returns an object of the same type and kind as the given one with no fields set, the current state of an
+// object has to be read into an empty one as decoding keeps fields of the target that are missing in the response
+func emptyObject(obj runtime.Object) runtime.Object {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		empty := &unstructured.Unstructured{}
+		empty.SetGroupVersionKind(u.GroupVersionKind())
+		return empty
+	}
+	empty := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	empty.GetObjectKind().SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	return empty
+}
+
 func prettyPrint(i interface{}) string {
 	s, _ := json.MarshalIndent(i, "", "  ")
 	return string(s)
@@ -197,125 +869,442 @@ func prettyPrint(i interface{}) string {
 // kubernetes native objects might be a problem because we cannot just compare the spec as the spec might have extra fields
 // and those extra fields are set by some kubernetes component
 // because of that for now we just try to apply the patch every time
+// only the fields the conflict policy of the object lets KUDO assert are patched, see conflictPolicy
+// built in kinds are patched with a strategic merge patch, custom resources with a merge patch, see patchTypeCache
 func patchExistingObject(newResource runtime.Object, existingResource runtime.Object, c client.Client) error {
-	newResourceJSON, _ := apijson.Marshal(newResource)
-	key, _ := client.ObjectKeyFromObject(newResource)
-	err := c.Patch(context.TODO(), existingResource, client.ConstantPatch(types.StrategicMergePatchType, newResourceJSON))
+	newResourceJSON, err := patchData(newResource, existingResource)
 	if err != nil {
-		// Right now applying a Strategic Merge Patch to custom resources does not work. There is
-		// certain metadata needed, which when missing, leads to an invalid Content-Type Header and
-		// causes the request to fail.
-		// ( see https://github.com/kubernetes-sigs/kustomize/issues/742#issuecomment-458650435 )
-		//
-		// We temporarily solve this by checking for the specific error when a SMP is applied to
-		// custom resources and handle it by defaulting to a Merge Patch.
-		//
-		// The error message for which we check is:
-		// 		the body of the request was in an unknown format - accepted media types include:
-		//			application/json-patch+json, application/merge-patch+json
-		//
+		return err
+	}
+	key, _ := client.ObjectKeyFromObject(newResource)
+	patchType := patchTypes.patchType(newResource)
+	err = c.Patch(context.TODO(), existingResource, client.ConstantPatch(patchType, newResourceJSON))
+	if err != nil && patchType == types.StrategicMergePatchType && apierrors.IsUnsupportedMediaType(err) {
+		// the kind looked built in but its API server does not accept strategic merge patches, it responds with
 		// 		Reason: "UnsupportedMediaType" Code: 415
-		if apierrors.IsUnsupportedMediaType(err) {
-			err = c.Patch(context.TODO(), newResource, client.ConstantPatch(types.MergePatchType, newResourceJSON))
-			if err != nil {
-				log.Printf("PlanExecution: Error when applying merge patch to object %v: %v", key, err)
-				return err
-			}
-		} else {
-			log.Printf("PlanExecution: Error when applying StrategicMergePatch to object %v: %v", key, err)
-			return err
-		}
+		log.Printf("PlanExecution: Strategic merge patch of object %v is not supported, using merge patch", key)
+		patchTypes.strategicMergeUnsupported(newResource)
+		patchType = types.MergePatchType
+		err = c.Patch(context.TODO(), existingResource, client.ConstantPatch(patchType, newResourceJSON))
+	}
+	if err != nil {
+		log.Printf("PlanExecution: Error when applying %s patch to object %v: %v", patchType, key, err)
+		return err
 	}
 	return nil
 }
 
+// evaluatePatchCondition renders the patch condition of a step with the existing and the desired object and returns true if
+// the existing object should be patched
+// empty condition means that the object is always patched
+func evaluatePatchCondition(condition string, existingResource runtime.Object, newResource runtime.Object) (bool, error) {
+	if condition == "" {
+		return true, nil
+	}
+
+	existing, err := runtime.DefaultUnstructuredConverter.ToUnstructured(existingResource)
+	if err != nil {
+		return false, errwrap.Wrap(err, "error converting existing object")
+	}
+	desired, err := runtime.DefaultUnstructuredConverter.ToUnstructured(newResource)
+	if err != nil {
+		return false, errwrap.Wrap(err, "error converting desired object")
+	}
+
+	rendered, err := kudoengine.New().Render(condition, map[string]interface{}{
+		"Existing": existing,
+		"Desired":  desired,
+	})
+	if err != nil {
+		return false, errwrap.Wrap(err, "error expanding patch condition")
+	}
+
+	result, err := strconv.ParseBool(strings.TrimSpace(rendered))
+	if err != nil {
+		return false, fmt.Errorf("patch condition %q rendered to %q which is not a boolean", condition, rendered)
+	}
+	return result, nil
+}
+
+// planEngine returns the engine rendering the templates of the plan, with the files and partials of the operator
+func planEngine(plan *activePlan, meta *executionMetadata) *kudoengine.Engine {
+	engine := kudoengine.New()
+	engine.DigestResolver = digestResolver(meta)
+	engine.Files = plan.Templates
+	engine.Partials = kudoengine.PartialsIn(plan.Templates)
+	return engine
+}
+
 // prepareKubeResources takes all resources in all tasks for a plan and renders them with the right parameters
 // it also takes care of applying KUDO specific conventions to the resources like commond labels
 func prepareKubeResources(plan *activePlan, meta *executionMetadata, renderer kubernetesObjectEnhancer) (*planResources, error) {
+	if err := validateParameterValues(plan.parameters, plan.params); err != nil {
+		log.Printf("PlanExecution: Invalid parameters of instance %s: %v", meta.instanceName, err)
+		return nil, &executionError{err: err, fatal: true, eventName: kudo.String("InvalidParameter")}
+	}
+
+	params, err := pinImageParameters(plan.parameters, plan.params, digestResolver(meta))
+	if err != nil {
+		log.Printf("PlanExecution: Images of instance %s cannot be pinned: %v", meta.instanceName, err)
+		return nil, &executionError{err: err, fatal: true, eventName: kudo.String("ImageNotPinned")}
+	}
+
 	configs := make(map[string]interface{})
 	configs["OperatorName"] = meta.operatorName
 	configs["Name"] = meta.instanceName
 	configs["Namespace"] = meta.instanceNamespace
-	configs["Params"] = plan.params
+	configs["Params"] = params
+	configs["Values"] = templateValues(plan.parameters, params)
+	configs["Cluster"] = meta.clusterVariables
+	if meta.clusterVariables == nil {
+		configs["Cluster"] = map[string]string{}
+	}
+	configs["Generated"] = meta.generatedValues
+	if meta.generatedValues == nil {
+		configs["Generated"] = map[string]interface{}{}
+	}
+	configs["PlanTrigger"] = meta.planTrigger
+	if meta.planTrigger == "" {
+		configs["PlanTrigger"] = planTriggerInstall
+	}
+	configs["InstanceLabels"] = instanceMetadata(meta.instanceLabels, meta.labelKeys)
+	configs["InstanceAnnotations"] = instanceMetadata(meta.instanceAnnotations, meta.labelKeys)
+	// the UID is unique across the lifecycles of instances of the same name, both are left out until the instance is
+	// persisted so that templates using them fail instead of rendering names that are not unique
+	if meta.resourcesOwner != nil && meta.resourcesOwner.GetUID() != "" {
+		configs["InstanceUID"] = string(meta.resourcesOwner.GetUID())
+		configs["InstanceGeneration"] = meta.resourcesOwner.GetGeneration()
+	}
 
 	result := &planResources{
 		PhaseResources: make(map[string]phaseResources),
 	}
 
+	dependencies, err := renderDependencies(plan.Spec, meta, planEngine(plan, meta), configs)
+	if err != nil {
+		log.Print(err)
+		return nil, &executionError{err: err, fatal: true, eventName: kudo.String("InvalidPlan")}
+	}
+	result.Dependencies = dependencies
+
 	for _, phase := range plan.Spec.Phases {
 		phaseState, _ := getPhaseFromStatus(phase.Name, plan.PlanStatus)
 		perStepResources := make(map[string][]runtime.Object)
-		result.PhaseResources[phase.Name] = phaseResources{
-			StepResources: perStepResources,
+		perStepUnchangedResources := make(map[string][]runtime.Object)
+		perStepPreviousResources := make(map[string][]runtime.Object)
+		perStepDeleteSelectors := make(map[string]*deleteSelector)
+		phaseRes := phaseResources{
+			StepResources:          perStepResources,
+			StepUnchangedResources: perStepUnchangedResources,
+			StepPreviousResources:  perStepPreviousResources,
+			StepPreResources:       make(map[string][]runtime.Object),
+			StepPostResources:      make(map[string][]runtime.Object),
+			StepDeleteSelectors:    perStepDeleteSelectors,
+			StepPodSelectors:       make(map[string]*podSelector),
+			StepBarriers:           make(map[string][]barrierCondition),
+		}
+
+		color, previousColor := "", ""
+		changedParams := plan.changedParams
+		if !isGoTemplate(plan.templatingLanguage) {
+			// references to parameters are tracked only in go templates
+			changedParams = nil
+		}
+		delete(configs, "Color")
+		if phase.Strategy == v1alpha1.BlueGreen {
+			// the new color needs all the resources
+			changedParams = nil
+			previousColor, color = blueGreenColors(plan.PlanStatus, phase.Name)
+			configs["Color"] = color
+			service, err := kudoengine.New().Render(phase.BlueGreen.Service, configs)
+			if err != nil {
+				err := errwrap.Wrap(err, "error expanding blue-green service name")
+				log.Print(err)
+				phaseState.Status = v1alpha1.ExecutionFatalError
+				return nil, &executionError{err: err, fatal: true, phase: phase.Name}
+			}
+			phaseRes.BlueGreenService = types.NamespacedName{Namespace: meta.instanceNamespace, Name: service}
+			phaseRes.BlueGreenColorLabel = meta.labelKeys.withDefaults().Color
 		}
+		result.PhaseResources[phase.Name] = phaseRes
+
 		for j, step := range phase.Steps {
+			renderStart := executionClock(meta).Now()
 			configs["PlanName"] = plan.Name
 			configs["PhaseName"] = phase.Name
 			configs["StepName"] = step.Name
 			configs["StepNumber"] = strconv.FormatInt(int64(j), 10)
-			var resources []runtime.Object
 			stepState, _ := getStepFromStatus(step.Name, phaseState)
 
-			engine := kudoengine.New()
-			for _, t := range step.Tasks {
-				if taskSpec, ok := plan.Tasks[t]; ok {
-					resourcesAsString := make(map[string]string)
-
-					for _, res := range taskSpec.Resources {
-						if resource, ok := plan.Templates[res]; ok {
-							templatedYaml, err := engine.Render(resource, configs)
-							if err != nil {
-								phaseState.Status = v1alpha1.ExecutionFatalError
-								stepState.Status = v1alpha1.ExecutionFatalError
-
-								err := errwrap.Wrap(err, "error expanding template")
-								log.Print(err)
-								return nil, &executionError{err, true, nil}
-							}
-							resourcesAsString[res] = templatedYaml
-						} else {
-							phaseState.Status = v1alpha1.ExecutionFatalError
-							stepState.Status = v1alpha1.ExecutionFatalError
-
-							err := fmt.Errorf("PlanExecution: Error finding resource named %v for operator version %v", res, meta.operatorVersionName)
-							log.Print(err)
-							return nil, &executionError{err, true, nil}
-						}
-					}
+			engine := planEngine(plan, meta)
+			templates, err := templateRenderer(plan.templatingLanguage, engine)
+			if err != nil {
+				log.Print(err)
+				return nil, failStep(phaseState, stepState, &executionError{err: err, fatal: true})
+			}
+			var skipped bool
+			step, skipped, err = withActiveTasks(step, engine, configs)
+			if err != nil {
+				log.Print(err)
+				return nil, failStep(phaseState, stepState, &executionError{err: err, fatal: true})
+			}
+			if skipped {
+				skipStep(stepState)
+				continue
+			}
+			if step.DeleteSelector != nil {
+				selector, err := renderDeleteSelector(step.DeleteSelector, meta, engine, configs)
+				if err != nil {
+					log.Print(err)
+					return nil, failStep(phaseState, stepState, &executionError{err: err, fatal: true})
+				}
+				perStepDeleteSelectors[step.Name] = selector
+			}
+			if step.PodHealth != nil {
+				selector, err := renderPodSelector(step.PodHealth, meta, engine, configs)
+				if err != nil {
+					log.Print(err)
+					return nil, failStep(phaseState, stepState, &executionError{err: err, fatal: true})
+				}
+				phaseRes.StepPodSelectors[step.Name] = selector
+			}
+			if step.Barrier != nil {
+				conditions, err := renderBarrier(step.Barrier, meta, engine, configs)
+				if err != nil {
+					log.Print(err)
+					return nil, failStep(phaseState, stepState, &executionError{err: err, fatal: true})
+				}
+				phaseRes.StepBarriers[step.Name] = conditions
+			}
 
-					resourcesWithConventions, err := renderer.applyConventionsToTemplates(resourcesAsString, metadata{
-						InstanceName:    meta.instanceName,
-						Namespace:       meta.instanceNamespace,
-						OperatorName:    meta.operatorName,
-						OperatorVersion: meta.operatorVersion,
-						PlanName:        plan.Name,
-						PhaseName:       phase.Name,
-						StepName:        step.Name,
-					}, meta.resourcesOwner)
+			resources, unchanged, err := renderStep(plan, meta, phase, step, engine, templates, configs, renderer, color, changedParams)
+			if err != nil {
+				return nil, failStep(phaseState, stepState, err)
+			}
+			perStepResources[step.Name] = resources
+			perStepUnchangedResources[step.Name] = unchanged
 
-					if err != nil {
-						phaseState.Status = v1alpha1.ErrorStatus
-						stepState.Status = v1alpha1.ErrorStatus
+			// pre and post tasks are always applied
+			if len(step.PreTasks) > 0 {
+				phaseRes.StepPreResources[step.Name], _, err = renderStepResources(plan, meta, phase, hookStep(step, step.PreTasks), engine, templates, configs, renderer, color, "", nil)
+				if err != nil {
+					return nil, failStep(phaseState, stepState, err)
+				}
+			}
+			if len(step.PostTasks) > 0 {
+				phaseRes.StepPostResources[step.Name], _, err = renderStepResources(plan, meta, phase, hookStep(step, step.PostTasks), engine, templates, configs, renderer, color, "", nil)
+				if err != nil {
+					return nil, failStep(phaseState, stepState, err)
+				}
+			}
 
-						log.Printf("Error creating Kubernetes objects from step %v in phase %v of plan %v and instance %s/%s: %v", step.Name, phase.Name, plan.Name, meta.instanceNamespace, meta.instanceName, err)
-						return nil, &executionError{err, false, nil}
+			if previousColor != "" {
+				// the live color of a blue-green phase is deleted once the target color is live
+				configs["Color"] = previousColor
+				previous, _, err := renderStep(plan, meta, phase, step, engine, templates, configs, renderer, previousColor, nil)
+				configs["Color"] = color
+				if err != nil {
+					return nil, failStep(phaseState, stepState, err)
+				}
+				perStepPreviousResources[step.Name] = previous
+			}
+			observeDuration(renderDuration, meta, plan.Name, phase.Name, step.Name, renderStart)
+		}
+	}
+
+	return result, nil
+}
+
+// renderStepResources renders templates of all the tasks of the step and applies KUDO conventions to them
+// templates are rendered in the templating language of the operator, the go template engine renders the rest of the spec
+// color is set only for steps of blue-green phases and item only for steps iterating over a list parameter
+// besides all the resources, it returns the subset of them rendered from templates that do not depend on any of changedParams
+func renderStepResources(plan *activePlan, meta *executionMetadata, phase v1alpha1.Phase, step v1alpha1.Step, engine *kudoengine.Engine, templates kudoengine.Renderer, configs map[string]interface{}, renderer kubernetesObjectEnhancer, color, item string, changedParams map[string]bool) ([]runtime.Object, []runtime.Object, error) {
+	var resources, unchanged []runtime.Object
+	for _, t := range step.Tasks {
+		if taskSpec, ok := plan.Tasks[t]; ok {
+			resourcesAsString := make(map[string]string)
+			unchangedAsString := make(map[string]string)
+			hashed := false
+
+			if taskSpec.Command != nil {
+				jobName := fmt.Sprintf("%s-%s-%s", plan.Name, step.Name, t)
+				job, err := renderCommandJob(jobName, t, taskSpec.Command, engine, configs)
+				if err != nil {
+					log.Print(err)
+					return nil, nil, &executionError{err: err, fatal: true}
+				}
+				resourcesAsString[fmt.Sprintf("%s-command.yaml", t)] = job
+			}
+
+			if taskSpec.Helm != nil {
+				manifests, err := renderHelmChart(t, taskSpec.Helm, plan.Templates, engine, configs)
+				if err != nil {
+					log.Print(err)
+					return nil, nil, &executionError{err: err, fatal: true}
+				}
+				for name, manifest := range manifests {
+					if isEmptyManifest(manifest) {
+						continue
 					}
-					resources = append(resources, resourcesWithConventions...)
-				} else {
-					phaseState.Status = v1alpha1.ErrorStatus
-					stepState.Status = v1alpha1.ErrorStatus
+					resourcesAsString[fmt.Sprintf("%s-%s", t, strings.Replace(name, "/", "-", -1))] = manifest
+					hashed = hashed || hasHashSuffix(manifest)
+				}
+			}
 
-					err := fmt.Errorf("Error finding task named %s for operator version %s", taskSpec, meta.operatorVersionName)
+			for _, res := range taskSpec.Resources {
+				if resource, ok := plan.Templates[res]; ok {
+					templatedYaml, err := templates.Render(resource, configs)
+					if err != nil {
+						err := errwrap.Wrap(err, "error expanding template")
+						log.Print(err)
+						return nil, nil, &executionError{err: err, fatal: true}
+					}
+					if isEmptyManifest(templatedYaml) {
+						log.Printf("PlanExecution: Skipping resource %s of task %s as it rendered to an empty manifest", res, t)
+						continue
+					}
+					hashed = hashed || hasHashSuffix(templatedYaml)
+					// containers from a parameter are merged into the objects of all the templates of the task
+					if step.Delete || step.Scale != "" || changedParams[taskSpec.Containers] || isTemplateAffected(resource, changedParams) {
+						resourcesAsString[res] = templatedYaml
+					} else {
+						unchangedAsString[res] = templatedYaml
+					}
+				} else {
+					err := fmt.Errorf("PlanExecution: Error finding resource named %v for operator version %v", res, meta.operatorVersionName)
 					log.Print(err)
-					return nil, &executionError{err, false, nil}
+					return nil, nil, &executionError{err: err, fatal: true}
 				}
 			}
 
-			perStepResources[step.Name] = resources
+			if hashed {
+				// references to objects with hashed names are rewritten only within one kustomization
+				for k, v := range unchangedAsString {
+					resourcesAsString[k] = v
+				}
+				unchangedAsString = map[string]string{}
+			}
+
+			owner, err := taskOwner(t, taskSpec.Owner, meta, engine, configs)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			objs, err := toObjectsWithConventions(plan, meta, phase, step, t, renderer, color, item, owner, resourcesAsString)
+			if err != nil {
+				return nil, nil, err
+			}
+			resources = append(resources, objs...)
+
+			if len(unchangedAsString) > 0 {
+				objs, err := toObjectsWithConventions(plan, meta, phase, step, t, renderer, color, item, owner, unchangedAsString)
+				if err != nil {
+					return nil, nil, err
+				}
+				resources = append(resources, objs...)
+				unchanged = append(unchanged, objs...)
+			}
+		} else {
+			err := fmt.Errorf("Error finding task named %s for operator version %s", t, meta.operatorVersionName)
+			log.Print(err)
+			return nil, nil, &executionError{err: err, fatal: false}
 		}
 	}
 
-	return result, nil
+	return resources, unchanged, nil
+}
+
+// toObjectsWithConventions turns rendered templates of a task of a step into objects with KUDO conventions applied and
+// mutators run, the objects are owned by the given owner
+func toObjectsWithConventions(plan *activePlan, meta *executionMetadata, phase v1alpha1.Phase, step v1alpha1.Step, task string, renderer kubernetesObjectEnhancer, color, item string, owner metav1.Object, templates map[string]string) ([]runtime.Object, error) {
+	containers, err := parseContainers(plan.params[plan.Tasks[task].Containers])
+	if err != nil {
+		err := fmt.Errorf("parameter %s with containers of task %s is not a valid YAML list of containers: %v", plan.Tasks[task].Containers, task, err)
+		log.Print(err)
+		return nil, &executionError{err: err, fatal: true, eventName: kudo.String("InvalidParameter")}
+	}
+	schedulingPolicy, err := parseSchedulingPolicy(meta.clusterVariables)
+	if err != nil {
+		// the plan is executed again once the cluster config is fixed
+		err := fmt.Errorf("cluster variable %s is not a valid scheduling policy: %v", SchedulingPolicyVariable, err)
+		log.Print(err)
+		return nil, &executionError{err: err, fatal: false, eventName: kudo.String("InvalidSchedulingPolicy")}
+	}
+	resourcesWithConventions, err := renderer.applyConventionsToTemplates(templates, metadata{
+		InstanceName:     meta.instanceName,
+		Namespace:        meta.instanceNamespace,
+		OperatorName:     meta.operatorName,
+		OperatorVersion:  meta.operatorVersion,
+		PlanName:         plan.Name,
+		PhaseName:        phase.Name,
+		StepName:         step.Name,
+		TaskName:         task,
+		Color:            color,
+		Item:             item,
+		Containers:       containers,
+		SchedulingPolicy: schedulingPolicy,
+		LabelKeys:        meta.labelKeys,
+	}, owner)
+
+	if err != nil {
+		log.Printf("Error creating Kubernetes objects from step %v in phase %v of plan %v and instance %s/%s: %v", step.Name, phase.Name, plan.Name, meta.instanceNamespace, meta.instanceName, err)
+		return nil, &executionError{err: err, fatal: false}
+	}
+	err = applyMutators(meta.mutators, resourcesWithConventions)
+	if err != nil {
+		err := errwrap.Wrapf(err, "error mutating objects of step %s in phase %s of plan %s", step.Name, phase.Name, plan.Name)
+		log.Print(err)
+		return nil, &executionError{err: err, fatal: false}
+	}
+	if err := validateCronJobs(resourcesWithConventions); err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	return resourcesWithConventions, nil
+}
+
+// failStep marks the given phase and step as failed with the status and message derived from the error
+// the returned error is an execution error pointing to the failed step
+func failStep(phaseState *v1alpha1.PhaseStatus, stepState *v1alpha1.StepStatus, err error) error {
+	phaseState.Status = statusForError(err)
+	stepState.Status = statusForError(err)
+	stepState.Message = stepErrorMessage(err)
+	return atStep(phaseState.Name, stepState.Name, err)
+}
+
+// atStep returns the error as an execution error pointing to the given step, errors that are not execution errors are
+// considered recoverable
+func atStep(phase string, step string, err error) error {
+	exErr := asExecutionError(err)
+	if exErr == nil {
+		exErr = &executionError{err: err}
+		err = exErr
+	}
+	if exErr.step == "" {
+		exErr.phase, exErr.step = phase, step
+	}
+	return err
+}
+
+// statusForError maps an error to the execution status, only fatal execution errors end up in the ExecutionFatalError state,
+// everything else is retried
+func statusForError(err error) v1alpha1.ExecutionStatus {
+	if exErr := asExecutionError(err); exErr != nil && exErr.Fatal() {
+		return v1alpha1.ExecutionFatalError
+	}
+	return v1alpha1.ErrorStatus
+}
+
+// stepErrorMessage returns message stored in the step status that makes it clear whether the error is going to be retried
+func stepErrorMessage(err error) string {
+	if exErr := asExecutionError(err); exErr != nil {
+		if exErr.Fatal() {
+			return fmt.Sprintf("fatal error, manual intervention required: %v", exErr.Unwrap())
+		}
+		return fmt.Sprintf("recoverable error, will be retried: %v", exErr.Unwrap())
+	}
+	return fmt.Sprintf("recoverable error, will be retried: %v", err)
 }
 
 func getStepFromStatus(stepName string, status *v1alpha1.PhaseStatus) (*v1alpha1.StepStatus, error) {
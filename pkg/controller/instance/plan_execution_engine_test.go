@@ -1,20 +1,28 @@
 package instance
 
 import (
+	"context"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
 	"github.com/kudobuilder/kudo/pkg/util/template"
 	"github.com/pkg/errors"
 
 	"github.com/ghodss/yaml"
 	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
@@ -59,7 +67,7 @@ func TestExecutePlan(t *testing.T) {
 		}, defaultMetadata, &v1alpha1.PlanStatus{
 			Status: v1alpha1.ExecutionInProgress,
 			Name:   "test",
-			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionInProgress, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionInProgress, Name: "step"}}}},
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionInProgress, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionInProgress, Name: "step", Message: "waiting for default/job1: job \"job1\" still running or failed"}}, CompletedSteps: 0, TotalSteps: 1}},
 		}},
 		// this plan deploys pod, that is marked as healthy immediately because we cannot evaluate health
 		{"plan with one step, immediately healthy -> completed", &activePlan{
@@ -80,7 +88,7 @@ func TestExecutePlan(t *testing.T) {
 		}, defaultMetadata, &v1alpha1.PlanStatus{
 			Status: v1alpha1.ExecutionComplete,
 			Name:   "test",
-			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionComplete, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionComplete, Name: "step"}}}},
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionComplete, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionComplete, Name: "step"}}, CompletedSteps: 1, TotalSteps: 1}},
 		}},
 		{"plan in errored state will be retried and completed when no error happens", &activePlan{
 			Name: "test",
@@ -100,7 +108,7 @@ func TestExecutePlan(t *testing.T) {
 		}, defaultMetadata, &v1alpha1.PlanStatus{
 			Status: v1alpha1.ExecutionComplete,
 			Name:   "test",
-			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionComplete, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionComplete, Name: "step"}}}},
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionComplete, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionComplete, Name: "step"}}, CompletedSteps: 1, TotalSteps: 1}},
 		}},
 	}
 
@@ -112,12 +120,632 @@ func TestExecutePlan(t *testing.T) {
 			t.Errorf("%s: Expecting no error but got error %v", tt.name, err)
 		}
 
-		if !reflect.DeepEqual(tt.expectedStatus, newStatus) {
-			t.Errorf("%s: Expecting status to be %v but got %v", tt.name, *tt.expectedStatus, *newStatus)
+		// start times of the steps depend on when the test runs
+		for i := range newStatus.Phases {
+			for j := range newStatus.Phases[i].Steps {
+				newStatus.Phases[i].Steps[j].StartedAt = metav1.Time{}
+			}
 		}
+		if !reflect.DeepEqual(tt.expectedStatus, newStatus.PlanStatus) {
+			t.Errorf("%s: Expecting status to be %v but got %v", tt.name, *tt.expectedStatus, *newStatus.PlanStatus)
+		}
+	}
+}
+
+func TestExecutePlanParallelFailFast(t *testing.T) {
+	metadata := &executionMetadata{
+		instanceName:        "Instance",
+		instanceNamespace:   "default",
+		operatorVersion:     "ov-1.0",
+		operatorName:        "operator",
+		resourcesOwner:      getJob("pod2", "default"),
+		operatorVersionName: "ovname",
+	}
+
+	names := []string{"one", "two", "three", "four"}
+	for _, failFast := range []bool{false, true} {
+		plan := podStepsPlan(v1alpha1.Parallel, names...)
+		plan.Spec.Phases[0].MaxConcurrency = 1
+		plan.Spec.Phases[0].FailFast = failFast
+		// steps take turns, so the order in which they are attempted is the order of creates
+		testClient := &orderRecordingClient{Client: &failingCreateClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme), failing: "two"}}
+
+		result, err := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+		if err == nil {
+			t.Errorf("failFast=%v: Expecting error of the failed step but got none", failFast)
+		}
+
+		if !failFast {
+			if len(testClient.created) != len(names) {
+				t.Errorf("failFast=%v: Expecting all steps to be attempted but got %v", failFast, testClient.created)
+			}
+			continue
+		}
+		if last := testClient.created[len(testClient.created)-1]; last != "two" {
+			t.Errorf("failFast=%v: Expecting no step to start after the failed one but got %v", failFast, testClient.created)
+		}
+		attempted := map[string]bool{}
+		for _, name := range testClient.created {
+			attempted[name] = true
+		}
+		for _, st := range result.Phases[0].Steps {
+			switch {
+			case st.Name == "two":
+				if st.Status != v1alpha1.ErrorStatus {
+					t.Errorf("failFast=%v: Expecting failed step to have error status but got %v", failFast, st.Status)
+				}
+			case attempted[st.Name]:
+				if st.Status != v1alpha1.ExecutionComplete {
+					t.Errorf("failFast=%v: Expecting step %s that started before the failure to complete but got %v", failFast, st.Name, st.Status)
+				}
+			default:
+				if st.Status != v1alpha1.ExecutionPending || st.Message != "aborted because step two failed" || !st.StartedAt.IsZero() {
+					t.Errorf("failFast=%v: Expecting step %s to be aborted but got %v: %s", failFast, st.Name, st.Status, st.Message)
+				}
+			}
+		}
+		// objects of the steps that started are kept
+		for name := range attempted {
+			if name == "two" {
+				continue
+			}
+			if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: name}, &corev1.Pod{}); err != nil {
+				t.Errorf("failFast=%v: Expecting object of step %s to be kept but got %v", failFast, name, err)
+			}
+		}
+	}
+}
+
+func TestExecuteStepStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	err := executeStep(ctx, v1alpha1.Step{Name: "step"}, state, []runtime.Object{getPod("pod", "default")}, nil, clock.RealClock{}, testClient)
+	if err != context.Canceled {
+		t.Errorf("Expecting the step to be cancelled but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting the cancelled step to stay in progress but got %v", state.Status)
+	}
+	if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "pod"}, &corev1.Pod{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expecting no object to be applied after the step was cancelled but got %v", err)
 	}
 }
 
+func TestExecutePlanRespectsMaxConcurrency(t *testing.T) {
+	metadata := &executionMetadata{
+		instanceName:        "Instance",
+		instanceNamespace:   "default",
+		operatorVersion:     "ov-1.0",
+		operatorName:        "operator",
+		resourcesOwner:      getJob("pod2", "default"),
+		operatorVersionName: "ovname",
+	}
+
+	for _, maxConcurrency := range []int{1, 2, 4} {
+		plan := podStepsPlan(v1alpha1.Parallel, "one", "two", "three", "four", "five", "six")
+		plan.Spec.Phases[0].MaxConcurrency = maxConcurrency
+
+		testClient := &concurrencyCountingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+		newStatus, err := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+
+		if err != nil {
+			t.Errorf("max concurrency %d: Expecting no error but got error %v", maxConcurrency, err)
+		}
+		if newStatus.Status != v1alpha1.ExecutionComplete {
+			t.Errorf("max concurrency %d: Expecting plan to be completed but got %v", maxConcurrency, newStatus.Status)
+		}
+		if testClient.maxInFlight > int32(maxConcurrency) {
+			t.Errorf("max concurrency %d: Expecting at most %d concurrent apply operations but got %d", maxConcurrency, maxConcurrency, testClient.maxInFlight)
+		}
+	}
+}
+
+func TestExecutePlanRespectsStepOrder(t *testing.T) {
+	metadata := &executionMetadata{
+		instanceName:        "Instance",
+		instanceNamespace:   "default",
+		operatorVersion:     "ov-1.0",
+		operatorName:        "operator",
+		resourcesOwner:      getJob("pod2", "default"),
+		operatorVersionName: "ovname",
+	}
+
+	tests := []struct {
+		name     string
+		orders   map[string]int
+		expected []string
+	}{
+		{"no order keeps the list order", map[string]int{}, []string{"one", "two", "three", "four"}},
+		{"lower order first", map[string]int{"one": 3, "two": 2, "three": 1, "four": 0}, []string{"four", "three", "two", "one"}},
+		{"ties broken by list order", map[string]int{"one": 1, "two": 0, "three": 1, "four": 0}, []string{"two", "four", "one", "three"}},
+		{"negative order runs before unordered steps", map[string]int{"four": -1}, []string{"four", "one", "two", "three"}},
+	}
+
+	for _, tt := range tests {
+		plan := podStepsPlan(v1alpha1.Serial, "one", "two", "three", "four")
+		for i, step := range plan.Spec.Phases[0].Steps {
+			plan.Spec.Phases[0].Steps[i].Order = tt.orders[step.Name]
+		}
+
+		testClient := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+		newStatus, err := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got error %v", tt.name, err)
+		}
+		if newStatus.Status != v1alpha1.ExecutionComplete {
+			t.Errorf("%s: Expecting plan to be completed but got %v", tt.name, newStatus.Status)
+		}
+		if !reflect.DeepEqual(testClient.created, tt.expected) {
+			t.Errorf("%s: Expecting steps to be executed in order %v but got %v", tt.name, tt.expected, testClient.created)
+		}
+	}
+}
+
+func TestExecutePlanPausesAtBreakpoint(t *testing.T) {
+	metadata := &executionMetadata{
+		instanceName:        "Instance",
+		instanceNamespace:   "default",
+		operatorVersion:     "ov-1.0",
+		operatorName:        "operator",
+		resourcesOwner:      getJob("pod2", "default"),
+		operatorVersionName: "ovname",
+		breakpoints:         map[string]bool{"two": true},
+	}
+
+	plan := podStepsPlan(v1alpha1.Serial, "one", "two", "three")
+	testClient := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+
+	// execution stops right before the step with the breakpoint, repeatedly
+	for i := 0; i < 2; i++ {
+		newStatus, err := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Fatalf("Expecting no error but got error %v", err)
+		}
+		if newStatus.Status != v1alpha1.ExecutionInProgress {
+			t.Errorf("Expecting plan to be in progress but got %v", newStatus.Status)
+		}
+		steps := newStatus.Phases[0].Steps
+		if steps[0].Status != v1alpha1.ExecutionComplete || steps[1].Status != v1alpha1.ExecutionPaused || steps[2].Status != v1alpha1.ExecutionPending {
+			t.Errorf("Expecting steps to be complete, paused and pending but got %v, %v and %v", steps[0].Status, steps[1].Status, steps[2].Status)
+		}
+		if !reflect.DeepEqual(testClient.created, []string{"one"}) {
+			t.Errorf("Expecting only step before the breakpoint to be executed but got %v", testClient.created)
+		}
+	}
+
+	// removing the breakpoint resumes the execution
+	metadata.breakpoints = map[string]bool{}
+	newStatus, err := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got error %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting plan to be completed but got %v", newStatus.Status)
+	}
+	if !reflect.DeepEqual(testClient.created, []string{"one", "two", "three"}) {
+		t.Errorf("Expecting all steps to be executed after the breakpoint was removed but got %v", testClient.created)
+	}
+}
+
+func TestExecutePlanRequeueHint(t *testing.T) {
+	metadata := &executionMetadata{instanceName: "Instance", instanceNamespace: "default", resourcesOwner: getJob("pod2", "default")}
+	plan := podStepsPlan(v1alpha1.Serial, "one", "two", "three")
+	testClient := &failingCreateClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+
+	tests := []struct {
+		name             string
+		failing          string
+		expectedAttempts int32
+		expectedRequeue  time.Duration
+	}{
+		{"failure after progress", "two", 1, minRequeueAfter},
+		{"failure without progress", "two", 2, 2 * minRequeueAfter},
+		{"another failure without progress", "two", 3, 4 * minRequeueAfter},
+		{"progress resets the backoff", "three", 1, minRequeueAfter},
+		{"success", "", 0, 0},
+	}
+
+	for _, tt := range tests {
+		testClient.failing = tt.failing
+		result, err := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+		if tt.failing != "" && err == nil {
+			t.Errorf("%s: Expecting error but got none", tt.name)
+		}
+		if tt.failing == "" && err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if result.FailedAttempts != tt.expectedAttempts {
+			t.Errorf("%s: Expecting %d failed attempts but got %d", tt.name, tt.expectedAttempts, result.FailedAttempts)
+		}
+		if result.RequeueAfter != tt.expectedRequeue {
+			t.Errorf("%s: Expecting requeue after %v but got %v", tt.name, tt.expectedRequeue, result.RequeueAfter)
+		}
+		if tt.failing == "" && result.LastError != nil {
+			t.Errorf("%s: Expecting last error to be cleared but got %v", tt.name, result.LastError)
+		}
+		if tt.failing != "" && (result.LastError == nil || result.LastError.Phase != "phase" || result.LastError.Step != tt.failing || !result.LastError.Retryable) {
+			t.Errorf("%s: Expecting retryable last error of step %s but got %v", tt.name, tt.failing, result.LastError)
+		}
+	}
+}
+
+func TestRequeueAfterIsCapped(t *testing.T) {
+	if after := requeueAfter(100); after != maxRequeueAfter {
+		t.Errorf("Expecting requeue after to be capped at %v but got %v", maxRequeueAfter, after)
+	}
+}
+
+func TestExecutePlanReportsPhaseProgress(t *testing.T) {
+	metadata := &executionMetadata{
+		instanceName:        "Instance",
+		instanceNamespace:   "default",
+		operatorVersion:     "ov-1.0",
+		operatorName:        "operator",
+		resourcesOwner:      getJob("pod2", "default"),
+		operatorVersionName: "ovname",
+	}
+	plan := &activePlan{
+		Name: "test",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Status: v1alpha1.ExecutionPending,
+			Name:   "test",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{
+				{Status: v1alpha1.ExecutionPending, Name: "pod1"},
+				{Status: v1alpha1.ExecutionPending, Name: "deployment"},
+				{Status: v1alpha1.ExecutionPending, Name: "pod2"},
+			}}},
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases: []v1alpha1.Phase{
+				{Name: "phase", Strategy: "parallel", Steps: []v1alpha1.Step{
+					{Name: "pod1", Tasks: []string{"pod1"}},
+					{Name: "deployment", Tasks: []string{"deployment"}},
+					{Name: "pod2", Tasks: []string{"pod2"}},
+				}},
+			},
+		},
+		Tasks: map[string]v1alpha1.TaskSpec{
+			"pod1":       {Resources: []string{"pod1"}},
+			"deployment": {Resources: []string{"deployment"}},
+			"pod2":       {Resources: []string{"pod2"}},
+		},
+		Templates: map[string]string{
+			"pod1":       getResourceAsString(getPod("pod1", "default")),
+			"deployment": getResourceAsString(getDeployment("deployment", "default", 3)),
+			"pod2":       getResourceAsString(getPod("pod2", "default")),
+		},
+	}
+
+	newStatus, err := executePlan(plan, metadata, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	phase := newStatus.Phases[0]
+	if phase.Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting phase to be in progress but got %v", phase.Status)
+	}
+	completed := 0
+	for _, st := range phase.Steps {
+		if st.Status == v1alpha1.ExecutionComplete {
+			completed++
+		}
+	}
+	if phase.CompletedSteps != 2 || phase.TotalSteps != 3 || phase.CompletedSteps != completed {
+		t.Errorf("Expecting 2/3 steps completed consistent with step statuses but got %d/%d", phase.CompletedSteps, phase.TotalSteps)
+	}
+}
+
+func TestExecuteStepWithPatchCondition(t *testing.T) {
+	condition := "{{ lt .Existing.spec.replicas .Desired.spec.replicas }}"
+	tests := []struct {
+		name             string
+		existingReplicas int32
+		expectedReplicas int32
+		expectedStatus   v1alpha1.ExecutionStatus
+	}{
+		{"condition not satisfied, patch is skipped and object considered healthy", 5, 5, v1alpha1.ExecutionComplete},
+		{"condition satisfied, object is patched", 1, 3, v1alpha1.ExecutionInProgress},
+	}
+
+	for _, tt := range tests {
+		existing := getDeployment("deployment", "default", tt.existingReplicas)
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+		step := v1alpha1.Step{Name: "step", PatchCondition: condition}
+
+		err := executeStep(context.TODO(), step, state, []runtime.Object{getDeployment("deployment", "default", 3)}, nil, clock.RealClock{}, testClient)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting step status %v but got %v", tt.name, tt.expectedStatus, state.Status)
+		}
+
+		actual := &appsv1.Deployment{}
+		_ = testClient.Get(context.TODO(), client.ObjectKey{Name: "deployment", Namespace: "default"}, actual)
+		if *actual.Spec.Replicas != tt.expectedReplicas {
+			t.Errorf("%s: Expecting %d replicas but got %d", tt.name, tt.expectedReplicas, *actual.Spec.Replicas)
+		}
+	}
+}
+
+func TestExecuteStepWithIgnoredHealth(t *testing.T) {
+	ignored := func(obj runtime.Object) runtime.Object {
+		obj.(metav1.Object).SetAnnotations(map[string]string{kudo.HealthAnnotation: kudo.HealthIgnoreValue})
+		return obj
+	}
+	tests := []struct {
+		name           string
+		resources      []runtime.Object
+		expectedStatus v1alpha1.ExecutionStatus
+	}{
+		{"ignored unhealthy deployment with healthy pod", []runtime.Object{ignored(getDeployment("ignored", "default", 3)), getPod("pod", "default")}, v1alpha1.ExecutionComplete},
+		{"ignored unhealthy deployment with unhealthy deployment", []runtime.Object{ignored(getDeployment("ignored", "default", 3)), getDeployment("gated", "default", 3)}, v1alpha1.ExecutionInProgress},
+		{"unhealthy deployment without annotation", []runtime.Object{getDeployment("gated", "default", 3)}, v1alpha1.ExecutionInProgress},
+	}
+
+	for _, tt := range tests {
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, tt.resources, nil, clock.RealClock{}, testClient)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting step status %v but got %v", tt.name, tt.expectedStatus, state.Status)
+		}
+	}
+}
+
+func TestExecutePlanEmbedsBundledFiles(t *testing.T) {
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: default\ndata:\n  app.conf: |\n{{ files.Get \"configs/app.conf\" | indent 4 }}\n"
+	tests := []struct {
+		name           string
+		files          map[string]string
+		expectedStatus v1alpha1.ExecutionStatus
+	}{
+		{"bundled file", map[string]string{"configs/app.conf": "port=8080\nlog=debug"}, v1alpha1.ExecutionComplete},
+		{"file not bundled", map[string]string{}, v1alpha1.ExecutionFatalError},
+	}
+
+	for _, tt := range tests {
+		templates := map[string]string{"config.yaml": configMap}
+		for name, content := range tt.files {
+			templates[name] = content
+		}
+		plan := &activePlan{
+			Name: "deploy",
+			PlanStatus: &v1alpha1.PlanStatus{
+				Status: v1alpha1.ExecutionPending,
+				Name:   "deploy",
+				Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionPending, Name: "step"}}}},
+			},
+			Spec: &v1alpha1.Plan{
+				Strategy: "serial",
+				Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+			},
+			Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"config.yaml"}}},
+			Templates: templates,
+		}
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+		metadata := &executionMetadata{instanceName: "Instance", instanceNamespace: "default", resourcesOwner: getJob("pod2", "default")}
+
+		result, _ := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+		if result.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting plan status %v but got %v", tt.name, tt.expectedStatus, result.Status)
+		}
+		if tt.expectedStatus != v1alpha1.ExecutionComplete {
+			continue
+		}
+		cm := &corev1.ConfigMap{}
+		if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "config"}, cm); err != nil {
+			t.Fatalf("%s: Expecting config map to be created but got %v", tt.name, err)
+		}
+		if cm.Data["app.conf"] != "port=8080\nlog=debug\n" {
+			t.Errorf("%s: Expecting bundled file to be embedded in the config map but got %q", tt.name, cm.Data["app.conf"])
+		}
+	}
+}
+
+func TestExecuteStepRespectsApplyOrder(t *testing.T) {
+	ordered := func(name string, order string) runtime.Object {
+		cm := getConfigMap(name, "default", nil)
+		if order != "" {
+			cm.SetAnnotations(map[string]string{kudo.ApplyOrderAnnotation: order})
+		}
+		return cm
+	}
+	tests := []struct {
+		name          string
+		resources     []runtime.Object
+		expectedOrder []string
+		expectedErr   bool
+	}{
+		{"no hints keep the template order", []runtime.Object{ordered("a", ""), ordered("b", ""), ordered("c", "")}, []string{"a", "b", "c"}, false},
+		{"hints reorder the objects", []runtime.Object{ordered("a", "2"), ordered("b", "1"), ordered("c", "")}, []string{"c", "b", "a"}, false},
+		{"negative hint goes first", []runtime.Object{ordered("a", ""), ordered("b", "-1")}, []string{"b", "a"}, false},
+		{"same hint keeps the template order", []runtime.Object{ordered("a", "1"), ordered("b", "1"), ordered("c", "0")}, []string{"c", "a", "b"}, false},
+		{"invalid hint", []runtime.Object{ordered("a", "first")}, nil, true},
+	}
+
+	for _, tt := range tests {
+		testClient := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, tt.resources, nil, clock.RealClock{}, testClient)
+		if tt.expectedErr != (err != nil) {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.expectedErr, err)
+		}
+		if !reflect.DeepEqual(testClient.created, tt.expectedOrder) {
+			t.Errorf("%s: Expecting objects to be applied in order %v but got %v", tt.name, tt.expectedOrder, testClient.created)
+		}
+	}
+
+	// the step keeps its objects in the template order
+	resources := []runtime.Object{ordered("a", "1"), ordered("b", "")}
+	_ = executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}, resources, nil, clock.RealClock{}, fake.NewFakeClientWithScheme(scheme.Scheme))
+	if resources[0].(metav1.Object).GetName() != "a" {
+		t.Error("Expecting objects of the step not to be reordered in place")
+	}
+}
+
+func TestExecuteStepWaitingForDaemonSet(t *testing.T) {
+	daemonSet := &appsv1.DaemonSet{
+		TypeMeta:   metav1.TypeMeta{Kind: "DaemonSet", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+	}
+	// the fake client does not ignore status in patches, so the rendered object carries the status as well
+	daemonSet.Status = appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 1}
+	existing := daemonSet.DeepCopy()
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{daemonSet}, nil, clock.RealClock{}, testClient)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting step status %v but got %v", v1alpha1.ExecutionInProgress, state.Status)
+	}
+	expected := "waiting for default/agent: daemonset agent is ready on 1 of 3 nodes"
+	if state.Message != expected {
+		t.Errorf("Expecting step message %q but got %q", expected, state.Message)
+	}
+}
+
+func TestExecuteStepWithDeploymentScaledToZero(t *testing.T) {
+	// the deployment is disabled by a parameter while its pods are still terminating
+	existing := getDeployment("feature", "default", 3)
+	existing.Status.ReadyReplicas = 3
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, []runtime.Object{getDeployment("feature", "default", 0)}, nil, clock.RealClock{}, testClient)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step status %v but got %v: %s", v1alpha1.ExecutionComplete, state.Status, state.Message)
+	}
+}
+
+func TestExecuteStepWithMinReadyReplicas(t *testing.T) {
+	withReady := func(d *appsv1.Deployment, ready int32) *appsv1.Deployment {
+		d.Status.ReadyReplicas = ready
+		return d
+	}
+	tests := []struct {
+		name           string
+		existing       []runtime.Object
+		resources      []runtime.Object
+		minReady       int32
+		expectedStatus v1alpha1.ExecutionStatus
+	}{
+		{"quorum reached across deployments", []runtime.Object{withReady(getDeployment("a", "default", 3), 2), withReady(getDeployment("b", "default", 3), 1)},
+			[]runtime.Object{getDeployment("a", "default", 3), getDeployment("b", "default", 3)}, 3, v1alpha1.ExecutionComplete},
+		{"one deployment fully up, the other down, quorum reached", []runtime.Object{withReady(getDeployment("a", "default", 3), 3), withReady(getDeployment("b", "default", 3), 0)},
+			[]runtime.Object{getDeployment("a", "default", 3), getDeployment("b", "default", 3)}, 3, v1alpha1.ExecutionComplete},
+		{"one deployment fully up, the other down, quorum not reached", []runtime.Object{withReady(getDeployment("a", "default", 3), 3), withReady(getDeployment("b", "default", 3), 0)},
+			[]runtime.Object{getDeployment("a", "default", 3), getDeployment("b", "default", 3)}, 4, v1alpha1.ExecutionInProgress},
+		{"non workload objects are still checked on their own", []runtime.Object{withReady(getDeployment("a", "default", 3), 3), getJob("job", "default")},
+			[]runtime.Object{getDeployment("a", "default", 3), getJob("job", "default")}, 3, v1alpha1.ExecutionInProgress},
+	}
+
+	for _, tt := range tests {
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme, tt.existing...)
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		err := executeStep(context.TODO(), v1alpha1.Step{Name: "step", MinReadyReplicas: tt.minReady}, state, tt.resources, nil, clock.RealClock{}, testClient)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting step status %v but got %v", tt.name, tt.expectedStatus, state.Status)
+		}
+	}
+}
+
+func TestEvaluatePatchCondition(t *testing.T) {
+	tests := []struct {
+		name        string
+		condition   string
+		expected    bool
+		expectError bool
+	}{
+		{"empty condition always patches", "", true, false},
+		{"true condition", "{{ eq .Existing.metadata.name .Desired.metadata.name }}", true, false},
+		{"false condition", "{{ ne .Existing.metadata.name .Desired.metadata.name }}", false, false},
+		{"not a boolean", "{{ .Existing.metadata.name }}", false, true},
+		{"invalid template", "{{ .Existing.metadata.name ", false, true},
+	}
+
+	for _, tt := range tests {
+		result, err := evaluatePatchCondition(tt.condition, getPod("pod", "default"), getPod("pod", "default"))
+		if tt.expectError != (err != nil) {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.expectError, err)
+		}
+		if result != tt.expected {
+			t.Errorf("%s: Expecting %v but got %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+// orderRecordingClient records names of the created objects in the order they were created in
+type orderRecordingClient struct {
+	client.Client
+	created []string
+}
+
+func (c *orderRecordingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	c.created = append(c.created, obj.(metav1.Object).GetName())
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// failingCreateClient fails creation of the object with the given name
+type failingCreateClient struct {
+	client.Client
+	failing string
+}
+
+func (c *failingCreateClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if obj.(metav1.Object).GetName() == c.failing {
+		return errors.Errorf("creating %s failed", c.failing)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// concurrencyCountingClient records the maximum number of create operations that were in flight at the same time
+type concurrencyCountingClient struct {
+	client.Client
+	inFlight    int32
+	maxInFlight int32
+	mu          sync.Mutex
+}
+
+func (c *concurrencyCountingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+	err := c.Client.Create(ctx, obj, opts...)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return err
+}
+
 func getJob(name string, namespace string) *batchv1.Job {
 	job := &batchv1.Job{
 		TypeMeta: metav1.TypeMeta{
@@ -133,6 +761,23 @@ func getJob(name string, namespace string) *batchv1.Job {
 	return job
 }
 
+func getDeployment(name string, namespace string, replicas int32) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+		},
+	}
+	return deployment
+}
+
 func getPod(name string, namespace string) *corev1.Pod {
 	pod := &corev1.Pod{
 		TypeMeta: metav1.TypeMeta{
@@ -148,6 +793,35 @@ func getPod(name string, namespace string) *corev1.Pod {
 	return pod
 }
 
+// podStepsPlan returns a pending plan "test" with a single phase "phase" of the given strategy, running a step for each
+// of the names that creates a pod of the same name
+func podStepsPlan(strategy v1alpha1.Ordering, names ...string) *activePlan {
+	steps := []v1alpha1.Step{}
+	stepStatuses := []v1alpha1.StepStatus{}
+	templates := map[string]string{}
+	tasks := map[string]v1alpha1.TaskSpec{}
+	for _, name := range names {
+		steps = append(steps, v1alpha1.Step{Name: name, Tasks: []string{name}})
+		stepStatuses = append(stepStatuses, v1alpha1.StepStatus{Name: name, Status: v1alpha1.ExecutionPending})
+		tasks[name] = v1alpha1.TaskSpec{Resources: []string{name}}
+		templates[name] = getResourceAsString(getPod(name, "default"))
+	}
+	return &activePlan{
+		Name: "test",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Status: v1alpha1.ExecutionPending,
+			Name:   "test",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: stepStatuses}},
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: v1alpha1.Serial,
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: strategy, Steps: steps}},
+		},
+		Tasks:     tasks,
+		Templates: templates,
+	}
+}
+
 func getResourceAsString(resource v1.Object) string {
 	bytes, _ := yaml.Marshal(resource)
 	return string(bytes)
@@ -166,3 +840,106 @@ func (k *testKubernetesObjectEnhancer) applyConventionsToTemplates(templates map
 	}
 	return result, nil
 }
+
+func TestRetryFailedSteps(t *testing.T) {
+	metadata := &executionMetadata{instanceName: "Instance", instanceNamespace: "default", resourcesOwner: getJob("pod2", "default")}
+	instance := &v1alpha1.Instance{
+		Status: v1alpha1.InstanceStatus{
+			PlanStatus: map[string]v1alpha1.PlanStatus{
+				"deploy": {Name: "deploy", Status: v1alpha1.ExecutionComplete},
+				"test": {
+					Name:      "test",
+					Status:    v1alpha1.ExecutionFatalError,
+					LastError: &v1alpha1.ExecutionError{Code: "CommandFailed", Phase: "phase", Step: "two"},
+					Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionFatalError, Steps: []v1alpha1.StepStatus{
+						{Name: "one", Status: v1alpha1.ExecutionComplete},
+						{Name: "two", Status: v1alpha1.ExecutionFatalError, Message: "command failed"},
+						{Name: "three", Status: v1alpha1.ExecutionPending},
+					}}},
+				},
+			},
+		},
+	}
+
+	if err := instance.RetryFailedSteps("deploy"); err == nil {
+		t.Errorf("Expecting error when retrying a plan that did not fail")
+	}
+	if err := instance.RetryFailedSteps("missing"); err == nil {
+		t.Errorf("Expecting error when retrying a plan that does not exist")
+	}
+	if err := instance.RetryFailedSteps("test"); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	planStatus := instance.Status.PlanStatus["test"]
+	if planStatus.Status != v1alpha1.ExecutionPending || planStatus.LastError != nil || instance.Status.AggregatedStatus.ActivePlanName != "test" {
+		t.Errorf("Expecting plan to be pending without error but got %v with %v", planStatus.Status, planStatus.LastError)
+	}
+	stepStatuses := planStatus.Phases[0].Steps
+	if stepStatuses[0].Status != v1alpha1.ExecutionComplete || stepStatuses[1].Status != v1alpha1.ExecutionPending || stepStatuses[1].Message != "" || stepStatuses[2].Status != v1alpha1.ExecutionPending {
+		t.Errorf("Expecting only the failed step to be pending again but got %v", stepStatuses)
+	}
+
+	// the object of the completed step exists already and is left alone
+	recording := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, getPod("one", "default"))}
+	testClient := &patchRecordingClient{Client: recording}
+	plan := podStepsPlan(v1alpha1.Serial, "one", "two", "three")
+	plan.PlanStatus = &planStatus
+	result, err := executePlan(plan, metadata, testClient, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if result.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting plan to be completed but got %v", result.Status)
+	}
+	if !reflect.DeepEqual(recording.created, []string{"two", "three"}) || len(testClient.patched) != 0 {
+		t.Errorf("Expecting only the failed and the following steps to be executed but got created %v and patched %v", recording.created, testClient.patched)
+	}
+
+	// nothing to retry once another plan runs
+	instance.Status.PlanStatus["deploy"] = v1alpha1.PlanStatus{Name: "deploy", Status: v1alpha1.ErrorStatus}
+	instance.Status.PlanStatus["test"] = v1alpha1.PlanStatus{Name: "test", Status: v1alpha1.ExecutionFatalError}
+	if err := instance.RetryFailedSteps("test"); err == nil {
+		t.Errorf("Expecting error when retrying a plan while another plan is in progress")
+	}
+}
+
+func TestPrepareKubeResourcesExposesInstanceUID(t *testing.T) {
+	template := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cache-{{ trunc 8 .InstanceUID }}
+  namespace: default
+data:
+  generation: "{{ .InstanceGeneration }}"
+`
+	tests := []struct {
+		name               string
+		owner              metav1.Object
+		expectedName       string
+		expectedGeneration string
+	}{
+		{"persisted instance", &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", UID: "4f6d8c1e-7b5a-4a8e-9d2c-0e1f2a3b4c5d", Generation: 3}}, "cache-4f6d8c1e", "3"},
+		{"instance not persisted yet", &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance"}}, "", ""},
+		{"no owner", nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", resourcesOwner: tt.owner}
+		resources, err := prepareKubeResources(generatedValuesPlan("deploy", template, nil), meta, &testKubernetesObjectEnhancer{})
+		if tt.expectedName == "" {
+			if exErr, ok := err.(*executionError); !ok || !exErr.fatal {
+				t.Errorf("%s: Expecting fatal error rendering a template using the UID but got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		cm := resources.PhaseResources["phase"].StepResources["step"][0].(*corev1.ConfigMap)
+		if cm.Name != tt.expectedName || cm.Data["generation"] != tt.expectedGeneration {
+			t.Errorf("%s: Expecting %s with generation %s but got %s with %v", tt.name, tt.expectedName, tt.expectedGeneration, cm.Name, cm.Data)
+		}
+	}
+}
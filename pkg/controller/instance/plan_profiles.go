@@ -0,0 +1,99 @@
+package instance
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+)
+
+// activeProfiles returns the profiles of the plan activated by the parameters
+func activeProfiles(plan *v1alpha1.Plan, params map[string]string) []v1alpha1.PlanProfile {
+	active := []v1alpha1.PlanProfile{}
+	for _, p := range plan.Profiles {
+		if value, ok := params[p.Parameter]; ok && value == p.Value {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+// selectedPhases returns the phases of the plan that run with the given active profiles, see v1alpha1.PlanProfile
+func selectedPhases(plan *v1alpha1.Plan, active []v1alpha1.PlanProfile) map[string]bool {
+	included := make(map[string]bool)
+	for _, p := range active {
+		for _, ph := range p.Include {
+			included[ph] = true
+		}
+	}
+
+	selected := make(map[string]bool)
+	for _, ph := range plan.Phases {
+		selected[ph.Name] = len(included) == 0 || included[ph.Name]
+	}
+	for _, p := range active {
+		for _, ph := range p.Exclude {
+			selected[ph] = false
+		}
+	}
+	return selected
+}
+
+// skipUnselectedPhases marks the phases the active profiles do not select as complete, so that they are never executed
+func skipUnselectedPhases(plan *activePlan, newState *v1alpha1.PlanStatus) {
+	if len(plan.Spec.Profiles) == 0 {
+		return
+	}
+	active := activeProfiles(plan.Spec, plan.params)
+	selected := selectedPhases(plan.Spec, active)
+
+	names := make([]string, 0, len(active))
+	for _, p := range active {
+		names = append(names, p.Name)
+	}
+	reason := "phase is not selected by any active profile"
+	if len(names) > 0 {
+		reason = fmt.Sprintf("phase is not selected by active profiles %s", strings.Join(names, ", "))
+	}
+
+	for _, ph := range plan.Spec.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, newState)
+		if err != nil || selected[ph.Name] || isFinished(phaseState.Status) {
+			continue
+		}
+		log.Printf("PlanExecution: Skipping phase %s of plan %s, %s", ph.Name, plan.Name, reason)
+		phaseState.Status = v1alpha1.ExecutionComplete
+		for i := range phaseState.Steps {
+			phaseState.Steps[i].Status = v1alpha1.ExecutionComplete
+			phaseState.Steps[i].Message = "skipped, " + reason
+		}
+		updatePhaseProgress(phaseState)
+	}
+}
+
+// validateProfiles returns errors of profiles referencing unknown parameters or phases
+func validateProfiles(plan *activePlan) []error {
+	parameters := make(map[string]bool)
+	for _, p := range plan.parameters {
+		parameters[p.Name] = true
+	}
+	phases := make(map[string]bool)
+	for _, ph := range plan.Spec.Phases {
+		phases[ph.Name] = true
+	}
+
+	var errs []error
+	for _, p := range plan.Spec.Profiles {
+		// parameters are not known when only the plan is validated
+		if plan.parameters != nil && !parameters[p.Parameter] {
+			errs = append(errs, fmt.Errorf("profile %s of plan %s references unknown parameter %s", p.Name, plan.Name, p.Parameter))
+		}
+		for _, ph := range append(append([]string{}, p.Include...), p.Exclude...) {
+			if !phases[ph] {
+				errs = append(errs, fmt.Errorf("profile %s of plan %s references unknown phase %s", p.Name, plan.Name, ph))
+			}
+		}
+	}
+	return errs
+}
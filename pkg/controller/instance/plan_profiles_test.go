@@ -0,0 +1,75 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+)
+
+func TestSelectedPhases(t *testing.T) {
+	plan := &v1alpha1.Plan{
+		Phases: []v1alpha1.Phase{{Name: "install"}, {Name: "upgrade"}, {Name: "restore"}, {Name: "verify"}},
+		Profiles: []v1alpha1.PlanProfile{
+			{Name: "fresh", Parameter: "MODE", Value: "install", Include: []string{"install", "verify"}},
+			{Name: "upgrade", Parameter: "MODE", Value: "upgrade", Include: []string{"upgrade", "verify"}},
+			{Name: "restore", Parameter: "RESTORE", Value: "true", Include: []string{"restore"}},
+			{Name: "no-restore", Parameter: "RESTORE", Value: "false", Exclude: []string{"restore"}},
+			{Name: "fast", Parameter: "VERIFY", Value: "false", Exclude: []string{"verify"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		expected []string
+	}{
+		{"no active profile", map[string]string{}, []string{"install", "upgrade", "restore", "verify"}},
+		{"install", map[string]string{"MODE": "install"}, []string{"install", "verify"}},
+		{"upgrade", map[string]string{"MODE": "upgrade"}, []string{"upgrade", "verify"}},
+		{"includes of several profiles are combined", map[string]string{"MODE": "install", "RESTORE": "true"}, []string{"install", "restore", "verify"}},
+		{"exclude without include", map[string]string{"RESTORE": "false"}, []string{"install", "upgrade", "verify"}},
+		{"exclude wins over include", map[string]string{"MODE": "upgrade", "VERIFY": "false"}, []string{"upgrade"}},
+	}
+
+	for _, tt := range tests {
+		selected := selectedPhases(plan, activeProfiles(plan, tt.params))
+		phases := []string{}
+		for _, ph := range plan.Phases {
+			if selected[ph.Name] {
+				phases = append(phases, ph.Name)
+			}
+		}
+		if !reflect.DeepEqual(phases, tt.expected) {
+			t.Errorf("%s: Expecting phases %v to run but got %v", tt.name, tt.expected, phases)
+		}
+	}
+}
+
+func TestSkipUnselectedPhases(t *testing.T) {
+	plan := &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Phases:   []v1alpha1.Phase{{Name: "install"}, {Name: "restore"}},
+			Profiles: []v1alpha1.PlanProfile{{Name: "restore", Parameter: "RESTORE", Value: "true", Include: []string{"restore"}}},
+		},
+		params: map[string]string{"RESTORE": "true"},
+	}
+	status := &v1alpha1.PlanStatus{Name: "deploy", Phases: []v1alpha1.PhaseStatus{
+		{Name: "install", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Name: "app", Status: v1alpha1.ExecutionPending}}},
+		{Name: "restore", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Name: "restore", Status: v1alpha1.ExecutionPending}}},
+	}}
+
+	skipUnselectedPhases(plan, status)
+
+	install := status.Phases[0]
+	if install.Status != v1alpha1.ExecutionComplete || install.Steps[0].Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting unselected phase to be skipped but got %v", install)
+	}
+	if install.Steps[0].Message != "skipped, phase is not selected by active profiles restore" {
+		t.Errorf("Expecting skipped step to say why but got %q", install.Steps[0].Message)
+	}
+	if status.Phases[1].Status != v1alpha1.ExecutionPending {
+		t.Errorf("Expecting selected phase to stay pending but got %v", status.Phases[1].Status)
+	}
+}
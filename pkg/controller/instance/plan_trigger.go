@@ -0,0 +1,37 @@
+package instance
+
+import (
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+)
+
+// values of `.PlanTrigger` telling templates why the plan is executed
+const (
+	// planTriggerInstall is a plan executed on an instance that never finished any plan, e.g. the first deploy
+	planTriggerInstall = "install"
+	// planTriggerUpgrade is a plan executed on an instance whose objects were last applied by another OperatorVersion
+	planTriggerUpgrade = "upgrade"
+	// planTriggerUpdate is a plan executed on an instance whose objects were last applied by the same OperatorVersion,
+	// e.g. after a parameter changed
+	planTriggerUpdate = "update"
+)
+
+// planTrigger determines whether the execution of the given plan installs, upgrades or updates the instance
+// the OperatorVersion that last successfully finished a plan is recorded in the status of the instance, so an instance
+// without it never had all its objects applied. Instances that finished plans before the OperatorVersion was recorded
+// are recognized by the status of their other plans, the active plan does not count as its status was reset when it
+// started.
+func planTrigger(instance *v1alpha1.Instance, ov *v1alpha1.OperatorVersion, activePlan string) string {
+	switch applied := instance.Status.AppliedOperatorVersion; {
+	case applied == ov.Name:
+		return planTriggerUpdate
+	case applied != "":
+		return planTriggerUpgrade
+	}
+
+	for name, planStatus := range instance.Status.PlanStatus {
+		if name != activePlan && planStatus.Status == v1alpha1.ExecutionComplete {
+			return planTriggerUpdate
+		}
+	}
+	return planTriggerInstall
+}
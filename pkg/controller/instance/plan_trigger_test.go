@@ -0,0 +1,77 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// initConfig is rendered only when the instance is installed, like an init Job that must not run again on upgrades
+const initConfig = `{{ if eq .PlanTrigger "install" }}
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: init
+data:
+  trigger: {{ .PlanTrigger }}
+{{ end }}`
+
+func TestPlanTrigger(t *testing.T) {
+	ov := &v1alpha1.OperatorVersion{ObjectMeta: metav1.ObjectMeta{Name: "operator-1.1"}}
+
+	tests := []struct {
+		name       string
+		status     v1alpha1.InstanceStatus
+		activePlan string
+		expected   string
+	}{
+		{"fresh instance", v1alpha1.InstanceStatus{
+			PlanStatus: map[string]v1alpha1.PlanStatus{"deploy": {Status: v1alpha1.ExecutionInProgress}, "backup": {Status: v1alpha1.ExecutionNeverRun}},
+		}, "deploy", planTriggerInstall},
+		{"same operator version", v1alpha1.InstanceStatus{AppliedOperatorVersion: "operator-1.1"}, "deploy", planTriggerUpdate},
+		{"other operator version", v1alpha1.InstanceStatus{AppliedOperatorVersion: "operator-1.0"}, "upgrade", planTriggerUpgrade},
+		{"plan finished before the operator version was recorded", v1alpha1.InstanceStatus{
+			PlanStatus: map[string]v1alpha1.PlanStatus{"deploy": {Status: v1alpha1.ExecutionComplete}, "backup": {Status: v1alpha1.ExecutionInProgress}},
+		}, "backup", planTriggerUpdate},
+		{"active plan completed in an earlier execution", v1alpha1.InstanceStatus{
+			PlanStatus: map[string]v1alpha1.PlanStatus{"deploy": {Status: v1alpha1.ExecutionComplete}},
+		}, "deploy", planTriggerInstall},
+	}
+
+	for _, tt := range tests {
+		instance := &v1alpha1.Instance{Status: tt.status}
+		if trigger := planTrigger(instance, ov, tt.activePlan); trigger != tt.expected {
+			t.Errorf("%s: Expecting trigger %s but got %s", tt.name, tt.expected, trigger)
+		}
+	}
+}
+
+func TestPlanTriggerInTemplates(t *testing.T) {
+	tests := []struct {
+		trigger         string
+		expectedObjects int
+	}{
+		{planTriggerInstall, 1},
+		{planTriggerUpgrade, 0},
+		{planTriggerUpdate, 0},
+	}
+
+	for _, tt := range tests {
+		meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", planTrigger: tt.trigger}
+
+		resources, err := prepareKubeResources(generatedValuesPlan("deploy", initConfig, nil), meta, &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.trigger, err)
+		}
+
+		objs := resources.PhaseResources["phase"].StepResources["step"]
+		if len(objs) != tt.expectedObjects {
+			t.Fatalf("%s: Expecting %d objects but got %v", tt.trigger, tt.expectedObjects, objs)
+		}
+		if len(objs) > 0 && objs[0].(*corev1.ConfigMap).Data["trigger"] != tt.trigger {
+			t.Errorf("%s: Expecting trigger to be rendered but got %v", tt.trigger, objs[0])
+		}
+	}
+}
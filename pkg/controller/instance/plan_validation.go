@@ -0,0 +1,309 @@
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// validatePlan walks all phases, steps, tasks and templates of the given plan and reports all structural errors at once
+// these are errors that would otherwise surface only in the middle of the plan execution, e.g. a step referencing a task
+// that does not exist
+// returns nil if the plan is valid
+func validatePlan(plan *activePlan) error {
+	if plan.Spec == nil {
+		return fmt.Errorf("plan %s has no specification", plan.Name)
+	}
+
+	var errs []error
+	if !isKnownStrategy(plan.Spec.Strategy) {
+		errs = append(errs, fmt.Errorf("plan %s has unknown strategy %q", plan.Name, plan.Spec.Strategy))
+	}
+	if _, err := templateRenderer(plan.templatingLanguage, kudoengine.New()); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, validateProfiles(plan)...)
+	errs = append(errs, validateDependencies(plan)...)
+
+	phaseNames := make(map[string]bool)
+	for _, ph := range plan.Spec.Phases {
+		if phaseNames[ph.Name] {
+			// statuses are looked up by name, so the status of the first phase would be used for both
+			errs = append(errs, fmt.Errorf("phase %s is defined more than once in plan %s", ph.Name, plan.Name))
+		}
+		phaseNames[ph.Name] = true
+
+		if !isKnownStrategy(ph.Strategy) && ph.Strategy != v1alpha1.BlueGreen && ph.Strategy != v1alpha1.Partitioned {
+			errs = append(errs, fmt.Errorf("phase %s of plan %s has unknown strategy %q", ph.Name, plan.Name, ph.Strategy))
+		}
+		if ph.Strategy == v1alpha1.BlueGreen && (ph.BlueGreen == nil || ph.BlueGreen.Service == "") {
+			errs = append(errs, fmt.Errorf("blue-green phase %s of plan %s must define the service to switch", ph.Name, plan.Name))
+		}
+
+		stepNames := make(map[string]bool)
+		for _, st := range ph.Steps {
+			if stepNames[st.Name] {
+				errs = append(errs, fmt.Errorf("step %s is defined more than once in phase %s of plan %s", st.Name, ph.Name, plan.Name))
+			}
+			stepNames[st.Name] = true
+
+			if st.DeleteSelector != nil && (st.DeleteSelector.APIVersion == "" || st.DeleteSelector.Kind == "") {
+				errs = append(errs, fmt.Errorf("delete selector of step %s in phase %s of plan %s must define both apiVersion and kind", st.Name, ph.Name, plan.Name))
+			}
+
+			if st.GracePeriodSeconds != nil && *st.GracePeriodSeconds < 0 {
+				errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s has negative grace period %d", st.Name, ph.Name, plan.Name, *st.GracePeriodSeconds))
+			}
+
+			for task := range st.TaskConditions {
+				if !stepRunsTask(st, task) {
+					errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s has a condition for task %s it does not run", st.Name, ph.Name, plan.Name, task))
+				}
+			}
+
+			if st.PodHealth != nil && st.Delete {
+				errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s deletes its objects and cannot wait for pods to be ready", st.Name, ph.Name, plan.Name))
+			}
+
+			if st.MinAgeSeconds < 0 {
+				errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s has negative minimum age %d", st.Name, ph.Name, plan.Name, st.MinAgeSeconds))
+			} else if st.MinAgeSeconds > 0 && st.Delete {
+				errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s deletes its objects and cannot wait for their minimum age", st.Name, ph.Name, plan.Name))
+			}
+
+			if st.Deadline != nil && st.Deadline.TimeoutSeconds < 1 {
+				errs = append(errs, fmt.Errorf("deadline of step %s in phase %s of plan %s must be at least one second", st.Name, ph.Name, plan.Name))
+			}
+
+			if plan.Spec.Verify && (st.Delete || st.DeleteSelector != nil) {
+				errs = append(errs, fmt.Errorf("step %s in phase %s of verify plan %s must not delete objects", st.Name, ph.Name, plan.Name))
+			}
+
+			if len(st.Tasks) == 0 && st.Barrier == nil {
+				errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s has no tasks", st.Name, ph.Name, plan.Name))
+			}
+			errs = append(errs, validateBarrier(plan, ph, st)...)
+			errs = append(errs, validateScale(plan, ph, st)...)
+			errs = append(errs, validateForEach(plan, ph, st)...)
+
+			errs = append(errs, validateStepTasks(plan, ph, st, "task", st.Tasks)...)
+			errs = append(errs, validateStepTasks(plan, ph, st, "pre task", st.PreTasks)...)
+			errs = append(errs, validateStepTasks(plan, ph, st, "post task", st.PostTasks)...)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateStepTasks checks that the given tasks of the step exist and that all the templates and charts they use exist
+func validateStepTasks(plan *activePlan, ph v1alpha1.Phase, st v1alpha1.Step, kind string, tasks []string) []error {
+	var errs []error
+	for _, t := range tasks {
+		taskSpec, ok := plan.Tasks[t]
+		if !ok {
+			errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s references unknown %s %s", st.Name, ph.Name, plan.Name, kind, t))
+			continue
+		}
+		if taskSpec.Command != nil && (taskSpec.Command.Image == "" || len(taskSpec.Command.Command) == 0) {
+			errs = append(errs, fmt.Errorf("command task %s used in step %s of phase %s must define both image and command", t, st.Name, ph.Name))
+		}
+		if taskSpec.Helm != nil {
+			if _, ok := plan.Templates[taskSpec.Helm.Chart+"/Chart.yaml"]; !ok {
+				errs = append(errs, fmt.Errorf("task %s used in step %s of phase %s references unknown chart %s", t, st.Name, ph.Name, taskSpec.Helm.Chart))
+			}
+		}
+		if taskSpec.Containers != "" && !isListParameter(plan.parameters, taskSpec.Containers) {
+			errs = append(errs, fmt.Errorf("task %s used in step %s of phase %s takes containers from %s which is not a list parameter", t, st.Name, ph.Name, taskSpec.Containers))
+		}
+		for _, res := range taskSpec.Resources {
+			if _, ok := plan.Templates[res]; !ok {
+				errs = append(errs, fmt.Errorf("task %s used in step %s of phase %s references unknown template %s", t, st.Name, ph.Name, res))
+			} else if kudoengine.IsPartial(res) {
+				errs = append(errs, fmt.Errorf("task %s used in step %s of phase %s references partial %s, partials only define named templates and cannot be applied", t, st.Name, ph.Name, res))
+			}
+		}
+	}
+	return errs
+}
+
+// validateBarrier checks that the barrier of the step waits for at least one object and all its conditions are complete
+func validateBarrier(plan *activePlan, ph v1alpha1.Phase, st v1alpha1.Step) []error {
+	if st.Barrier == nil {
+		return nil
+	}
+	var errs []error
+	if len(st.Barrier.Conditions) == 0 {
+		errs = append(errs, fmt.Errorf("barrier of step %s in phase %s of plan %s has no conditions", st.Name, ph.Name, plan.Name))
+	}
+	for _, c := range st.Barrier.Conditions {
+		if c.APIVersion == "" || c.Kind == "" || c.Name == "" {
+			errs = append(errs, fmt.Errorf("barrier condition of step %s in phase %s of plan %s must define apiVersion, kind and name", st.Name, ph.Name, plan.Name))
+		}
+		if c.State != "" && c.State != v1alpha1.BarrierHealthy && c.State != v1alpha1.BarrierAbsent {
+			errs = append(errs, fmt.Errorf("barrier condition of step %s in phase %s of plan %s has unknown state %q", st.Name, ph.Name, plan.Name, c.State))
+		}
+	}
+	return errs
+}
+
+// validateScale checks that a step scaling workloads has a known mode and does nothing else than scaling them
+// the previous replica counts are tracked in the plan status, which only serial phases update one step at a time
+func validateScale(plan *activePlan, ph v1alpha1.Phase, st v1alpha1.Step) []error {
+	if st.Scale == "" {
+		return nil
+	}
+	var errs []error
+	if st.Scale != v1alpha1.ScaleDown && st.Scale != v1alpha1.ScaleRestore {
+		errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s has unknown scale mode %q", st.Name, ph.Name, plan.Name, st.Scale))
+	}
+	if ph.Strategy != v1alpha1.Serial {
+		errs = append(errs, fmt.Errorf("step %s scaling workloads must be in a serial phase but phase %s of plan %s is %s", st.Name, ph.Name, plan.Name, ph.Strategy))
+	}
+	if st.Delete || st.DeleteSelector != nil || st.Barrier != nil || len(st.PreTasks) > 0 || len(st.PostTasks) > 0 {
+		errs = append(errs, fmt.Errorf("step %s in phase %s of plan %s scales workloads and must not delete objects, have a barrier, pre or post tasks", st.Name, ph.Name, plan.Name))
+	}
+	return errs
+}
+
+// validateForEach checks that a step iterating over a parameter iterates over a list parameter
+func validateForEach(plan *activePlan, ph v1alpha1.Phase, st v1alpha1.Step) []error {
+	if st.ForEach == "" {
+		return nil
+	}
+	for _, p := range plan.parameters {
+		if p.Name != st.ForEach {
+			continue
+		}
+		if p.Type != v1alpha1.ListParameterType {
+			return []error{fmt.Errorf("step %s in phase %s of plan %s iterates over parameter %s which is not a list", st.Name, ph.Name, plan.Name, st.ForEach)}
+		}
+		return nil
+	}
+	return []error{fmt.Errorf("step %s in phase %s of plan %s iterates over unknown parameter %s", st.Name, ph.Name, plan.Name, st.ForEach)}
+}
+
+// isListParameter returns true if the parameter of the given name is defined as a list parameter
+func isListParameter(parameters []v1alpha1.Parameter, name string) bool {
+	for _, p := range parameters {
+		if p.Name == name {
+			return p.Type == v1alpha1.ListParameterType
+		}
+	}
+	return false
+}
+
+func isKnownStrategy(strategy v1alpha1.Ordering) bool {
+	return strategy == v1alpha1.Serial || strategy == v1alpha1.Parallel
+}
+
+// validateParameterValues checks that values of all typed parameters match their type, e.g. that quantity parameters
+// hold a valid resource quantity, so that malformed values do not surface only when objects are rejected by the API server
+// it also checks that conditionally required parameters are set when their conditions are met
+// empty values are not validated as they mean the parameter was not set
+func validateParameterValues(parameters []v1alpha1.Parameter, values map[string]string) error {
+	var errs []error
+	for _, p := range parameters {
+		value := values[p.Name]
+		if value == "" {
+			if err := checkRequiredWhen(p, parameters, values); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		switch p.Type {
+		case v1alpha1.QuantityParameterType:
+			if _, err := resource.ParseQuantity(value); err != nil {
+				errs = append(errs, fmt.Errorf("parameter %s has value %q which is not a valid quantity", p.Name, value))
+			}
+		case v1alpha1.ImageParameterType:
+			if _, err := reference.ParseNormalizedNamed(value); err != nil {
+				errs = append(errs, fmt.Errorf("parameter %s has value %q which is not a valid image", p.Name, value))
+			}
+		case v1alpha1.ValuesParameterType:
+			if _, err := parseValues(value); err != nil {
+				errs = append(errs, fmt.Errorf("parameter %s has value which is not a valid YAML map: %v", p.Name, err))
+			}
+		case v1alpha1.ListParameterType:
+			if _, err := parseList(value); err != nil {
+				errs = append(errs, fmt.Errorf("parameter %s has value which is not a valid YAML list: %v", p.Name, err))
+			}
+		case "", v1alpha1.StringParameterType:
+			// any value is fine
+		default:
+			errs = append(errs, fmt.Errorf("parameter %s has unknown type %q", p.Name, p.Type))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// checkRequiredWhen returns error if the parameter is required because all of its conditions are met
+// conditions are combined with AND, so conditions requiring different values of the same parameter are never met together
+// and the parameter is never required by them, referencing an unknown parameter is always an error
+func checkRequiredWhen(p v1alpha1.Parameter, parameters []v1alpha1.Parameter, values map[string]string) error {
+	if len(p.RequiredWhen) == 0 {
+		return nil
+	}
+
+	allMet := true
+	var conditions []string
+	for _, cond := range p.RequiredWhen {
+		if !isKnownParameter(cond.Parameter, parameters) {
+			return fmt.Errorf("parameter %s is required based on unknown parameter %s", p.Name, cond.Parameter)
+		}
+		if values[cond.Parameter] != cond.Value {
+			allMet = false
+		}
+		conditions = append(conditions, fmt.Sprintf("%s is %q", cond.Parameter, cond.Value))
+	}
+	if allMet {
+		return fmt.Errorf("parameter %s is required when %s", p.Name, strings.Join(conditions, " and "))
+	}
+	return nil
+}
+
+func isKnownParameter(name string, parameters []v1alpha1.Parameter) bool {
+	for _, p := range parameters {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pinImageParameters returns the parameter values with images of parameters with PinDigest resolved to their digests
+// PinDigest is ignored for parameters that are not images, the given values are not modified
+func pinImageParameters(parameters []v1alpha1.Parameter, values map[string]string, resolver kudoengine.DigestResolver) (map[string]string, error) {
+	pinned := make(map[string]string, len(values))
+	for k, v := range values {
+		pinned[k] = v
+	}
+
+	var errs []error
+	for _, p := range parameters {
+		value := values[p.Name]
+		if !p.PinDigest || p.Type != v1alpha1.ImageParameterType || value == "" || kudoengine.IsPinned(value) {
+			continue
+		}
+		image, err := kudoengine.PinImage(resolver, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parameter %s requires a pinned image: %v", p.Name, err))
+			continue
+		}
+		pinned[p.Name] = image
+	}
+	return pinned, utilerrors.NewAggregate(errs)
+}
+
+// digestResolver returns the resolver of image digests for the execution
+func digestResolver(meta *executionMetadata) kudoengine.DigestResolver {
+	if meta.digestResolver != nil {
+		return meta.digestResolver
+	}
+	return kudoengine.DefaultDigestResolver
+}
@@ -0,0 +1,389 @@
+package instance
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/opencontainers/go-digest"
+)
+
+const testDigest = digest.Digest("sha256:2a03a6059f21e150ae84b0973863609494aad70f0a80eaeb64bddd8d92465812")
+
+func TestValidatePlan(t *testing.T) {
+	validPlan := func() *activePlan {
+		return &activePlan{
+			Name: "deploy",
+			Spec: &v1alpha1.Plan{
+				Strategy: "serial",
+				Phases: []v1alpha1.Phase{
+					{Name: "phase", Strategy: "parallel", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}, {Name: "other", Tasks: []string{"task"}}}},
+				},
+			},
+			Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"pod"}}},
+			Templates: map[string]string{"pod": getResourceAsString(getPod("pod1", "default"))},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		modify         func(p *activePlan)
+		expectedErrors []string
+	}{
+		{"valid plan", func(p *activePlan) {}, nil},
+		{"missing spec", func(p *activePlan) { p.Spec = nil }, []string{"plan deploy has no specification"}},
+		{"unknown plan strategy", func(p *activePlan) { p.Spec.Strategy = "random" }, []string{"plan deploy has unknown strategy \"random\""}},
+		{"unknown phase strategy", func(p *activePlan) { p.Spec.Phases[0].Strategy = "" }, []string{"phase phase of plan deploy has unknown strategy \"\""}},
+		{"blue-green phase without service", func(p *activePlan) { p.Spec.Phases[0].Strategy = v1alpha1.BlueGreen }, []string{"blue-green phase phase of plan deploy must define the service to switch"}},
+		{"blue-green phase", func(p *activePlan) {
+			p.Spec.Phases[0].Strategy = v1alpha1.BlueGreen
+			p.Spec.Phases[0].BlueGreen = &v1alpha1.BlueGreenSpec{Service: "web"}
+		}, nil},
+		{"partitioned phase", func(p *activePlan) { p.Spec.Phases[0].Strategy = v1alpha1.Partitioned }, nil},
+		{"envsubst templates", func(p *activePlan) { p.templatingLanguage = v1alpha1.Envsubst }, nil},
+		{"unknown templating language", func(p *activePlan) { p.templatingLanguage = "jsonnet" }, []string{"templating language \"jsonnet\" is not supported"}},
+		{"duplicate step name", func(p *activePlan) { p.Spec.Phases[0].Steps[1].Name = "step" }, []string{"step step is defined more than once"}},
+		{"duplicate phase name", func(p *activePlan) {
+			p.Spec.Phases = append(p.Spec.Phases, p.Spec.Phases[0], p.Spec.Phases[0])
+		}, []string{"phase phase is defined more than once in plan deploy"}},
+		{"missing task", func(p *activePlan) { p.Tasks = map[string]v1alpha1.TaskSpec{} }, []string{
+			"step step in phase phase of plan deploy references unknown task task",
+			"step other in phase phase of plan deploy references unknown task task",
+		}},
+		{"negative grace period", func(p *activePlan) {
+			grace := int64(-1)
+			p.Spec.Phases[0].Steps[0].GracePeriodSeconds = &grace
+		}, []string{"step step in phase phase of plan deploy has negative grace period -1"}},
+		{"incomplete delete selector", func(p *activePlan) { p.Spec.Phases[0].Steps[0].DeleteSelector = &v1alpha1.DeleteSelector{Kind: "Pod"} }, []string{"delete selector of step step in phase phase of plan deploy must define both apiVersion and kind"}},
+		{"missing template", func(p *activePlan) { p.Templates = map[string]string{} }, []string{"task task used in step step of phase phase references unknown template pod"}},
+		{"profile with unknown parameter and phase", func(p *activePlan) {
+			p.parameters = []v1alpha1.Parameter{{Name: "MODE"}}
+			p.Spec.Profiles = []v1alpha1.PlanProfile{{Name: "restore", Parameter: "RESTORE", Value: "true", Include: []string{"restore"}}}
+		}, []string{"profile restore of plan deploy references unknown parameter RESTORE", "profile restore of plan deploy references unknown phase restore"}},
+		{"delete step in verify plan", func(p *activePlan) {
+			p.Spec.Verify = true
+			p.Spec.Phases[0].Steps[0].Delete = true
+		}, []string{"step step in phase phase of verify plan deploy must not delete objects"}},
+		{"partial used as resource", func(p *activePlan) {
+			p.Templates["_helpers.tpl"] = `{{ define "common.labels" }}app: web{{ end }}`
+			p.Tasks["task"] = v1alpha1.TaskSpec{Resources: []string{"_helpers.tpl"}}
+		}, []string{"task task used in step step of phase phase references partial _helpers.tpl"}},
+		{"missing pre and post tasks", func(p *activePlan) {
+			p.Spec.Phases[0].Steps[0].PreTasks = []string{"scale-down"}
+			p.Spec.Phases[0].Steps[0].PostTasks = []string{"scale-up"}
+		}, []string{
+			"step step in phase phase of plan deploy references unknown pre task scale-down",
+			"step step in phase phase of plan deploy references unknown post task scale-up",
+		}},
+		{"missing template of pre task", func(p *activePlan) {
+			p.Tasks["scale-down"] = v1alpha1.TaskSpec{Resources: []string{"scale"}}
+			p.Spec.Phases[0].Steps[0].PreTasks = []string{"scale-down"}
+		}, []string{"task scale-down used in step step of phase phase references unknown template scale"}},
+		{"missing chart", func(p *activePlan) { p.Tasks["task"] = v1alpha1.TaskSpec{Helm: &v1alpha1.HelmSpec{Chart: "web"}} }, []string{
+			"task task used in step step of phase phase references unknown chart web",
+		}},
+		{"barrier step without tasks", func(p *activePlan) {
+			p.Spec.Phases[0].Steps[0].Tasks = nil
+			p.Spec.Phases[0].Steps[0].Barrier = &v1alpha1.Barrier{Conditions: []v1alpha1.BarrierCondition{{APIVersion: "v1", Kind: "Pod", Name: "db"}}}
+		}, nil},
+		{"step without tasks", func(p *activePlan) { p.Spec.Phases[0].Steps[0].Tasks = nil }, []string{"step step in phase phase of plan deploy has no tasks"}},
+		{"incomplete barrier", func(p *activePlan) {
+			p.Spec.Phases[0].Steps[0].Barrier = &v1alpha1.Barrier{Conditions: []v1alpha1.BarrierCondition{{APIVersion: "v1", Kind: "Pod", State: "Ready"}}}
+		}, []string{
+			"barrier condition of step step in phase phase of plan deploy must define apiVersion, kind and name",
+			`barrier condition of step step in phase phase of plan deploy has unknown state "Ready"`,
+		}},
+		{"scale step", func(p *activePlan) {
+			p.Spec.Phases[0].Strategy = v1alpha1.Serial
+			p.Spec.Phases[0].Steps[0].Scale = v1alpha1.ScaleDown
+		}, nil},
+		{"invalid scale step", func(p *activePlan) {
+			p.Spec.Phases[0].Steps[0].Scale = "Up"
+			p.Spec.Phases[0].Steps[0].PostTasks = []string{"task"}
+		}, []string{
+			`step step in phase phase of plan deploy has unknown scale mode "Up"`,
+			"step step scaling workloads must be in a serial phase but phase phase of plan deploy is parallel",
+			"step step in phase phase of plan deploy scales workloads and must not delete objects, have a barrier, pre or post tasks",
+		}},
+		{"step iterating over a list", func(p *activePlan) {
+			p.parameters = []v1alpha1.Parameter{{Name: "SHARDS", Type: v1alpha1.ListParameterType}}
+			p.Spec.Phases[0].Steps[0].ForEach = "SHARDS"
+		}, nil},
+		{"step iterating over a parameter that is not a list", func(p *activePlan) {
+			p.parameters = []v1alpha1.Parameter{{Name: "SHARDS"}}
+			p.Spec.Phases[0].Steps[0].ForEach = "SHARDS"
+			p.Spec.Phases[0].Steps[1].ForEach = "ZONES"
+		}, []string{
+			"step step in phase phase of plan deploy iterates over parameter SHARDS which is not a list",
+			"step other in phase phase of plan deploy iterates over unknown parameter ZONES",
+		}},
+		{"containers from a parameter that is not a list", func(p *activePlan) {
+			p.parameters = []v1alpha1.Parameter{{Name: "SIDECARS"}}
+			p.Tasks["task"] = v1alpha1.TaskSpec{Resources: []string{"pod"}, Containers: "SIDECARS"}
+		}, []string{"task task used in step step of phase phase takes containers from SIDECARS which is not a list parameter"}},
+		{"deleting step with pod health", func(p *activePlan) {
+			p.Spec.Phases[0].Steps[0].Delete = true
+			p.Spec.Phases[0].Steps[0].PodHealth = &v1alpha1.PodHealth{}
+		}, []string{"step step in phase phase of plan deploy deletes its objects and cannot wait for pods to be ready"}},
+		{"deleting step with minimum age", func(p *activePlan) {
+			p.Spec.Phases[0].Steps[0].Delete = true
+			p.Spec.Phases[0].Steps[0].MinAgeSeconds = 30
+		}, []string{"step step in phase phase of plan deploy deletes its objects and cannot wait for their minimum age"}},
+		{"deadline of zero seconds", func(p *activePlan) {
+			p.Spec.Phases[0].Steps[0].Deadline = &v1alpha1.StepDeadline{}
+		}, []string{"deadline of step step in phase phase of plan deploy must be at least one second"}},
+		{"multiple errors reported at once", func(p *activePlan) {
+			p.Spec.Strategy = "random"
+			p.Templates = map[string]string{}
+		}, []string{"plan deploy has unknown strategy", "references unknown template pod"}},
+	}
+
+	for _, tt := range tests {
+		plan := validPlan()
+		tt.modify(plan)
+
+		err := validatePlan(plan)
+		if len(tt.expectedErrors) == 0 {
+			if err != nil {
+				t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: Expecting error but got none", tt.name)
+			continue
+		}
+		for _, expected := range tt.expectedErrors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: Expecting error to contain %q but got %v", tt.name, expected, err)
+			}
+		}
+	}
+}
+
+func TestExecutePlanFailsOnInvalidPlan(t *testing.T) {
+	plan := &activePlan{
+		Name: "test",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Status: v1alpha1.ExecutionPending,
+			Name:   "test",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionPending, Name: "step"}}}},
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases: []v1alpha1.Phase{
+				{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"missing"}}}},
+			},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{},
+		Templates: map[string]string{},
+	}
+
+	newStatus, err := executePlan(plan, &executionMetadata{instanceName: "Instance"}, nil, &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Fatal("Expecting error for invalid plan but got none")
+	}
+	if exErr, ok := err.(*executionError); !ok || !exErr.fatal {
+		t.Errorf("Expecting fatal execution error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting plan status to be %v but got %v", v1alpha1.ExecutionFatalError, newStatus.Status)
+	}
+}
+
+func TestValidateParameterValues(t *testing.T) {
+	parameters := []v1alpha1.Parameter{
+		{Name: "MEMORY", Type: v1alpha1.QuantityParameterType},
+		{Name: "CPU", Type: v1alpha1.QuantityParameterType},
+		{Name: "NAME"},
+		{Name: "IMAGE", Type: v1alpha1.ImageParameterType},
+		{Name: "SHARDS", Type: v1alpha1.ListParameterType},
+	}
+
+	tests := []struct {
+		name          string
+		values        map[string]string
+		expectedError string
+	}{
+		{"valid quantities", map[string]string{"MEMORY": "512Mi", "CPU": "0.5", "NAME": "512 megs"}, ""},
+		{"unset quantity", map[string]string{"MEMORY": "", "NAME": "name"}, ""},
+		{"malformed quantity", map[string]string{"MEMORY": "512MB", "CPU": "1"}, "parameter MEMORY has value \"512MB\" which is not a valid quantity"},
+		{"not a number", map[string]string{"CPU": "two"}, "parameter CPU has value \"two\" which is not a valid quantity"},
+		{"valid image", map[string]string{"IMAGE": "gcr.io/google/pause:3.1"}, ""},
+		{"malformed image", map[string]string{"IMAGE": "Nginx:1.17"}, "parameter IMAGE has value \"Nginx:1.17\" which is not a valid image"},
+		{"valid list", map[string]string{"SHARDS": "[a, b]"}, ""},
+		{"malformed list", map[string]string{"SHARDS": "a: b"}, "parameter SHARDS has value which is not a valid YAML list"},
+	}
+
+	for _, tt := range tests {
+		err := validateParameterValues(parameters, tt.values)
+		if tt.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+			t.Errorf("%s: Expecting error to contain %q but got %v", tt.name, tt.expectedError, err)
+		}
+	}
+
+	err := validateParameterValues([]v1alpha1.Parameter{{Name: "PORT", Type: "port"}}, map[string]string{"PORT": "8080"})
+	if err == nil {
+		t.Error("Expecting error for unknown parameter type but got none")
+	}
+}
+
+func TestExecutePlanFailsOnMalformedQuantity(t *testing.T) {
+	plan := &activePlan{
+		Name: "test",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Status: v1alpha1.ExecutionPending,
+			Name:   "test",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionPending, Name: "step"}}}},
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases: []v1alpha1.Phase{
+				{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}},
+			},
+		},
+		Tasks:      map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"pod"}}},
+		Templates:  map[string]string{"pod": getResourceAsString(getPod("pod1", "default"))},
+		params:     map[string]string{"MEMORY": "lots"},
+		parameters: []v1alpha1.Parameter{{Name: "MEMORY", Type: v1alpha1.QuantityParameterType}},
+	}
+
+	newStatus, err := executePlan(plan, &executionMetadata{instanceName: "Instance"}, nil, &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Fatal("Expecting error for malformed quantity but got none")
+	}
+	if exErr, ok := err.(*executionError); !ok || !exErr.fatal {
+		t.Errorf("Expecting fatal execution error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting plan status to be %v but got %v", v1alpha1.ExecutionFatalError, newStatus.Status)
+	}
+}
+
+func TestPinImageParameters(t *testing.T) {
+	resolver := kudoengine.DigestResolverFunc(func(image reference.Named) (digest.Digest, error) {
+		if reference.Path(image) == "library/missing" {
+			return "", fmt.Errorf("manifest unknown")
+		}
+		return testDigest, nil
+	})
+	parameters := []v1alpha1.Parameter{
+		{Name: "IMAGE", Type: v1alpha1.ImageParameterType, PinDigest: true},
+		{Name: "SIDECAR", Type: v1alpha1.ImageParameterType},
+		{Name: "NAME", PinDigest: true},
+	}
+
+	tests := []struct {
+		name     string
+		values   map[string]string
+		expected map[string]string
+		err      bool
+	}{
+		{"pinned", map[string]string{"IMAGE": "nginx:1.17", "SIDECAR": "busybox:1.31", "NAME": "nginx:1.17"},
+			map[string]string{"IMAGE": "nginx:1.17@" + string(testDigest), "SIDECAR": "busybox:1.31", "NAME": "nginx:1.17"}, false},
+		{"already pinned", map[string]string{"IMAGE": "nginx@" + string(testDigest)}, map[string]string{"IMAGE": "nginx@" + string(testDigest)}, false},
+		{"unset", map[string]string{}, map[string]string{}, false},
+		{"unknown image", map[string]string{"IMAGE": "missing:1.0"}, map[string]string{"IMAGE": "missing:1.0"}, true},
+	}
+
+	for _, tt := range tests {
+		pinned, err := pinImageParameters(parameters, tt.values, resolver)
+		if tt.err != (err != nil) {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.err, err)
+		}
+		if !reflect.DeepEqual(pinned, tt.expected) {
+			t.Errorf("%s: Expecting values %v but got %v", tt.name, tt.expected, pinned)
+		}
+	}
+}
+
+func TestExecutePlanFailsOnUnpinnedImage(t *testing.T) {
+	plan := &activePlan{
+		Name: "test",
+		PlanStatus: &v1alpha1.PlanStatus{
+			Status: v1alpha1.ExecutionPending,
+			Name:   "test",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Status: v1alpha1.ExecutionPending, Name: "step"}}}},
+		},
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases: []v1alpha1.Phase{
+				{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}},
+			},
+		},
+		Tasks:      map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"pod"}}},
+		Templates:  map[string]string{"pod": getResourceAsString(getPod("pod1", "default"))},
+		params:     map[string]string{"IMAGE": "nginx:1.17"},
+		parameters: []v1alpha1.Parameter{{Name: "IMAGE", Type: v1alpha1.ImageParameterType, PinDigest: true}},
+	}
+	meta := &executionMetadata{instanceName: "Instance", digestResolver: kudoengine.DigestResolverFunc(func(image reference.Named) (digest.Digest, error) {
+		return "", fmt.Errorf("registry not reachable")
+	})}
+
+	newStatus, err := executePlan(plan, meta, nil, &testKubernetesObjectEnhancer{})
+	if exErr, ok := err.(*executionError); !ok || !exErr.fatal || *exErr.eventName != "ImageNotPinned" {
+		t.Errorf("Expecting fatal ImageNotPinned execution error but got %v", err)
+	}
+	if newStatus.Status != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting plan status to be %v but got %v", v1alpha1.ExecutionFatalError, newStatus.Status)
+	}
+}
+
+func TestValidateConditionallyRequiredParameters(t *testing.T) {
+	parameters := []v1alpha1.Parameter{
+		{Name: "TLS_ENABLED", Group: "security"},
+		{Name: "AUTH", Group: "security"},
+		{Name: "TLS_CERT", Group: "security", RequiredWhen: []v1alpha1.ParameterCondition{{Parameter: "TLS_ENABLED", Value: "true"}}},
+		{Name: "AUTH_SECRET", Group: "security", RequiredWhen: []v1alpha1.ParameterCondition{
+			{Parameter: "TLS_ENABLED", Value: "true"},
+			{Parameter: "AUTH", Value: "password"},
+		}},
+		{Name: "NEVER", RequiredWhen: []v1alpha1.ParameterCondition{
+			{Parameter: "AUTH", Value: "password"},
+			{Parameter: "AUTH", Value: "token"},
+		}},
+	}
+
+	tests := []struct {
+		name           string
+		parameters     []v1alpha1.Parameter
+		values         map[string]string
+		expectedErrors []string
+	}{
+		{"condition not met", parameters, map[string]string{"TLS_ENABLED": "false"}, nil},
+		{"condition met and parameter set", parameters, map[string]string{"TLS_ENABLED": "true", "TLS_CERT": "cert"}, nil},
+		{"condition met and parameter missing", parameters, map[string]string{"TLS_ENABLED": "true"}, []string{"parameter TLS_CERT is required when TLS_ENABLED is \"true\""}},
+		{"only some of the conditions met", parameters, map[string]string{"TLS_ENABLED": "true", "TLS_CERT": "cert", "AUTH": "none"}, nil},
+		{"all conditions met", parameters, map[string]string{"TLS_ENABLED": "true", "TLS_CERT": "cert", "AUTH": "password"}, []string{"parameter AUTH_SECRET is required when TLS_ENABLED is \"true\" and AUTH is \"password\""}},
+		{"unknown parameter in condition", []v1alpha1.Parameter{{Name: "CERT", RequiredWhen: []v1alpha1.ParameterCondition{{Parameter: "TLS", Value: "true"}}}}, map[string]string{}, []string{"parameter CERT is required based on unknown parameter TLS"}},
+	}
+
+	for _, tt := range tests {
+		err := validateParameterValues(tt.parameters, tt.values)
+		if len(tt.expectedErrors) == 0 {
+			if err != nil {
+				t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: Expecting error but got none", tt.name)
+			continue
+		}
+		for _, expected := range tt.expectedErrors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: Expecting error to contain %q but got %v", tt.name, expected, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	errwrap "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podHealthPollInterval is the time after which pods of a step waiting for them are checked again, pods are not watched
+const podHealthPollInterval = 10 * time.Second
+
+// podSelector is a rendered v1alpha1.PodHealth with all the labels that pods of the step have to match
+type podSelector struct {
+	namespace string
+	labels    map[string]string
+	allowNone bool
+}
+
+// renderPodSelector renders labels of the pod health of the step, only pods of the current instance are ever selected
+func renderPodSelector(podHealth *v1alpha1.PodHealth, meta *executionMetadata, engine *kudoengine.Engine, configs map[string]interface{}) (*podSelector, error) {
+	labels, err := renderSelectorLabels(podHealth.MatchLabels, meta, engine, configs)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error expanding pod health selector")
+	}
+	return &podSelector{namespace: meta.instanceNamespace, labels: labels, allowNone: podHealth.AllowNone}, nil
+}
+
+// checkPods returns true if all the pods matching the selector are ready, otherwise the message of the step tells how
+// many of them are. Terminating, completed and failed pods are not counted, they never become ready again, e.g. evicted
+// pods stay around failed until they are garbage collected.
+func checkPods(selector *podSelector, state *v1alpha1.StepStatus, c client.Client) (bool, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(context.TODO(), pods, client.InNamespace(selector.namespace), client.MatchingLabels(selector.labels)); err != nil {
+		return false, errwrap.Wrap(err, "error listing pods of the step")
+	}
+
+	var total, ready int
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		total++
+		if isPodReady(&pod) {
+			ready++
+		}
+	}
+
+	selectorString := labels.SelectorFromSet(selector.labels).String()
+	if total == 0 {
+		if selector.allowNone {
+			return true, nil
+		}
+		log.Printf("PlanExecution: Step %s waits for pods matching %s, none found", state.Name, selectorString)
+		state.Message = fmt.Sprintf("waiting for pods matching %s, none found", selectorString)
+		return false, nil
+	}
+	if ready < total {
+		log.Printf("PlanExecution: Step %s has %d of %d pods matching %s ready", state.Name, ready, total, selectorString)
+		state.Message = fmt.Sprintf("waiting for pods matching %s, %d of %d ready", selectorString, ready, total)
+		return false, nil
+	}
+	return true, nil
+}
+
+// isPodReady returns true if the pod reports the Ready condition
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// executeStepTasks applies the tasks of the step, steps with pod health are complete only once the pods are ready too
+func executeStepTasks(ctx context.Context, step v1alpha1.Step, state *v1alpha1.StepStatus, resources phaseResources, clk clock.Clock, c client.Client) error {
+	err := executeStep(ctx, step, state, resources.StepResources[step.Name], resources.StepDeleteSelectors[step.Name], clk, c)
+	if err == nil && resources.Partitioned && !step.Delete && state.Status == v1alpha1.ExecutionComplete {
+		rolledOut, err := statefulSetsRolledOut(resources.StepResources[step.Name], state, c)
+		if err != nil || !rolledOut {
+			state.Status = v1alpha1.ExecutionInProgress
+			return err
+		}
+	}
+	selector := resources.StepPodSelectors[step.Name]
+	if err != nil || selector == nil || state.Status != v1alpha1.ExecutionComplete {
+		return err
+	}
+	ready, err := checkPods(selector, state, c)
+	if err != nil || !ready {
+		state.Status = v1alpha1.ExecutionInProgress
+		return err
+	}
+	return nil
+}
+
+// podHealthRequeueAfter returns the time after which pods of the steps waiting for them are checked again, zero if no
+// step with pod health is in progress. Pods are not watched, so their readiness does not trigger the next execution.
+func podHealthRequeueAfter(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus) time.Duration {
+	for _, ph := range plan.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			if st.PodHealth == nil {
+				continue
+			}
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || stepState.Status != v1alpha1.ExecutionInProgress {
+				continue
+			}
+			if stepState.Stage == "" || stepState.Stage == v1alpha1.TasksStage {
+				return podHealthPollInterval
+			}
+		}
+	}
+	return 0
+}
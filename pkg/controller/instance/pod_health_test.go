@@ -0,0 +1,112 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func getSelectedPod(name string, instance string, ready bool) *corev1.Pod {
+	pod := getPod(name, "default")
+	pod.Labels = map[string]string{
+		kudo.HeritageLabel: "kudo",
+		kudo.OperatorLabel: "kafka",
+		kudo.InstanceLabel: instance,
+		"app":              "broker",
+	}
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	return pod
+}
+
+func TestExecuteStepWithPodHealth(t *testing.T) {
+	deletedAt := metav1.Now()
+	terminating := getSelectedPod("terminating", "kafka", false)
+	terminating.DeletionTimestamp = &deletedAt
+	completed := getSelectedPod("completed", "kafka", false)
+	completed.Status.Phase = corev1.PodSucceeded
+	evicted := getSelectedPod("evicted", "kafka", false)
+	evicted.Status.Phase = corev1.PodFailed
+	evicted.Status.Reason = "Evicted"
+
+	tests := []struct {
+		name            string
+		allowNone       bool
+		pods            []runtime.Object
+		expectedStatus  v1alpha1.ExecutionStatus
+		expectedMessage string
+	}{
+		{"all pods ready", false, []runtime.Object{getSelectedPod("broker-0", "kafka", true), getSelectedPod("broker-1", "kafka", true)}, v1alpha1.ExecutionComplete, ""},
+		{"some pods not ready", false, []runtime.Object{getSelectedPod("broker-0", "kafka", true), getSelectedPod("broker-1", "kafka", false), getSelectedPod("broker-2", "kafka", true)},
+			v1alpha1.ExecutionInProgress, "waiting for pods matching app=broker,heritage=kudo,kudo.dev/instance=kafka,kudo.dev/operator=kafka, 2 of 3 ready"},
+		{"terminating and completed pods are not counted", false, []runtime.Object{getSelectedPod("broker-0", "kafka", true), terminating, completed}, v1alpha1.ExecutionComplete, ""},
+		{"failed pods are not counted", false, []runtime.Object{getSelectedPod("broker-0", "kafka", true), evicted}, v1alpha1.ExecutionComplete, ""},
+		{"pods of other instances are not selected", false, []runtime.Object{getSelectedPod("broker-0", "kafka", true), getSelectedPod("other-0", "other", false)}, v1alpha1.ExecutionComplete, ""},
+		{"no pods", false, nil, v1alpha1.ExecutionInProgress, "waiting for pods matching app=broker,heritage=kudo,kudo.dev/instance=kafka,kudo.dev/operator=kafka, none found"},
+		{"no pods allowed", true, nil, v1alpha1.ExecutionComplete, ""},
+	}
+
+	meta := &executionMetadata{instanceName: "kafka", instanceNamespace: "default", operatorName: "kafka"}
+	for _, tt := range tests {
+		podHealth := &v1alpha1.PodHealth{MatchLabels: map[string]string{"app": "{{ .Name }}"}, AllowNone: tt.allowNone}
+		selector, err := renderPodSelector(podHealth, meta, kudoengine.New(), map[string]interface{}{"Name": "broker"})
+		if err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		step := v1alpha1.Step{Name: "step", Tasks: []string{"task"}, PodHealth: podHealth}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+		resources := phaseResources{
+			// the health of the objects of the step is not checked, only the pods decide
+			StepResources:    map[string][]runtime.Object{"step": {getConfigMap("broker-config", "default", nil), getDeployment("unready", "default", 1)}},
+			StepPodSelectors: map[string]*podSelector{"step": selector},
+		}
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme, tt.pods...)
+
+		if err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient); err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting status %v but got %v", tt.name, tt.expectedStatus, state.Status)
+		}
+		if state.Message != tt.expectedMessage {
+			t.Errorf("%s: Expecting message %q but got %q", tt.name, tt.expectedMessage, state.Message)
+		}
+	}
+}
+
+func TestPodHealthRequeueAfter(t *testing.T) {
+	podHealth := &v1alpha1.PodHealth{MatchLabels: map[string]string{"app": "broker"}}
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "waiting", PodHealth: podHealth},
+		{Name: "finished", PodHealth: podHealth},
+		{Name: "other"},
+	}}}}
+	status := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+		{Name: "waiting", Status: v1alpha1.ExecutionInProgress},
+		{Name: "finished", Status: v1alpha1.ExecutionComplete},
+		{Name: "other", Status: v1alpha1.ExecutionInProgress},
+	}}}}
+
+	if after := podHealthRequeueAfter(plan, status); after != podHealthPollInterval {
+		t.Errorf("Expecting requeue after %v while pods of a step are waited for but got %v", podHealthPollInterval, after)
+	}
+	status.Phases[0].Steps[0].Stage = v1alpha1.PostTasksStage
+	if after := podHealthRequeueAfter(plan, status); after != 0 {
+		t.Errorf("Expecting no requeue once the step is past its tasks but got %v", after)
+	}
+	if after := podHealthRequeueAfter(plan, &v1alpha1.PlanStatus{}); after != 0 {
+		t.Errorf("Expecting no requeue without steps in progress but got %v", after)
+	}
+}
@@ -0,0 +1,192 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// checkQuota returns a fatal error if the workloads the plan applies do not fit into the resource quotas of their
+// namespaces
+// the additional usage of a workload is what it requests minus what the existing workload of the same name already uses,
+// the quota usage reported by the API server already contains the latter. Quotas with scopes are ignored as they only
+// apply to some pods, and so are DaemonSets as their usage depends on the number of nodes
+func checkQuota(plan *v1alpha1.Plan, resources *planResources, c client.Client) error {
+	needed := make(map[string]corev1.ResourceList)
+	for _, ph := range plan.Phases {
+		phaseRes := resources.PhaseResources[ph.Name]
+		for _, st := range ph.Steps {
+			if st.Delete {
+				continue
+			}
+			for _, stage := range [][]runtime.Object{phaseRes.StepPreResources[st.Name], phaseRes.StepResources[st.Name], phaseRes.StepPostResources[st.Name]} {
+				for _, obj := range stage {
+					usage, namespace, err := additionalUsage(obj, c)
+					if err != nil {
+						return err
+					}
+					if usage == nil {
+						continue
+					}
+					if needed[namespace] == nil {
+						needed[namespace] = corev1.ResourceList{}
+					}
+					addResources(needed[namespace], usage)
+				}
+			}
+		}
+	}
+
+	var problems []string
+	for namespace, usage := range needed {
+		quotas := &corev1.ResourceQuotaList{}
+		if err := c.List(context.TODO(), quotas, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		for _, q := range quotas.Items {
+			if len(q.Spec.Scopes) > 0 || q.Spec.ScopeSelector != nil {
+				continue
+			}
+			problems = append(problems, exceededQuota(q, usage)...)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &executionError{err: fmt.Errorf("plan does not fit into resource quota: %s", strings.Join(problems, ", ")), fatal: true, eventName: kudo.String("QuotaExceeded")}
+	}
+	return nil
+}
+
+// exceededQuota describes the resources of the quota the usage does not fit into
+func exceededQuota(quota corev1.ResourceQuota, usage corev1.ResourceList) []string {
+	var problems []string
+	for name, hard := range quota.Spec.Hard {
+		needed, ok := usage[name]
+		if !ok || needed.Sign() <= 0 {
+			continue
+		}
+		left := hard.DeepCopy()
+		if used, ok := quota.Status.Used[name]; ok {
+			left.Sub(used)
+		}
+		if needed.Cmp(left) > 0 {
+			problems = append(problems, fmt.Sprintf("%s needs %s more %s but quota %s has only %s left", quota.Namespace, needed.String(), name, quota.Name, left.String()))
+		}
+	}
+	return problems
+}
+
+// additionalUsage returns the quota usage the object adds once applied and its namespace, nil for objects that are not
+// workloads
+func additionalUsage(obj runtime.Object, c client.Client) (corev1.ResourceList, string, error) {
+	usage := quotaUsage(obj)
+	if usage == nil {
+		return nil, "", nil
+	}
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	existing := obj.DeepCopyObject()
+	err = c.Get(context.TODO(), key, existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, "", err
+	}
+	if err == nil {
+		subtractResources(usage, quotaUsage(existing))
+	}
+	return usage, key.Namespace, nil
+}
+
+// quotaUsage returns the resources all pods of the workload count against quota, nil for objects that are not workloads
+func quotaUsage(obj runtime.Object) corev1.ResourceList {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return podUsage(o.Spec, 1)
+	case *appsv1.Deployment:
+		return podUsage(o.Spec.Template.Spec, replicasOrOne(o.Spec.Replicas))
+	case *appsv1.StatefulSet:
+		return podUsage(o.Spec.Template.Spec, replicasOrOne(o.Spec.Replicas))
+	case *appsv1.ReplicaSet:
+		return podUsage(o.Spec.Template.Spec, replicasOrOne(o.Spec.Replicas))
+	case *batchv1.Job:
+		return podUsage(o.Spec.Template.Spec, replicasOrOne(o.Spec.Parallelism))
+	default:
+		return nil
+	}
+}
+
+func replicasOrOne(replicas *int32) int64 {
+	if replicas == nil {
+		return 1
+	}
+	return int64(*replicas)
+}
+
+// podUsage returns the usage of the given number of pods, a pod uses the sum of the requests and limits of its containers
+// or the largest ones of its init containers, whichever is bigger
+func podUsage(spec corev1.PodSpec, pods int64) corev1.ResourceList {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, container := range spec.Containers {
+		addResources(requests, container.Resources.Requests)
+		addResources(limits, container.Resources.Limits)
+	}
+	for _, container := range spec.InitContainers {
+		maxResources(requests, container.Resources.Requests)
+		maxResources(limits, container.Resources.Limits)
+	}
+
+	usage := corev1.ResourceList{corev1.ResourcePods: *resource.NewQuantity(pods, resource.DecimalSI)}
+	for name, q := range requests {
+		total := multiply(q, pods)
+		usage[name] = total
+		usage[corev1.ResourceName("requests."+string(name))] = total
+	}
+	for name, q := range limits {
+		usage[corev1.ResourceName("limits."+string(name))] = multiply(q, pods)
+	}
+	return usage
+}
+
+func multiply(q resource.Quantity, times int64) resource.Quantity {
+	total := resource.Quantity{Format: q.Format}
+	for i := int64(0); i < times; i++ {
+		total.Add(q)
+	}
+	return total
+}
+
+func addResources(total corev1.ResourceList, added corev1.ResourceList) {
+	for name, q := range added {
+		sum := total[name]
+		sum.Add(q)
+		total[name] = sum
+	}
+}
+
+func subtractResources(total corev1.ResourceList, subtracted corev1.ResourceList) {
+	for name, q := range subtracted {
+		diff := total[name]
+		diff.Sub(q)
+		total[name] = diff
+	}
+}
+
+func maxResources(total corev1.ResourceList, other corev1.ResourceList) {
+	for name, q := range other {
+		if current, ok := total[name]; !ok || q.Cmp(current) > 0 {
+			total[name] = q.DeepCopy()
+		}
+	}
+}
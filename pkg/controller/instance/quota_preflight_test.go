@@ -0,0 +1,93 @@
+package instance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func getQuota(hard corev1.ResourceList, used corev1.ResourceList) *corev1.ResourceQuota {
+	return &corev1.ResourceQuota{
+		TypeMeta:   metav1.TypeMeta{Kind: "ResourceQuota", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "default"},
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+		Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+	}
+}
+
+func getRequestingDeployment(name string, replicas int32, cpu string) *appsv1.Deployment {
+	d := getDeployment(name, "default", replicas)
+	d.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:      "app",
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}},
+	}}
+	return d
+}
+
+func TestCheckQuota(t *testing.T) {
+	cpu := func(q string) corev1.ResourceList {
+		return corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse(q)}
+	}
+
+	tests := []struct {
+		name          string
+		existing      []runtime.Object
+		applied       []runtime.Object
+		expectedError string
+	}{
+		{"no quota", nil, []runtime.Object{getRequestingDeployment("web", 3, "1")}, ""},
+		{"fits", []runtime.Object{getQuota(cpu("4"), cpu("1"))}, []runtime.Object{getRequestingDeployment("web", 3, "1")}, ""},
+		{"exceeds", []runtime.Object{getQuota(cpu("4"), cpu("2"))}, []runtime.Object{getRequestingDeployment("web", 3, "1")},
+			"default needs 3 more requests.cpu but quota compute has only 2 left"},
+		{"exceeds with several workloads", []runtime.Object{getQuota(cpu("4"), cpu("0"))}, []runtime.Object{getRequestingDeployment("web", 2, "1"), getRequestingDeployment("db", 1, "2500m")},
+			"default needs 4500m more requests.cpu but quota compute has only 4 left"},
+		{"existing usage is replaced", []runtime.Object{getQuota(cpu("4"), cpu("3")), getRequestingDeployment("web", 2, "1")}, []runtime.Object{getRequestingDeployment("web", 3, "1")}, ""},
+		{"quota of other resources", []runtime.Object{getQuota(corev1.ResourceList{corev1.ResourceRequestsMemory: resource.MustParse("1Gi")}, nil)}, []runtime.Object{getRequestingDeployment("web", 3, "1")}, ""},
+		{"pods are counted", []runtime.Object{getQuota(corev1.ResourceList{corev1.ResourcePods: resource.MustParse("2")}, nil)}, []runtime.Object{getRequestingDeployment("web", 3, "1")},
+			"default needs 3 more pods but quota compute has only 2 left"},
+		{"not a workload", []runtime.Object{getQuota(cpu("0"), nil)}, []runtime.Object{getConfigMap("config", "default", nil)}, ""},
+	}
+
+	for _, tt := range tests {
+		plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{{Name: "step"}}}}}
+		resources := &planResources{PhaseResources: map[string]phaseResources{
+			"phase": {StepResources: map[string][]runtime.Object{"step": tt.applied}},
+		}}
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme, tt.existing...)
+
+		err := checkQuota(plan, resources, testClient)
+		if tt.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+			t.Errorf("%s: Expecting error %q but got %v", tt.name, tt.expectedError, err)
+			continue
+		}
+		if statusForError(err) != v1alpha1.ExecutionFatalError {
+			t.Errorf("%s: Expecting exceeded quota to be a fatal error but got %v", tt.name, statusForError(err))
+		}
+	}
+}
+
+func TestCheckQuotaIgnoresDeleteSteps(t *testing.T) {
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{{Name: "step", Delete: true}}}}}
+	resources := &planResources{PhaseResources: map[string]phaseResources{
+		"phase": {StepResources: map[string][]runtime.Object{"step": {getRequestingDeployment("web", 3, "1")}}},
+	}}
+	quota := getQuota(corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")}, nil)
+
+	if err := checkQuota(plan, resources, fake.NewFakeClientWithScheme(scheme.Scheme, quota)); err != nil {
+		t.Errorf("Expecting objects that are deleted not to count against quota but got %v", err)
+	}
+}
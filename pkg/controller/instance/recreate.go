@@ -0,0 +1,96 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	apijson "k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// unrecreatableKinds hold data that is lost with the object, they are never recreated
+var unrecreatableKinds = map[string]bool{
+	"StatefulSet":              true,
+	"PersistentVolumeClaim":    true,
+	"PersistentVolume":         true,
+	"Namespace":                true,
+	"CustomResourceDefinition": true,
+}
+
+// isRecreated returns true if changes of the object are applied by recreating it, because the step or the update strategy
+// annotation of the object asks for it, the annotation wins over the step
+func isRecreated(step v1alpha1.Step, obj runtime.Object) (bool, error) {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return false, err
+	}
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	recreate := step.Recreate
+	switch strategy, ok := objMeta.GetAnnotations()[kudo.UpdateStrategyAnnotation]; {
+	case !ok:
+	case strategy == kudo.UpdateStrategyPatch:
+		recreate = false
+	case strategy == kudo.UpdateStrategyRecreate:
+		recreate = true
+	default:
+		return false, &executionError{err: fmt.Errorf("%s %s has unknown %s %q", kind, objMeta.GetName(), kudo.UpdateStrategyAnnotation, strategy), fatal: true}
+	}
+	if recreate && unrecreatableKinds[kind] {
+		return false, &executionError{err: fmt.Errorf("%s %s cannot be recreated, its data would be lost", kind, objMeta.GetName()), fatal: true, eventName: kudo.String("InvalidUpdateStrategy")}
+	}
+	return recreate, nil
+}
+
+// recreateObject deletes the existing object if it differs from the rendered one and creates the rendered one once the
+// existing one is gone, it returns the current state of the object. The object is terminating until it is gone, which
+// can take several executions, e.g. until the pods of a Job are deleted. The recreated object has to become healthy
+// like a created one.
+func recreateObject(step v1alpha1.Step, rendered runtime.Object, existing runtime.Object, key client.ObjectKey, c client.Client) (runtime.Object, error) {
+	if isTerminating(existing) {
+		log.Printf("PlanExecution: Step %s waits for %s to be gone before creating it again", step.Name, prettyPrint(key))
+		return existing, nil
+	}
+
+	renderedJSON, err := apijson.Marshal(rendered)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := patchedFields(existing, renderedJSON)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return existing, nil
+	}
+
+	log.Printf("PlanExecution: Step %s recreates %s, it differs from its template: %s", step.Name, prettyPrint(key), strings.Join(fields, ", "))
+	if err := c.Delete(context.TODO(), existing, deleteOptions(step)...); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	current := emptyObject(rendered)
+	err = c.Get(context.TODO(), key, current)
+	if apierrors.IsNotFound(err) {
+		// objects without finalizers are gone right away
+		if err := c.Create(context.TODO(), rendered); err != nil {
+			return nil, err
+		}
+		return rendered.DeepCopyObject(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// isTerminating returns true if the object is being deleted
+func isTerminating(obj runtime.Object) bool {
+	objMeta, err := meta.Accessor(obj)
+	return err == nil && objMeta.GetDeletionTimestamp() != nil
+}
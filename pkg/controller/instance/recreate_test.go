@@ -0,0 +1,185 @@
+package instance
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// immutableJobClient rejects patches of Jobs like the API server rejects changes of their pod template
+type immutableJobClient struct {
+	client.Client
+}
+
+func (c *immutableJobClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if job, ok := obj.(*batchv1.Job); ok {
+		return apierrors.NewInvalid(schema.GroupKind{Group: "batch", Kind: "Job"}, job.Name, field.ErrorList{field.Invalid(field.NewPath("spec", "template"), nil, "field is immutable")})
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func getMigrationJob(image string, annotations map[string]string) *batchv1.Job {
+	job := getJob("migrate", "default")
+	job.Annotations = annotations
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "migrate", Image: image}}
+	return job
+}
+
+func TestExecuteStepRecreatesChangedJob(t *testing.T) {
+	recreate := map[string]string{kudo.UpdateStrategyAnnotation: kudo.UpdateStrategyRecreate}
+
+	tests := []struct {
+		name            string
+		step            v1alpha1.Step
+		rendered        *batchv1.Job
+		expectedImage   string
+		expectRecreated bool
+		expectErr       bool
+	}{
+		{"changed job is not patched", v1alpha1.Step{Name: "step"}, getMigrationJob("migrate:2", nil), "migrate:1", false, true},
+		{"changed job recreated by step", v1alpha1.Step{Name: "step", Recreate: true}, getMigrationJob("migrate:2", nil), "migrate:2", true, false},
+		{"changed job recreated by annotation", v1alpha1.Step{Name: "step"}, getMigrationJob("migrate:2", recreate), "migrate:2", true, false},
+		{"annotation opts out of recreation", v1alpha1.Step{Name: "step", Recreate: true}, getMigrationJob("migrate:2", map[string]string{kudo.UpdateStrategyAnnotation: kudo.UpdateStrategyPatch}), "migrate:1", false, true},
+		{"unchanged job is kept", v1alpha1.Step{Name: "step", Recreate: true}, getMigrationJob("migrate:1", nil), "migrate:1", false, false},
+	}
+
+	for _, tt := range tests {
+		existing := getMigrationJob("migrate:1", nil)
+		existing.Status.Succeeded = 1
+		testClient := &terminatingClient{Client: &immutableJobClient{fake.NewFakeClientWithScheme(scheme.Scheme, existing)}}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		err := executeStep(context.TODO(), tt.step, state, []runtime.Object{tt.rendered}, nil, clock.RealClock{}, testClient)
+		if tt.expectErr != (err != nil) {
+			t.Errorf("%s: Expecting error to be %v but got %v", tt.name, tt.expectErr, err)
+		}
+
+		current := &batchv1.Job{}
+		if err := testClient.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "migrate"}, current); err != nil {
+			t.Fatalf("%s: Expecting job to exist but got %v", tt.name, err)
+		}
+		if image := current.Spec.Template.Spec.Containers[0].Image; image != tt.expectedImage {
+			t.Errorf("%s: Expecting job with image %s but got %s", tt.name, tt.expectedImage, image)
+		}
+		if recreated := len(testClient.gracePeriods) > 0; recreated != tt.expectRecreated {
+			t.Errorf("%s: Expecting job to be recreated %v but got %v", tt.name, tt.expectRecreated, recreated)
+		}
+		if tt.expectRecreated && state.Status != v1alpha1.ExecutionInProgress {
+			// the recreated job has to complete again
+			t.Errorf("%s: Expecting step to wait for the recreated job but got %v", tt.name, state.Status)
+		}
+	}
+}
+
+func TestExecuteStepWaitsForRecreatedObjectToBeGone(t *testing.T) {
+	existing := getMigrationJob("migrate:1", nil)
+	existing.Finalizers = []string{"foregroundDeletion"}
+	testClient := &terminatingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, existing)}
+	step := v1alpha1.Step{Name: "step", Recreate: true}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	// the deleted job is kept until its pods are gone
+	if err := executeStep(context.TODO(), step, state, []runtime.Object{getMigrationJob("migrate:2", nil)}, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	key := client.ObjectKey{Namespace: "default", Name: "migrate"}
+	current := &batchv1.Job{}
+	if err := testClient.Get(context.TODO(), key, current); err != nil {
+		t.Fatalf("Expecting job to still exist but got %v", err)
+	}
+	// the fake client does not set the deletion timestamp, the API server does
+	deletedAt := metav1.Now()
+	current.DeletionTimestamp = &deletedAt
+	if err := testClient.Client.Update(context.TODO(), current); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if err := executeStep(context.TODO(), step, state, []runtime.Object{getMigrationJob("migrate:2", nil)}, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress || state.Message != "waiting for default/migrate to be gone before creating it again" {
+		t.Errorf("Expecting step to wait for the job to be gone but got %v: %s", state.Status, state.Message)
+	}
+
+	// the job is gone, the step creates it again
+	if err := testClient.Get(context.TODO(), key, current); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	current.Finalizers = nil
+	if err := testClient.Update(context.TODO(), current); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if err := executeStep(context.TODO(), step, state, []runtime.Object{getMigrationJob("migrate:2", nil)}, nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if err := testClient.Get(context.TODO(), key, current); err != nil {
+		t.Fatalf("Expecting job to be created again but got %v", err)
+	}
+	if current.Spec.Template.Spec.Containers[0].Image != "migrate:2" {
+		t.Errorf("Expecting recreated job to have the rendered image but got %s", current.Spec.Template.Spec.Containers[0].Image)
+	}
+}
+
+func TestRecreatingStatefulObjectsFails(t *testing.T) {
+	statefulSet := &appsv1.StatefulSet{}
+	statefulSet.Kind = "StatefulSet"
+	statefulSet.Name = "db"
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Kind = "PersistentVolumeClaim"
+	pvc.Name = "data"
+	pvc.Annotations = map[string]string{kudo.UpdateStrategyAnnotation: kudo.UpdateStrategyRecreate}
+
+	tests := []struct {
+		name string
+		step v1alpha1.Step
+		obj  runtime.Object
+	}{
+		{"statefulset in recreating step", v1alpha1.Step{Name: "step", Recreate: true}, statefulSet},
+		{"pvc with recreate annotation", v1alpha1.Step{Name: "step"}, pvc},
+		{"unknown update strategy", v1alpha1.Step{Name: "step"}, getMigrationJob("migrate:1", map[string]string{kudo.UpdateStrategyAnnotation: "replace"})},
+	}
+
+	for _, tt := range tests {
+		_, err := isRecreated(tt.step, tt.obj)
+		if err == nil {
+			t.Errorf("%s: Expecting an error but got none", tt.name)
+			continue
+		}
+		if statusForError(err) != v1alpha1.ExecutionFatalError {
+			t.Errorf("%s: Expecting a fatal error but got %v", tt.name, err)
+		}
+	}
+}
+
+func TestRecreatingSecretDoesNotLogData(t *testing.T) {
+	live := getSecret("secret")
+	live.StringData = nil
+	rendered := live.DeepCopy()
+	rendered.Annotations = map[string]string{kudo.UpdateStrategyAnnotation: kudo.UpdateStrategyRecreate}
+	rendered.Data = map[string][]byte{"password": []byte("n3w-" + secretValue)}
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, live)
+
+	output := captureLog(func() {
+		if _, err := recreateObject(v1alpha1.Step{Name: "step"}, rendered, live, client.ObjectKey{Namespace: "default", Name: "secret"}, testClient); err != nil {
+			t.Errorf("Expecting no error but got %v", err)
+		}
+	})
+	if !strings.Contains(output, "data.password: "+v1alpha1.SensitiveValueMask+" -> "+v1alpha1.SensitiveValueMask) {
+		t.Errorf("Expecting the changed field to be logged masked but got %s", output)
+	}
+	assertNoSecret(t, "recreate", output)
+}
@@ -0,0 +1,105 @@
+package instance
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// redacted returns a copy of the object that is safe to be logged, values of the data of Secrets and ConfigMaps are
+// masked, and so is everything but the metadata of objects annotated as sensitive
+func redacted(obj runtime.Object) (map[string]interface{}, error) {
+	var content map[string]interface{}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		content = u.DeepCopy().Object
+	} else {
+		var err error
+		if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	if isSensitiveContent(content) {
+		for field := range content {
+			if field != "apiVersion" && field != "kind" && field != "metadata" {
+				content[field] = v1alpha1.SensitiveValueMask
+			}
+		}
+		return content, nil
+	}
+
+	if isDataObject(obj) {
+		for _, field := range []string{"data", "stringData", "binaryData"} {
+			// keys are kept, they tell what the object contains
+			if values, ok := content[field].(map[string]interface{}); ok {
+				for key := range values {
+					values[key] = v1alpha1.SensitiveValueMask
+				}
+			}
+		}
+	}
+	return content, nil
+}
+
+// isSensitiveContent returns true if the content of an object is annotated as sensitive
+func isSensitiveContent(content map[string]interface{}) bool {
+	u := &unstructured.Unstructured{Object: content}
+	return u.GetAnnotations()[kudo.SensitiveAnnotation] == "true"
+}
+
+// isMaskedField returns true if redacted masks the field at the path, e.g. `data.password` of a Secret, the path is
+// the one of a field listed by patchedFields
+func isMaskedField(path string, sensitive bool, dataObject bool) bool {
+	field := strings.SplitN(path, ".", 2)[0]
+	if sensitive {
+		return field != "apiVersion" && field != "kind" && field != "metadata"
+	}
+	return dataObject && (field == "data" || field == "stringData" || field == "binaryData")
+}
+
+// isDataObject returns true for Secrets and ConfigMaps, typed objects do not always have their kind set
+func isDataObject(obj runtime.Object) bool {
+	switch obj.(type) {
+	case *corev1.Secret, *corev1.ConfigMap:
+		return true
+	}
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	return gvk.Group == "" && (gvk.Kind == "Secret" || gvk.Kind == "ConfigMap")
+}
+
+// loggable returns the object as compact JSON with its sensitive content redacted, see redacted
+func loggable(obj runtime.Object) string {
+	content, err := redacted(obj)
+	if err != nil {
+		// better to log nothing than the secret
+		return "<object cannot be logged>"
+	}
+	var s strings.Builder
+	encoder := json.NewEncoder(&s)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(content)
+	return strings.TrimSpace(s.String())
+}
+
+// redactedYAML returns the objects as YAML documents with their sensitive content redacted, see redacted
+func redactedYAML(objs []runtime.Object) string {
+	docs := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		content, err := redacted(obj)
+		if err == nil {
+			var doc []byte
+			if doc, err = sigsyaml.Marshal(content); err == nil {
+				docs = append(docs, string(doc))
+				continue
+			}
+		}
+		docs = append(docs, "# object cannot be logged\n")
+	}
+	return strings.Join(docs, "---\n")
+}
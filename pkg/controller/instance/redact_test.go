@@ -0,0 +1,109 @@
+package instance
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const secretValue = "s3cr3t"
+
+func getSecret(name string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte(secretValue)},
+		StringData: map[string]string{"token": secretValue},
+	}
+}
+
+// captureLog returns everything logged by the standard logger while running f
+func captureLog(f func()) string {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	f()
+	return out.String()
+}
+
+// assertNoSecret fails the test if the output contains the secret value in plain text or base64 encoded
+func assertNoSecret(t *testing.T, name string, output string) {
+	if strings.Contains(output, secretValue) || strings.Contains(output, base64.StdEncoding.EncodeToString([]byte(secretValue))) {
+		t.Errorf("%s: Expecting secret value not to be logged but got %s", name, output)
+	}
+}
+
+func TestExecuteStepDoesNotLogSecrets(t *testing.T) {
+	cm := getConfigMap("config", "default", nil)
+	cm.Data = map[string]string{"password": secretValue}
+	sensitive := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Database",
+		"metadata":   map[string]interface{}{"name": "db", "namespace": "default", "annotations": map[string]interface{}{kudo.SensitiveAnnotation: "true"}},
+		"spec":       map[string]interface{}{"password": secretValue},
+	}}
+
+	tests := []struct {
+		name string
+		step kudov1alpha1.Step
+		obj  runtime.Object
+	}{
+		{"secret", kudov1alpha1.Step{Name: "step"}, getSecret("secret")},
+		{"config map", kudov1alpha1.Step{Name: "step"}, cm},
+		{"deleted secret", kudov1alpha1.Step{Name: "step", Delete: true}, getSecret("secret")},
+		{"sensitive object", kudov1alpha1.Step{Name: "step"}, sensitive},
+	}
+
+	for _, tt := range tests {
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+		state := &kudov1alpha1.StepStatus{Name: "step", Status: kudov1alpha1.ExecutionPending}
+
+		output := captureLog(func() {
+			_ = executeStep(context.TODO(), tt.step, state, []runtime.Object{tt.obj}, nil, clock.RealClock{}, testClient)
+		})
+		if !strings.Contains(output, kudov1alpha1.SensitiveValueMask) {
+			t.Errorf("%s: Expecting the object to be logged redacted but got %s", tt.name, output)
+		}
+		assertNoSecret(t, tt.name, output)
+	}
+}
+
+func TestApplyConventionsDebugOutputDoesNotLogSecrets(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = kudov1alpha1.AddToScheme(s)
+	owner := &kudov1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}}
+	meta := metadata{InstanceName: "instance", Namespace: "default", OperatorName: "operator", PlanName: "deploy", PhaseName: "phase", StepName: "step"}
+
+	hashed := getSecret("hashed")
+	hashed.Annotations = map[string]string{kudo.HashSuffixAnnotation: "true"}
+	templates := map[string]string{
+		"secret.yaml": getResourceAsString(getSecret("secret")),
+		"hashed.yaml": getResourceAsString(hashed),
+	}
+
+	var messages []string
+	enhancer := &kustomizeEnhancer{scheme: s, log: recordingLogger{verbosity: 1, messages: &messages}}
+	if _, err := enhancer.applyConventionsToTemplates(templates, meta, owner); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	output := strings.Join(messages, "\n")
+	if !strings.Contains(output, "name: instance-secret") || !strings.Contains(output, "password: "+kudov1alpha1.SensitiveValueMask) {
+		t.Errorf("Expecting the secret to be logged redacted but got %s", output)
+	}
+	assertNoSecret(t, "debug output", output)
+}
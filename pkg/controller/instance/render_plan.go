@@ -0,0 +1,86 @@
+package instance
+
+import (
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RenderedStep holds the objects a step of a plan applies or deletes, pre and post tasks included
+type RenderedStep struct {
+	Phase   string
+	Step    string
+	Delete  bool
+	Objects []runtime.Object
+}
+
+// RenderPlan renders all the objects of the plan of the operator version for an instance of the name with the
+// parameters, the same way executing the plan would, without a cluster. The parameters override the defaults of the
+// operator version. The objects are labeled and annotated with the label keys, like the controller configured with them
+// labels the objects it applies. Steps are returned in the order they are defined in, steps skipped by their task conditions are left
+// out.
+//
+// The instance does not exist, so the owner references of the objects name it but have an empty UID and templates using
+// `.InstanceUID` fail to render. There are no cluster variables or generated values, and tasks naming another owner than
+// the instance fail, their owner cannot be read.
+func RenderPlan(scheme *runtime.Scheme, ov *v1alpha1.OperatorVersion, planName string, instanceName string, namespace string, params map[string]string, labelKeys LabelKeys) ([]RenderedStep, error) {
+	instance, err := offlineInstance(ov, planName, instanceName, namespace, params)
+	if err != nil {
+		return nil, err
+	}
+	planStatus := instance.Status.PlanStatus[planName]
+
+	plan, metadata, err := preparePlanExecution(instance, ov, &planStatus)
+	if err != nil {
+		return nil, err
+	}
+	metadata.labelKeys = labelKeys
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	// objects kustomize cannot handle get the conventions applied directly instead of failing the render
+	resources, err := prepareKubeResources(plan, metadata, &kustomizeEnhancer{scheme: scheme, fallback: true})
+	if err != nil {
+		return nil, err
+	}
+	return renderedSteps(plan, resources), nil
+}
+
+// offlineInstance returns an instance of the operator version that exists only in memory with the plan started
+func offlineInstance(ov *v1alpha1.OperatorVersion, planName string, instanceName string, namespace string, params map[string]string) (*v1alpha1.Instance, error) {
+	instance := &v1alpha1.Instance{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "Instance"},
+		ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: namespace},
+		Spec: v1alpha1.InstanceSpec{
+			OperatorVersion: corev1.ObjectReference{Name: ov.Name},
+			Parameters:      params,
+		},
+	}
+	instance.EnsurePlanStatusInitialized(ov)
+	if err := instance.StartPlanExecution(planName, ov); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// renderedSteps collects the objects of the steps in the order the steps are defined in
+func renderedSteps(plan *activePlan, resources *planResources) []RenderedStep {
+	steps := []RenderedStep{}
+	for _, ph := range plan.Spec.Phases {
+		phaseRes := resources.PhaseResources[ph.Name]
+		phaseState, _ := getPhaseFromStatus(ph.Name, plan.PlanStatus)
+		for _, st := range ph.Steps {
+			if stepState, _ := getStepFromStatus(st.Name, phaseState); stepState != nil && isFinished(stepState.Status) {
+				continue
+			}
+			rendered := RenderedStep{Phase: ph.Name, Step: st.Name, Delete: st.Delete, Objects: []runtime.Object{}}
+			for _, stage := range [][]runtime.Object{phaseRes.StepPreResources[st.Name], phaseRes.StepResources[st.Name], phaseRes.StepPostResources[st.Name]} {
+				rendered.Objects = append(rendered.Objects, stage...)
+			}
+			steps = append(steps, rendered)
+		}
+	}
+	return steps
+}
@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const renderedConfig = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  greeting: {{ .Params.GREETING }}
+`
+
+const renderedDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: {{ .Params.REPLICAS }}
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx
+`
+
+func TestRenderPlan(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = appsv1.AddToScheme(s)
+
+	ov := &v1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-1.0", Namespace: "default"},
+		Spec: v1alpha1.OperatorVersionSpec{
+			Operator: corev1.ObjectReference{Name: "operator"},
+			Version:  "1.0",
+			Parameters: []v1alpha1.Parameter{
+				{Name: "GREETING", Default: kudo.String("hello")},
+				{Name: "REPLICAS", Default: kudo.String("1")},
+			},
+			Templates: map[string]string{"config.yaml": renderedConfig, "deployment.yaml": renderedDeployment},
+			Tasks: map[string]v1alpha1.TaskSpec{
+				"config": {Resources: []string{"config.yaml"}},
+				"app":    {Resources: []string{"deployment.yaml"}},
+			},
+			Plans: map[string]v1alpha1.Plan{"deploy": {Strategy: "serial", Phases: []v1alpha1.Phase{{
+				Name: "main", Strategy: "serial", Steps: []v1alpha1.Step{
+					{Name: "config", Tasks: []string{"config"}},
+					{Name: "app", Tasks: []string{"app"}},
+				},
+			}}}},
+		},
+	}
+
+	steps, err := RenderPlan(s, ov, "deploy", "instance", "ns", map[string]string{"REPLICAS": "3"}, LabelKeys{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if len(steps) != 2 || steps[0].Step != "config" || steps[1].Step != "app" || len(steps[0].Objects) != 1 || len(steps[1].Objects) != 1 {
+		t.Fatalf("Expecting steps config and app with one object each but got %+v", steps)
+	}
+
+	cm, ok := steps[0].Objects[0].(*corev1.ConfigMap)
+	if !ok {
+		t.Fatalf("Expecting a config map but got %T", steps[0].Objects[0])
+	}
+	if cm.Name != "instance-config" || cm.Namespace != "ns" {
+		t.Errorf("Expecting config map ns/instance-config but got %s/%s", cm.Namespace, cm.Name)
+	}
+	if cm.Data["greeting"] != "hello" {
+		t.Errorf("Expecting default greeting hello but got %s", cm.Data["greeting"])
+	}
+	if cm.Labels[kudo.InstanceLabel] != "instance" || cm.Labels[kudo.OperatorLabel] != "operator" {
+		t.Errorf("Expecting labels of the instance and operator but got %v", cm.Labels)
+	}
+
+	// the instance does not exist, the owner reference names it without a UID
+	owners := cm.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Kind != "Instance" || owners[0].Name != "instance" || owners[0].UID != "" {
+		t.Errorf("Expecting owner reference to instance without UID but got %+v", owners)
+	}
+
+	deployment, ok := steps[1].Objects[0].(*appsv1.Deployment)
+	if !ok {
+		t.Fatalf("Expecting a deployment but got %T", steps[1].Objects[0])
+	}
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("Expecting 3 replicas from the parameters but got %d", *deployment.Spec.Replicas)
+	}
+
+	if _, err := RenderPlan(s, ov, "backup", "instance", "ns", nil, LabelKeys{}); err == nil {
+		t.Errorf("Expecting an error rendering a plan that does not exist")
+	}
+}
@@ -0,0 +1,30 @@
+package instance
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+)
+
+// retryResource records a failed attempt to apply an object of a step with ResourceRetries, it returns a fatal error
+// once the object failed more times in a row than the step allows and nil while the object is within its budget
+// fatal errors are returned as they are, retrying them would not help
+func retryResource(step v1alpha1.Step, state *v1alpha1.StepStatus, objKey string, err error) error {
+	if exErr := asExecutionError(err); exErr != nil && exErr.Fatal() {
+		return err
+	}
+
+	if state.ResourceAttempts == nil {
+		state.ResourceAttempts = make(map[string]int32)
+	}
+	state.ResourceAttempts[objKey]++
+	attempts := state.ResourceAttempts[objKey]
+	if attempts > step.ResourceRetries {
+		return &executionError{err: fmt.Errorf("applying %s in step %s failed %d times in a row: %v", objKey, step.Name, attempts, err), fatal: true, eventName: kudo.String("ResourceRetriesExhausted")}
+	}
+
+	log.Printf("PlanExecution: Applying %s in step %s failed %d times, %d failures in a row are allowed: %v", objKey, step.Name, attempts, step.ResourceRetries, err)
+	return nil
+}
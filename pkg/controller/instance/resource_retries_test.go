@@ -0,0 +1,99 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// flakyCreateClient fails to create the object with the given name the given number of times
+type flakyCreateClient struct {
+	client.Client
+	flaky    string
+	failures int
+}
+
+func (c *flakyCreateClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if obj.(metav1.Object).GetName() == c.flaky && c.failures != 0 {
+		c.failures--
+		return errors.Errorf("creating %s failed", c.flaky)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func stepConfigMaps() []runtime.Object {
+	return []runtime.Object{getConfigMap("one", "default", nil), getConfigMap("flaky", "default", nil), getConfigMap("three", "default", nil)}
+}
+
+func TestExecuteStepRetriesFlakyResource(t *testing.T) {
+	recorder := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+	testClient := &flakyCreateClient{Client: recorder, flaky: "flaky", failures: 2}
+	step := v1alpha1.Step{Name: "step", ResourceRetries: 2}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	for attempt := int32(1); attempt <= 2; attempt++ {
+		err := executeStep(context.TODO(), step, state, stepConfigMaps(), nil, clock.RealClock{}, testClient)
+		if err == nil || statusForError(err) != v1alpha1.ErrorStatus {
+			t.Fatalf("attempt %d: Expecting recoverable error but got %v", attempt, err)
+		}
+		if state.ResourceAttempts["ConfigMap/default/flaky"] != attempt {
+			t.Errorf("attempt %d: Expecting %d failed attempts of the flaky object but got %v", attempt, attempt, state.ResourceAttempts)
+		}
+		if _, ok := state.ResourceAttempts["ConfigMap/default/three"]; ok {
+			t.Errorf("attempt %d: Expecting no failed attempts of the other objects but got %v", attempt, state.ResourceAttempts)
+		}
+	}
+
+	// the objects following the flaky one are created with the first execution
+	if len(recorder.created) != 2 || recorder.created[0] != "one" || recorder.created[1] != "three" {
+		t.Errorf("Expecting the other objects to be created once but got %v", recorder.created)
+	}
+
+	if err := executeStep(context.TODO(), step, state, stepConfigMaps(), nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error once the flaky object is created but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step to be complete but got %s", state.Status)
+	}
+	if len(state.ResourceAttempts) != 0 {
+		t.Errorf("Expecting failed attempts to be cleared once the object is applied but got %v", state.ResourceAttempts)
+	}
+}
+
+func TestExecuteStepFailsWhenResourceExhaustsRetries(t *testing.T) {
+	testClient := &flakyCreateClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme), flaky: "flaky", failures: -1}
+	step := v1alpha1.Step{Name: "step", ResourceRetries: 1}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	if err := executeStep(context.TODO(), step, state, stepConfigMaps(), nil, clock.RealClock{}, testClient); statusForError(err) != v1alpha1.ErrorStatus {
+		t.Fatalf("Expecting recoverable error of the first failure but got %v", err)
+	}
+	err := executeStep(context.TODO(), step, state, stepConfigMaps(), nil, clock.RealClock{}, testClient)
+	if statusForError(err) != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting fatal error once the object exhausted its retries but got %v", err)
+	}
+}
+
+func TestExecuteStepWithoutResourceRetriesStopsAtFirstFailure(t *testing.T) {
+	recorder := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+	testClient := &flakyCreateClient{Client: recorder, flaky: "flaky", failures: 1}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+	if err := executeStep(context.TODO(), v1alpha1.Step{Name: "step"}, state, stepConfigMaps(), nil, clock.RealClock{}, testClient); err == nil {
+		t.Fatal("Expecting error of the failed object but got none")
+	}
+	if len(recorder.created) != 1 || recorder.created[0] != "one" {
+		t.Errorf("Expecting only the objects before the failed one to be created but got %v", recorder.created)
+	}
+	if state.ResourceAttempts != nil {
+		t.Errorf("Expecting no failed attempts to be tracked without retries but got %v", state.ResourceAttempts)
+	}
+}
@@ -0,0 +1,111 @@
+package instance
+
+import (
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ExecutionRecord describes a plan execution that finished, either completed or failed fatally
+type ExecutionRecord struct {
+	Namespace       string
+	Instance        string
+	Operator        string
+	OperatorVersion string
+	Plan            string
+	Status          v1alpha1.ExecutionStatus
+	// Error is the error the plan failed with, empty for completed plans
+	Error string
+	// ParameterChanges are the parameters that changed since the last successfully finished plan, see PlanStatus
+	ParameterChanges []v1alpha1.ParameterChange
+	// StartedAt is the time the first step of the plan started, zero if no step started
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   time.Duration
+	// ResourceDigests are sha256 digests of the objects rendered by the last execution of the plan, keyed by kind,
+	// namespace and name of the object
+	ResourceDigests map[string]string
+}
+
+// ResultSink persists records of finished plan executions outside of the instance, e.g. in a ConfigMap or a database,
+// so that they are kept for longer than the status of the instance can hold them
+type ResultSink interface {
+	// Persist stores the record, errors are logged and do not affect the execution
+	Persist(record ExecutionRecord) error
+}
+
+// ResultSinkFunc is an adapter allowing to use ordinary functions as ResultSink
+type ResultSinkFunc func(record ExecutionRecord) error
+
+// Persist calls f(record)
+func (f ResultSinkFunc) Persist(record ExecutionRecord) error {
+	return f(record)
+}
+
+// persistResult hands the record of the execution to the sink once the plan becomes terminal, nothing is persisted when
+// no sink is configured or the plan was terminal already
+func persistResult(metadata *executionMetadata, statusBefore v1alpha1.ExecutionStatus, newState *v1alpha1.PlanStatus, execErr error, now time.Time) {
+	if metadata.resultSink == nil || statusBefore.IsTerminal() || !newState.Status.IsTerminal() {
+		return
+	}
+	record := ExecutionRecord{
+		Namespace:        metadata.instanceNamespace,
+		Instance:         metadata.instanceName,
+		Operator:         metadata.operatorName,
+		OperatorVersion:  metadata.operatorVersion,
+		Plan:             newState.Name,
+		Status:           newState.Status,
+		ParameterChanges: newState.ParameterChanges,
+		StartedAt:        planStartedAt(newState),
+		FinishedAt:       now,
+		ResourceDigests:  metadata.renderedDigests,
+	}
+	if execErr != nil {
+		record.Error = execErr.Error()
+	}
+	if !record.StartedAt.IsZero() {
+		record.Duration = now.Sub(record.StartedAt)
+	}
+	if err := metadata.resultSink.Persist(record); err != nil {
+		log.Printf("PlanExecution: Error persisting result of plan %s of instance %s/%s: %v", newState.Name, metadata.instanceNamespace, metadata.instanceName, err)
+	}
+}
+
+// planStartedAt returns the time the first step of the plan started, the plan itself does not track when it started
+func planStartedAt(planState *v1alpha1.PlanStatus) time.Time {
+	var started time.Time
+	for _, ph := range planState.Phases {
+		for _, st := range ph.Steps {
+			if !st.StartedAt.IsZero() && (started.IsZero() || st.StartedAt.Time.Before(started)) {
+				started = st.StartedAt.Time
+			}
+		}
+	}
+	return started
+}
+
+// resourceDigests returns sha256 digests of all the objects rendered for the plan, keyed by kind, namespace and name
+func resourceDigests(resources *planResources) map[string]string {
+	digests := make(map[string]string)
+	add := func(objs []runtime.Object) {
+		for _, obj := range objs {
+			digest, err := objectDigest(obj)
+			if err != nil {
+				continue
+			}
+			key, _ := client.ObjectKeyFromObject(obj)
+			digests[appliedKey(obj, key)] = digest
+		}
+	}
+	for _, phase := range resources.PhaseResources {
+		for _, byStep := range []map[string][]runtime.Object{phase.StepPreResources, phase.StepResources, phase.StepPostResources} {
+			for _, objs := range byStep {
+				add(objs)
+			}
+		}
+	}
+	return digests
+}
@@ -0,0 +1,104 @@
+package instance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeResultSink keeps the persisted records in memory
+type fakeResultSink struct {
+	records []ExecutionRecord
+	err     error
+}
+
+func (s *fakeResultSink) Persist(record ExecutionRecord) error {
+	s.records = append(s.records, record)
+	return s.err
+}
+
+func TestExecutePlanPersistsResult(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	changes := []v1alpha1.ParameterChange{{Name: "REPLICAS", Old: kudo.String("1"), New: kudo.String("3")}}
+
+	tests := []struct {
+		name            string
+		template        string
+		sinkErr         error
+		expectedStatus  v1alpha1.ExecutionStatus
+		expectedError   bool
+		expectedDigests int
+	}{
+		{"completed plan", getResourceAsString(getConfigMap("config", "default", nil)), nil, v1alpha1.ExecutionComplete, false, 1},
+		{"failed plan", "{{ .Params.REPLICAS", nil, v1alpha1.ExecutionFatalError, true, 0},
+		{"failing sink does not fail the plan", getResourceAsString(getConfigMap("config", "default", nil)), errors.New("store unavailable"), v1alpha1.ExecutionComplete, false, 1},
+	}
+
+	for _, tt := range tests {
+		sink := &fakeResultSink{err: tt.sinkErr}
+		plan := generatedValuesPlan("deploy", tt.template, nil)
+		plan.PlanStatus.Status = v1alpha1.ExecutionInProgress
+		plan.PlanStatus.ParameterChanges = changes
+		plan.PlanStatus.Phases[0].Status = v1alpha1.ExecutionInProgress
+		plan.PlanStatus.Phases[0].Steps[0].Status = v1alpha1.ExecutionInProgress
+		plan.PlanStatus.Phases[0].Steps[0].StartedAt = metav1.NewTime(now.Add(-time.Minute))
+		meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", operatorName: "kafka", operatorVersion: "1.0.0", clock: clock.NewFakeClock(now), resultSink: sink}
+
+		_, err := executePlan(plan, meta, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{})
+		if tt.expectedError != (err != nil) {
+			t.Errorf("%s: Expecting error to be %v but got %v", tt.name, tt.expectedError, err)
+		}
+		if len(sink.records) != 1 {
+			t.Fatalf("%s: Expecting one record but got %d", tt.name, len(sink.records))
+		}
+		record := sink.records[0]
+		if record.Instance != "instance" || record.Namespace != "default" || record.Operator != "kafka" || record.OperatorVersion != "1.0.0" || record.Plan != "deploy" {
+			t.Errorf("%s: Expecting record of plan deploy of instance default/instance but got %+v", tt.name, record)
+		}
+		if record.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting status %v but got %v", tt.name, tt.expectedStatus, record.Status)
+		}
+		if tt.expectedError != (record.Error != "") {
+			t.Errorf("%s: Expecting record error to be set %v but got %q", tt.name, tt.expectedError, record.Error)
+		}
+		if record.Duration != time.Minute || !record.FinishedAt.Equal(now) {
+			t.Errorf("%s: Expecting plan to finish at %v after a minute but got %v after %v", tt.name, now, record.FinishedAt, record.Duration)
+		}
+		if len(record.ParameterChanges) != 1 || record.ParameterChanges[0].Name != "REPLICAS" {
+			t.Errorf("%s: Expecting parameter changes %v but got %v", tt.name, changes, record.ParameterChanges)
+		}
+		if len(record.ResourceDigests) != tt.expectedDigests || (tt.expectedDigests > 0 && len(record.ResourceDigests["ConfigMap/default/config"]) != 64) {
+			t.Errorf("%s: Expecting %d resource digests but got %v", tt.name, tt.expectedDigests, record.ResourceDigests)
+		}
+
+		// the plan is terminal already, it is not persisted again
+		if _, err := executePlan(plan, meta, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{}); err != nil && !tt.expectedError {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if len(sink.records) != 1 {
+			t.Errorf("%s: Expecting the record to be persisted once but got %d records", tt.name, len(sink.records))
+		}
+	}
+}
+
+func TestExecutePlanInProgressIsNotPersisted(t *testing.T) {
+	sink := &fakeResultSink{}
+	plan := generatedValuesPlan("deploy", getResourceAsString(getDeployment("app", "default", 1)), nil)
+	plan.PlanStatus.Status = v1alpha1.ExecutionPending
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default", resultSink: sink}
+
+	result, err := executePlan(plan, meta, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if result.Status.IsTerminal() || len(sink.records) != 0 {
+		t.Errorf("Expecting plan waiting for the deployment not to be persisted but got %v with %d records", result.Status, len(sink.records))
+	}
+}
@@ -0,0 +1,122 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/health"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// executeScaleStep scales the workloads of a step with Scale instead of applying them, see v1alpha1.Step
+// scaling down records the replica count of each workload in the plan status before setting it to zero, a count that
+// is already recorded is kept as the workload is then already scaled down by an earlier execution of the plan. The
+// step is complete once no pods of the workloads are left. Restoring sets the recorded count, the step is complete once
+// the workloads are healthy and the counts are removed from the status then.
+func executeScaleStep(step v1alpha1.Step, state *v1alpha1.StepStatus, planState *v1alpha1.PlanStatus, resources []runtime.Object, c client.Client) error {
+	if !isInProgress(state.Status) {
+		return nil
+	}
+	state.Status = v1alpha1.ExecutionInProgress
+	state.Message = ""
+
+	allDone := true
+	var restored []string
+	for _, r := range resources {
+		kind := r.GetObjectKind().GroupVersionKind().Kind
+		if kind != "Deployment" && kind != "StatefulSet" {
+			return &executionError{err: fmt.Errorf("step %s can scale only Deployments and StatefulSets but its tasks render %s", step.Name, kind), fatal: true, eventName: kudo.String("InvalidScaleStep")}
+		}
+
+		key, _ := client.ObjectKeyFromObject(r)
+		objKey := appliedKey(r, key)
+		existing := emptyObject(r)
+		err := c.Get(context.TODO(), key, existing)
+		if apierrors.IsNotFound(err) {
+			log.Printf("PlanExecution: %s does not exist, step %s does not scale it", objKey, step.Name)
+			restored = append(restored, objKey)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(existing)
+		if err != nil {
+			return err
+		}
+
+		switch step.Scale {
+		case v1alpha1.ScaleDown:
+			if _, ok := planState.ScaledReplicas[objKey]; !ok {
+				replicas, found, err := unstructured.NestedInt64(content, "spec", "replicas")
+				if err != nil {
+					return err
+				}
+				if !found {
+					// the default of the API server
+					replicas = 1
+				}
+				if planState.ScaledReplicas == nil {
+					planState.ScaledReplicas = make(map[string]int32)
+				}
+				planState.ScaledReplicas[objKey] = int32(replicas)
+				log.Printf("PlanExecution: Scaling %s with %d replicas to zero in step %s", objKey, replicas, step.Name)
+			}
+			if err := scaleWorkload(existing, 0, c); err != nil {
+				return err
+			}
+			// the status of the workload is not updated by the patch, the pods left are reported by the next read
+			if pods, _, _ := unstructured.NestedInt64(content, "status", "replicas"); pods > 0 {
+				allDone = false
+				if state.Message == "" {
+					state.Message = fmt.Sprintf("waiting for %s/%s to scale down, %d pods left", key.Namespace, key.Name, pods)
+				}
+			}
+
+		case v1alpha1.ScaleRestore:
+			replicas, ok := planState.ScaledReplicas[objKey]
+			if !ok {
+				log.Printf("PlanExecution: %s was not scaled down, step %s does not restore it", objKey, step.Name)
+				continue
+			}
+			if err := scaleWorkload(existing, replicas, c); err != nil {
+				return err
+			}
+			if err := health.IsHealthy(c, existing); err != nil {
+				allDone = false
+				if state.Message == "" {
+					state.Message = fmt.Sprintf("waiting for %s/%s to scale back to %d replicas: %v", key.Namespace, key.Name, replicas, err)
+				}
+				continue
+			}
+			restored = append(restored, objKey)
+
+		default:
+			return &executionError{err: fmt.Errorf("step %s has unknown scale mode %q", step.Name, step.Scale), fatal: true, eventName: kudo.String("InvalidScaleStep")}
+		}
+	}
+
+	if allDone {
+		if step.Scale == v1alpha1.ScaleRestore {
+			for _, objKey := range restored {
+				delete(planState.ScaledReplicas, objKey)
+			}
+		}
+		state.Status = v1alpha1.ExecutionComplete
+	}
+	return nil
+}
+
+// scaleWorkload patches the replica count of the workload
+func scaleWorkload(workload runtime.Object, replicas int32, c client.Client) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	return c.Patch(context.TODO(), workload, client.ConstantPatch(types.MergePatchType, patch))
+}
@@ -0,0 +1,128 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func maintenancePlan() *activePlan {
+	step := func(name string, scale v1alpha1.ScaleMode) v1alpha1.Phase {
+		return v1alpha1.Phase{Name: name, Strategy: v1alpha1.Serial, Steps: []v1alpha1.Step{{Name: name, Tasks: []string{"app"}, Scale: scale}}}
+	}
+	status := func(name string) v1alpha1.PhaseStatus {
+		return v1alpha1.PhaseStatus{Name: name, Status: v1alpha1.ExecutionPending, Steps: []v1alpha1.StepStatus{{Name: name, Status: v1alpha1.ExecutionPending}}}
+	}
+	return &activePlan{
+		Name: "maintenance",
+		Spec: &v1alpha1.Plan{
+			Strategy: v1alpha1.Serial,
+			Phases:   []v1alpha1.Phase{step("quiesce", v1alpha1.ScaleDown), step("resume", v1alpha1.ScaleRestore)},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "maintenance",
+			Status: v1alpha1.ExecutionPending,
+			Phases: []v1alpha1.PhaseStatus{status("quiesce"), status("resume")},
+		},
+		Tasks: map[string]v1alpha1.TaskSpec{"app": {Resources: []string{"deployment.yaml"}}, "missing": {Resources: []string{"missing.yaml"}}},
+		// the template does not say how many replicas the workload has, the live object does
+		Templates: map[string]string{"deployment.yaml": getResourceAsString(getDeployment("web", "default", 1)), "missing.yaml": getResourceAsString(getDeployment("missing", "default", 1))},
+	}
+}
+
+// setDeploymentStatus simulates the deployment controller reporting the given number of ready pods
+func setDeploymentStatus(t *testing.T, c client.Client, pods int32) {
+	d := &appsv1.Deployment{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, d); err != nil {
+		t.Fatal(err)
+	}
+	d.Status.Replicas, d.Status.ReadyReplicas, d.Status.UpdatedReplicas, d.Status.AvailableReplicas = pods, pods, pods, pods
+	if err := c.Update(context.TODO(), d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScaleDownAndRestoreWorkload(t *testing.T) {
+	live := getDeployment("web", "default", 5)
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, live)
+	setDeploymentStatus(t, testClient, 5)
+	plan := maintenancePlan()
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	execute := func() {
+		result, err := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Fatalf("Expecting no error but got %v", err)
+		}
+		plan.PlanStatus = result.PlanStatus
+	}
+	replicas := func() int32 {
+		d := &appsv1.Deployment{}
+		if err := testClient.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, d); err != nil {
+			t.Fatal(err)
+		}
+		return *d.Spec.Replicas
+	}
+
+	execute()
+	if replicas() != 0 || plan.PlanStatus.ScaledReplicas["Deployment/default/web"] != 5 {
+		t.Fatalf("Expecting workload scaled to zero with 5 replicas recorded but got %d and %v", replicas(), plan.PlanStatus.ScaledReplicas)
+	}
+	if plan.PlanStatus.Phases[0].Status == v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting scale down to wait for the pods to be gone")
+	}
+
+	// executing the step again does not record the current count of zero
+	execute()
+	if plan.PlanStatus.ScaledReplicas["Deployment/default/web"] != 5 {
+		t.Errorf("Expecting the original replica count to be kept but got %v", plan.PlanStatus.ScaledReplicas)
+	}
+
+	setDeploymentStatus(t, testClient, 0)
+	execute()
+	if plan.PlanStatus.Phases[0].Status != v1alpha1.ExecutionComplete {
+		t.Fatalf("Expecting scale down to be complete once the pods are gone but got %s", plan.PlanStatus.Phases[0].Status)
+	}
+	if replicas() != 5 {
+		t.Errorf("Expecting workload scaled back to 5 replicas but got %d", replicas())
+	}
+	if plan.PlanStatus.Status == v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting restore to wait for the workload to be healthy")
+	}
+
+	setDeploymentStatus(t, testClient, 5)
+	execute()
+	if plan.PlanStatus.Status != v1alpha1.ExecutionComplete {
+		t.Fatalf("Expecting plan to be complete once the workload is healthy but got %s", plan.PlanStatus.Status)
+	}
+	if len(plan.PlanStatus.ScaledReplicas) != 0 {
+		t.Errorf("Expecting restored replica count to be removed from the status but got %v", plan.PlanStatus.ScaledReplicas)
+	}
+}
+
+func TestScaleMissingWorkload(t *testing.T) {
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+	plan := maintenancePlan()
+	for i := range plan.Spec.Phases {
+		plan.Spec.Phases[i].Steps[0].Tasks = []string{"missing"}
+	}
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	result, err := executePlan(plan, meta, testClient, &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if result.Status != v1alpha1.ExecutionComplete || len(result.ScaledReplicas) != 0 {
+		t.Errorf("Expecting missing workload to be skipped but got status %s and %v", result.Status, result.ScaledReplicas)
+	}
+	d := &appsv1.Deployment{}
+	if err := testClient.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "missing"}, d); err == nil {
+		t.Errorf("Expecting missing workload not to be created")
+	}
+}
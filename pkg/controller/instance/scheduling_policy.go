@@ -0,0 +1,223 @@
+package instance
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+
+	"github.com/kudobuilder/kudo/pkg/util/template"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/pkg/fs"
+	"sigs.k8s.io/kustomize/pkg/patch"
+	ktypes "sigs.k8s.io/kustomize/pkg/types"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// SchedulingPolicyVariable is the cluster variable holding the scheduling policy merged into all the pods of all the
+// instances, a YAML map with `tolerations`, `nodeSelector` and `affinity` of a pod spec, e.g. to tolerate the taints
+// of dedicated nodes of the cluster without a parameter in every operator
+const SchedulingPolicyVariable = "SchedulingPolicy"
+
+// SchedulingPolicy is the part of a pod spec that decides where the pod runs
+type SchedulingPolicy struct {
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Affinity     *corev1.Affinity    `json:"affinity,omitempty"`
+}
+
+// parseSchedulingPolicy parses the scheduling policy from the cluster variables, nil if there is none
+func parseSchedulingPolicy(clusterVariables map[string]string) (*SchedulingPolicy, error) {
+	value := clusterVariables[SchedulingPolicyVariable]
+	if value == "" {
+		return nil, nil
+	}
+	policy := &SchedulingPolicy{}
+	if err := sigsyaml.UnmarshalStrict([]byte(value), policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// podSpecPath returns the path to the pod spec of objects of the kind, nil for kinds without pods
+func podSpecPath(kind string) []string {
+	switch {
+	case kind == "Pod":
+		return []string{"spec"}
+	case kind == "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	case podTemplateKinds[kind]:
+		return []string{"spec", "template", "spec"}
+	}
+	return nil
+}
+
+// mergeSchedulingPolicy merges the policy into the pod spec and returns the fields of the pod spec it changed. The
+// template has the last word: tolerations of the policy are added to the ones of the template, node selector labels
+// are added unless the template selects a value for the same label, and the affinity is set only when the template
+// does not define any.
+func mergeSchedulingPolicy(podSpec map[string]interface{}, policy *SchedulingPolicy) (map[string]interface{}, error) {
+	policyFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]interface{})
+
+	if tolerations, ok := policyFields["tolerations"].([]interface{}); ok {
+		existing, _, _ := unstructured.NestedSlice(podSpec, "tolerations")
+		merged := existing
+		for _, t := range tolerations {
+			found := false
+			for _, e := range existing {
+				if reflect.DeepEqual(e, t) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				merged = append(merged, t)
+			}
+		}
+		if len(merged) > len(existing) {
+			podSpec["tolerations"] = merged
+			changed["tolerations"] = merged
+		}
+	}
+
+	if nodeSelector, ok := policyFields["nodeSelector"].(map[string]interface{}); ok {
+		existing, _, _ := unstructured.NestedMap(podSpec, "nodeSelector")
+		added := make(map[string]interface{})
+		for k, v := range nodeSelector {
+			if _, ok := existing[k]; !ok {
+				added[k] = v
+			}
+		}
+		if len(added) > 0 {
+			if existing == nil {
+				existing = make(map[string]interface{})
+			}
+			for k, v := range added {
+				existing[k] = v
+			}
+			podSpec["nodeSelector"] = existing
+			// the node selector is a map, so the patch adds the labels to the ones of the template
+			changed["nodeSelector"] = added
+		}
+	}
+
+	if affinity, ok := policyFields["affinity"].(map[string]interface{}); ok {
+		if _, defined := podSpec["affinity"]; !defined {
+			podSpec["affinity"] = affinity
+			changed["affinity"] = affinity
+		}
+	}
+	return changed, nil
+}
+
+// addSchedulingPatches adds a strategic merge patch to the kustomization for each object of the rendered template with
+// pods, the patch merges the scheduling policy into the pod spec, see mergeSchedulingPolicy
+func addSchedulingPatches(fsys fs.FileSystem, kustomization *ktypes.Kustomization, templateName string, rendered string, policy *SchedulingPolicy) error {
+	objs, err := template.ParseKubernetesObjects(rendered)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing template %s", templateName)
+	}
+	for _, o := range objs {
+		gvk := o.GetObjectKind().GroupVersionKind()
+		specPath := podSpecPath(gvk.Kind)
+		if specPath == nil {
+			continue
+		}
+		name := o.(v1.Object).GetName()
+		changed, err := schedulingChanges(o, specPath, policy)
+		if err != nil {
+			return errors.Wrapf(err, "error merging scheduling policy into %s %s", gvk.Kind, name)
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		overlay := map[string]interface{}{
+			"apiVersion": gvk.GroupVersion().String(),
+			"kind":       gvk.Kind,
+			"metadata":   map[string]interface{}{"name": name},
+		}
+		if err := unstructured.SetNestedMap(overlay, changed, specPath...); err != nil {
+			return errors.Wrapf(err, "error generating scheduling patch of %s %s", gvk.Kind, name)
+		}
+		patchYAML, err := sigsyaml.Marshal(overlay)
+		if err != nil {
+			return errors.Wrapf(err, "error generating scheduling patch of %s %s", gvk.Kind, name)
+		}
+		file := fmt.Sprintf("%s.scheduling/%s-%s.yaml", templateName, gvk.Kind, name)
+		if err := fsys.WriteFile(path.Join(basePath, file), patchYAML); err != nil {
+			return errors.Wrapf(err, "error when writing scheduling patch of %s %s to filesystem before applying kustomize", gvk.Kind, name)
+		}
+		kustomization.PatchesStrategicMerge = append(kustomization.PatchesStrategicMerge, patch.StrategicMerge(file))
+	}
+	return nil
+}
+
+// applySchedulingPolicy merges the scheduling policy into the pod spec of the parsed objects directly, it is used when
+// the conventions are applied without kustomize
+func applySchedulingPolicy(objs []runtime.Object, policy *SchedulingPolicy) ([]runtime.Object, error) {
+	result := make([]runtime.Object, 0, len(objs))
+	for _, o := range objs {
+		gvk := o.GetObjectKind().GroupVersionKind()
+		specPath := podSpecPath(gvk.Kind)
+		if specPath == nil {
+			result = append(result, o)
+			continue
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+		if err != nil {
+			return nil, err
+		}
+		podSpec, _, _ := unstructured.NestedMap(content, specPath...)
+		if podSpec == nil {
+			podSpec = make(map[string]interface{})
+		}
+		if _, err := mergeSchedulingPolicy(podSpec, policy); err != nil {
+			return nil, errors.Wrapf(err, "error merging scheduling policy into %s %s", gvk.Kind, o.(v1.Object).GetName())
+		}
+		if err := unstructured.SetNestedMap(content, podSpec, specPath...); err != nil {
+			return nil, err
+		}
+		merged, err := typedObject(content, o, gvk)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, merged)
+	}
+	return result, nil
+}
+
+// schedulingChanges returns the fields of the pod spec of the object the scheduling policy changes
+func schedulingChanges(obj runtime.Object, specPath []string, policy *SchedulingPolicy) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	podSpec, _, _ := unstructured.NestedMap(content, specPath...)
+	if podSpec == nil {
+		podSpec = make(map[string]interface{})
+	}
+	return mergeSchedulingPolicy(podSpec, policy)
+}
+
+// typedObject converts the content back into an object of the type of the original one
+func typedObject(content map[string]interface{}, original runtime.Object, gvk schema.GroupVersionKind) (runtime.Object, error) {
+	if _, ok := original.(*unstructured.Unstructured); ok {
+		u := &unstructured.Unstructured{Object: content}
+		u.SetGroupVersionKind(gvk)
+		return u, nil
+	}
+	typed := original.DeepCopyObject()
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, typed); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
@@ -0,0 +1,167 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const scheduledDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      nodeSelector:
+        disktype: ssd
+      tolerations:
+      - key: gpu
+        operator: Exists
+        effect: NoSchedule
+      containers:
+      - name: web
+        image: nginx
+`
+
+const schedulingPolicy = `tolerations:
+- key: dedicated
+  operator: Equal
+  value: kudo
+  effect: NoSchedule
+nodeSelector:
+  disktype: hdd
+  pool: services
+affinity:
+  podAntiAffinity:
+    preferredDuringSchedulingIgnoredDuringExecution:
+    - weight: 100
+      podAffinityTerm:
+        topologyKey: kubernetes.io/hostname
+`
+
+func TestSchedulingPolicyMergedIntoPodSpec(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = appsv1.AddToScheme(s)
+
+	expectedTolerations := []corev1.Toleration{
+		{Key: "gpu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+		{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "kudo", Effect: corev1.TaintEffectNoSchedule},
+	}
+	// the template selects the disk type itself
+	expectedNodeSelector := map[string]string{"disktype": "ssd", "pool": "services"}
+
+	tests := []struct {
+		name       string
+		enhancer   kubernetesObjectEnhancer
+		containers string
+	}{
+		{"kustomize", &kustomizeEnhancer{scheme: s}, ""},
+		{"kustomize with containers from a parameter", &kustomizeEnhancer{scheme: s}, "[{name: proxy, image: envoy}]"},
+		{"conventions applied directly", &kustomizeEnhancer{scheme: s, fallback: true}, ""},
+	}
+
+	for _, tt := range tests {
+		meta := &executionMetadata{
+			instanceName:      "instance",
+			instanceNamespace: "default",
+			operatorName:      "operator",
+			clusterVariables:  map[string]string{SchedulingPolicyVariable: schedulingPolicy},
+			resourcesOwner:    &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "uid"}},
+		}
+		plan := generatedValuesPlan("deploy", scheduledDeployment, map[string]string{"SIDECARS": tt.containers})
+		plan.Tasks["task"] = v1alpha1.TaskSpec{Resources: []string{"template.yaml"}, Containers: "SIDECARS"}
+
+		var objs []runtime.Object
+		if k := tt.enhancer.(*kustomizeEnhancer); k.fallback {
+			// kustomize does not fail on the template, so the fallback is called on its own
+			policy, err := parseSchedulingPolicy(meta.clusterVariables)
+			if err != nil {
+				t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+			}
+			objs, err = applyConventionsDirectly(map[string]string{"template.yaml": scheduledDeployment}, metadata{InstanceName: "instance", Namespace: "default", SchedulingPolicy: policy})
+			if err != nil {
+				t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+			}
+		} else {
+			resources, err := prepareKubeResources(plan, meta, tt.enhancer)
+			if err != nil {
+				t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+			}
+			objs = resources.PhaseResources["phase"].StepResources["step"]
+		}
+
+		podSpec := objs[0].(*appsv1.Deployment).Spec.Template.Spec
+		if !reflect.DeepEqual(podSpec.Tolerations, expectedTolerations) {
+			t.Errorf("%s: Expecting tolerations %v but got %v", tt.name, expectedTolerations, podSpec.Tolerations)
+		}
+		if !reflect.DeepEqual(podSpec.NodeSelector, expectedNodeSelector) {
+			t.Errorf("%s: Expecting node selector %v but got %v", tt.name, expectedNodeSelector, podSpec.NodeSelector)
+		}
+		if podSpec.Affinity == nil || podSpec.Affinity.PodAntiAffinity == nil {
+			t.Errorf("%s: Expecting pod anti affinity of the policy but got %v", tt.name, podSpec.Affinity)
+		}
+		kept := false
+		for _, c := range podSpec.Containers {
+			kept = kept || (c.Name == "web" && c.Image == "nginx")
+		}
+		if !kept {
+			t.Errorf("%s: Expecting containers of the template to be kept but got %v", tt.name, podSpec.Containers)
+		}
+	}
+}
+
+func TestSchedulingPolicyKeepsAffinityOfTemplate(t *testing.T) {
+	policy, err := parseSchedulingPolicy(map[string]string{SchedulingPolicyVariable: schedulingPolicy})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	podSpec := map[string]interface{}{"affinity": map[string]interface{}{"nodeAffinity": map[string]interface{}{}}}
+
+	changed, err := mergeSchedulingPolicy(podSpec, policy)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if _, ok := changed["affinity"]; ok {
+		t.Errorf("Expecting affinity of the template to be kept but got %v", podSpec["affinity"])
+	}
+	if _, ok := changed["tolerations"]; !ok {
+		t.Errorf("Expecting tolerations to be added but got %v", changed)
+	}
+}
+
+func TestParseSchedulingPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		expectNil     bool
+		expectedError bool
+	}{
+		{"no policy", "", true, false},
+		{"policy", schedulingPolicy, false, false},
+		{"unknown field", "priorityClassName: high", true, true},
+		{"not a map", "[dedicated]", true, true},
+	}
+
+	for _, tt := range tests {
+		policy, err := parseSchedulingPolicy(map[string]string{SchedulingPolicyVariable: tt.value})
+		if (err != nil) != tt.expectedError {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.expectedError, err)
+		}
+		if (policy == nil) != tt.expectNil {
+			t.Errorf("%s: Expecting policy to be nil %v but got %v", tt.name, tt.expectNil, policy)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package instance
+
+import (
+	"log"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// scopeCache tells whether objects of a kind are namespaced or cluster scoped, the scope of each kind is looked up using
+// discovery only once as it does not change while the kind exists
+type scopeCache struct {
+	mapper meta.RESTMapper
+
+	mu     sync.Mutex
+	scopes map[schema.GroupVersionKind]bool
+}
+
+func newScopeCache(mapper meta.RESTMapper) *scopeCache {
+	return &scopeCache{
+		mapper: mapper,
+		scopes: make(map[schema.GroupVersionKind]bool),
+	}
+}
+
+// resettableMapper is a mapper caching the discovered kinds that can be made to discover them again, e.g.
+// restmapper.DeferredDiscoveryRESTMapper
+type resettableMapper interface {
+	meta.RESTMapper
+	Reset()
+}
+
+// newDiscoveryScopeCache returns a scope cache looking up kinds using discovery, kinds not known yet are discovered again
+func newDiscoveryScopeCache(config *rest.Config) (*scopeCache, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return newScopeCache(restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))), nil
+}
+
+// isNamespaced returns true if objects of the kind live in a namespace
+// kinds not known to the cluster yet, e.g. custom resources whose CRD is created by an earlier step of the same plan, are
+// assumed to be namespaced and looked up again next time. Only mappers that can be reset, see resettableMapper, discover
+// kinds whose CRDs were created after the mapper was, other mappers keep treating those kinds as namespaced.
+func (s *scopeCache) isNamespaced(gvk schema.GroupVersionKind) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if namespaced, ok := s.scopes[gvk]; ok {
+		return namespaced, nil
+	}
+	mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if resettable, ok := s.mapper.(resettableMapper); ok && meta.IsNoMatchError(err) {
+		resettable.Reset()
+		mapping, err = s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if meta.IsNoMatchError(err) {
+		log.Printf("PlanExecution: Kind %v is not known to the cluster yet, assuming it is namespaced", gvk)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	s.scopes[gvk] = namespaced
+	return namespaced, nil
+}
@@ -0,0 +1,59 @@
+package instance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// appliedKey identifies an object in the AppliedAt status of a step
+func appliedKey(obj runtime.Object, key client.ObjectKey) string {
+	return fmt.Sprintf("%s/%s/%s", obj.GetObjectKind().GroupVersionKind().Kind, key.Namespace, key.Name)
+}
+
+// isSettling returns true while the object applied by the step is in the settle window of the step, the first time the
+// step applied the object is recorded in its status, so objects patched again by later executions of the same step do
+// not start a new window
+func isSettling(step v1alpha1.Step, state *v1alpha1.StepStatus, objKey string, now time.Time) bool {
+	if step.SettleSeconds <= 0 {
+		return false
+	}
+	if state.AppliedAt == nil {
+		state.AppliedAt = make(map[string]metav1.Time)
+	}
+	applied, ok := state.AppliedAt[objKey]
+	if !ok {
+		applied = metav1.NewTime(now)
+		state.AppliedAt[objKey] = applied
+	}
+	return now.Before(applied.Add(time.Duration(step.SettleSeconds) * time.Second))
+}
+
+// settleRequeueAfter returns the time until the earliest settle window of the plan ends, zero if no step is settling
+// objects in the settle window do not change, so nothing else triggers the next execution once the window is over
+func settleRequeueAfter(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus, now time.Time) time.Duration {
+	var after time.Duration
+	for _, ph := range plan.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || isFinished(stepState.Status) {
+				continue
+			}
+			for _, applied := range stepState.AppliedAt {
+				remaining := applied.Add(time.Duration(st.SettleSeconds) * time.Second).Sub(now)
+				if remaining > 0 && (after == 0 || remaining < after) {
+					after = remaining
+				}
+			}
+		}
+	}
+	return after
+}
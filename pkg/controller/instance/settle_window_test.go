@@ -0,0 +1,79 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecuteStepWaitsForStatusToSettle(t *testing.T) {
+	// the deployment reports all replicas ready right away, e.g. because its status is still from the previous version
+	deployment := getDeployment("web", "default", 3)
+	deployment.Status = appsv1.DeploymentStatus{ReadyReplicas: 3}
+	step := v1alpha1.Step{Name: "step", SettleSeconds: 30}
+	key := "Deployment/default/web"
+
+	tests := []struct {
+		name           string
+		step           v1alpha1.Step
+		appliedAt      map[string]metav1.Time
+		expectedStatus v1alpha1.ExecutionStatus
+	}{
+		{"no settle window", v1alpha1.Step{Name: "step"}, nil, v1alpha1.ExecutionComplete},
+		{"just applied", step, nil, v1alpha1.ExecutionInProgress},
+		{"within settle window", step, map[string]metav1.Time{key: metav1.NewTime(time.Now().Add(-10 * time.Second))}, v1alpha1.ExecutionInProgress},
+		{"settle window is over", step, map[string]metav1.Time{key: metav1.NewTime(time.Now().Add(-time.Minute))}, v1alpha1.ExecutionComplete},
+	}
+
+	for _, tt := range tests {
+		testClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress, AppliedAt: tt.appliedAt}
+		appliedBefore := state.AppliedAt[key]
+
+		err := executeStep(context.TODO(), tt.step, state, []runtime.Object{deployment.DeepCopy()}, nil, clock.RealClock{}, testClient)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting step status %v but got %v", tt.name, tt.expectedStatus, state.Status)
+		}
+		if tt.step.SettleSeconds > 0 && state.AppliedAt[key].Time.IsZero() {
+			t.Errorf("%s: Expecting time the deployment was applied to be recorded but got %v", tt.name, state.AppliedAt)
+		}
+		if !appliedBefore.IsZero() && !state.AppliedAt[key].Time.Equal(appliedBefore.Time) {
+			t.Errorf("%s: Expecting applying the deployment again to keep the settle window but got %v", tt.name, state.AppliedAt[key])
+		}
+	}
+}
+
+func TestSettleRequeueAfter(t *testing.T) {
+	now := time.Now()
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "settling", SettleSeconds: 30},
+		{Name: "settled", SettleSeconds: 5},
+		{Name: "finished", SettleSeconds: 60},
+	}}}}
+	status := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+		{Name: "settling", Status: v1alpha1.ExecutionInProgress, AppliedAt: map[string]metav1.Time{
+			"Deployment/default/first":  metav1.NewTime(now.Add(-10 * time.Second)),
+			"Deployment/default/second": metav1.NewTime(now.Add(-20 * time.Second)),
+		}},
+		{Name: "settled", Status: v1alpha1.ExecutionInProgress, AppliedAt: map[string]metav1.Time{"Deployment/default/web": metav1.NewTime(now.Add(-time.Minute))}},
+		{Name: "finished", Status: v1alpha1.ExecutionComplete, AppliedAt: map[string]metav1.Time{"Deployment/default/web": metav1.NewTime(now)}},
+	}}}}
+
+	if after := settleRequeueAfter(plan, status, now); after != 10*time.Second {
+		t.Errorf("Expecting requeue once the earliest settle window is over in 10s but got %v", after)
+	}
+	if after := settleRequeueAfter(plan, &v1alpha1.PlanStatus{}, now); after != 0 {
+		t.Errorf("Expecting no requeue without settling steps but got %v", after)
+	}
+}
@@ -0,0 +1,210 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/health"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// defaultSimulationIterations is the number of executions after which a simulation stops when the options do not say
+const defaultSimulationIterations = 100
+
+// SimulationOptions control how the simulated cluster behaves while a plan runs in it. Objects are identified by their
+// kind and name as rendered, e.g. `Deployment/instance-web`.
+type SimulationOptions struct {
+	// MaxIterations is the number of executions of the plan after which the simulation stops, 100 when not set
+	MaxIterations int
+	// HealthyAfter is the number of executions an object stays unhealthy for after the execution that created it,
+	// objects that are not listed become healthy right after the execution that created them and objects with a
+	// negative number never become healthy
+	HealthyAfter map[string]int
+	// Failures are errors the simulated API server returns for creating or patching the objects
+	Failures map[string]string
+	// LabelKeys are the keys of the labels and annotations added to the objects, see Reconciler
+	LabelKeys LabelKeys
+}
+
+// SimulationResult is the state of the plan after the simulation stopped
+type SimulationResult struct {
+	Status *v1alpha1.PlanStatus
+	// Iterations is the number of times the plan was executed
+	Iterations int
+	// Err is the error of the last execution, if it failed
+	Err error
+}
+
+// SimulatePlan runs the plan of the operator version for an instance of the name with the parameters in a fake cluster
+// until the plan is terminal or the maximum number of iterations is reached, so that operator authors can check in CI
+// that a plan finishes without a real cluster. Objects the plan applies become healthy the way the options say, the
+// simulated clock moves forward by the time the execution asked to be requeued after, at least a second, between the
+// executions. Owner references and templates are handled as by RenderPlan.
+func SimulatePlan(scheme *runtime.Scheme, ov *v1alpha1.OperatorVersion, planName string, instanceName string, namespace string, params map[string]string, options SimulationOptions) (*SimulationResult, error) {
+	instance, err := offlineInstance(ov, planName, instanceName, namespace, params)
+	if err != nil {
+		return nil, err
+	}
+	planStatus := instance.Status.PlanStatus[planName]
+
+	maxIterations := options.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultSimulationIterations
+	}
+	c := &simulatedClient{
+		Client:       fake.NewFakeClientWithScheme(scheme),
+		healthyAfter: options.HealthyAfter,
+		failures:     options.Failures,
+		createdIn:    make(map[string]int),
+		objects:      make(map[string]runtime.Object),
+	}
+	clk := clock.NewFakeClock(time.Now())
+	renderer := &kustomizeEnhancer{scheme: scheme, fallback: true}
+
+	result := &SimulationResult{Status: &planStatus}
+	for result.Iterations < maxIterations && !planStatus.Status.IsTerminal() {
+		result.Iterations++
+		c.iteration = result.Iterations
+
+		plan, metadata, err := preparePlanExecution(instance, ov, &planStatus)
+		if err != nil {
+			return nil, err
+		}
+		metadata.clock = clk
+		metadata.labelKeys = options.LabelKeys
+
+		execResult, err := executePlan(plan, metadata, c, renderer)
+		result.Err = err
+		if execResult.PlanStatus != nil {
+			planStatus = *execResult.PlanStatus
+		}
+		if err := c.advanceHealth(); err != nil {
+			return nil, err
+		}
+
+		step := execResult.RequeueAfter
+		if step < time.Second {
+			step = time.Second
+		}
+		clk.Step(step)
+	}
+	return result, nil
+}
+
+// simulatedClient is a fake API server that makes objects healthy after the configured number of executions and fails
+// creates and patches of the configured objects
+type simulatedClient struct {
+	client.Client
+	healthyAfter map[string]int
+	failures     map[string]string
+
+	// iteration is the number of the current execution
+	iteration int
+	// createdIn is the execution each object was created in, keyed like SimulationOptions
+	createdIn map[string]int
+	// objects are the created objects keyed like SimulationOptions
+	objects map[string]runtime.Object
+}
+
+// simulationKey returns the key of the object in SimulationOptions
+func simulationKey(obj runtime.Object) string {
+	return fmt.Sprintf("%s/%s", obj.GetObjectKind().GroupVersionKind().Kind, obj.(metav1.Object).GetName())
+}
+
+func (c *simulatedClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	key := simulationKey(obj)
+	if msg, ok := c.failures[key]; ok {
+		return errors.New(msg)
+	}
+	if err := c.Client.Create(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.createdIn[key] = c.iteration
+	c.objects[key] = obj.DeepCopyObject()
+	return nil
+}
+
+func (c *simulatedClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if msg, ok := c.failures[simulationKey(obj)]; ok {
+		return errors.New(msg)
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// advanceHealth makes the objects healthy that were created long enough ago, like the controllers of a cluster would
+func (c *simulatedClient) advanceHealth() error {
+	for key, created := range c.createdIn {
+		after, ok := c.healthyAfter[key]
+		if ok && (after < 0 || c.iteration-created < after) {
+			continue
+		}
+		if err := c.makeHealthy(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeHealthy sets the status of the object to one health.IsReady accepts, objects deleted since are skipped
+func (c *simulatedClient) makeHealthy(key string) error {
+	obj := emptyObject(c.objects[key])
+	objKey, _ := client.ObjectKeyFromObject(c.objects[key])
+	if err := c.Client.Get(context.TODO(), objKey, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if health.IsReady(obj) == nil {
+		return nil
+	}
+
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		replicas := defaultReplicas(&o.Spec.Replicas)
+		o.Status = appsv1.DeploymentStatus{ObservedGeneration: o.Generation, Replicas: replicas, UpdatedReplicas: replicas, ReadyReplicas: replicas, AvailableReplicas: replicas}
+	case *appsv1.StatefulSet:
+		replicas := defaultReplicas(&o.Spec.Replicas)
+		o.Status = appsv1.StatefulSetStatus{ObservedGeneration: o.Generation, Replicas: replicas, UpdatedReplicas: replicas, ReadyReplicas: replicas, CurrentReplicas: replicas}
+	case *appsv1.DaemonSet:
+		o.Status = appsv1.DaemonSetStatus{ObservedGeneration: o.Generation, DesiredNumberScheduled: 1, CurrentNumberScheduled: 1, UpdatedNumberScheduled: 1, NumberReady: 1, NumberAvailable: 1}
+	case *batchv1.Job:
+		o.Status = batchv1.JobStatus{Succeeded: 1, Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}}
+	case *corev1.PersistentVolumeClaim:
+		o.Status.Phase = corev1.ClaimBound
+	case *unstructured.Unstructured:
+		conditionType, ok := o.GetAnnotations()[kudo.ReadyConditionAnnotation]
+		if !ok {
+			conditionType = health.DefaultReadyCondition
+		}
+		conditions := []interface{}{map[string]interface{}{"type": conditionType, "status": string(corev1.ConditionTrue)}}
+		if err := unstructured.SetNestedSlice(o.Object, conditions, "status", "conditions"); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	return c.Client.Update(context.TODO(), obj)
+}
+
+// defaultReplicas sets the replicas to one if they are not set, like the API server does, and returns them
+func defaultReplicas(replicas **int32) int32 {
+	if *replicas == nil {
+		one := int32(1)
+		*replicas = &one
+	}
+	return **replicas
+}
@@ -0,0 +1,80 @@
+package instance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSimulatePlan(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	_ = appsv1.AddToScheme(s)
+
+	ov := &v1alpha1.OperatorVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-1.0", Namespace: "default"},
+		Spec: v1alpha1.OperatorVersionSpec{
+			Operator:  corev1.ObjectReference{Name: "operator"},
+			Version:   "1.0",
+			Templates: map[string]string{"config.yaml": renderedConfig, "deployment.yaml": renderedDeployment},
+			Parameters: []v1alpha1.Parameter{
+				{Name: "GREETING", Default: kudo.String("hello")},
+				{Name: "REPLICAS", Default: kudo.String("3")},
+			},
+			Tasks: map[string]v1alpha1.TaskSpec{
+				"config": {Resources: []string{"config.yaml"}},
+				"app":    {Resources: []string{"deployment.yaml"}},
+			},
+			Plans: map[string]v1alpha1.Plan{"deploy": {Strategy: "serial", Phases: []v1alpha1.Phase{{
+				Name: "main", Strategy: "serial", Steps: []v1alpha1.Step{
+					{Name: "config", Tasks: []string{"config"}},
+					{Name: "app", Tasks: []string{"app"}},
+				},
+			}}}},
+		},
+	}
+
+	tests := []struct {
+		name               string
+		options            SimulationOptions
+		expectedStatus     v1alpha1.ExecutionStatus
+		expectedIterations int
+		expectedErr        string
+	}{
+		{"objects healthy right away", SimulationOptions{}, v1alpha1.ExecutionComplete, 2, ""},
+		{"deployment healthy after two more executions", SimulationOptions{HealthyAfter: map[string]int{"Deployment/instance-app": 2}}, v1alpha1.ExecutionComplete, 4, ""},
+		{"deployment never healthy", SimulationOptions{MaxIterations: 10, HealthyAfter: map[string]int{"Deployment/instance-app": -1}}, v1alpha1.ExecutionInProgress, 10, ""},
+		{"creating config map fails", SimulationOptions{MaxIterations: 5, Failures: map[string]string{"ConfigMap/instance-config": "quota exceeded"}}, v1alpha1.ExecutionInProgress, 5, "quota exceeded"},
+	}
+
+	for _, tt := range tests {
+		result, err := SimulatePlan(s, ov, "deploy", "instance", "default", nil, tt.options)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if result.Status.Status != tt.expectedStatus {
+			t.Errorf("%s: Expecting plan status %v but got %v", tt.name, tt.expectedStatus, result.Status.Status)
+		}
+		if result.Iterations != tt.expectedIterations {
+			t.Errorf("%s: Expecting %d iterations but got %d", tt.name, tt.expectedIterations, result.Iterations)
+		}
+		if tt.expectedErr == "" && result.Err != nil {
+			t.Errorf("%s: Expecting no error of the last execution but got %v", tt.name, result.Err)
+		}
+		if tt.expectedErr != "" && (result.Err == nil || !strings.Contains(result.Err.Error(), tt.expectedErr)) {
+			t.Errorf("%s: Expecting error of the last execution containing %q but got %v", tt.name, tt.expectedErr, result.Err)
+		}
+	}
+
+	if _, err := SimulatePlan(s, ov, "backup", "instance", "default", nil, SimulationOptions{}); err == nil {
+		t.Errorf("Expecting an error simulating a plan that does not exist")
+	}
+}
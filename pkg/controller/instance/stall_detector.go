@@ -0,0 +1,65 @@
+package instance
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultStallTimeout is the time a plan can make no progress before it is reported as stalled
+const DefaultStallTimeout = 30 * time.Minute
+
+// stepProgress is the status and stage of all steps of a plan keyed by the phase and step name, a change of any of them
+// is progress of the plan, messages are left out as they change while a step waits
+type stepProgress map[string]string
+
+func progressOf(status *v1alpha1.PlanStatus) stepProgress {
+	progress := make(stepProgress)
+	for _, ph := range status.Phases {
+		for _, st := range ph.Steps {
+			progress[ph.Name+"/"+st.Name] = fmt.Sprintf("%s/%s", st.Status, st.Stage)
+		}
+	}
+	return progress
+}
+
+// detectStall records the time of the last progress of the plan and sets the stalled condition of a plan that made no
+// progress for longer than the timeout, it returns true if the plan stalled in this execution and the time after which
+// the plan stalls if it makes no further progress, zero if it cannot stall anymore
+// a zero timeout disables the detection
+func detectStall(before stepProgress, state *v1alpha1.PlanStatus, timeout time.Duration, now time.Time) (bool, time.Duration) {
+	if timeout == 0 {
+		return false, 0
+	}
+
+	after := progressOf(state)
+	progressed := len(before) != len(after)
+	for k, v := range after {
+		progressed = progressed || before[k] != v
+	}
+	if progressed || state.LastProgressTime.IsZero() {
+		state.LastProgressTime = metav1.NewTime(now)
+		if c := state.Condition(v1alpha1.PlanStalled); c != nil && c.Status == corev1.ConditionTrue {
+			state.SetCondition(v1alpha1.PlanCondition{Type: v1alpha1.PlanStalled, Status: corev1.ConditionFalse, Reason: "Progressing", LastTransitionTime: metav1.NewTime(now)})
+		}
+	}
+	if state.Status.IsTerminal() {
+		return false, 0
+	}
+	if c := state.Condition(v1alpha1.PlanStalled); c != nil && c.Status == corev1.ConditionTrue {
+		return false, 0
+	}
+
+	stallsAt := state.LastProgressTime.Add(timeout)
+	if now.Before(stallsAt) {
+		return false, stallsAt.Sub(now)
+	}
+	message := fmt.Sprintf("plan %s made no progress since %s", state.Name, state.LastProgressTime.Format(time.RFC3339))
+	log.Printf("PlanExecution: WARNING: %s", message)
+	state.SetCondition(v1alpha1.PlanCondition{Type: v1alpha1.PlanStalled, Status: corev1.ConditionTrue, Reason: "NoProgress", Message: message, LastTransitionTime: metav1.NewTime(now)})
+	return true, 0
+}
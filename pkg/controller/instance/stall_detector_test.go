@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func waitingPlanStatus(lastProgress time.Time) *v1alpha1.PlanStatus {
+	return &v1alpha1.PlanStatus{
+		Name:             "deploy",
+		Status:           v1alpha1.ExecutionInProgress,
+		LastProgressTime: metav1.NewTime(lastProgress),
+		Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionInProgress, Steps: []v1alpha1.StepStatus{
+			{Name: "first", Status: v1alpha1.ExecutionComplete},
+			{Name: "second", Status: v1alpha1.ExecutionInProgress, Message: "waiting for default/web"},
+		}}},
+	}
+}
+
+func TestDetectStall(t *testing.T) {
+	now := time.Now()
+	timeout := 30 * time.Minute
+
+	tests := []struct {
+		name              string
+		lastProgress      time.Time
+		progress          func(status *v1alpha1.PlanStatus)
+		expectedStalled   bool
+		expectedCondition corev1.ConditionStatus
+		expectedAfter     time.Duration
+	}{
+		{"no progress for a short time", now.Add(-10 * time.Minute), func(*v1alpha1.PlanStatus) {}, false, "", 20 * time.Minute},
+		{"only the message changed", now.Add(-10 * time.Minute), func(s *v1alpha1.PlanStatus) { s.Phases[0].Steps[1].Message = "waiting for default/db" }, false, "", 20 * time.Minute},
+		{"no progress for too long", now.Add(-time.Hour), func(*v1alpha1.PlanStatus) {}, true, corev1.ConditionTrue, 0},
+		{"step completed", now.Add(-time.Hour), func(s *v1alpha1.PlanStatus) { s.Phases[0].Steps[1].Status = v1alpha1.ExecutionComplete }, false, "", timeout},
+		{"step moved to the next stage", now.Add(-time.Hour), func(s *v1alpha1.PlanStatus) { s.Phases[0].Steps[1].Stage = v1alpha1.PostTasksStage }, false, "", timeout},
+	}
+
+	for _, tt := range tests {
+		status := waitingPlanStatus(tt.lastProgress)
+		before := progressOf(status)
+		tt.progress(status)
+
+		stalled, after := detectStall(before, status, timeout, now)
+		if stalled != tt.expectedStalled {
+			t.Errorf("%s: Expecting stalled to be %v but got %v", tt.name, tt.expectedStalled, stalled)
+		}
+		if after != tt.expectedAfter {
+			t.Errorf("%s: Expecting plan to stall after %v but got %v", tt.name, tt.expectedAfter, after)
+		}
+		condition := status.Condition(v1alpha1.PlanStalled)
+		if tt.expectedCondition == "" && condition != nil {
+			t.Errorf("%s: Expecting no stalled condition but got %v", tt.name, condition)
+		}
+		if tt.expectedCondition != "" && (condition == nil || condition.Status != tt.expectedCondition) {
+			t.Errorf("%s: Expecting stalled condition %v but got %v", tt.name, tt.expectedCondition, condition)
+		}
+		if status.Status != v1alpha1.ExecutionInProgress {
+			t.Errorf("%s: Expecting plan to keep running but got %v", tt.name, status.Status)
+		}
+	}
+}
+
+func TestDetectStallReportsOnce(t *testing.T) {
+	now := time.Now()
+	status := waitingPlanStatus(now.Add(-time.Hour))
+
+	if stalled, _ := detectStall(progressOf(status), status, 30*time.Minute, now); !stalled {
+		t.Fatal("Expecting plan without progress to stall")
+	}
+	stalledAt := status.Condition(v1alpha1.PlanStalled).LastTransitionTime
+
+	// later executions without progress do not report the plan again
+	if stalled, _ := detectStall(progressOf(status), status, 30*time.Minute, now.Add(time.Minute)); stalled {
+		t.Error("Expecting stalled plan to be reported only once")
+	}
+	if c := status.Condition(v1alpha1.PlanStalled); c.LastTransitionTime != stalledAt {
+		t.Errorf("Expecting transition time %v to be kept but got %v", stalledAt, c.LastTransitionTime)
+	}
+
+	// progress clears the condition
+	before := progressOf(status)
+	status.Phases[0].Steps[1].Status = v1alpha1.ExecutionComplete
+	status.Status = v1alpha1.ExecutionComplete
+	detectStall(before, status, 30*time.Minute, now.Add(2*time.Minute))
+	if c := status.Condition(v1alpha1.PlanStalled); c == nil || c.Status != corev1.ConditionFalse {
+		t.Errorf("Expecting stalled condition to be cleared once the plan progressed but got %v", c)
+	}
+	if !status.LastProgressTime.Time.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("Expecting last progress time to be updated but got %v", status.LastProgressTime)
+	}
+}
+
+func TestDetectStallDisabled(t *testing.T) {
+	status := waitingPlanStatus(time.Now().Add(-24 * time.Hour))
+	if stalled, after := detectStall(progressOf(status), status, 0, time.Now()); stalled || after != 0 {
+		t.Errorf("Expecting no stall detection without timeout but got %v after %v", stalled, after)
+	}
+}
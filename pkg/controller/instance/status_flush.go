@@ -0,0 +1,59 @@
+package instance
+
+import (
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// statusFlusher persists the status of the plan in the middle of its execution
+//
+// the execution of a plan only changes the status in memory and the caller persists it once at the end of the
+// reconciliation, so that a plan with many steps does not cause an API write per step. The status has to be persisted
+// earlier only before an irreversible action of a step that starts, that is deleting objects or running a command,
+// so that a failure of the controller right after the action does not make KUDO forget that the step started and when
+type statusFlusher func(status *v1alpha1.PlanStatus) error
+
+// startSteps records the start of the given steps that did not start yet and persists the plan status when any of them
+// is about to do something irreversible
+func startSteps(steps []v1alpha1.Step, states []*v1alpha1.StepStatus, resources phaseResources, planStatus *v1alpha1.PlanStatus, clk clock.Clock, flush statusFlusher) error {
+	flushRequired := false
+	for i, st := range steps {
+		if startStep(states[i], clk.Now()) && isIrreversible(st, resources) {
+			flushRequired = true
+		}
+	}
+	if !flushRequired || flush == nil {
+		return nil
+	}
+	log.Printf("PlanExecution: Persisting status of plan %s before starting irreversible steps", planStatus.Name)
+	return flush(planStatus)
+}
+
+// startStep sets the start time of a pending step, returns true if the step starts now
+func startStep(state *v1alpha1.StepStatus, now time.Time) bool {
+	if state.Status != v1alpha1.ExecutionPending || !state.StartedAt.IsZero() {
+		return false
+	}
+	state.StartedAt = metav1.NewTime(now)
+	return true
+}
+
+// isIrreversible returns true if the step deletes objects or runs commands
+func isIrreversible(step v1alpha1.Step, resources phaseResources) bool {
+	if step.Delete || resources.StepDeleteSelectors[step.Name] != nil {
+		return true
+	}
+	for _, objs := range [][]runtime.Object{resources.StepPreResources[step.Name], resources.StepResources[step.Name], resources.StepPostResources[step.Name]} {
+		for _, o := range objs {
+			if isCommandJob(o) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// recordingFlusher keeps copies of the flushed plan statuses
+type recordingFlusher struct {
+	flushed []*v1alpha1.PlanStatus
+	err     error
+}
+
+func (f *recordingFlusher) flush(status *v1alpha1.PlanStatus) error {
+	f.flushed = append(f.flushed, status.DeepCopy())
+	return f.err
+}
+
+func flushTestPlan(strategy v1alpha1.Ordering, deleting ...string) *activePlan {
+	plan := podStepsPlan(strategy, "one", "two", "three", "four", "five")
+	for i, step := range plan.Spec.Phases[0].Steps {
+		for _, d := range deleting {
+			plan.Spec.Phases[0].Steps[i].Delete = plan.Spec.Phases[0].Steps[i].Delete || d == step.Name
+		}
+	}
+	return plan
+}
+
+func TestExecutePlanFlushesStatusOnlyBeforeIrreversibleSteps(t *testing.T) {
+	tests := []struct {
+		name            string
+		strategy        v1alpha1.Ordering
+		deleting        []string
+		expectedFlushes int
+	}{
+		{"serial plan without irreversible steps", v1alpha1.Serial, nil, 0},
+		{"serial plan with one deleting step", v1alpha1.Serial, []string{"three"}, 1},
+		{"serial plan with two deleting steps", v1alpha1.Serial, []string{"two", "four"}, 2},
+		{"parallel plan with two deleting steps", v1alpha1.Parallel, []string{"two", "four"}, 1},
+	}
+
+	for _, tt := range tests {
+		flusher := &recordingFlusher{}
+		metadata := &executionMetadata{instanceName: "Instance", instanceNamespace: "default", resourcesOwner: getJob("pod2", "default"), flushStatus: flusher.flush}
+		plan := flushTestPlan(tt.strategy, tt.deleting...)
+
+		result, err := executePlan(plan, metadata, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if result.Status != v1alpha1.ExecutionComplete {
+			t.Errorf("%s: Expecting plan to be completed in one execution but got %v", tt.name, result.Status)
+		}
+		if len(flusher.flushed) != tt.expectedFlushes {
+			t.Errorf("%s: Expecting %d status flushes but got %d", tt.name, tt.expectedFlushes, len(flusher.flushed))
+		}
+		for _, st := range result.Phases[0].Steps {
+			if st.StartedAt.IsZero() {
+				t.Errorf("%s: Expecting start time of step %s to be set", tt.name, st.Name)
+			}
+		}
+
+		// the flushed status already knows that the irreversible step started, but not that it finished
+		if len(flusher.flushed) > 0 {
+			flushed := flusher.flushed[0].Phases[0].Steps
+			for _, st := range flushed {
+				if st.Name == tt.deleting[0] && (st.StartedAt.IsZero() || st.Status != v1alpha1.ExecutionPending) {
+					t.Errorf("%s: Expecting step %s to be started but not executed in the flushed status but got %v", tt.name, st.Name, st)
+				}
+			}
+		}
+
+		// nothing starts in a completed plan
+		flusher.flushed = nil
+		if _, err := executePlan(plan, metadata, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{}); err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if len(flusher.flushed) != 0 {
+			t.Errorf("%s: Expecting no status flushes for completed plan but got %d", tt.name, len(flusher.flushed))
+		}
+	}
+}
+
+func TestExecutePlanStopsWhenStatusFlushFails(t *testing.T) {
+	flusher := &recordingFlusher{err: fmt.Errorf("conflict")}
+	metadata := &executionMetadata{instanceName: "Instance", instanceNamespace: "default", resourcesOwner: getJob("pod2", "default"), flushStatus: flusher.flush}
+	plan := flushTestPlan(v1alpha1.Serial, "three")
+
+	result, err := executePlan(plan, metadata, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{})
+	if err == nil {
+		t.Fatal("Expecting error when status cannot be flushed but got none")
+	}
+	for _, st := range result.Phases[0].Steps {
+		executed := st.Name == "one" || st.Name == "two"
+		if executed != (st.Status == v1alpha1.ExecutionComplete) {
+			t.Errorf("Expecting only steps before the irreversible step to be executed but step %s is %s", st.Name, st.Status)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// isDeadlineExceeded returns true once the step is in progress for longer than its deadline allows
+func isDeadlineExceeded(deadline *v1alpha1.StepDeadline, state *v1alpha1.StepStatus, now time.Time) bool {
+	if deadline == nil || deadline.TimeoutSeconds <= 0 || state.StartedAt.IsZero() {
+		return false
+	}
+	return !now.Before(state.StartedAt.Add(time.Duration(deadline.TimeoutSeconds) * time.Second))
+}
+
+// recordCreated remembers that the step created the object, so that it can be deleted when the step is rolled back
+func recordCreated(step v1alpha1.Step, state *v1alpha1.StepStatus, key string) {
+	if step.Deadline == nil || !step.Deadline.Rollback {
+		return
+	}
+	for _, k := range state.Created {
+		if k == key {
+			return
+		}
+	}
+	state.Created = append(state.Created, key)
+}
+
+// failDeadline fails the step that exceeded its deadline, the objects it created are deleted first if the deadline asks
+// for it. Objects that cannot be deleted stay in the created objects of the step and the error is not fatal, so that the
+// deletion is retried by the next execution before the step fails for good.
+func failDeadline(step v1alpha1.Step, state *v1alpha1.StepStatus, resources []runtime.Object, c client.Client) error {
+	reason := fmt.Sprintf("step %s is not healthy after %ds", step.Name, step.Deadline.TimeoutSeconds)
+	if state.Message != "" {
+		reason = fmt.Sprintf("%s, %s", reason, state.Message)
+	}
+
+	if step.Deadline.Rollback && len(state.Created) > 0 {
+		created := make(map[string]bool)
+		for _, k := range state.Created {
+			created[k] = true
+		}
+		var remaining []string
+		var deleteErr error
+		for _, r := range resources {
+			key, _ := client.ObjectKeyFromObject(r)
+			k := appliedKey(r, key)
+			if !created[k] {
+				continue
+			}
+			log.Printf("PlanExecution: Step %s exceeded its deadline, deleting %s it created", step.Name, k)
+			err := c.Delete(context.TODO(), r, deleteOptions(step)...)
+			if err != nil && !apierrors.IsNotFound(err) {
+				log.Printf("PlanExecution: Error deleting %s while rolling back step %s: %v", k, step.Name, err)
+				remaining = append(remaining, k)
+				deleteErr = err
+			}
+		}
+		// objects that are not rendered anymore cannot be deleted, they are left behind like the ones of any other step
+		state.Created = remaining
+		if deleteErr != nil {
+			return fmt.Errorf("%s, rolling back failed for %d objects: %v", reason, len(remaining), deleteErr)
+		}
+		reason += ", the objects it created are deleted"
+	}
+
+	return &executionError{err: fmt.Errorf("%s", reason), fatal: true, eventName: kudo.String("StepDeadlineExceeded")}
+}
+
+// deadlineRequeueAfter returns the shortest time after which a step of the plan in progress exceeds its deadline, zero if
+// no step has a deadline. Steps waiting for objects that never become healthy do not trigger the next execution on their own
+func deadlineRequeueAfter(plan *v1alpha1.Plan, planState *v1alpha1.PlanStatus, now time.Time) time.Duration {
+	var after time.Duration
+	for _, ph := range plan.Phases {
+		phaseState, err := getPhaseFromStatus(ph.Name, planState)
+		if err != nil {
+			continue
+		}
+		for _, st := range ph.Steps {
+			if st.Deadline == nil || st.Deadline.TimeoutSeconds <= 0 {
+				continue
+			}
+			stepState, err := getStepFromStatus(st.Name, phaseState)
+			if err != nil || stepState.Status != v1alpha1.ExecutionInProgress || stepState.StartedAt.IsZero() {
+				continue
+			}
+			wait := stepState.StartedAt.Add(time.Duration(st.Deadline.TimeoutSeconds) * time.Second).Sub(now)
+			if wait <= 0 {
+				wait = time.Second
+			}
+			if after == 0 || wait < after {
+				after = wait
+			}
+		}
+	}
+	return after
+}
@@ -0,0 +1,126 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// failingDeleteClient fails all deletions, like an API server that denies them
+type failingDeleteClient struct {
+	client.Client
+}
+
+func (c *failingDeleteClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return errors.New("deletion denied")
+}
+
+func TestExecuteStepRollsBackAfterDeadline(t *testing.T) {
+	tests := []struct {
+		name            string
+		rollback        bool
+		failDelete      bool
+		expectedStatus  v1alpha1.ExecutionStatus
+		expectDeleted   bool
+		expectedCreated []string
+	}{
+		{"created objects are deleted", true, false, v1alpha1.ExecutionFatalError, true, nil},
+		{"failed deletion is retried", true, true, v1alpha1.ErrorStatus, false, []string{"Deployment/default/app"}},
+		{"objects are kept without rollback", false, false, v1alpha1.ExecutionFatalError, false, nil},
+	}
+
+	for _, tt := range tests {
+		fakeClock := clock.NewFakeClock(time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC))
+		var c client.Client = fake.NewFakeClientWithScheme(scheme.Scheme, getConfigMap("existing", "default", nil))
+		if tt.failDelete {
+			c = &failingDeleteClient{c}
+		}
+		step := v1alpha1.Step{Name: "step", Deadline: &v1alpha1.StepDeadline{TimeoutSeconds: 60, Rollback: tt.rollback}}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending, StartedAt: metav1.NewTime(fakeClock.Now())}
+		resources := func() []runtime.Object {
+			return []runtime.Object{getConfigMap("existing", "default", nil), getDeployment("app", "default", 1)}
+		}
+
+		// the deployment never gets ready
+		if err := executeStep(context.TODO(), step, state, resources(), nil, fakeClock, c); err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != v1alpha1.ExecutionInProgress {
+			t.Fatalf("%s: Expecting step to wait for the deployment but got %v", tt.name, state.Status)
+		}
+
+		fakeClock.Step(61 * time.Second)
+		err := executeStep(context.TODO(), step, state, resources(), nil, fakeClock, c)
+		if err == nil {
+			t.Fatalf("%s: Expecting an error but got none", tt.name)
+		}
+		if status := statusForError(err); status != tt.expectedStatus {
+			t.Errorf("%s: Expecting status %v but got %v: %v", tt.name, tt.expectedStatus, status, err)
+		}
+
+		err = c.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "app"}, &appsv1.Deployment{})
+		if deleted := apierrors.IsNotFound(err); deleted != tt.expectDeleted {
+			t.Errorf("%s: Expecting deployment to be deleted %v but got %v", tt.name, tt.expectDeleted, err)
+		}
+		if err := c.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "existing"}, &corev1.ConfigMap{}); err != nil {
+			t.Errorf("%s: Expecting the config map the step did not create to be kept but got %v", tt.name, err)
+		}
+		if len(state.Created) != len(tt.expectedCreated) || (len(tt.expectedCreated) > 0 && state.Created[0] != tt.expectedCreated[0]) {
+			t.Errorf("%s: Expecting created objects %v but got %v", tt.name, tt.expectedCreated, state.Created)
+		}
+	}
+}
+
+func TestExecuteStepRetriesFailedRollback(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC))
+	c := fake.NewFakeClientWithScheme(scheme.Scheme)
+	step := v1alpha1.Step{Name: "step", Deadline: &v1alpha1.StepDeadline{TimeoutSeconds: 60, Rollback: true}}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ErrorStatus, StartedAt: metav1.NewTime(fakeClock.Now().Add(-2 * time.Minute)), Created: []string{"Deployment/default/app"}}
+	if err := c.Create(context.TODO(), getDeployment("app", "default", 1)); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	// the deployment is deleted without being applied again
+	err := executeStep(context.TODO(), step, state, []runtime.Object{getDeployment("app", "default", 2)}, nil, fakeClock, c)
+	if statusForError(err) != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting a fatal error but got %v", err)
+	}
+	if err := c.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "app"}, &appsv1.Deployment{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expecting deployment to be deleted but got %v", err)
+	}
+	if len(state.Created) != 0 {
+		t.Errorf("Expecting no created objects left but got %v", state.Created)
+	}
+}
+
+func TestDeadlineRequeueAfter(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	plan := &v1alpha1.Plan{Phases: []v1alpha1.Phase{{Name: "phase", Steps: []v1alpha1.Step{
+		{Name: "step", Deadline: &v1alpha1.StepDeadline{TimeoutSeconds: 60}},
+		{Name: "other"},
+	}}}}
+	planState := &v1alpha1.PlanStatus{Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+		{Name: "step", Status: v1alpha1.ExecutionInProgress, StartedAt: metav1.NewTime(now.Add(-20 * time.Second))},
+		{Name: "other", Status: v1alpha1.ExecutionInProgress, StartedAt: metav1.NewTime(now)},
+	}}}}
+
+	if after := deadlineRequeueAfter(plan, planState, now); after != 40*time.Second {
+		t.Errorf("Expecting requeue after 40s but got %v", after)
+	}
+	planState.Phases[0].Steps[0].Status = v1alpha1.ExecutionComplete
+	if after := deadlineRequeueAfter(plan, planState, now); after != 0 {
+		t.Errorf("Expecting no requeue for a completed step but got %v", after)
+	}
+}
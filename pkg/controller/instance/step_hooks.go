@@ -0,0 +1,74 @@
+package instance
+
+import (
+	"context"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// executeStepWithHooks executes the step together with its barrier, pre and post tasks, the step goes through them one by
+// one and the stage it is in is kept in its status so that pre tasks are not applied again once the tasks of the step started
+// the step is complete once the last stage is healthy, an error in any stage fails the step
+func executeStepWithHooks(ctx context.Context, step v1alpha1.Step, state *v1alpha1.StepStatus, resources phaseResources, clk clock.Clock, c client.Client) error {
+	if step.Barrier != nil && isInProgress(state.Status) && (state.Stage == "" || state.Stage == v1alpha1.BarrierStage) {
+		state.Stage = v1alpha1.BarrierStage
+		passed, err := passBarrier(step, state, resources.StepBarriers[step.Name], clk.Now(), c)
+		if err != nil || !passed {
+			return err
+		}
+		log.Printf("PlanExecution: Barrier of step %s passed, applying its tasks", step.Name)
+		if len(step.PreTasks) == 0 && len(step.Tasks) == 0 && len(step.PostTasks) == 0 {
+			state.Status = v1alpha1.ExecutionComplete
+			return nil
+		}
+		state.Stage = v1alpha1.TasksStage
+		if len(step.PreTasks) > 0 {
+			state.Stage = v1alpha1.PreTasksStage
+		}
+	}
+
+	if len(step.PreTasks) == 0 && len(step.PostTasks) == 0 {
+		return executeStepTasks(ctx, step, state, resources, clk, c)
+	}
+	if !isInProgress(state.Status) {
+		return nil
+	}
+
+	if state.Stage == "" {
+		state.Stage = v1alpha1.TasksStage
+		if len(step.PreTasks) > 0 {
+			state.Stage = v1alpha1.PreTasksStage
+		}
+	}
+
+	if state.Stage == v1alpha1.PreTasksStage {
+		err := executeStep(ctx, hookStep(step, step.PreTasks), state, resources.StepPreResources[step.Name], nil, clk, c)
+		if err != nil || !isFinished(state.Status) {
+			return err
+		}
+		log.Printf("PlanExecution: Pre tasks of step %s are healthy, applying its tasks", step.Name)
+		state.Status = v1alpha1.ExecutionInProgress
+		state.Stage = v1alpha1.TasksStage
+	}
+
+	if state.Stage == v1alpha1.TasksStage {
+		err := executeStepTasks(ctx, step, state, resources, clk, c)
+		if err != nil || !isFinished(state.Status) || len(step.PostTasks) == 0 {
+			return err
+		}
+		log.Printf("PlanExecution: Tasks of step %s are healthy, applying its post tasks", step.Name)
+		state.Status = v1alpha1.ExecutionInProgress
+		state.Stage = v1alpha1.PostTasksStage
+	}
+
+	return executeStep(ctx, hookStep(step, step.PostTasks), state, resources.StepPostResources[step.Name], nil, clk, c)
+}
+
+// hookStep returns a step applying the given pre or post tasks of the step, none of the options of the step apply to them
+// except for the conditions of the tasks
+func hookStep(step v1alpha1.Step, tasks []string) v1alpha1.Step {
+	return v1alpha1.Step{Name: step.Name, Tasks: tasks, TaskConditions: step.TaskConditions}
+}
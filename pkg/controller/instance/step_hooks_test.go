@@ -0,0 +1,86 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecuteStepWithHooks(t *testing.T) {
+	readyDeployment := getDeployment("scale-down", "default", 1)
+	readyDeployment.Status.ReadyReplicas = 1
+	failedJob := getCommandJob("command", "default")
+	failedJob.Status = batchv1.JobStatus{Failed: 1}
+
+	tests := []struct {
+		name            string
+		pre             runtime.Object
+		existing        []runtime.Object
+		expectedStatus  v1alpha1.ExecutionStatus
+		expectedStage   v1alpha1.StepStage
+		expectedCreated []string
+		expectFatal     bool
+	}{
+		{"pre task not healthy yet", getDeployment("scale-down", "default", 1), nil, v1alpha1.ExecutionInProgress, v1alpha1.PreTasksStage, []string{"scale-down"}, false},
+		{"pre task healthy", getDeployment("scale-down", "default", 1), []runtime.Object{readyDeployment}, v1alpha1.ExecutionComplete, v1alpha1.PostTasksStage, []string{"main", "scale-up"}, false},
+		{"pre task failed", getCommandJob("command", "default"), []runtime.Object{failedJob, getCommandPod("command-pod", "default", "command", "failed")}, v1alpha1.ExecutionInProgress, v1alpha1.PreTasksStage, nil, true},
+	}
+
+	for _, tt := range tests {
+		step := v1alpha1.Step{Name: "step", Tasks: []string{"main"}, PreTasks: []string{"pre"}, PostTasks: []string{"post"}}
+		resources := phaseResources{
+			StepResources:     map[string][]runtime.Object{"step": {getConfigMap("main", "default", nil)}},
+			StepPreResources:  map[string][]runtime.Object{"step": {tt.pre}},
+			StepPostResources: map[string][]runtime.Object{"step": {getConfigMap("scale-up", "default", nil)}},
+		}
+		testClient := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, tt.existing...)}
+		state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionPending}
+
+		err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient)
+		if tt.expectFatal {
+			if exErr := asExecutionError(err); exErr == nil || !exErr.Fatal() {
+				t.Errorf("%s: Expecting fatal error but got %v", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if state.Status != tt.expectedStatus || state.Stage != tt.expectedStage {
+			t.Errorf("%s: Expecting step to be %v in stage %v but got %v in stage %v", tt.name, tt.expectedStatus, tt.expectedStage, state.Status, state.Stage)
+		}
+		if len(testClient.created) != len(tt.expectedCreated) {
+			t.Errorf("%s: Expecting %v to be created but got %v", tt.name, tt.expectedCreated, testClient.created)
+			continue
+		}
+		for i, name := range tt.expectedCreated {
+			if testClient.created[i] != name {
+				t.Errorf("%s: Expecting %v to be created but got %v", tt.name, tt.expectedCreated, testClient.created)
+			}
+		}
+	}
+}
+
+func TestExecuteStepWithHooksDoesNotRepeatPreTasks(t *testing.T) {
+	step := v1alpha1.Step{Name: "step", Tasks: []string{"main"}, PreTasks: []string{"pre"}}
+	resources := phaseResources{
+		StepResources:    map[string][]runtime.Object{"step": {getPod("main", "default")}},
+		StepPreResources: map[string][]runtime.Object{"step": {getConfigMap("pre", "default", nil)}},
+	}
+	testClient := &orderRecordingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress, Stage: v1alpha1.TasksStage}
+
+	if err := executeStepWithHooks(context.TODO(), step, state, resources, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if len(testClient.created) != 1 || testClient.created[0] != "main" {
+		t.Errorf("Expecting only the tasks of the step to be applied but got %v", testClient.created)
+	}
+	pod := &corev1.Pod{}
+	assertExists(t, testClient, pod, "main", true)
+}
@@ -0,0 +1,32 @@
+package instance
+
+import (
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// isHealthSticky returns true if the template of the object asked for its health to be checked only until it was healthy
+func isHealthSticky(obj runtime.Object) bool {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	return objMeta.GetAnnotations()[kudo.HealthAnnotation] == kudo.HealthStickyValue
+}
+
+// wasHealthy returns true if the object was healthy before in the current execution of the plan and was rendered the
+// same then
+func wasHealthy(state *v1alpha1.StepStatus, key string, digest string) bool {
+	healthyDigest, ok := state.Healthy[key]
+	return ok && healthyDigest == digest
+}
+
+// recordHealthy remembers the object was healthy with the rendered content of the digest, see wasHealthy
+func recordHealthy(state *v1alpha1.StepStatus, key string, digest string) {
+	if state.Healthy == nil {
+		state.Healthy = make(map[string]string)
+	}
+	state.Healthy[key] = digest
+}
@@ -0,0 +1,86 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExecuteStepSkipsHealthOfStickyObjectOnceHealthy(t *testing.T) {
+	sticky := func(replicas int32) *appsv1.Deployment {
+		d := getDeployment("web", "default", replicas)
+		d.Annotations = map[string]string{kudo.HealthAnnotation: kudo.HealthStickyValue}
+		return d
+	}
+	rendered := func(replicas int32) []runtime.Object {
+		return []runtime.Object{sticky(replicas), getDeployment("db", "default", 1)}
+	}
+	setReady := func(c client.Client, name string, ready int32) {
+		d := &appsv1.Deployment{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: name}, d); err != nil {
+			t.Fatal(err)
+		}
+		d.Status.ReadyReplicas = ready
+		if err := c.Update(context.TODO(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	web := sticky(3)
+	web.Status.ReadyReplicas = 3
+	testClient := fake.NewFakeClientWithScheme(scheme.Scheme, web, getDeployment("db", "default", 1))
+	step := v1alpha1.Step{Name: "step"}
+	state := &v1alpha1.StepStatus{Name: "step", Status: v1alpha1.ExecutionInProgress}
+
+	// web is healthy, db is not
+	if err := executeStep(context.TODO(), step, state, rendered(3), nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress {
+		t.Fatalf("Expecting step to wait for db but got %v", state.Status)
+	}
+	if _, ok := state.Healthy["Deployment/default/web"]; !ok || len(state.Healthy) != 1 {
+		t.Errorf("Expecting only web to be recorded healthy but got %v", state.Healthy)
+	}
+
+	// web degrades, but its health is not checked again
+	setReady(testClient, "web", 0)
+	setReady(testClient, "db", 1)
+	if err := executeStep(context.TODO(), step, state, rendered(3), nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting step to complete without checking web again but got %v: %s", state.Status, state.Message)
+	}
+
+	// changed content of web makes its health checked again
+	state.Status = v1alpha1.ExecutionInProgress
+	if err := executeStep(context.TODO(), step, state, rendered(4), nil, clock.RealClock{}, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if state.Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting step to check health of the changed web but got %v", state.Status)
+	}
+}
+
+func TestStartPlanExecutionForgetsHealthyObjects(t *testing.T) {
+	instance := &v1alpha1.Instance{Status: v1alpha1.InstanceStatus{PlanStatus: map[string]v1alpha1.PlanStatus{
+		"deploy": {Name: "deploy", Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{
+			{Name: "step", Status: v1alpha1.ExecutionComplete, Healthy: map[string]string{"Deployment/default/web": "digest"}},
+		}}}},
+	}}}
+	if err := instance.StartPlanExecution("deploy", &v1alpha1.OperatorVersion{}); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if healthy := instance.Status.PlanStatus["deploy"].Phases[0].Steps[0].Healthy; healthy != nil {
+		t.Errorf("Expecting healthy objects to be forgotten when the plan starts again but got %v", healthy)
+	}
+}
@@ -0,0 +1,77 @@
+package instance
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	errwrap "github.com/pkg/errors"
+)
+
+// activeTasks returns the tasks of the step whose conditions hold, tasks without a condition are always active
+func activeTasks(step v1alpha1.Step, tasks []string, engine *kudoengine.Engine, configs map[string]interface{}) ([]string, error) {
+	if len(step.TaskConditions) == 0 {
+		return tasks, nil
+	}
+	active := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		condition, ok := step.TaskConditions[t]
+		if !ok {
+			active = append(active, t)
+			continue
+		}
+		rendered, err := engine.Render(condition, configs)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error expanding condition of task %s in step %s", t, step.Name)
+		}
+		holds, err := strconv.ParseBool(strings.TrimSpace(rendered))
+		if err != nil {
+			return nil, fmt.Errorf("condition of task %s in step %s rendered to %q which is not a boolean", t, step.Name, rendered)
+		}
+		if !holds {
+			log.Printf("PlanExecution: Skipping task %s of step %s, its condition is false", t, step.Name)
+			continue
+		}
+		active = append(active, t)
+	}
+	return active, nil
+}
+
+// withActiveTasks returns the step with only its tasks, pre and post tasks whose conditions hold, and true if the step
+// had tasks and all of them were skipped
+func withActiveTasks(step v1alpha1.Step, engine *kudoengine.Engine, configs map[string]interface{}) (v1alpha1.Step, bool, error) {
+	if len(step.TaskConditions) == 0 {
+		return step, false, nil
+	}
+	all := len(step.PreTasks) + len(step.Tasks) + len(step.PostTasks)
+	var err error
+	for _, tasks := range []*[]string{&step.PreTasks, &step.Tasks, &step.PostTasks} {
+		if *tasks, err = activeTasks(step, *tasks, engine, configs); err != nil {
+			return step, false, err
+		}
+	}
+	return step, all > 0 && len(step.PreTasks)+len(step.Tasks)+len(step.PostTasks) == 0, nil
+}
+
+// skipStep marks the step whose tasks were all skipped complete, nothing is applied for it
+func skipStep(state *v1alpha1.StepStatus) {
+	if state == nil || isFinished(state.Status) {
+		return
+	}
+	log.Printf("PlanExecution: Skipping step %s, conditions of all its tasks are false", state.Name)
+	state.Status = v1alpha1.ExecutionComplete
+	state.Message = "skipped, conditions of all its tasks are false"
+}
+
+// stepRunsTask returns true if the task is one of the tasks, pre or post tasks of the step
+func stepRunsTask(step v1alpha1.Step, task string) bool {
+	for _, t := range allStepTasks(step) {
+		if t == task {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package instance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func conditionalTasksPlan(params map[string]string) *activePlan {
+	plan := generatedValuesPlan("deploy", getResourceAsString(getConfigMap("config", "default", nil)), params)
+	plan.Templates["tls.yaml"] = getResourceAsString(getConfigMap("tls", "default", nil))
+	plan.Templates["metrics.yaml"] = getResourceAsString(getConfigMap("metrics", "default", nil))
+	plan.Tasks["tls"] = v1alpha1.TaskSpec{Resources: []string{"tls.yaml"}}
+	plan.Tasks["metrics"] = v1alpha1.TaskSpec{Resources: []string{"metrics.yaml"}}
+	plan.Spec.Phases[0].Steps[0].Tasks = []string{"tls", "metrics"}
+	plan.Spec.Phases[0].Steps[0].TaskConditions = map[string]string{
+		"tls":     `{{ eq .Params.TLS "true" }}`,
+		"metrics": `{{ .Params.METRICS }}`,
+	}
+	return plan
+}
+
+func TestTaskConditions(t *testing.T) {
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	tests := []struct {
+		name            string
+		params          map[string]string
+		expectedObjects []string
+		expectSkipped   bool
+		expectedError   string
+	}{
+		{"both tasks applied", map[string]string{"TLS": "true", "METRICS": "true"}, []string{"tls", "metrics"}, false, ""},
+		{"one task excluded", map[string]string{"TLS": "false", "METRICS": "true"}, []string{"metrics"}, false, ""},
+		{"all tasks excluded", map[string]string{"TLS": "false", "METRICS": "false"}, nil, true, ""},
+		{"condition is not a boolean", map[string]string{"TLS": "true", "METRICS": "yes please"}, nil, false, `rendered to "yes please" which is not a boolean`},
+	}
+
+	for _, tt := range tests {
+		plan := conditionalTasksPlan(tt.params)
+		stepState := &plan.PlanStatus.Phases[0].Steps[0]
+		stepState.Status = v1alpha1.ExecutionPending
+
+		resources, err := prepareKubeResources(plan, meta, &testKubernetesObjectEnhancer{})
+		if tt.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("%s: Expecting error %q but got %v", tt.name, tt.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+
+		var names []string
+		for _, obj := range resources.PhaseResources["phase"].StepResources["step"] {
+			names = append(names, obj.(interface{ GetName() string }).GetName())
+		}
+		if strings.Join(names, ",") != strings.Join(tt.expectedObjects, ",") {
+			t.Errorf("%s: Expecting objects %v but got %v", tt.name, tt.expectedObjects, names)
+		}
+		if skipped := stepState.Status == v1alpha1.ExecutionComplete; skipped != tt.expectSkipped {
+			t.Errorf("%s: Expecting step to be skipped %v but got %v: %s", tt.name, tt.expectSkipped, stepState.Status, stepState.Message)
+		}
+	}
+}
+
+func TestSkippedStepCompletesPlan(t *testing.T) {
+	plan := conditionalTasksPlan(map[string]string{"TLS": "false", "METRICS": "false"})
+	plan.PlanStatus.Status = v1alpha1.ExecutionPending
+	plan.PlanStatus.Phases[0].Status = v1alpha1.ExecutionPending
+	plan.PlanStatus.Phases[0].Steps[0].Status = v1alpha1.ExecutionPending
+	meta := &executionMetadata{instanceName: "instance", instanceNamespace: "default"}
+
+	newState, err := proceedWithPlan(plan, meta, fake.NewFakeClientWithScheme(scheme.Scheme), &testKubernetesObjectEnhancer{})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if newState.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting plan without any task to apply to complete but got %v", newState.Status)
+	}
+	if msg := newState.Phases[0].Steps[0].Message; msg != "skipped, conditions of all its tasks are false" {
+		t.Errorf("Expecting step to be reported as skipped but got %q", msg)
+	}
+}
+
+func TestTaskConditionsOfUnknownTasks(t *testing.T) {
+	plan := conditionalTasksPlan(nil)
+	plan.Spec.Phases[0].Steps[0].TaskConditions["migrate"] = "true"
+
+	err := validatePlan(plan)
+	if err == nil || !strings.Contains(err.Error(), "step step in phase phase of plan deploy has a condition for task migrate it does not run") {
+		t.Errorf("Expecting error for condition of a task the step does not run but got %v", err)
+	}
+}
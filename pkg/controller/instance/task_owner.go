@@ -0,0 +1,60 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ownerResolver reads the object of the given kind and name that owns objects of a task, cluster scoped owners are found
+// regardless of the namespace
+type ownerResolver func(apiVersion, kind, namespace, name string) (metav1.Object, error)
+
+// clientOwnerResolver reads owners using the client
+func clientOwnerResolver(c client.Client) ownerResolver {
+	return func(apiVersion, kind, namespace, name string) (metav1.Object, error) {
+		owner := &unstructured.Unstructured{}
+		owner.SetAPIVersion(apiVersion)
+		owner.SetKind(kind)
+		err := c.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, owner)
+		return owner, err
+	}
+}
+
+// taskOwner returns the object owning the objects of the task, that is the instance unless the task names another owner
+// a missing owner is an error worth retrying as it can be created by someone else later, an owner in another namespace
+// cannot own anything in the namespace of the instance
+func taskOwner(task string, spec *v1alpha1.TaskOwner, meta *executionMetadata, engine *kudoengine.Engine, configs map[string]interface{}) (metav1.Object, error) {
+	if spec == nil {
+		return meta.resourcesOwner, nil
+	}
+	if meta.ownerResolver == nil {
+		return nil, &executionError{err: fmt.Errorf("owner of task %s cannot be read", task), fatal: true}
+	}
+
+	name, err := engine.Render(spec.Name, configs)
+	if err != nil {
+		return nil, &executionError{err: fmt.Errorf("error expanding name of the owner of task %s: %v", task, err), fatal: true}
+	}
+	owner, err := meta.ownerResolver(spec.APIVersion, spec.Kind, meta.instanceNamespace, name)
+	if apierrors.IsNotFound(err) {
+		log.Printf("PlanExecution: Owner %s %s of task %s does not exist yet", spec.Kind, name, task)
+		return nil, &executionError{err: fmt.Errorf("owner %s %s of task %s not found", spec.Kind, name, task), fatal: false}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ns := owner.GetNamespace(); ns != "" && ns != meta.instanceNamespace {
+		return nil, &executionError{err: fmt.Errorf("owner %s %s of task %s is in namespace %s, it has to be in namespace %s of the instance", spec.Kind, name, task, ns, meta.instanceNamespace), fatal: true, eventName: kudo.String("InvalidOwner")}
+	}
+	return owner, nil
+}
@@ -0,0 +1,88 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func ownedPlan(owner *v1alpha1.TaskOwner) *activePlan {
+	return &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"shared", "app"}}}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+		},
+		Tasks: map[string]v1alpha1.TaskSpec{
+			"shared": {Resources: []string{"shared.yaml"}, Owner: owner},
+			"app":    {Resources: []string{"app.yaml"}},
+		},
+		Templates: map[string]string{
+			"shared.yaml": getResourceAsString(getConfigMap("shared", "default", nil)),
+			"app.yaml":    getResourceAsString(getConfigMap("app", "default", nil)),
+		},
+	}
+}
+
+func TestPrepareKubeResourcesWithTaskOwner(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = v1alpha1.AddToScheme(s)
+	_ = corev1.AddToScheme(s)
+	ov := &v1alpha1.OperatorVersion{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "kudo.dev/v1alpha1", Kind: "OperatorVersion"},
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-1.0", Namespace: "default", UID: "ov-uid"},
+	}
+	instance := &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "default", UID: "instance-uid"}}
+	meta := &executionMetadata{
+		instanceName:      "instance",
+		instanceNamespace: "default",
+		operatorName:      "operator",
+		resourcesOwner:    instance,
+		ownerResolver:     clientOwnerResolver(fake.NewFakeClientWithScheme(s, ov)),
+	}
+
+	plan := ownedPlan(&v1alpha1.TaskOwner{APIVersion: "kudo.dev/v1alpha1", Kind: "OperatorVersion", Name: "{{ .OperatorName }}-1.0"})
+	resources, err := prepareKubeResources(plan, meta, &kustomizeEnhancer{scheme: s})
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	expected := map[string]string{"instance-shared": "ov-uid", "instance-app": "instance-uid"}
+	for _, o := range resources.PhaseResources["phase"].StepResources["step"] {
+		objMeta := o.(metav1.Object)
+		owner := metav1.GetControllerOf(objMeta)
+		if owner == nil || string(owner.UID) != expected[objMeta.GetName()] || (owner.UID == "ov-uid" && owner.Kind != "OperatorVersion") {
+			t.Errorf("Expecting %s to be owned by %s but got %v", objMeta.GetName(), expected[objMeta.GetName()], owner)
+		}
+	}
+
+	// the owner does not exist yet
+	plan = ownedPlan(&v1alpha1.TaskOwner{APIVersion: "kudo.dev/v1alpha1", Kind: "OperatorVersion", Name: "operator-2.0"})
+	_, err = prepareKubeResources(plan, meta, &kustomizeEnhancer{scheme: s})
+	if err == nil || statusForError(err) != v1alpha1.ErrorStatus {
+		t.Errorf("Expecting error worth retrying for missing owner but got %v", err)
+	}
+}
+
+func TestTaskOwnerInOtherNamespace(t *testing.T) {
+	other := &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "parent", Namespace: "other"}}
+	meta := &executionMetadata{
+		instanceNamespace: "default",
+		ownerResolver: func(apiVersion, kind, namespace, name string) (metav1.Object, error) {
+			return other, nil
+		},
+	}
+
+	_, err := taskOwner("task", &v1alpha1.TaskOwner{APIVersion: "kudo.dev/v1alpha1", Kind: "Instance", Name: "parent"}, meta, kudoengine.New(), map[string]interface{}{})
+	if err == nil || statusForError(err) != v1alpha1.ExecutionFatalError {
+		t.Errorf("Expecting fatal error for owner in another namespace but got %v", err)
+	}
+}
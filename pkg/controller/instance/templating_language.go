@@ -0,0 +1,25 @@
+package instance
+
+import (
+	"fmt"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	kudoengine "github.com/kudobuilder/kudo/pkg/engine"
+)
+
+// templateRenderer returns the engine rendering templates written in the given language, go templates are rendered by the
+// given engine which has to be configured for the plan already
+func templateRenderer(language v1alpha1.TemplatingLanguage, engine *kudoengine.Engine) (kudoengine.Renderer, error) {
+	switch {
+	case isGoTemplate(language):
+		return engine, nil
+	case language == v1alpha1.Envsubst:
+		return kudoengine.NewEnvsubst(), nil
+	}
+	return nil, fmt.Errorf("templating language %q is not supported", language)
+}
+
+// isGoTemplate returns true if templates in the language are go templates, which is the default
+func isGoTemplate(language v1alpha1.TemplatingLanguage) bool {
+	return language == "" || language == v1alpha1.GoTemplate
+}
@@ -0,0 +1,78 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestTemplatingLanguagesRenderEquivalentObjects(t *testing.T) {
+	templates := map[v1alpha1.TemplatingLanguage]string{
+		v1alpha1.GoTemplate: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Name }}-web
+  namespace: {{ .Namespace }}
+spec:
+  replicas: {{ .Params.REPLICAS }}
+`,
+		v1alpha1.Envsubst: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ${Name}-web
+  namespace: ${Namespace}
+spec:
+  replicas: ${Params.REPLICAS}
+`,
+	}
+	metadata := &executionMetadata{instanceName: "instance", instanceNamespace: "default", resourcesOwner: getJob("pod2", "default")}
+
+	rendered := map[v1alpha1.TemplatingLanguage]*planResources{}
+	for language, template := range templates {
+		plan := &activePlan{
+			Name: "deploy",
+			PlanStatus: &v1alpha1.PlanStatus{
+				Name:   "deploy",
+				Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+			},
+			Spec: &v1alpha1.Plan{
+				Strategy: "serial",
+				Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+			},
+			Tasks:              map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"deployment.yaml"}}},
+			Templates:          map[string]string{"deployment.yaml": template},
+			templatingLanguage: language,
+			params:             map[string]string{"REPLICAS": "3"},
+		}
+		resources, err := prepareKubeResources(plan, metadata, &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", language, err)
+		}
+		rendered[language] = resources
+	}
+
+	goTemplate := rendered[v1alpha1.GoTemplate].PhaseResources["phase"].StepResources["step"]
+	envsubst := rendered[v1alpha1.Envsubst].PhaseResources["phase"].StepResources["step"]
+	if len(goTemplate) != 1 {
+		t.Fatalf("Expecting go template to render one object but got %v", goTemplate)
+	}
+	if d, ok := goTemplate[0].(*appsv1.Deployment); !ok || d.Name != "instance-web" || *d.Spec.Replicas != 3 {
+		t.Errorf("Expecting go template to render the deployment but got %v", goTemplate[0])
+	}
+	if !reflect.DeepEqual(goTemplate, envsubst) {
+		t.Errorf("Expecting envsubst to render the same objects as go template but got %v and %v", envsubst, goTemplate)
+	}
+}
+
+func TestTemplateRenderer(t *testing.T) {
+	for _, language := range []v1alpha1.TemplatingLanguage{"", v1alpha1.GoTemplate, v1alpha1.Envsubst} {
+		if _, err := templateRenderer(language, nil); err != nil {
+			t.Errorf("Expecting templating language %q to be supported but got %v", language, err)
+		}
+	}
+	if _, err := templateRenderer("jsonnet", nil); err == nil {
+		t.Errorf("Expecting error for unsupported templating language")
+	}
+}
@@ -0,0 +1,75 @@
+package instance
+
+import (
+	"fmt"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// parseValues parses value of a values parameter, it has to be a YAML map
+func parseValues(value string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(value), &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, fmt.Errorf("values are not a map")
+	}
+	return values, nil
+}
+
+// templateValues returns the values exposed to templates as `.Values`, values of the values parameters are merged in the
+// order the parameters are defined in and all the other parameters override their top-level keys
+// values are expected to be validated already, the ones that cannot be parsed are left out
+func templateValues(parameters []v1alpha1.Parameter, params map[string]string) map[string]interface{} {
+	result := make(map[string]interface{})
+	isValues := make(map[string]bool)
+	for _, p := range parameters {
+		if p.Type != v1alpha1.ValuesParameterType {
+			continue
+		}
+		isValues[p.Name] = true
+		if params[p.Name] == "" {
+			continue
+		}
+		values, err := parseValues(params[p.Name])
+		if err != nil {
+			continue
+		}
+		mergeValues(result, values)
+	}
+
+	for k, v := range params {
+		if !isValues[k] {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// mergeValues merges the values into dst recursively, maps are merged and everything else is overridden
+func mergeValues(dst map[string]interface{}, values map[string]interface{}) {
+	for k, v := range values {
+		vMap, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dstMap, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dstMap = make(map[string]interface{})
+			dst[k] = dstMap
+		}
+		mergeValues(dstMap, vMap)
+	}
+}
+
+// parseList parses value of a list parameter, it has to be a YAML list, an empty value is an empty list
+func parseList(value string) ([]interface{}, error) {
+	var items []interface{}
+	if err := yaml.Unmarshal([]byte(value), &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
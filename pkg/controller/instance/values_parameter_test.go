@@ -0,0 +1,101 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const valuesConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: default
+data:
+  image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"
+  replicas: "{{ .Values.replicas }}"
+  {{- range .Values.extra }}
+  {{ .name }}: "{{ .value }}"
+  {{- end }}
+`
+
+func valuesTestPlan(params map[string]string) *activePlan {
+	return &activePlan{
+		Name: "deploy",
+		Spec: &v1alpha1.Plan{
+			Strategy: "serial",
+			Phases:   []v1alpha1.Phase{{Name: "phase", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "step", Tasks: []string{"task"}}}}},
+		},
+		PlanStatus: &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Steps: []v1alpha1.StepStatus{{Name: "step"}}}},
+		},
+		Tasks:     map[string]v1alpha1.TaskSpec{"task": {Resources: []string{"config"}}},
+		Templates: map[string]string{"config": valuesConfigMap},
+		params:    params,
+		parameters: []v1alpha1.Parameter{
+			{Name: "VALUES", Type: v1alpha1.ValuesParameterType},
+			{Name: "OVERRIDES", Type: v1alpha1.ValuesParameterType},
+			{Name: "replicas"},
+		},
+	}
+}
+
+func TestPrepareKubeResourcesRendersValues(t *testing.T) {
+	values := `
+image:
+  repository: nginx
+  tag: "1.16"
+replicas: 1
+extra:
+- name: color
+  value: blue
+`
+	tests := []struct {
+		name     string
+		params   map[string]string
+		expected map[string]string
+	}{
+		{"values", map[string]string{"VALUES": values}, map[string]string{"image": "nginx:1.16", "replicas": "1", "color": "blue"}},
+		{"later values parameter is merged over earlier one", map[string]string{"VALUES": values, "OVERRIDES": "image:\n  tag: \"1.17\""},
+			map[string]string{"image": "nginx:1.17", "replicas": "1", "color": "blue"}},
+		{"individual parameter takes precedence", map[string]string{"VALUES": values, "replicas": "3"}, map[string]string{"image": "nginx:1.16", "replicas": "3", "color": "blue"}},
+	}
+
+	for _, tt := range tests {
+		resources, err := prepareKubeResources(valuesTestPlan(tt.params), &executionMetadata{instanceName: "instance", instanceNamespace: "default"}, &testKubernetesObjectEnhancer{})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		objs := resources.PhaseResources["phase"].StepResources["step"]
+		if len(objs) != 1 {
+			t.Errorf("%s: Expecting one rendered object but got %d", tt.name, len(objs))
+			continue
+		}
+		cm := objs[0].(*corev1.ConfigMap)
+		for k, v := range tt.expected {
+			if cm.Data[k] != v {
+				t.Errorf("%s: Expecting %s to be %s but got %s", tt.name, k, v, cm.Data[k])
+			}
+		}
+	}
+}
+
+func TestPrepareKubeResourcesFailsOnMalformedValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values string
+	}{
+		{"not yaml", "image: [nginx"},
+		{"not a map", "- nginx"},
+	}
+
+	for _, tt := range tests {
+		_, err := prepareKubeResources(valuesTestPlan(map[string]string{"VALUES": tt.values}), &executionMetadata{instanceName: "instance", instanceNamespace: "default"}, &testKubernetesObjectEnhancer{})
+		if exErr := asExecutionError(err); exErr == nil || !exErr.Fatal() {
+			t.Errorf("%s: Expecting fatal execution error but got %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/health"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// verifyPlan checks the health of all the objects of a verify plan and records the result in its status, nothing is
+// created, patched or deleted
+// all phases and steps are checked in one go as there is nothing to apply in order, steps with a missing or unhealthy
+// object stay in progress and report the first problem, the plan is complete once all its steps are healthy
+func verifyPlan(plan *activePlan, metadata *executionMetadata, newState *v1alpha1.PlanStatus, resources *planResources, c client.Client) error {
+	newState.Status = v1alpha1.ExecutionInProgress
+	allPhasesHealthy := true
+	for _, ph := range plan.Spec.Phases {
+		phaseState, _ := getPhaseFromStatus(ph.Name, newState)
+		if isFinished(phaseState.Status) {
+			continue
+		}
+		phaseState.Status = v1alpha1.ExecutionInProgress
+		phaseRes := resources.PhaseResources[ph.Name]
+
+		allStepsHealthy := true
+		for _, st := range ph.Steps {
+			stepState, _ := getStepFromStatus(st.Name, phaseState)
+			if isFinished(stepState.Status) {
+				continue
+			}
+			var objs []runtime.Object
+			for _, stage := range [][]runtime.Object{phaseRes.StepPreResources[st.Name], phaseRes.StepResources[st.Name], phaseRes.StepUnchangedResources[st.Name], phaseRes.StepPostResources[st.Name]} {
+				objs = append(objs, stage...)
+			}
+
+			problem, err := verifyObjects(objs, c)
+			if err != nil {
+				return failStep(phaseState, stepState, err)
+			}
+			stepState.Message = problem
+			if problem != "" {
+				log.Printf("PlanExecution: Step %s of verify plan %s and instance %s is NOT healthy: %s", st.Name, plan.Name, metadata.instanceName, problem)
+				stepState.Status = v1alpha1.ExecutionInProgress
+				allStepsHealthy = false
+				continue
+			}
+			stepState.Status = v1alpha1.ExecutionComplete
+		}
+
+		updatePhaseProgress(phaseState)
+		if allStepsHealthy {
+			phaseState.Status = v1alpha1.ExecutionComplete
+		} else {
+			allPhasesHealthy = false
+		}
+	}
+
+	if allPhasesHealthy {
+		log.Printf("PlanExecution: All objects of verify plan %s and instance %s are healthy", plan.Name, metadata.instanceName)
+		newState.Status = v1alpha1.ExecutionComplete
+	}
+	return nil
+}
+
+// verifyObjects returns the first problem of the objects, empty if all of them exist and are healthy
+func verifyObjects(objs []runtime.Object, c client.Client) (string, error) {
+	for _, obj := range objs {
+		key, err := client.ObjectKeyFromObject(obj)
+		if err != nil {
+			return "", err
+		}
+		existing := obj.DeepCopyObject()
+		err = c.Get(context.TODO(), key, existing)
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("%s/%s does not exist", key.Namespace, key.Name), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if isHealthCheckIgnored(obj) {
+			continue
+		}
+		if err := health.IsHealthy(c, existing); err != nil {
+			return fmt.Sprintf("%s/%s is not healthy: %v", key.Namespace, key.Name, err), nil
+		}
+	}
+	return "", nil
+}
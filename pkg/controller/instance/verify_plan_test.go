@@ -0,0 +1,102 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// readOnlyClient fails all writes
+type readOnlyClient struct {
+	client.Client
+}
+
+func (c *readOnlyClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return fmt.Errorf("unexpected create")
+}
+
+func (c *readOnlyClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return fmt.Errorf("unexpected update")
+}
+
+func (c *readOnlyClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return fmt.Errorf("unexpected patch")
+}
+
+func (c *readOnlyClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return fmt.Errorf("unexpected delete")
+}
+
+func TestVerifyPlan(t *testing.T) {
+	healthy := getDeployment("healthy", "default", 1)
+	healthy.Status.ReadyReplicas = 1
+	unhealthy := getDeployment("unhealthy", "default", 3)
+	unhealthy.Status.ReadyReplicas = 1
+
+	plan := &activePlan{
+		Name: "verify",
+		Spec: &v1alpha1.Plan{Strategy: "serial", Verify: true, Phases: []v1alpha1.Phase{
+			{Name: "app", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "healthy"}, {Name: "unhealthy"}}},
+			{Name: "config", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "existing"}, {Name: "missing"}}},
+		}},
+	}
+	resources := &planResources{PhaseResources: map[string]phaseResources{
+		"app": {StepResources: map[string][]runtime.Object{
+			"healthy":   {getDeployment("healthy", "default", 1)},
+			"unhealthy": {getDeployment("unhealthy", "default", 3)},
+		}},
+		"config": {
+			StepResources:          map[string][]runtime.Object{"existing": {getConfigMap("existing", "default", nil)}},
+			StepUnchangedResources: map[string][]runtime.Object{"missing": {getConfigMap("missing", "default", nil)}},
+		},
+	}}
+	status := &v1alpha1.PlanStatus{Name: "verify", Phases: []v1alpha1.PhaseStatus{
+		{Name: "app", Steps: []v1alpha1.StepStatus{{Name: "healthy"}, {Name: "unhealthy"}}},
+		{Name: "config", Steps: []v1alpha1.StepStatus{{Name: "existing"}, {Name: "missing"}}},
+	}}
+	testClient := &readOnlyClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, healthy, unhealthy, getConfigMap("existing", "default", nil))}
+
+	if err := verifyPlan(plan, &executionMetadata{instanceName: "instance"}, status, resources, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if status.Status != v1alpha1.ExecutionInProgress {
+		t.Errorf("Expecting plan with unhealthy objects to be in progress but got %v", status.Status)
+	}
+
+	expected := map[string]struct {
+		status  v1alpha1.ExecutionStatus
+		message string
+	}{
+		"healthy":   {v1alpha1.ExecutionComplete, ""},
+		"unhealthy": {v1alpha1.ExecutionInProgress, "default/unhealthy is not healthy"},
+		"existing":  {v1alpha1.ExecutionComplete, ""},
+		"missing":   {v1alpha1.ExecutionInProgress, "default/missing does not exist"},
+	}
+	for _, ph := range status.Phases {
+		if ph.Status != v1alpha1.ExecutionInProgress {
+			t.Errorf("Expecting phase %s with an unhealthy step to be in progress but got %v", ph.Name, ph.Status)
+		}
+		for _, st := range ph.Steps {
+			e := expected[st.Name]
+			if st.Status != e.status || len(st.Message) < len(e.message) || st.Message[:len(e.message)] != e.message {
+				t.Errorf("%s: Expecting step to be %v with message %q but got %v with %q", st.Name, e.status, e.message, st.Status, st.Message)
+			}
+		}
+	}
+
+	// the objects become healthy
+	unhealthy.Status.ReadyReplicas = 3
+	testClient = &readOnlyClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, healthy, unhealthy, getConfigMap("existing", "default", nil), getConfigMap("missing", "default", nil))}
+	if err := verifyPlan(plan, &executionMetadata{instanceName: "instance"}, status, resources, testClient); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if status.Status != v1alpha1.ExecutionComplete {
+		t.Errorf("Expecting plan to be complete once all objects are healthy but got %v", status.Status)
+	}
+}
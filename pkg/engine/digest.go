@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// DigestResolver resolves an image reference to the digest of the manifest it currently points to
+type DigestResolver interface {
+	Digest(image reference.Named) (digest.Digest, error)
+}
+
+// DigestResolverFunc is a function implementing DigestResolver
+type DigestResolverFunc func(image reference.Named) (digest.Digest, error)
+
+// Digest calls the function
+func (f DigestResolverFunc) Digest(image reference.Named) (digest.Digest, error) {
+	return f(image)
+}
+
+// DefaultDigestResolver asks the registries for digests and caches them for 10 minutes
+var DefaultDigestResolver = NewCachingResolver(&registryResolver{client: &http.Client{Timeout: 10 * time.Second}}, 10*time.Minute)
+
+// PinImage returns the image reference with the digest of its tag, e.g. `nginx:1.17` becomes `nginx:1.17@sha256:...`
+// images that already have a digest are returned unchanged
+func PinImage(resolver DigestResolver, image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid image: %v", image, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return image, nil
+	}
+	named = reference.TagNameOnly(named)
+
+	d, err := resolver.Digest(named)
+	if err != nil {
+		return "", fmt.Errorf("resolving digest of image %s: %v", image, err)
+	}
+	pinned, err := reference.WithDigest(named, d)
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(pinned), nil
+}
+
+// IsPinned returns true if the image reference contains a digest
+func IsPinned(image string) bool {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	_, ok := named.(reference.Digested)
+	return ok
+}
+
+// pinDigest is the template function pinning images, when the digest cannot be resolved, e.g. because the registry is
+// not reachable, the image is used as it is with a warning
+func (e *Engine) pinDigest(image string) string {
+	pinned, err := PinImage(e.DigestResolver, image)
+	if err != nil {
+		log.Printf("Engine: Warning: using image %s without digest: %v", image, err)
+		return image
+	}
+	return pinned
+}
+
+// cachingResolver remembers digests resolved by another resolver for a limited time, tags can be moved to other images
+// so they cannot be cached forever
+type cachingResolver struct {
+	resolver DigestResolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]cachedDigest
+}
+
+type cachedDigest struct {
+	digest  digest.Digest
+	expires time.Time
+}
+
+// NewCachingResolver returns a resolver caching successful lookups of the given resolver for ttl
+func NewCachingResolver(resolver DigestResolver, ttl time.Duration) DigestResolver {
+	return &cachingResolver{resolver: resolver, ttl: ttl, now: time.Now, entries: make(map[string]cachedDigest)}
+}
+
+func (c *cachingResolver) Digest(image reference.Named) (digest.Digest, error) {
+	key := image.String()
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	c.lock.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.digest, nil
+	}
+
+	d, err := c.resolver.Digest(image)
+	if err != nil {
+		return "", err
+	}
+	c.lock.Lock()
+	c.entries[key] = cachedDigest{digest: d, expires: c.now().Add(c.ttl)}
+	c.lock.Unlock()
+	return d, nil
+}
+
+// registryResolver asks the registry of the image for the digest of the manifest using the Docker Registry HTTP API V2,
+// only anonymous access is supported
+type registryResolver struct {
+	client *http.Client
+}
+
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+func (r *registryResolver) Digest(image reference.Named) (digest.Digest, error) {
+	tagged, ok := image.(reference.Tagged)
+	if !ok {
+		return "", fmt.Errorf("image %s has no tag", image)
+	}
+	registry := reference.Domain(image)
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, reference.Path(image), tagged.Tag())
+
+	resp, err := r.head(url, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := r.token(resp.Header.Get("Www-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = r.head(url, token); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry responded with %s", resp.Status)
+	}
+	return digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+}
+
+func (r *registryResolver) head(url string, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token gets an anonymous token for the bearer challenge of the registry
+func (r *registryResolver) token(challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	req, err := http.NewRequest(http.MethodGet, params["realm"], nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	q.Set("service", params["service"])
+	q.Set("scope", params["scope"])
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request responded with %s", resp.Status)
+	}
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+const testDigest = digest.Digest("sha256:2a03a6059f21e150ae84b0973863609494aad70f0a80eaeb64bddd8d92465812")
+
+// mockResolver resolves all tags to testDigest and counts the lookups
+type mockResolver struct {
+	lookups int
+	err     error
+}
+
+func (r *mockResolver) Digest(image reference.Named) (digest.Digest, error) {
+	r.lookups++
+	return testDigest, r.err
+}
+
+func TestPinImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		expected string
+		err      bool
+	}{
+		{"tag", "nginx:1.17", "nginx:1.17@" + string(testDigest), false},
+		{"implicit latest tag", "nginx", "nginx:latest@" + string(testDigest), false},
+		{"other registry", "gcr.io/google/pause:3.1", "gcr.io/google/pause:3.1@" + string(testDigest), false},
+		{"already pinned", "nginx@sha256:0000000000000000000000000000000000000000000000000000000000000000", "nginx@sha256:0000000000000000000000000000000000000000000000000000000000000000", false},
+		{"invalid image", "Nginx:1.17", "", true},
+	}
+
+	for _, tt := range tests {
+		pinned, err := PinImage(&mockResolver{}, tt.image)
+		if tt.err != (err != nil) {
+			t.Errorf("%s: Expecting error %v but got %v", tt.name, tt.err, err)
+		}
+		if pinned != tt.expected {
+			t.Errorf("%s: Expecting %s but got %s", tt.name, tt.expected, pinned)
+		}
+	}
+}
+
+func TestPinDigestFunction(t *testing.T) {
+	engine := New()
+	engine.DigestResolver = &mockResolver{}
+	rendered, err := engine.Render(`image: {{ pinDigest "nginx:1.17" }}`, nil)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if rendered != "image: nginx:1.17@"+string(testDigest) {
+		t.Errorf("Expecting pinned image but got %s", rendered)
+	}
+
+	// unreachable registry falls back to the tag
+	engine.DigestResolver = &mockResolver{err: fmt.Errorf("registry not reachable")}
+	rendered, err = engine.Render(`image: {{ pinDigest "nginx:1.17" }}`, nil)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if rendered != "image: nginx:1.17" {
+		t.Errorf("Expecting image with the tag only but got %s", rendered)
+	}
+}
+
+func TestCachingResolver(t *testing.T) {
+	mock := &mockResolver{}
+	now := time.Now()
+	resolver := NewCachingResolver(mock, time.Minute).(*cachingResolver)
+	resolver.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := PinImage(resolver, "nginx:1.17"); err != nil {
+			t.Fatalf("Expecting no error but got %v", err)
+		}
+	}
+	if mock.lookups != 1 {
+		t.Errorf("Expecting digest to be looked up once but got %d lookups", mock.lookups)
+	}
+
+	now = now.Add(2 * time.Minute)
+	_, _ = PinImage(resolver, "nginx:1.17")
+	if mock.lookups != 2 {
+		t.Errorf("Expecting expired digest to be looked up again but got %d lookups", mock.lookups)
+	}
+
+	mock.err = fmt.Errorf("registry not reachable")
+	_, _ = PinImage(resolver, "nginx:1.16")
+	_, _ = PinImage(resolver, "nginx:1.16")
+	if mock.lookups != 4 {
+		t.Errorf("Expecting failed lookups not to be cached but got %d lookups", mock.lookups)
+	}
+}
+
+func TestRegistryResolver(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token" && r.URL.Query().Get("scope") == "repository:library/nginx:pull":
+			fmt.Fprint(w, `{"token": "secret"}`)
+		case r.URL.Path == "/v2/library/nginx/manifests/1.17" && r.Header.Get("Authorization") == "Bearer secret":
+			if !strings.Contains(r.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.v2+json") {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", string(testDigest))
+		case strings.HasPrefix(r.URL.Path, "/v2/"):
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:library/nginx:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	resolver := &registryResolver{client: server.Client()}
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	pinned, err := PinImage(resolver, host+"/library/nginx:1.17")
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if pinned != host+"/library/nginx:1.17@"+string(testDigest) {
+		t.Errorf("Expecting pinned image but got %s", pinned)
+	}
+
+	if _, err := PinImage(resolver, host+"/library/redis:5"); err == nil {
+		t.Error("Expecting error for image the registry does not know but got none")
+	}
+}
@@ -3,19 +3,38 @@ package engine
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/masterminds/sprig"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // Engine is the control struct for parsing and templating Kubernetes resources in an ordered fashion
 type Engine struct {
 	FuncMap template.FuncMap
+
+	// DigestResolver is used by the `pinDigest` function to resolve image tags to digests
+	DigestResolver DigestResolver
+
+	// Files are the files bundled with the operator, templates read them using the `files` function
+	Files Files
+
+	// Partials define named templates shared by all the templates, see PartialsIn
+	Partials map[string]string
 }
 
 // New creates an engine with a default function map, using a modified Sprig func map. Because these
 // templates are rendered by the operator, we delete any functions that potentially access the environment
 // the controller is running in.
+//
+// The sprig string functions authors know from Helm are available under the same names, e.g. `trim`, `upper`, `lower`,
+// `replace`, `trunc`, `hasPrefix`, `hasSuffix`, `quote` and `default`, see http://masterminds.github.io/sprig/strings.html
+// So is `sha256sum`, e.g. `checksum/config: {{ include "config" . | sha256sum }}` in the pod template of a Deployment
+// rolls its pods whenever the rendered config changes.
 func New() *Engine {
 	f := sprig.TxtFuncMap()
 
@@ -26,19 +45,55 @@ func New() *Engine {
 		delete(f, fun)
 	}
 
-	return &Engine{
-		FuncMap: f,
+	f["toQuantity"] = toQuantity
+	f["percentOf"] = percentOf
+
+	e := &Engine{
+		FuncMap:        f,
+		DigestResolver: DefaultDigestResolver,
 	}
+	f["pinDigest"] = e.pinDigest
+	f["files"] = func() Files { return e.Files }
+	return e
 }
 
 // Render creates a fully rendered template based on a set of values. It parses these in strict mode,
 // returning errors when keys are missing.
+// Named templates defined by the partials can be used with `{{ template "name" . }}`, or with `include` when the output
+// has to be piped to other functions, e.g. `{{ include "common.labels" . | indent 4 }}`.
 func (e *Engine) Render(tpl string, vals map[string]interface{}) (string, error) {
 	t := template.New("gotpl")
 	t.Option("missingkey=error")
 
+	funcs := template.FuncMap{}
+	for k, v := range e.FuncMap {
+		funcs[k] = v
+	}
+	funcs["include"] = func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		err := t.ExecuteTemplate(&buf, name, data)
+		return buf.String(), err
+	}
+	t.Funcs(funcs)
+
+	partials, err := e.parsePartials(t, funcs)
+	if err != nil {
+		return "", err
+	}
+
+	// named templates of the partials cannot be redefined, so the same name means the same thing in all the templates
+	own, err := template.New("tpl").Funcs(funcs).Parse(tpl)
+	if err != nil {
+		return "", fmt.Errorf("error parsing template: %s", err)
+	}
+	for _, d := range own.Templates() {
+		if partial, ok := partials[d.Name()]; ok {
+			return "", fmt.Errorf("error parsing template: template %q is already defined in partial %s", d.Name(), partial)
+		}
+	}
+
 	var buf bytes.Buffer
-	t = t.New("tpl").Funcs(e.FuncMap)
+	t = t.New("tpl")
 
 	if _, err := t.Parse(tpl); err != nil {
 		return "", fmt.Errorf("error parsing template: %s", err)
@@ -50,3 +105,95 @@ func (e *Engine) Render(tpl string, vals map[string]interface{}) (string, error)
 
 	return buf.String(), nil
 }
+
+// parsePartials adds the named templates defined by the partials to the template set and returns the partial each of
+// them is defined in, a named template can be defined by one partial only
+func (e *Engine) parsePartials(t *template.Template, funcs template.FuncMap) (map[string]string, error) {
+	names := make([]string, 0, len(e.Partials))
+	for name := range e.Partials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	definedIn := make(map[string]string)
+	for _, name := range names {
+		// parsed on its own first to tell which named templates this partial defines
+		own, err := template.New(name).Funcs(funcs).Parse(e.Partials[name])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing partial %s: %s", name, err)
+		}
+		for _, d := range own.Templates() {
+			if d.Name() == name {
+				continue
+			}
+			if other, ok := definedIn[d.Name()]; ok {
+				return nil, fmt.Errorf("template %q is defined in both partials %s and %s", d.Name(), other, name)
+			}
+			definedIn[d.Name()] = name
+		}
+		if _, err := t.New(name).Parse(e.Partials[name]); err != nil {
+			return nil, fmt.Errorf("error parsing partial %s: %s", name, err)
+		}
+	}
+	return definedIn, nil
+}
+
+// PartialsIn returns the partials among the templates of an operator, partials are the files directly in the templates
+// directory whose name starts with an underscore, e.g. `templates/_helpers.tpl`
+// they are not applied, they only define named templates used by the other templates
+func PartialsIn(templates map[string]string) map[string]string {
+	partials := make(map[string]string)
+	for name, content := range templates {
+		if IsPartial(name) {
+			partials[name] = content
+		}
+	}
+	return partials
+}
+
+// IsPartial returns true if the template is a partial, see PartialsIn
+func IsPartial(name string) bool {
+	return strings.HasPrefix(name, "_") && !strings.Contains(name, "/")
+}
+
+// Files gives templates access to the files bundled with the operator keyed by their path, e.g. a config file can be
+// embedded with `{{ files.Get "configs/app.conf" | indent 4 }}`
+type Files map[string]string
+
+// Get returns content of the bundled file, the rendering fails when the operator does not bundle it
+func (f Files) Get(name string) (string, error) {
+	content, ok := f[name]
+	if !ok {
+		return "", fmt.Errorf("file %s is not bundled with the operator", name)
+	}
+	return content, nil
+}
+
+// toQuantity parses the value as a resource quantity and returns it in its canonical form, e.g. `1024Mi` becomes `1Gi`
+// and `0.5` becomes `500m`, malformed quantities fail the rendering
+func toQuantity(value string) (string, error) {
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid quantity: %v", value, err)
+	}
+	return q.String(), nil
+}
+
+// percentOf returns the percentage of the quantity, e.g. `{{ .Cluster.NodeMemory | percentOf 50 }}` is half the memory of
+// a node. The percentage can be a number or a string, so that it can come from a parameter. Results are rounded down to
+// millis, results of at least a thousand units are rounded down to whole units as they are sizes in bytes, not cores.
+func percentOf(percent interface{}, value string) (string, error) {
+	p, err := strconv.ParseFloat(strings.TrimSuffix(fmt.Sprint(percent), "%"), 64)
+	if err != nil || p < 0 {
+		return "", fmt.Errorf("%v is not a valid percentage", percent)
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid quantity: %v", value, err)
+	}
+	milli := int64(math.Floor(float64(q.MilliValue()) * p / 100))
+	if milli >= 1000*1000 {
+		return resource.NewQuantity(milli/1000, q.Format).String(), nil
+	}
+	return resource.NewMilliQuantity(milli, q.Format).String(), nil
+}
@@ -62,3 +62,219 @@ func TestUnsafeFuncs(t *testing.T) {
 	}
 
 }
+
+func TestStringFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"trim", `{{ "  web  " | trim }}`, "web"},
+		{"trim empty", `{{ "   " | trim }}`, ""},
+		{"upper", `{{ "web-1" | upper }}`, "WEB-1"},
+		{"lower", `{{ "Web-1" | lower }}`, "web-1"},
+		{"replace", `{{ "a.b.c" | replace "." "-" }}`, "a-b-c"},
+		{"replace without match", `{{ "abc" | replace "." "-" }}`, "abc"},
+		{"trunc", `{{ "kafka-cluster" | trunc 5 }}`, "kafka"},
+		{"trunc short string", `{{ "zk" | trunc 5 }}`, "zk"},
+		{"hasPrefix", `{{ "kafka-0" | hasPrefix "kafka" }}`, "true"},
+		{"hasPrefix without match", `{{ "zk-0" | hasPrefix "kafka" }}`, "false"},
+		{"hasSuffix", `{{ "data.yaml" | hasSuffix ".yaml" }}`, "true"},
+		{"quote", `{{ "a \"b\"" | quote }}`, `"a \"b\""`},
+		{"quote number", `{{ 3 | quote }}`, `"3"`},
+		{"default of empty value", `{{ "" | default "web" }}`, "web"},
+		{"default of set value", `{{ "db" | default "web" }}`, "db"},
+		{"default of missing parameter", `{{ .Params.MISSING | default "web" }}`, "web"},
+	}
+
+	engine := New()
+	for _, tt := range tests {
+		rendered, err := engine.Render(tt.template, map[string]interface{}{"Params": map[string]interface{}{"MISSING": nil}})
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if rendered != tt.expected {
+			t.Errorf("%s: Expecting %q but got %q", tt.name, tt.expected, rendered)
+		}
+	}
+}
+
+func TestToQuantity(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		err      bool
+	}{
+		{name: "binary suffix", value: "512Mi", expected: "512Mi"},
+		{name: "normalized binary suffix", value: "1024Mi", expected: "1Gi"},
+		{name: "decimal fraction", value: "0.5", expected: "500m"},
+		{name: "millicores", value: "250m", expected: "250m"},
+		{name: "unknown suffix", value: "512MB", err: true},
+		{name: "not a number", value: "lots", err: true},
+		{name: "empty", value: "", err: true},
+	}
+
+	engine := New()
+
+	for _, test := range tests {
+		rendered, err := engine.Render("{{ .Params.Memory | toQuantity }}", map[string]interface{}{
+			"Params": map[string]interface{}{"Memory": test.value},
+		})
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected error for %q, got none", test.name, test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error rendering template: %s", test.name, err)
+		}
+		if rendered != test.expected {
+			t.Errorf("%s: quantity mismatch, expected: %s, got: %s", test.name, test.expected, rendered)
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		value    string
+		expected string
+		err      bool
+	}{
+		{name: "half of node memory", template: "{{ .Node | percentOf 50 }}", value: "16Gi", expected: "8Gi"},
+		{name: "fraction of node memory in kibibytes", template: "{{ .Node | percentOf 25 }}", value: "16331976Ki", expected: "4082994Ki"},
+		{name: "odd bytes are rounded down", template: "{{ .Node | percentOf 33 }}", value: "1Gi", expected: "354334801"},
+		{name: "fraction of cores", template: "{{ .Node | percentOf 30 }}", value: "4", expected: "1200m"},
+		{name: "fraction of millicores", template: "{{ .Node | percentOf 10 }}", value: "3500m", expected: "350m"},
+		{name: "percentage from a parameter", template: `{{ .Node | percentOf "75%" }}`, value: "8Gi", expected: "6Gi"},
+		{name: "invalid percentage", template: `{{ .Node | percentOf "most" }}`, value: "8Gi", err: true},
+		{name: "negative percentage", template: "{{ .Node | percentOf -10 }}", value: "8Gi", err: true},
+		{name: "invalid quantity", template: "{{ .Node | percentOf 50 }}", value: "lots", err: true},
+	}
+
+	engine := New()
+
+	for _, test := range tests {
+		rendered, err := engine.Render(test.template, map[string]interface{}{"Node": test.value})
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", test.name, rendered)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error rendering template: %s", test.name, err)
+		}
+		if rendered != test.expected {
+			t.Errorf("%s: quantity mismatch, expected: %s, got: %s", test.name, test.expected, rendered)
+		}
+	}
+}
+
+func TestFilesFunction(t *testing.T) {
+	engine := New()
+	engine.Files = Files{"configs/app.conf": "port=8080\nlog=debug"}
+
+	rendered, err := engine.Render("data:\n  app.conf: |\n{{ files.Get \"configs/app.conf\" | indent 4 }}", nil)
+	if err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+	if rendered != "data:\n  app.conf: |\n    port=8080\n    log=debug" {
+		t.Errorf("Expecting bundled file to be embedded but got %q", rendered)
+	}
+
+	if _, err := engine.Render(`{{ files.Get "missing.conf" }}`, nil); err == nil {
+		t.Error("Expecting error for file that is not bundled but got none")
+	}
+}
+
+func TestPartials(t *testing.T) {
+	engine := New()
+	engine.Partials = PartialsIn(map[string]string{
+		"_helpers.tpl":    "{{ define \"common.labels\" }}app: {{ .Name }}\nheritage: kudo{{ end }}",
+		"deployment.yaml": "not a partial",
+	})
+
+	vals := map[string]interface{}{"Name": "zk"}
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"template", "kind: Service\nmetadata:\n  labels:\n{{ template \"common.labels\" . }}", "kind: Service\nmetadata:\n  labels:\napp: zk\nheritage: kudo"},
+		{"include", "kind: Deployment\nmetadata:\n  labels:\n{{ include \"common.labels\" . | indent 4 }}", "kind: Deployment\nmetadata:\n  labels:\n    app: zk\n    heritage: kudo"},
+	}
+	for _, tt := range tests {
+		rendered, err := engine.Render(tt.template, vals)
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+			continue
+		}
+		if rendered != tt.expected {
+			t.Errorf("%s: Expecting %q but got %q", tt.name, tt.expected, rendered)
+		}
+	}
+}
+
+func TestSha256sumOfInclude(t *testing.T) {
+	engine := New()
+	engine.Partials = map[string]string{"_config.tpl": "{{ define \"config\" }}port: {{ .Params.PORT }}{{ end }}"}
+	tpl := "checksum/config: {{ include \"config\" . | sha256sum }}"
+	render := func(port string) string {
+		rendered, err := engine.Render(tpl, map[string]interface{}{"Params": map[string]string{"PORT": port}})
+		if err != nil {
+			t.Fatalf("Expecting no error but got %v", err)
+		}
+		return rendered
+	}
+
+	// sha256 of "port: 8080"
+	expected := "checksum/config: c095a45d67410f4c43955c3385319c9971ab26de7a03e39875729c0809891165"
+	first := render("8080")
+	if first != render("8080") {
+		t.Errorf("Expecting the same hash for the same config but got %q and %q", first, render("8080"))
+	}
+	if first != expected {
+		t.Errorf("Expecting %q but got %q", expected, first)
+	}
+	if changed := render("9090"); changed == first {
+		t.Errorf("Expecting the hash to change with the config but got %q for both", changed)
+	}
+}
+
+func TestPartialCollisions(t *testing.T) {
+	engine := New()
+	engine.Partials = map[string]string{
+		"_a.tpl": "{{ define \"common.labels\" }}a{{ end }}",
+		"_b.tpl": "{{ define \"common.labels\" }}b{{ end }}",
+	}
+	if _, err := engine.Render("{{ template \"common.labels\" . }}", nil); err == nil {
+		t.Error("Expecting error for named template defined in two partials but got none")
+	}
+
+	engine.Partials = map[string]string{"_a.tpl": "{{ define \"common.labels\" }}a{{ end }}"}
+	if _, err := engine.Render("{{ define \"common.labels\" }}b{{ end }}{{ template \"common.labels\" . }}", nil); err == nil {
+		t.Error("Expecting error for template redefining a named template of a partial but got none")
+	}
+}
+
+func TestIsPartial(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"_helpers.tpl", true},
+		{"_labels.yaml", true},
+		{"helpers.tpl", false},
+		{"chart/_helpers.tpl", false},
+	}
+	for _, tt := range tests {
+		if IsPartial(tt.name) != tt.expected {
+			t.Errorf("%s: Expecting partial to be %v but got %v", tt.name, tt.expected, !tt.expected)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Renderer renders a template with the given values, it is implemented by the engines of all the templating languages
+// operators can write their templates in
+type Renderer interface {
+	Render(tpl string, vals map[string]interface{}) (string, error)
+}
+
+// variableReference matches `${Params.REPLICAS}` style references and the `$$` escape of a literal `$`
+var variableReference = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// Envsubst renders templates written in the envsubst templating language, which only substitutes references like
+// `${Name}` or `${Params.REPLICAS}` with the values, there are no functions and no control structures
+// a literal `$` is written as `$$`
+type Envsubst struct{}
+
+// NewEnvsubst creates an engine for templates written in the envsubst templating language
+func NewEnvsubst() *Envsubst {
+	return &Envsubst{}
+}
+
+// Render substitutes all the references in the template, like the go template engine it fails on references to values
+// that do not exist
+func (e *Envsubst) Render(tpl string, vals map[string]interface{}) (string, error) {
+	var err error
+	rendered := variableReference.ReplaceAllStringFunc(tpl, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		name := strings.TrimSpace(match[2 : len(match)-1])
+		value, lookupErr := lookupValue(name, vals)
+		if lookupErr != nil && err == nil {
+			err = lookupErr
+		}
+		return value
+	})
+	if err != nil {
+		return "", fmt.Errorf("error rendering template: %s", err)
+	}
+	return rendered, nil
+}
+
+// lookupValue walks the dot separated path through the nested maps of the values
+func lookupValue(name string, vals map[string]interface{}) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty reference")
+	}
+	var current interface{} = vals
+	for _, key := range strings.Split(name, ".") {
+		m := reflect.ValueOf(current)
+		if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String {
+			return "", fmt.Errorf("%s is not a map, cannot look up %s", name, key)
+		}
+		v := m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key()))
+		if !v.IsValid() {
+			return "", fmt.Errorf("map has no entry for key %q of %s", key, name)
+		}
+		current = v.Interface()
+	}
+	if current == nil {
+		return "", nil
+	}
+	return fmt.Sprint(current), nil
+}
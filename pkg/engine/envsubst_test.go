@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestEnvsubstRender(t *testing.T) {
+	vals := map[string]interface{}{
+		"Name":   "instance",
+		"Params": map[string]string{"REPLICAS": "3"},
+		"Values": map[string]interface{}{"resources": map[string]interface{}{"cpu": "500m"}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+		err      bool
+	}{
+		{name: "empty", template: "", expected: ""},
+		{name: "top level value", template: "name: ${Name}", expected: "name: instance"},
+		{name: "parameter", template: "replicas: ${ Params.REPLICAS }", expected: "replicas: 3"},
+		{name: "nested value", template: "cpu: ${Values.resources.cpu}", expected: "cpu: 500m"},
+		{name: "escaped dollar", template: "price: $$5 for ${Name}", expected: "price: $5 for instance"},
+		{name: "dollar without braces is kept", template: "command: echo $HOME", expected: "command: echo $HOME"},
+		{name: "missing value", template: "image: ${Params.IMAGE}", err: true},
+		{name: "not a map", template: "${Name.first}", err: true},
+		{name: "empty reference", template: "${}", err: true},
+	}
+
+	for _, tt := range tests {
+		rendered, err := NewEnvsubst().Render(tt.template, vals)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: Expecting error but got %q", tt.name, rendered)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Expecting no error but got %v", tt.name, err)
+		}
+		if rendered != tt.expected {
+			t.Errorf("%s: Expecting %q but got %q", tt.name, tt.expected, rendered)
+		}
+	}
+}
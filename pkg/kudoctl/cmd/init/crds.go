@@ -160,9 +160,10 @@ func generateOperatorVersion() *apiextv1beta1.CustomResourceDefinition {
 				Properties: paramProps,
 			}, JSONSchemas: []apiextv1beta1.JSONSchemaProps{}},
 		},
-		"plans":     apiextv1beta1.JSONSchemaProps{Type: "object", Description: "Plans specify a map a plans that specify how to"},
-		"tasks":     apiextv1beta1.JSONSchemaProps{Type: "object"},
-		"templates": apiextv1beta1.JSONSchemaProps{Type: "object", Description: "List of go templates YAML files that define the application operator instance"},
+		"plans":              apiextv1beta1.JSONSchemaProps{Type: "object", Description: "Plans specify a map a plans that specify how to"},
+		"tasks":              apiextv1beta1.JSONSchemaProps{Type: "object"},
+		"templates":          apiextv1beta1.JSONSchemaProps{Type: "object", Description: "List of go templates YAML files that define the application operator instance"},
+		"templatingLanguage": apiextv1beta1.JSONSchemaProps{Type: "string", Description: "TemplatingLanguage is the language the templates are written in, go-template (default) or envsubst"},
 		"upgradableFrom": apiextv1beta1.JSONSchemaProps{
 			Type:        "array",
 			Description: "UpgradableFrom lists all OperatorVersions that can upgrade to this OperatorVersion",
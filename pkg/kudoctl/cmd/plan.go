@@ -1,16 +1,31 @@
 package cmd
 
 import (
+	"github.com/kudobuilder/kudo/pkg/kudoctl/cmd/install"
 	"github.com/kudobuilder/kudo/pkg/kudoctl/cmd/plan"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
 const (
 	planHistExample = `  # View plan status
   kubectl kudo plan history <operatorVersion> --instance=<instanceName>
+`
+	planDiffExample = `  # View the changes the deploy plan would make to the objects of an instance
+  kubectl kudo plan diff --instance=<instanceName> --plan=deploy
+`
+	planSimulateExample = `  # Check that the deploy plan of a local operator completes when its Deployment takes two executions to become ready
+  kubectl kudo plan simulate ./operator --plan=deploy -p REPLICAS=3 --healthy-after=Deployment/instance-web=2
+
+  # Check how the plan behaves when creating a ConfigMap fails
+  kubectl kudo plan simulate ./operator --fail=ConfigMap/instance-config="quota exceeded"
 `
 	planStatuExample = `  # View plan status
   kubectl kudo plan status --instance=<instanceName>
+
+  # View plan status as JSON
+  kubectl kudo plan status --instance=<instanceName> --output=json
 `
 )
 
@@ -24,6 +39,8 @@ func newPlanCmd() *cobra.Command {
 
 	newCmd.AddCommand(NewPlanHistoryCmd())
 	newCmd.AddCommand(NewPlanStatusCmd())
+	newCmd.AddCommand(NewPlanDiffCmd())
+	newCmd.AddCommand(NewPlanSimulateCmd(fs))
 
 	return newCmd
 }
@@ -58,6 +75,63 @@ func NewPlanStatusCmd() *cobra.Command {
 	}
 
 	statusCmd.Flags().StringVar(&options.Instance, "instance", "", "The instance name available from 'kubectl get instances'")
+	statusCmd.Flags().StringVarP(&options.Output, "output", "o", "", "Output format, \"json\" prints the state of the active plan as JSON")
 
 	return statusCmd
 }
+
+// NewPlanDiffCmd creates a command that shows the changes a plan would make to the objects of an instance without changing them
+func NewPlanDiffCmd() *cobra.Command {
+	options := plan.DefaultDiffOptions
+	diffCmd := &cobra.Command{
+		Use:     "diff",
+		Short:   "Shows the objects a plan would create, update or delete.",
+		Example: planDiffExample,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return plan.RunDiff(cmd, options, &Settings)
+		},
+	}
+
+	diffCmd.Flags().StringVar(&options.Instance, "instance", "", "The instance name available from 'kubectl get instances'")
+	diffCmd.Flags().StringVar(&options.Plan, "plan", "", "The name of the plan to diff.")
+	diffCmd.Flags().StringVar(&options.LabelKeys, "label-keys", "", "The label keys the KUDO manager is configured with in KUDO_LABEL_KEYS, e.g. 'instance=example.com/instance'")
+	diffCmd.Flags().StringVar(&options.ClusterConfig, "cluster-config", options.ClusterConfig, "The namespace and name of the cluster config map the KUDO manager reads cluster variables from, empty for none.")
+	diffCmd.Flags().BoolVar(&options.ConventionsFallback, "conventions-fallback", false, "Whether the KUDO manager is configured with KUDO_CONVENTIONS_FALLBACK.")
+
+	return diffCmd
+}
+
+// NewPlanSimulateCmd creates a command that runs a plan of a local operator in a fake cluster until it is finished
+func NewPlanSimulateCmd(fs afero.Fs) *cobra.Command {
+	options := plan.DefaultSimulateOptions
+	var parameters, healthyAfter, failures []string
+	simulateCmd := &cobra.Command{
+		Use:     "simulate <operator>",
+		Short:   "Runs a plan of a local operator in a simulated cluster.",
+		Example: planSimulateExample,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if options.Parameters, err = install.GetParameterMap(parameters); err != nil {
+				return errors.WithMessage(err, "could not parse parameters")
+			}
+			if options.HealthyAfter, err = install.GetParameterMap(healthyAfter); err != nil {
+				return errors.WithMessage(err, "could not parse healthy-after")
+			}
+			if options.Failures, err = install.GetParameterMap(failures); err != nil {
+				return errors.WithMessage(err, "could not parse failures")
+			}
+			return plan.RunSimulate(cmd, args, options, fs)
+		},
+	}
+
+	simulateCmd.Flags().StringVar(&options.Instance, "instance", options.Instance, "The name of the simulated instance.")
+	simulateCmd.Flags().StringVar(&options.Namespace, "namespace", options.Namespace, "The namespace of the simulated instance.")
+	simulateCmd.Flags().StringVar(&options.Plan, "plan", options.Plan, "The name of the plan to simulate.")
+	simulateCmd.Flags().StringArrayVarP(&parameters, "parameter", "p", nil, "The parameter name and value separated by '='")
+	simulateCmd.Flags().StringArrayVar(&healthyAfter, "healthy-after", nil, "The kind and name of an object and the number of executions it stays unhealthy for separated by '=', negative numbers keep it unhealthy")
+	simulateCmd.Flags().StringArrayVar(&failures, "fail", nil, "The kind and name of an object and the error creating or patching it fails with separated by '='")
+	simulateCmd.Flags().IntVar(&options.MaxIterations, "max-iterations", 100, "The number of executions after which the simulation stops.")
+	simulateCmd.Flags().StringVar(&options.LabelKeys, "label-keys", "", "The label keys the KUDO manager is configured with in KUDO_LABEL_KEYS, e.g. 'instance=example.com/instance'")
+
+	return simulateCmd
+}
@@ -0,0 +1,133 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kudobuilder/kudo/pkg/apis"
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/controller/instance"
+	"github.com/kudobuilder/kudo/pkg/kudoctl/env"
+	"github.com/spf13/cobra"
+	"github.com/xlab/treeprint"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+var (
+	// DefaultDiffOptions provides the default options for plan diff
+	DefaultDiffOptions = &Options{ClusterConfig: fmt.Sprintf("kudo-system/%s", instance.ClusterConfigMapName)}
+)
+
+// RunDiff runs the plan diff command
+func RunDiff(cmd *cobra.Command, options *Options, settings *env.Settings) error {
+	if options.Instance == "" {
+		return fmt.Errorf("flag Error: Please set instance flag, e.g. \"--instance=<instanceName>\"")
+	}
+	if options.Plan == "" {
+		return fmt.Errorf("flag Error: Please set plan flag, e.g. \"--plan=<planName>\"")
+	}
+
+	err := planDiff(cmd, options, settings)
+	if err != nil {
+		return fmt.Errorf("client Error: %v", err)
+	}
+	return nil
+}
+
+func planDiff(cmd *cobra.Command, options *Options, settings *env.Settings) error {
+	config, err := clientcmd.BuildConfigFromFlags("", settings.KubeConfig)
+	if err != nil {
+		return err
+	}
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		return err
+	}
+	if err := apis.AddToScheme(s); err != nil {
+		return err
+	}
+	c, err := client.New(config, client.Options{Scheme: s})
+	if err != nil {
+		return err
+	}
+
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = settings.Namespace
+	}
+	i := &v1alpha1.Instance{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: options.Instance}, i); err != nil {
+		return err
+	}
+	ov := &v1alpha1.OperatorVersion{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: i.OperatorVersionNamespace(), Name: i.Spec.OperatorVersion.Name}, ov); err != nil {
+		return err
+	}
+
+	labelKeys, err := instance.ParseLabelKeys(options.LabelKeys)
+	if err != nil {
+		return fmt.Errorf("invalid label keys: %v", err)
+	}
+	clusterConfig, err := parseClusterConfig(options.ClusterConfig)
+	if err != nil {
+		return err
+	}
+	mapper, err := apiutil.NewDiscoveryRESTMapper(config)
+	if err != nil {
+		return err
+	}
+	// the objects are rendered the way the controller renders them
+	changes, err := instance.DiffPlan(c, s, i, ov, options.Plan, instance.DiffOptions{
+		ClusterConfig:       clusterConfig,
+		LabelKeys:           labelKeys,
+		ConventionsFallback: options.ConventionsFallback,
+		Mapper:              mapper,
+	})
+	if err != nil {
+		return err
+	}
+
+	tree := treeprint.New()
+	tree.SetValue(fmt.Sprintf("%s (Plan: %s)", i.Name, options.Plan))
+	var phase, step treeprint.Tree
+	lastPhase, lastStep := "", ""
+	for _, change := range changes {
+		if phase == nil || change.Phase != lastPhase {
+			phase = tree.AddBranch(fmt.Sprintf("Phase %s", change.Phase))
+			lastPhase, step = change.Phase, nil
+		}
+		if step == nil || change.Step != lastStep {
+			step = phase.AddBranch(fmt.Sprintf("Step %s", change.Step))
+			lastStep = change.Step
+		}
+		object := fmt.Sprintf("%s %s/%s: %s", change.Kind, change.Namespace, change.Name, change.Type)
+		if len(change.Fields) == 0 {
+			step.AddNode(object)
+			continue
+		}
+		fields := step.AddBranch(object)
+		for _, f := range change.Fields {
+			fields.AddNode(f)
+		}
+	}
+	fmt.Fprint(cmd.OutOrStdout(), tree.String())
+	return nil
+}
+
+// parseClusterConfig parses the `namespace/name` of the cluster config map, empty means no cluster config map
+func parseClusterConfig(clusterConfig string) (types.NamespacedName, error) {
+	if clusterConfig == "" {
+		return types.NamespacedName{}, nil
+	}
+	parts := strings.Split(clusterConfig, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, fmt.Errorf("flag Error: cluster config must be namespace/name but is %q", clusterConfig)
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, nil
+}
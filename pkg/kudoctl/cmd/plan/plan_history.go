@@ -15,6 +15,15 @@ import (
 type Options struct {
 	Instance  string
 	Namespace string
+	Plan      string
+	// Output is the format of the plan status, either empty for a tree or `json`
+	Output string
+	// LabelKeys are the label keys the controller is configured with as its KUDO_LABEL_KEYS, see instance.ParseLabelKeys
+	LabelKeys string
+	// ClusterConfig is the `namespace/name` of the cluster config map the controller reads `.Cluster` variables from
+	ClusterConfig string
+	// ConventionsFallback is whether the controller is configured with KUDO_CONVENTIONS_FALLBACK
+	ConventionsFallback bool
 }
 
 var (
@@ -0,0 +1,103 @@
+package plan
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kudobuilder/kudo/pkg/apis"
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/controller/instance"
+	"github.com/kudobuilder/kudo/pkg/kudoctl/packages"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/xlab/treeprint"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// SimulateOptions are the options of plan simulate
+type SimulateOptions struct {
+	Instance   string
+	Namespace  string
+	Plan       string
+	Parameters map[string]string
+	// HealthyAfter is the number of executions objects stay unhealthy for keyed by kind and name, e.g. `Deployment/instance-web`
+	HealthyAfter map[string]string
+	// Failures are errors returned for creating or patching objects keyed by kind and name
+	Failures      map[string]string
+	MaxIterations int
+	// LabelKeys are the label keys the controller is configured with as its KUDO_LABEL_KEYS, see instance.ParseLabelKeys
+	LabelKeys string
+}
+
+var (
+	// DefaultSimulateOptions provides the default options for plan simulate
+	DefaultSimulateOptions = &SimulateOptions{Instance: "instance", Namespace: "default", Plan: "deploy"}
+)
+
+// RunSimulate runs the plan simulate command
+func RunSimulate(cmd *cobra.Command, args []string, options *SimulateOptions, fs afero.Fs) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expecting exactly one argument - directory or tarball of the operator")
+	}
+	healthyAfter := make(map[string]int, len(options.HealthyAfter))
+	for key, value := range options.HealthyAfter {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("flag Error: healthy-after of %s must be a number but is %q", key, value)
+		}
+		healthyAfter[key] = n
+	}
+	labelKeys, err := instance.ParseLabelKeys(options.LabelKeys)
+	if err != nil {
+		return fmt.Errorf("flag Error: invalid label keys: %v", err)
+	}
+
+	pkg, err := packages.ReadPackage(fs, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read package %s: %v", args[0], err)
+	}
+	crds, err := pkg.GetCRDs()
+	if err != nil {
+		return fmt.Errorf("failed to read package %s: %v", args[0], err)
+	}
+
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		return err
+	}
+	if err := apis.AddToScheme(s); err != nil {
+		return err
+	}
+	result, err := instance.SimulatePlan(s, crds.OperatorVersion, options.Plan, options.Instance, options.Namespace, options.Parameters, instance.SimulationOptions{
+		MaxIterations: options.MaxIterations,
+		HealthyAfter:  healthyAfter,
+		Failures:      options.Failures,
+		LabelKeys:     labelKeys,
+	})
+	if err != nil {
+		return err
+	}
+
+	tree := treeprint.New()
+	tree.SetValue(fmt.Sprintf("%s (Plan: %s, %s after %d iterations)", options.Instance, options.Plan, result.Status.Status, result.Iterations))
+	for _, ph := range result.Status.Phases {
+		phase := tree.AddBranch(fmt.Sprintf("Phase %s (%s)", ph.Name, ph.Status))
+		for _, st := range ph.Steps {
+			step := fmt.Sprintf("Step %s (%s)", st.Name, st.Status)
+			if st.Message != "" {
+				step = fmt.Sprintf("%s: %s", step, st.Message)
+			}
+			phase.AddNode(step)
+		}
+	}
+	fmt.Fprint(cmd.OutOrStdout(), tree.String())
+
+	if result.Status.Status != v1alpha1.ExecutionComplete {
+		if result.Err != nil {
+			return fmt.Errorf("plan %s did not complete, its status is %s: %v", options.Plan, result.Status.Status, result.Err)
+		}
+		return fmt.Errorf("plan %s did not complete, its status is %s", options.Plan, result.Status.Status)
+	}
+	return nil
+}
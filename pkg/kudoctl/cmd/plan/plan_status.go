@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 
 	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
 	"github.com/kudobuilder/kudo/pkg/kudoctl/env"
@@ -25,6 +26,9 @@ func RunStatus(cmd *cobra.Command, args []string, options *Options, settings *en
 	if err != nil || instanceFlag == "" {
 		return fmt.Errorf("flag Error: Please set instance flag, e.g. \"--instance=<instanceName>\"")
 	}
+	if options.Output != "" && options.Output != "json" {
+		return fmt.Errorf("flag Error: Unsupported output format %q, only \"json\" is supported", options.Output)
+	}
 
 	err = planStatus(options, settings)
 	if err != nil {
@@ -99,6 +103,13 @@ func planStatus(options *Options, settings *env.Settings) error {
 
 	activePlanStatus := instance.GetPlanInProgress()
 
+	if options.Output == "json" {
+		if activePlanStatus == nil {
+			return fmt.Errorf("no active plan exists for instance %s", instance.Name)
+		}
+		return NewExecutionReport(&instance, activePlanStatus).WriteJSON(os.Stdout)
+	}
+
 	if activePlanStatus == nil {
 		log.Printf("No active plan exists for instance %s", instance.Name)
 		return nil
@@ -112,7 +123,7 @@ func planStatus(options *Options, settings *env.Settings) error {
 			planDisplay := fmt.Sprintf("Plan %s (%s strategy) [%s]", name, plan.Strategy, activePlanStatus.Status)
 			planBranchName := rootBranchName.AddBranch(planDisplay)
 			for _, phase := range activePlanStatus.Phases {
-				phaseDisplay := fmt.Sprintf("Phase %s [%s]", phase.Name, phase.Status)
+				phaseDisplay := fmt.Sprintf("Phase %s [%s] (%d/%d steps completed)", phase.Name, phase.Status, phase.CompletedSteps, phase.TotalSteps)
 				phaseBranchName := planBranchName.AddBranch(phaseDisplay)
 				for _, steps := range phase.Steps {
 					stepsDisplay := fmt.Sprintf("Step %s (%s)", steps.Name, steps.Status)
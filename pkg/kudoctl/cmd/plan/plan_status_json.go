@@ -0,0 +1,124 @@
+package plan
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StatusSchemaVersion identifies the layout of the JSON written by plan status, it changes only when fields are
+// removed or change their meaning, adding fields keeps the version
+const StatusSchemaVersion = "kudo.dev/plan-status/v1"
+
+// ExecutionReport is the machine readable state of a plan execution printed by `plan status --output json`. It is
+// deliberately decoupled from the API types so that the CLI contract does not change with new API versions.
+type ExecutionReport struct {
+	SchemaVersion string `json:"schemaVersion"`
+	Instance      string `json:"instance"`
+	Namespace     string `json:"namespace"`
+
+	Plan             string          `json:"plan"`
+	Status           string          `json:"status"`
+	Message          string          `json:"message,omitempty"`
+	LastProgressTime *time.Time      `json:"lastProgressTime,omitempty"`
+	LastFinishedRun  *time.Time      `json:"lastFinishedRun,omitempty"`
+	Phases           []PhaseReport   `json:"phases"`
+	Current          *ReportPosition `json:"current,omitempty"`
+}
+
+// PhaseReport is the state of a single phase of an ExecutionReport
+type PhaseReport struct {
+	Name           string       `json:"name"`
+	Status         string       `json:"status"`
+	CompletedSteps int          `json:"completedSteps"`
+	TotalSteps     int          `json:"totalSteps"`
+	Steps          []StepReport `json:"steps"`
+}
+
+// StepReport is the state of a single step of an ExecutionReport
+type StepReport struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	Stage     string     `json:"stage,omitempty"`
+	Message   string     `json:"message,omitempty"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+}
+
+// ReportPosition points to the phase and step a plan execution is currently at
+type ReportPosition struct {
+	Phase string `json:"phase"`
+	Step  string `json:"step,omitempty"`
+}
+
+// NewExecutionReport projects the status of a plan of the given instance into an ExecutionReport
+func NewExecutionReport(instance *kudov1alpha1.Instance, status *kudov1alpha1.PlanStatus) *ExecutionReport {
+	report := &ExecutionReport{
+		SchemaVersion:    StatusSchemaVersion,
+		Instance:         instance.Name,
+		Namespace:        instance.Namespace,
+		Plan:             status.Name,
+		Status:           string(status.Status),
+		LastProgressTime: reportTime(status.LastProgressTime),
+		LastFinishedRun:  reportTime(status.LastFinishedRun),
+		Phases:           make([]PhaseReport, 0, len(status.Phases)),
+	}
+	if status.LastError != nil {
+		report.Message = status.LastError.Message
+	}
+
+	for _, phase := range status.Phases {
+		phaseReport := PhaseReport{
+			Name:           phase.Name,
+			Status:         string(phase.Status),
+			CompletedSteps: phase.CompletedSteps,
+			TotalSteps:     phase.TotalSteps,
+			Steps:          make([]StepReport, 0, len(phase.Steps)),
+		}
+		for _, step := range phase.Steps {
+			phaseReport.Steps = append(phaseReport.Steps, StepReport{
+				Name:      step.Name,
+				Status:    string(step.Status),
+				Stage:     string(step.Stage),
+				Message:   step.Message,
+				StartedAt: reportTime(step.StartedAt),
+			})
+
+			if report.Current == nil && isActive(status.Status) && !isDone(step.Status) {
+				report.Current = &ReportPosition{Phase: phase.Name, Step: step.Name}
+			}
+		}
+		if report.Current == nil && isActive(status.Status) && !isDone(phase.Status) {
+			report.Current = &ReportPosition{Phase: phase.Name}
+		}
+		report.Phases = append(report.Phases, phaseReport)
+	}
+
+	return report
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *ExecutionReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// isActive returns true for plans that are being executed, only those have a current position
+func isActive(status kudov1alpha1.ExecutionStatus) bool {
+	return status != "" && status != kudov1alpha1.ExecutionComplete && status != kudov1alpha1.ExecutionNeverRun
+}
+
+func isDone(status kudov1alpha1.ExecutionStatus) bool {
+	return status == kudov1alpha1.ExecutionComplete
+}
+
+func reportTime(t metav1.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,131 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testInstance() *v1alpha1.Instance {
+	return &v1alpha1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "zk", Namespace: "default"}}
+}
+
+func TestExecutionReportJSONShape(t *testing.T) {
+	started := metav1.NewTime(time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC))
+	status := &v1alpha1.PlanStatus{
+		Name:             "deploy",
+		Status:           v1alpha1.ExecutionInProgress,
+		LastProgressTime: started,
+		Phases: []v1alpha1.PhaseStatus{
+			{Name: "zookeeper", Status: v1alpha1.ExecutionInProgress, CompletedSteps: 1, TotalSteps: 2, Steps: []v1alpha1.StepStatus{
+				{Name: "config", Status: v1alpha1.ExecutionComplete, StartedAt: started},
+				{Name: "everything", Status: v1alpha1.ExecutionInProgress, Stage: v1alpha1.TasksStage, Message: "waiting for statefulset default/zk", StartedAt: started},
+			}},
+			{Name: "validation", Status: v1alpha1.ExecutionPending, TotalSteps: 1, Steps: []v1alpha1.StepStatus{
+				{Name: "validate", Status: v1alpha1.ExecutionPending},
+			}},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := NewExecutionReport(testInstance(), status).WriteJSON(&out); err != nil {
+		t.Fatalf("Expecting no error but got %v", err)
+	}
+
+	var shape map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &shape); err != nil {
+		t.Fatalf("Expecting valid JSON but got %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"schemaVersion":    StatusSchemaVersion,
+		"instance":         "zk",
+		"namespace":        "default",
+		"plan":             "deploy",
+		"status":           "IN_PROGRESS",
+		"lastProgressTime": "2019-07-01T12:00:00Z",
+		"current":          map[string]interface{}{"phase": "zookeeper", "step": "everything"},
+		"phases": []interface{}{
+			map[string]interface{}{
+				"name": "zookeeper", "status": "IN_PROGRESS", "completedSteps": float64(1), "totalSteps": float64(2),
+				"steps": []interface{}{
+					map[string]interface{}{"name": "config", "status": "COMPLETE", "startedAt": "2019-07-01T12:00:00Z"},
+					map[string]interface{}{"name": "everything", "status": "IN_PROGRESS", "stage": "TASKS", "message": "waiting for statefulset default/zk", "startedAt": "2019-07-01T12:00:00Z"},
+				},
+			},
+			map[string]interface{}{
+				"name": "validation", "status": "PENDING", "completedSteps": float64(0), "totalSteps": float64(1),
+				"steps": []interface{}{
+					map[string]interface{}{"name": "validate", "status": "PENDING"},
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(expected, shape) {
+		t.Errorf("Expecting JSON\n%v\nbut got\n%s", expected, out.String())
+	}
+}
+
+func TestExecutionReportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          *v1alpha1.PlanStatus
+		expectedCurrent *ReportPosition
+	}{
+		{"complete plan has no current position", &v1alpha1.PlanStatus{
+			Name:   "deploy",
+			Status: v1alpha1.ExecutionComplete,
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionComplete, Steps: []v1alpha1.StepStatus{{Name: "step", Status: v1alpha1.ExecutionComplete}}}},
+		}, nil},
+		{"failed plan points at the failed step", &v1alpha1.PlanStatus{
+			Name:      "deploy",
+			Status:    v1alpha1.ExecutionFatalError,
+			LastError: &v1alpha1.ExecutionError{Code: "InvalidPlan", Message: "step has no tasks"},
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionFatalError, Steps: []v1alpha1.StepStatus{
+				{Name: "first", Status: v1alpha1.ExecutionComplete},
+				{Name: "second", Status: v1alpha1.ExecutionFatalError, Message: "step has no tasks"},
+			}}},
+		}, &ReportPosition{Phase: "phase", Step: "second"}},
+		{"plan that never ran has no current position", &v1alpha1.PlanStatus{
+			Name:   "backup",
+			Status: v1alpha1.ExecutionNeverRun,
+			Phases: []v1alpha1.PhaseStatus{{Name: "phase", Status: v1alpha1.ExecutionNeverRun}},
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := NewExecutionReport(testInstance(), tt.status).WriteJSON(&out); err != nil {
+			t.Fatalf("%s: Expecting no error but got %v", tt.name, err)
+		}
+
+		report := ExecutionReport{}
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("%s: Expecting valid JSON but got %v", tt.name, err)
+		}
+
+		if report.Plan != tt.status.Name || report.Status != string(tt.status.Status) {
+			t.Errorf("%s: Expecting plan %s with status %s but got %s with %s", tt.name, tt.status.Name, tt.status.Status, report.Plan, report.Status)
+		}
+		if tt.status.LastError != nil && report.Message != tt.status.LastError.Message {
+			t.Errorf("%s: Expecting message %q but got %q", tt.name, tt.status.LastError.Message, report.Message)
+		}
+		if len(report.Phases) != len(tt.status.Phases) {
+			t.Fatalf("%s: Expecting %d phases but got %d", tt.name, len(tt.status.Phases), len(report.Phases))
+		}
+		for i, phase := range tt.status.Phases {
+			if report.Phases[i].Name != phase.Name || report.Phases[i].Status != string(phase.Status) || len(report.Phases[i].Steps) != len(phase.Steps) {
+				t.Errorf("%s: Expecting phase %v but got %v", tt.name, phase, report.Phases[i])
+			}
+		}
+		if !reflect.DeepEqual(tt.expectedCurrent, report.Current) {
+			t.Errorf("%s: Expecting current position %v but got %v", tt.name, tt.expectedCurrent, report.Current)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -23,7 +24,7 @@ import (
 
 const (
 	operatorFileName      = "operator.yaml"
-	templateFileNameRegex = "templates/.*.yaml"
+	templateFileNameRegex = "templates/.+"
 	paramsFileName        = "params.yaml"
 )
 
@@ -56,6 +57,8 @@ type Operator struct {
 	URL               string                       `json:"url,omitempty"`
 	Tasks             map[string]v1alpha1.TaskSpec `json:"tasks"`
 	Plans             map[string]v1alpha1.Plan     `json:"plans"`
+	// TemplatingLanguage is the language of the templates of the operator, go templates are used when it is not set
+	TemplatingLanguage v1alpha1.TemplatingLanguage `json:"templatingLanguage,omitempty"`
 }
 
 // PackageFilesDigest is a tuple of data used to return the package files AND the digest of a tarball
@@ -149,7 +152,13 @@ func (p *PackageFiles) getCRDs() (*PackageCRDs, error) {
 				errs = append(errs, fmt.Sprintf("task %s missing template: %s", k, res))
 			}
 		}
+		if v.Helm != nil {
+			if _, ok := p.Templates[v.Helm.Chart+"/Chart.yaml"]; !ok {
+				errs = append(errs, fmt.Sprintf("task %s missing chart: %s", k, v.Helm.Chart))
+			}
+		}
 	}
+	errs = append(errs, duplicateNames(p.Operator.Plans)...)
 
 	if len(errs) != 0 {
 		return nil, errors.New(strings.Join(errs, "\n"))
@@ -188,12 +197,13 @@ func (p *PackageFiles) getCRDs() (*PackageCRDs, error) {
 				Name: p.Operator.Name,
 				Kind: "Operator",
 			},
-			Version:        p.Operator.Version,
-			Templates:      p.Templates,
-			Tasks:          p.Operator.Tasks,
-			Parameters:     p.Params,
-			Plans:          p.Operator.Plans,
-			UpgradableFrom: nil,
+			Version:            p.Operator.Version,
+			Templates:          p.Templates,
+			Tasks:              p.Operator.Tasks,
+			TemplatingLanguage: p.Operator.TemplatingLanguage,
+			Parameters:         p.Params,
+			Plans:              p.Operator.Plans,
+			UpgradableFrom:     nil,
 		},
 		Status: v1alpha1.OperatorVersionStatus{},
 	}
@@ -222,6 +232,35 @@ func (p *PackageFiles) getCRDs() (*PackageCRDs, error) {
 	}, nil
 }
 
+// duplicateNames returns the phases defined more than once in a plan and the steps defined more than once in a phase,
+// the controller looks their status up by name so it would mix them up
+func duplicateNames(plans map[string]v1alpha1.Plan) []string {
+	planNames := make([]string, 0, len(plans))
+	for name := range plans {
+		planNames = append(planNames, name)
+	}
+	sort.Strings(planNames)
+
+	var errs []string
+	for _, planName := range planNames {
+		phases := make(map[string]bool)
+		for _, ph := range plans[planName].Phases {
+			if phases[ph.Name] {
+				errs = append(errs, fmt.Sprintf("plan %s defines phase %s more than once", planName, ph.Name))
+			}
+			phases[ph.Name] = true
+			steps := make(map[string]bool)
+			for _, st := range ph.Steps {
+				if steps[st.Name] {
+					errs = append(errs, fmt.Sprintf("phase %s of plan %s defines step %s more than once", ph.Name, planName, st.Name))
+				}
+				steps[st.Name] = true
+			}
+		}
+	}
+	return errs
+}
+
 // GetFilesDigest maps []string of paths to the [] Operators
 func GetFilesDigest(fs afero.Fs, paths []string) []*PackageFilesDigest {
 	return mapPaths(fs, paths, pathToOperator)
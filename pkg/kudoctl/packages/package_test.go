@@ -120,3 +120,34 @@ func loadCRDsFromPath(goldenPath string) (*PackageCRDs, error) {
 	}
 	return result, nil
 }
+
+func TestGetCRDsRejectsDuplicateNames(t *testing.T) {
+	phase := v1alpha1.Phase{Name: "main", Strategy: "serial", Steps: []v1alpha1.Step{{Name: "app", Tasks: []string{"app"}}, {Name: "app", Tasks: []string{"app"}}}}
+	pkg := &PackageFiles{
+		Templates: map[string]string{"deployment.yaml": ""},
+		Params:    []v1alpha1.Parameter{},
+		Operator: &Operator{
+			Name:    "kafka",
+			Version: "1.0.0",
+			Tasks:   map[string]v1alpha1.TaskSpec{"app": {Resources: []string{"deployment.yaml"}}},
+			Plans: map[string]v1alpha1.Plan{
+				"deploy":  {Strategy: "serial", Phases: []v1alpha1.Phase{phase, {Name: "main", Strategy: "serial"}}},
+				"upgrade": {Strategy: "serial", Phases: []v1alpha1.Phase{{Name: "main", Strategy: "serial", Steps: phase.Steps[:1]}}},
+			},
+		},
+	}
+
+	_, err := pkg.getCRDs()
+	if err == nil {
+		t.Fatal("Expecting an error for duplicate names but got none")
+	}
+	expected := "phase main of plan deploy defines step app more than once\nplan deploy defines phase main more than once"
+	if err.Error() != expected {
+		t.Errorf("Expecting error %q but got %q", expected, err.Error())
+	}
+
+	pkg.Operator.Plans["deploy"] = pkg.Operator.Plans["upgrade"]
+	if _, err := pkg.getCRDs(); err != nil {
+		t.Errorf("Expecting no error for unique names but got %v", err)
+	}
+}
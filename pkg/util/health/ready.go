@@ -1,56 +1,281 @@
 package health
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// IsHealthy returns whether an object is healthy. Must be implemented for each type.
+// IsHealthy fetches the current state of the object and returns whether it is healthy.
+// The object is updated in place with the fetched state.
 func IsHealthy(c client.Client, obj runtime.Object) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+	err = c.Get(context.TODO(), key, obj)
+	if err != nil {
+		log.Printf("HealthUtil: Error fetching %v to check its health: %v", key, err)
+		return fmt.Errorf("error fetching %v to check its health: %v", key, err)
+	}
+	if pvc, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+		return pvcHealthy(c, pvc)
+	}
+	return IsReady(obj)
+}
 
+// IsReady returns whether an object is healthy based on its current state, without talking to the cluster.
+// Must be implemented for each type, objects of types without readiness rules (including Pods) are healthy once they exist.
+func IsReady(obj runtime.Object) error {
 	switch obj := obj.(type) {
 	case *appsv1.StatefulSet:
-		if obj.Spec.Replicas == nil {
-			return fmt.Errorf("replicas not set, so can't be healthy")
-		}
-		if obj.Status.ReadyReplicas == *obj.Spec.Replicas {
-			log.Printf("Statefulset %v is marked healthy\n", obj.Name)
-			return nil
-		}
-		log.Printf("HealthUtil: Statefulset %v is NOT healthy. Not enough ready replicas: %v/%v", obj.Name, obj.Status.ReadyReplicas, obj.Status.Replicas)
-		return fmt.Errorf("ready replicas (%v) does not equal requested replicas (%v)", obj.Status.ReadyReplicas, obj.Status.Replicas)
+		return statefulSetReady(obj)
 	case *appsv1.Deployment:
-		if obj.Spec.Replicas != nil && obj.Status.ReadyReplicas == *obj.Spec.Replicas {
-			log.Printf("HealthUtil: Deployment %v is marked healthy", obj.Name)
-			return nil
-		}
-		log.Printf("HealthUtil: Deployment %v is NOT healthy. Not enough ready replicas: %v/%v", obj.Name, obj.Status.ReadyReplicas, *obj.Spec.Replicas)
-		return fmt.Errorf("ready replicas (%v) does not equal requested replicas (%v)", obj.Status.ReadyReplicas, *obj.Spec.Replicas)
+		return deploymentReady(obj)
+	case *appsv1.DaemonSet:
+		return daemonSetReady(obj)
 	case *batchv1.Job:
+		return jobReady(obj)
+	case *batchv1beta1.CronJob:
+		// a CronJob only creates Jobs on its schedule, there is nothing to wait for once it exists
+		return nil
+	case *corev1.PersistentVolumeClaim:
+		return pvcReady(obj, false)
+	case *kudov1alpha1.Instance:
+		return instanceReady(obj)
+	case *unstructured.Unstructured:
+		return conditionReady(obj)
 
-		if obj.Status.Succeeded == int32(1) {
-			// Done!
-			log.Printf("HealthUtil: Job \"%v\" is marked healthy", obj.Name)
+	// unless we build logic for what a healthy object is, assume it's healthy when created.
+	default:
+		log.Printf("HealthUtil: Unknown type is marked healthy by default")
+		return nil
+	}
+}
+
+// statefulSetReady returns nil once all the requested replicas are ready and the ones from the partition of its rolling
+// update up are updated, a StatefulSet scaled to zero is healthy as there is nothing to run
+func statefulSetReady(obj *appsv1.StatefulSet) error {
+	if obj.Spec.Replicas == nil {
+		return fmt.Errorf("replicas not set, so can't be healthy")
+	}
+	if *obj.Spec.Replicas == 0 {
+		log.Printf("HealthUtil: Statefulset %v is scaled to zero, it is marked healthy", obj.Name)
+		return nil
+	}
+	if obj.Status.ObservedGeneration < obj.Generation {
+		return fmt.Errorf("statefulset %v has not observed its latest generation yet", obj.Name)
+	}
+	// only the pods from the partition up are updated, e.g. a canary keeps the pods below the partition at the old template
+	if rollingUpdate := obj.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		updated := *obj.Spec.Replicas - *rollingUpdate.Partition
+		if updated < 0 {
+			updated = 0
+		}
+		if obj.Status.UpdatedReplicas < updated {
+			log.Printf("HealthUtil: Statefulset %v is NOT healthy. Rollout is at partition %v: %v/%v replicas updated", obj.Name, *rollingUpdate.Partition, obj.Status.UpdatedReplicas, updated)
+			return fmt.Errorf("rollout is at partition %v, updated replicas (%v) is less than %v", *rollingUpdate.Partition, obj.Status.UpdatedReplicas, updated)
+		}
+	}
+	if obj.Status.ReadyReplicas == *obj.Spec.Replicas {
+		log.Printf("Statefulset %v is marked healthy\n", obj.Name)
+		return nil
+	}
+	log.Printf("HealthUtil: Statefulset %v is NOT healthy. Not enough ready replicas: %v/%v", obj.Name, obj.Status.ReadyReplicas, obj.Status.Replicas)
+	return fmt.Errorf("ready replicas (%v) does not equal requested replicas (%v)", obj.Status.ReadyReplicas, obj.Status.Replicas)
+}
+
+// StatefulSetRolledOutTo returns nil once all the pods of the StatefulSet from the given partition up are updated and all
+// its pods are ready, the StatefulSet has to have observed its latest generation
+func StatefulSetRolledOutTo(obj *appsv1.StatefulSet, partition int32) error {
+	if obj.Spec.Replicas == nil {
+		return fmt.Errorf("replicas not set, so can't be healthy")
+	}
+	if obj.Status.ObservedGeneration < obj.Generation {
+		return fmt.Errorf("statefulset %v has not observed its latest generation yet", obj.Name)
+	}
+	if updated := *obj.Spec.Replicas - partition; obj.Status.UpdatedReplicas < updated {
+		return fmt.Errorf("updated replicas (%v) of statefulset %v is less than %v", obj.Status.UpdatedReplicas, obj.Name, updated)
+	}
+	if obj.Status.ReadyReplicas != *obj.Spec.Replicas {
+		return fmt.Errorf("ready replicas (%v) of statefulset %v does not equal requested replicas (%v)", obj.Status.ReadyReplicas, obj.Name, *obj.Spec.Replicas)
+	}
+	return nil
+}
+
+// deploymentReady returns nil once all the requested replicas are ready, a Deployment scaled to zero, e.g. one disabled by
+// a parameter, is healthy as there is nothing to run even while its old pods are still terminating
+func deploymentReady(obj *appsv1.Deployment) error {
+	if obj.Spec.Replicas == nil {
+		return fmt.Errorf("replicas not set, so can't be healthy")
+	}
+	if *obj.Spec.Replicas == 0 {
+		log.Printf("HealthUtil: Deployment %v is scaled to zero, it is marked healthy", obj.Name)
+		return nil
+	}
+	if obj.Status.ReadyReplicas == *obj.Spec.Replicas {
+		log.Printf("HealthUtil: Deployment %v is marked healthy", obj.Name)
+		return nil
+	}
+	log.Printf("HealthUtil: Deployment %v is NOT healthy. Not enough ready replicas: %v/%v", obj.Name, obj.Status.ReadyReplicas, *obj.Spec.Replicas)
+	return fmt.Errorf("ready replicas (%v) does not equal requested replicas (%v)", obj.Status.ReadyReplicas, *obj.Spec.Replicas)
+}
+
+// daemonSetReady returns nil once the pod is ready and updated on all the nodes the DaemonSet is scheduled to
+// a DaemonSet not matching any node is healthy as there is nothing to wait for, pods of DaemonSets with the OnDelete
+// update strategy are only updated when deleted manually, so only their readiness is checked
+func daemonSetReady(obj *appsv1.DaemonSet) error {
+	if obj.Status.ObservedGeneration < obj.Generation {
+		return fmt.Errorf("daemonset %v has not observed its latest generation yet", obj.Name)
+	}
+	desired := obj.Status.DesiredNumberScheduled
+	if desired == 0 {
+		log.Printf("HealthUtil: DaemonSet %v is not scheduled to any node, it is marked healthy", obj.Name)
+		return nil
+	}
+	if obj.Spec.UpdateStrategy.Type != appsv1.OnDeleteDaemonSetStrategyType && obj.Status.UpdatedNumberScheduled < desired {
+		log.Printf("HealthUtil: DaemonSet %v is NOT healthy. Rollout in progress: %v/%v nodes updated", obj.Name, obj.Status.UpdatedNumberScheduled, desired)
+		return fmt.Errorf("daemonset %v is updated on %v of %v nodes", obj.Name, obj.Status.UpdatedNumberScheduled, desired)
+	}
+	if obj.Status.NumberReady < desired {
+		log.Printf("HealthUtil: DaemonSet %v is NOT healthy. Not ready on all nodes: %v/%v", obj.Name, obj.Status.NumberReady, desired)
+		return fmt.Errorf("daemonset %v is ready on %v of %v nodes", obj.Name, obj.Status.NumberReady, desired)
+	}
+	log.Printf("HealthUtil: DaemonSet %v is marked healthy", obj.Name)
+	return nil
+}
+
+func jobReady(obj *batchv1.Job) error {
+	if obj.Status.Succeeded == int32(1) {
+		// Done!
+		log.Printf("HealthUtil: Job \"%v\" is marked healthy", obj.Name)
+		return nil
+	}
+	return fmt.Errorf("job \"%v\" still running or failed", obj.Name)
+}
+
+// pvcHealthy returns nil once the PVC is bound, or while it is pending for a storage class that binds volumes only once a
+// pod using the claim is scheduled. Such a claim stays pending until a pod of a later step uses it, waiting for it to be
+// bound would block the plan forever.
+func pvcHealthy(c client.Client, obj *corev1.PersistentVolumeClaim) error {
+	if obj.Status.Phase != corev1.ClaimPending {
+		return pvcReady(obj, false)
+	}
+	className := storageClassName(obj)
+	if className == "" {
+		return pvcReady(obj, false)
+	}
+	class := &storagev1.StorageClass{}
+	err := c.Get(context.TODO(), client.ObjectKey{Name: className}, class)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("pvc %v is pending: storage class %s does not exist", obj.Name, className)
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching storage class %s of pvc %v: %v", className, obj.Name, err)
+	}
+	waitsForConsumer := class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer
+	return pvcReady(obj, waitsForConsumer)
+}
+
+// storageClassName returns the name of the storage class of the PVC, the beta annotation takes precedence over the field
+// as it does in Kubernetes
+func storageClassName(obj *corev1.PersistentVolumeClaim) string {
+	if class, ok := obj.Annotations[corev1.BetaStorageClassAnnotation]; ok {
+		return class
+	}
+	if obj.Spec.StorageClassName != nil {
+		return *obj.Spec.StorageClassName
+	}
+	return ""
+}
+
+// pvcReady returns nil once the PVC is bound to a volume, a pending PVC is healthy only if its storage class binds the
+// volume when the first pod using the claim is scheduled
+func pvcReady(obj *corev1.PersistentVolumeClaim, waitsForConsumer bool) error {
+	switch obj.Status.Phase {
+	case corev1.ClaimBound:
+		log.Printf("HealthUtil: PVC %v is marked healthy", obj.Name)
+		return nil
+	case corev1.ClaimLost:
+		log.Printf("HealthUtil: PVC %v is NOT healthy. It lost its volume %v", obj.Name, obj.Spec.VolumeName)
+		return fmt.Errorf("pvc %v lost its volume %v", obj.Name, obj.Spec.VolumeName)
+	default:
+		if waitsForConsumer {
+			log.Printf("HealthUtil: PVC %v is pending until it is used by a pod, it is marked healthy", obj.Name)
 			return nil
 		}
-		return fmt.Errorf("job \"%v\" still running or failed", obj.Name)
-	case *kudov1alpha1.Instance:
-		log.Printf("HealthUtil: Instance %v is in state %v", obj.Name, obj.Status.AggregatedStatus.Status)
+		log.Printf("HealthUtil: PVC %v is NOT healthy. It is not bound yet", obj.Name)
+		return fmt.Errorf("pvc %v is pending: no matching storage class or volume", obj.Name)
+	}
+}
+
+func instanceReady(obj *kudov1alpha1.Instance) error {
+	log.Printf("HealthUtil: Instance %v is in state %v", obj.Name, obj.Status.AggregatedStatus.Status)
+
+	if obj.Status.AggregatedStatus.Status.IsFinished() {
+		return nil
+	}
+	return fmt.Errorf("instance's active plan is in state %v", obj.Status.AggregatedStatus.Status)
+}
+
+// DefaultReadyCondition is the type of the status condition reporting readiness of custom resources
+const DefaultReadyCondition = "Ready"
+
+// conditionReady returns nil once the custom resource has a status condition of the ready type with status "True"
+// the type is "Ready" unless the object names another one in the ReadyConditionAnnotation. Custom resources that do not
+// report any conditions are healthy once they exist, unless they name the condition explicitly
+func conditionReady(obj *unstructured.Unstructured) error {
+	conditionType, explicit := obj.GetAnnotations()[kudo.ReadyConditionAnnotation]
+	if !explicit {
+		conditionType = DefaultReadyCondition
+	}
+
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		return fmt.Errorf("%s %v has malformed status conditions: %v", obj.GetKind(), obj.GetName(), err)
+	}
+	if !found && !explicit {
+		log.Printf("HealthUtil: %s %v does not report any conditions, it is marked healthy", obj.GetKind(), obj.GetName())
+		return nil
+	}
 
-		if obj.Status.AggregatedStatus.Status.IsFinished() {
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		if condition["status"] == "True" {
+			log.Printf("HealthUtil: %s %v is marked healthy", obj.GetKind(), obj.GetName())
 			return nil
 		}
-		return fmt.Errorf("instance's active plan is in state %v", obj.Status.AggregatedStatus.Status)
+		log.Printf("HealthUtil: %s %v is NOT healthy. Condition %s is %v: %v", obj.GetKind(), obj.GetName(), conditionType, condition["status"], condition["message"])
+		return fmt.Errorf("condition %s of %s %v is %v: %v", conditionType, obj.GetKind(), obj.GetName(), condition["status"], condition["message"])
+	}
+	log.Printf("HealthUtil: %s %v is NOT healthy. It does not report condition %s yet", obj.GetKind(), obj.GetName(), conditionType)
+	return fmt.Errorf("%s %v does not report condition %s yet", obj.GetKind(), obj.GetName(), conditionType)
+}
 
-	// unless we build logic for what a healthy object is, assume it's healthy when created.
+// ReadyReplicas returns the number of ready replicas of workload objects (Deployments and StatefulSets)
+// the second return value is false for objects that have no replicas
+func ReadyReplicas(obj runtime.Object) (int32, bool) {
+	switch obj := obj.(type) {
+	case *appsv1.StatefulSet:
+		return obj.Status.ReadyReplicas, true
+	case *appsv1.Deployment:
+		return obj.Status.ReadyReplicas, true
 	default:
-		log.Printf("HealthUtil: Unknown type is marked healthy by default")
-		return nil
+		return 0, false
 	}
 }
@@ -0,0 +1,227 @@
+package health
+
+import (
+	"testing"
+
+	kudov1alpha1 "github.com/kudobuilder/kudo/pkg/apis/kudo/v1alpha1"
+	"github.com/kudobuilder/kudo/pkg/util/kudo"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIsReady(t *testing.T) {
+	replicas := func(r int32) *int32 { return &r }
+
+	tests := []struct {
+		name    string
+		obj     runtime.Object
+		healthy bool
+	}{
+		{"deployment with all replicas ready", &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: replicas(3)}, Status: appsv1.DeploymentStatus{ReadyReplicas: 3}}, true},
+		{"deployment with some replicas ready", &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: replicas(3)}, Status: appsv1.DeploymentStatus{ReadyReplicas: 2}}, false},
+		{"deployment without replicas", &appsv1.Deployment{}, false},
+		{"deployment scaled to zero", &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: replicas(0)}}, true},
+		{"deployment scaled to zero with terminating replicas", &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: replicas(0)}, Status: appsv1.DeploymentStatus{ReadyReplicas: 2}}, true},
+		{"statefulset with all replicas ready", &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: replicas(3)}, Status: appsv1.StatefulSetStatus{ReadyReplicas: 3}}, true},
+		{"statefulset with some replicas ready", &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: replicas(3)}, Status: appsv1.StatefulSetStatus{ReadyReplicas: 1}}, false},
+		{"statefulset without replicas", &appsv1.StatefulSet{}, false},
+		{"statefulset scaled to zero with terminating replicas", &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Spec: appsv1.StatefulSetSpec{Replicas: replicas(0)}, Status: appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3}}, true},
+		{"statefulset with unobserved update", &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Spec: appsv1.StatefulSetSpec{Replicas: replicas(3)}, Status: appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3}}, false},
+		{"statefulset with pods above the partition not updated yet", &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: replicas(3), UpdateStrategy: partitioned(1)}, Status: appsv1.StatefulSetStatus{ReadyReplicas: 3, UpdatedReplicas: 1}}, false},
+		{"statefulset rolled out to its canary partition", &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: replicas(3), UpdateStrategy: partitioned(2)}, Status: appsv1.StatefulSetStatus{ReadyReplicas: 3, UpdatedReplicas: 1}}, true},
+		{"statefulset with finished partitioned rollout", &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: replicas(3), UpdateStrategy: partitioned(0)}, Status: appsv1.StatefulSetStatus{ReadyReplicas: 3, UpdatedReplicas: 3}}, true},
+		{"daemonset ready on all nodes", &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3}}, true},
+		{"daemonset not ready on all nodes", &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 2}}, false},
+		{"daemonset in the middle of rollout", &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 1, NumberReady: 3}}, false},
+		{"daemonset with on delete updates", &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: appsv1.OnDeleteDaemonSetStrategyType}}, Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 1, NumberReady: 3}}, true},
+		{"daemonset not scheduled to any node", &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 0}}, true},
+		{"daemonset with unobserved update", &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Status: appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3}}, false},
+		{"succeeded job", &batchv1.Job{Status: batchv1.JobStatus{Succeeded: 1}}, true},
+		{"running job", &batchv1.Job{Status: batchv1.JobStatus{Active: 1}}, false},
+		{"failed job", &batchv1.Job{Status: batchv1.JobStatus{Failed: 1}}, false},
+		{"cronjob that never ran", &batchv1beta1.CronJob{Spec: batchv1beta1.CronJobSpec{Schedule: "0 3 * * *"}}, true},
+		{"instance with finished plan", &kudov1alpha1.Instance{Status: kudov1alpha1.InstanceStatus{AggregatedStatus: kudov1alpha1.AggregatedStatus{Status: kudov1alpha1.ExecutionComplete}}}, true},
+		{"instance with plan in progress", &kudov1alpha1.Instance{Status: kudov1alpha1.InstanceStatus{AggregatedStatus: kudov1alpha1.AggregatedStatus{Status: kudov1alpha1.ExecutionInProgress}}}, false},
+		{"pod is healthy once it exists", &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}, true},
+		{"unknown kind is healthy", &corev1.ConfigMap{}, true},
+	}
+
+	for _, tt := range tests {
+		err := IsReady(tt.obj)
+		if tt.healthy && err != nil {
+			t.Errorf("%s: Expecting object to be healthy but got %v", tt.name, err)
+		}
+		if !tt.healthy && err == nil {
+			t.Errorf("%s: Expecting object to be unhealthy but it is healthy", tt.name)
+		}
+	}
+}
+
+func customResource(annotations map[string]string, conditions ...interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kafka.strimzi.io/v1beta1",
+		"kind":       "Kafka",
+		"metadata":   map[string]interface{}{"name": "kafka", "namespace": "default"},
+	}}
+	obj.SetAnnotations(annotations)
+	if conditions != nil {
+		obj.Object["status"] = map[string]interface{}{"conditions": conditions}
+	}
+	return obj
+}
+
+func TestIsReadyChecksConditionsOfCustomResources(t *testing.T) {
+	ready := map[string]interface{}{"type": "Ready", "status": "True"}
+	notReady := map[string]interface{}{"type": "Ready", "status": "False", "message": "waiting for brokers"}
+	available := map[string]interface{}{"type": "Available", "status": "True"}
+	custom := map[string]string{kudo.ReadyConditionAnnotation: "Available"}
+
+	tests := []struct {
+		name     string
+		obj      *unstructured.Unstructured
+		expected string
+	}{
+		{"ready condition true", customResource(nil, ready), ""},
+		{"ready condition false", customResource(nil, notReady), "condition Ready of Kafka kafka is False: waiting for brokers"},
+		{"ready condition missing", customResource(nil, available), "Kafka kafka does not report condition Ready yet"},
+		{"no conditions reported", customResource(nil), ""},
+		{"custom condition true", customResource(custom, notReady, available), ""},
+		{"custom condition not reported yet", customResource(custom), "Kafka kafka does not report condition Available yet"},
+	}
+
+	for _, tt := range tests {
+		err := IsReady(tt.obj)
+		if tt.expected == "" && err != nil {
+			t.Errorf("%s: Expecting object to be healthy but got %v", tt.name, err)
+		}
+		if tt.expected != "" && (err == nil || err.Error() != tt.expected) {
+			t.Errorf("%s: Expecting error %s but got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func partitioned(partition int32) appsv1.StatefulSetUpdateStrategy {
+	return appsv1.StatefulSetUpdateStrategy{
+		Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+	}
+}
+
+func TestStatefulSetRolledOutTo(t *testing.T) {
+	replicas := int32(3)
+	tests := []struct {
+		name      string
+		partition int32
+		status    appsv1.StatefulSetStatus
+		rolledOut bool
+	}{
+		{"nothing updated yet", 3, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3}, true},
+		{"pod of the partition not updated yet", 2, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3}, false},
+		{"pod of the partition updated but not ready", 2, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, UpdatedReplicas: 1}, false},
+		{"pod of the partition updated and ready", 2, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, UpdatedReplicas: 1}, true},
+		{"partition not observed yet", 2, appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3, UpdatedReplicas: 1}, false},
+	}
+
+	for _, tt := range tests {
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: 2},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: partitioned(tt.partition)},
+			Status:     tt.status,
+		}
+		err := StatefulSetRolledOutTo(sts, tt.partition)
+		if tt.rolledOut && err != nil {
+			t.Errorf("%s: Expecting statefulset to be rolled out but got %v", tt.name, err)
+		}
+		if !tt.rolledOut && err == nil {
+			t.Errorf("%s: Expecting statefulset not to be rolled out but it is", tt.name)
+		}
+	}
+}
+
+func TestIsHealthyFetchesCurrentState(t *testing.T) {
+	replicas := int32(2)
+	current := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "deployment", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: 2},
+	}
+	c := fake.NewFakeClientWithScheme(scheme.Scheme, current)
+
+	// the object passed in carries a stale status
+	stale := current.DeepCopy()
+	stale.Status.ReadyReplicas = 0
+	if err := IsHealthy(c, stale); err != nil {
+		t.Errorf("Expecting deployment to be healthy based on its current state but got %v", err)
+	}
+	if stale.Status.ReadyReplicas != 2 {
+		t.Errorf("Expecting object to be updated with the current state but got %d ready replicas", stale.Status.ReadyReplicas)
+	}
+
+	missing := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "default"},
+	}
+	if err := IsHealthy(c, missing); err == nil {
+		t.Error("Expecting object that does not exist to be unhealthy")
+	}
+}
+
+func TestIsHealthyChecksPersistentVolumeClaims(t *testing.T) {
+	waitForConsumer := storagev1.VolumeBindingWaitForFirstConsumer
+	immediate := storagev1.VolumeBindingImmediate
+	classes := []runtime.Object{
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "local"}, VolumeBindingMode: &waitForConsumer},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "standard"}, VolumeBindingMode: &immediate},
+	}
+	pvc := func(class string, phase corev1.PersistentVolumeClaimPhase) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{
+			TypeMeta:   metav1.TypeMeta{Kind: "PersistentVolumeClaim", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: phase},
+		}
+		if class != "" {
+			pvc.Spec.StorageClassName = &class
+		}
+		return pvc
+	}
+	annotated := pvc("", corev1.ClaimPending)
+	annotated.Annotations = map[string]string{corev1.BetaStorageClassAnnotation: "local"}
+
+	tests := []struct {
+		name    string
+		pvc     *corev1.PersistentVolumeClaim
+		healthy bool
+	}{
+		{"bound", pvc("standard", corev1.ClaimBound), true},
+		{"pending", pvc("standard", corev1.ClaimPending), false},
+		{"pending without storage class", pvc("", corev1.ClaimPending), false},
+		{"pending with unknown storage class", pvc("fast", corev1.ClaimPending), false},
+		{"pending with wait for first consumer", pvc("local", corev1.ClaimPending), true},
+		{"pending with wait for first consumer by annotation", annotated, true},
+		{"lost", pvc("local", corev1.ClaimLost), false},
+	}
+
+	for _, tt := range tests {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, append(classes, tt.pvc.DeepCopy())...)
+		err := IsHealthy(c, tt.pvc)
+		if tt.healthy && err != nil {
+			t.Errorf("%s: Expecting pvc to be healthy but got %v", tt.name, err)
+		}
+		if !tt.healthy && err == nil {
+			t.Errorf("%s: Expecting pvc not to be healthy", tt.name)
+		}
+	}
+
+	if err := IsReady(pvc("local", corev1.ClaimPending)); err == nil {
+		t.Error("Expecting pending pvc not to be ready without checking its storage class")
+	}
+}
@@ -1,6 +1,10 @@
 package kudo
 
 const (
+	// ReservedKeyPrefix is the prefix of the label and annotation keys KUDO sets and reads, they are never copied from the
+	// instance to the objects it owns
+	ReservedKeyPrefix = "kudo.dev/"
+
 	// OperatorLabel is k8s label key for identifying operator
 	OperatorLabel = "kudo.dev/operator"
 	// OperatorVersionAnnotation is k8s label key for operator version
@@ -9,6 +13,8 @@ const (
 	InstanceLabel = "kudo.dev/instance"
 	// HeritageLabel is k8s label key for heritage
 	HeritageLabel = "heritage" // this is not specific to KUDO
+	// ColorLabel is k8s label key for the color of objects created by a blue-green phase
+	ColorLabel = "kudo.dev/color"
 
 	// PlanAnnotation is k8s annotation key for plan name that created this object
 	PlanAnnotation = "kudo.dev/plan"
@@ -16,4 +22,89 @@ const (
 	PhaseAnnotation = "kudo.dev/phase"
 	// StepAnnotation is k8s annotation key for step that created this object
 	StepAnnotation = "kudo.dev/step"
+	// TaskAnnotation is k8s annotation key for task that created this object
+	TaskAnnotation = "kudo.dev/task"
+	// TemplateAnnotation is k8s annotation key for name of the template this object was rendered from
+	TemplateAnnotation = "kudo.dev/template"
+
+	// HealthAnnotation is k8s annotation key that can be used in templates to override how health of this object is evaluated
+	HealthAnnotation = "kudo.dev/health"
+	// HealthIgnoreValue is value of HealthAnnotation that makes KUDO skip the health check for this object
+	HealthIgnoreValue = "ignore"
+	// HealthStickyValue is value of HealthAnnotation that makes KUDO check the health of this object only until it was
+	// healthy once in the current execution of the plan, unless its rendered content changes. Objects that degrade later
+	// are not noticed by the plan, the instance is still executed again when their status changes.
+	HealthStickyValue = "sticky"
+	// ReadyConditionAnnotation is k8s annotation key that can be used in templates of custom resources to name the type of
+	// the status condition that reports their readiness, "Ready" is used by default
+	ReadyConditionAnnotation = "kudo.dev/ready-condition"
+
+	// ApplyOrderAnnotation is k8s annotation key that can be used in templates to control the order in which the objects
+	// of a step are applied, objects are applied from the lowest integer to the highest, objects without it have order 0
+	ApplyOrderAnnotation = "kudo.dev/apply-order"
+
+	// OwnerReferenceAnnotation is k8s annotation key that can be used in templates to control the owner reference KUDO sets
+	// on the object, by default the instance is set as the controller owner so that the object is garbage collected with it
+	OwnerReferenceAnnotation = "kudo.dev/owner-reference"
+	// OwnerReferenceNoneValue is value of OwnerReferenceAnnotation that makes KUDO skip the owner reference, the object
+	// then outlives the instance, e.g. a PVC holding data that should survive a reinstall
+	OwnerReferenceNoneValue = "none"
+	// OwnerReferenceControllerValue is the default value of OwnerReferenceAnnotation that makes the owner the controller
+	// of the object
+	OwnerReferenceControllerValue = "controller"
+	// OwnerReferenceOwnerValue is value of OwnerReferenceAnnotation that makes the owner one of the owners of the object
+	// without being its controller, so that another owner listed in the template can be the controller
+	OwnerReferenceOwnerValue = "owner"
+
+	// BlockOwnerDeletionAnnotation is k8s annotation key that can be used in templates to control whether the owner
+	// reference KUDO sets blocks the foreground deletion of the owner until the object is gone, `true` by default
+	BlockOwnerDeletionAnnotation = "kudo.dev/block-owner-deletion"
+
+	// ConflictPolicyAnnotation is k8s annotation key that can be used in templates to control which fields KUDO asserts when
+	// it patches an object also changed by someone else, e.g. replicas of a Deployment scaled by an autoscaler
+	ConflictPolicyAnnotation = "kudo.dev/conflict-policy"
+	// ConflictPolicyKudoWins is the default value of ConflictPolicyAnnotation, all the rendered fields are patched
+	ConflictPolicyKudoWins = "kudo-wins"
+	// ConflictPolicyOtherWins is value of ConflictPolicyAnnotation that makes KUDO patch only fields the object does not have yet
+	ConflictPolicyOtherWins = "other-wins"
+	// ConflictPolicyMerge is value of ConflictPolicyAnnotation that makes KUDO patch only fields it did not apply before or
+	// whose rendered value changed since it applied them last time
+	ConflictPolicyMerge = "merge"
+	// ConflictPolicyDetectDrift is value of ConflictPolicyAnnotation that makes KUDO leave the object untouched when it
+	// differs from the rendered one and report the changed fields as drift in the step status instead
+	ConflictPolicyDetectDrift = "detect-drift"
+	// UpdateStrategyAnnotation is k8s annotation key that can be used in templates to control how changes of the object are
+	// applied, e.g. to re-run a Job or to change an immutable field
+	UpdateStrategyAnnotation = "kudo.dev/update-strategy"
+	// UpdateStrategyPatch is the default value of UpdateStrategyAnnotation, the existing object is patched
+	UpdateStrategyPatch = "patch"
+	// UpdateStrategyRecreate is value of UpdateStrategyAnnotation that makes KUDO delete the existing object when it differs
+	// from the rendered one and create the rendered one once the existing one is gone
+	UpdateStrategyRecreate = "recreate"
+	// LastAppliedAnnotation is k8s annotation key holding the fields KUDO applied last time to objects with the merge policy
+	LastAppliedAnnotation = "kudo.dev/last-applied"
+
+	// HashSuffixAnnotation is k8s annotation key that can be used in templates of ConfigMaps and Secrets, when set to "true"
+	// the name of the object gets a suffix hashed from its content and references to it are rewritten accordingly
+	HashSuffixAnnotation = "kudo.dev/hash-suffix"
+
+	// CommandTaskAnnotation is k8s annotation key identifying Jobs that run a command task, the value is the name of the task
+	CommandTaskAnnotation = "kudo.dev/command-task"
+	// BreakpointsAnnotation is k8s annotation key of an instance listing comma separated names of steps the execution of
+	// plans stops before, the execution continues once the step is removed from the list
+	BreakpointsAnnotation = "kudo.dev/breakpoints"
+	// RetryPlanAnnotation is k8s annotation key of an instance naming a failed plan whose failed steps should be executed
+	// again, KUDO removes the annotation once the retry started
+	RetryPlanAnnotation = "kudo.dev/retry-plan"
+	// ReconcileDriftAnnotation is k8s annotation key of an instance naming a plan that should be executed again patching
+	// also objects with the detect-drift conflict policy, the annotation is removed once the plan is started
+	ReconcileDriftAnnotation = "kudo.dev/reconcile-drift"
+	// CaptureOutputAnnotation is k8s annotation key marking command Jobs whose output should be stored in the step status
+	CaptureOutputAnnotation = "kudo.dev/capture-output"
+	// GeneratedLabel is k8s label key that can be used in templates of ConfigMaps and Secrets to expose their data to
+	// templates of all plans of the instance under `.Generated`, keyed by the value of the label
+	GeneratedLabel = "kudo.dev/generated"
+	// SensitiveAnnotation is k8s annotation key that can be used in templates, when set to "true" nothing but the metadata
+	// of the object is logged by KUDO, data of Secrets and ConfigMaps is never logged
+	SensitiveAnnotation = "kudo.dev/sensitive"
 )
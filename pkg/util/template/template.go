@@ -3,14 +3,17 @@ package template
 import (
 	"strings"
 
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
 
 	"k8s.io/client-go/kubernetes/scheme"
 )
 
 //ParseKubernetesObjects parses a list of runtime.Objects from the provided yaml
-func ParseKubernetesObjects(yaml string) (objs []runtime.Object, err error) {
-	sepYamlfiles := strings.Split(yaml, "---")
+//objects of kinds not known to the scheme, e.g. custom resources, are parsed as unstructured objects
+func ParseKubernetesObjects(content string) (objs []runtime.Object, err error) {
+	sepYamlfiles := strings.Split(content, "---")
 	for _, f := range sepYamlfiles {
 		if f == "\n" || f == "" {
 			// ignore empty cases
@@ -19,6 +22,9 @@ func ParseKubernetesObjects(yaml string) (objs []runtime.Object, err error) {
 
 		decode := scheme.Codecs.UniversalDeserializer().Decode
 		obj, _, e := decode([]byte(f), nil, nil)
+		if runtime.IsNotRegisteredError(e) {
+			obj, e = parseUnstructured(f)
+		}
 
 		if e != nil {
 			err = e
@@ -28,3 +34,12 @@ func ParseKubernetesObjects(yaml string) (objs []runtime.Object, err error) {
 	}
 	return
 }
+
+func parseUnstructured(content string) (runtime.Object, error) {
+	json, err := yaml.ToJSON([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := unstructured.UnstructuredJSONScheme.Decode(json, nil, nil)
+	return obj, err
+}